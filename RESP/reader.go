@@ -0,0 +1,319 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+
+	gttype "github.com/BeginerAndProgresses/generalized-tools/type"
+)
+
+const (
+	// MaxBulkLen 单个批量字符串允许的最大长度，与Redis的proto-max-bulk-len默认值一致
+	MaxBulkLen = 512 * 1024 * 1024
+	// MaxMultiBulkLen 单个聚合类型允许的最大元素个数
+	MaxMultiBulkLen = 1024 * 1024 * 1024
+	// MaxInlineLen 内联命令以及协议中单行（例如*与$开头的长度行）允许的最大长度
+	MaxInlineLen = 64 * 1024
+)
+
+// ProtocolError 协议格式错误，出现后连接中后续的数据已无法可靠解析
+type ProtocolError struct {
+	msg string
+}
+
+func (e *ProtocolError) Error() string {
+	return "Protocol error: " + e.msg
+}
+
+func protocolErr(msg string) error {
+	return &ProtocolError{msg: msg}
+}
+
+// Reader 基于bufio.Reader的流式RESP解码器，每次返回一个完整解码的值，
+// 帧不完整时阻塞等待后续数据
+type Reader struct {
+	rd *bufio.Reader
+}
+
+// NewReader 创建Reader，若rd本身已是*bufio.Reader则直接复用
+func NewReader(rd io.Reader) *Reader {
+	if br, ok := rd.(*bufio.Reader); ok {
+		return &Reader{rd: br}
+	}
+	return &Reader{rd: bufio.NewReader(rd)}
+}
+
+// Buffered 返回已读入缓冲区但尚未解码的字节数
+func (r *Reader) Buffered() int {
+	return r.rd.Buffered()
+}
+
+// ReadValue 读取并解码一个完整的RESP值，返回的类型与Parse保持一致；
+// 空批量字符串($-1)、空数组(*-1)与RESP3的空值(_)均返回nil。
+// 在帧的起始位置遇到EOF时返回io.EOF，帧中途断开返回io.ErrUnexpectedEOF
+func (r *Reader) ReadValue() (any, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	v, err := r.readValue(line)
+	return v, unexpectedEOF(err)
+}
+
+// ReadCommand 读取一条客户端命令，支持批量字符串数组与内联命令两种格式。
+// 空命令返回长度为0的切片，调用方应直接忽略
+func (r *Reader) ReadCommand() ([]string, error) {
+	first, err := r.rd.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] != typeArrSign {
+		return r.readInline()
+	}
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.ParseInt(string(line[1:]), 10, 64)
+	if err != nil || n > MaxMultiBulkLen {
+		return nil, protocolErr("invalid multibulk length")
+	}
+	if n <= 0 {
+		return []string{}, nil
+	}
+	args := make([]string, 0, min(n, 1024))
+	for i := int64(0); i < n; i++ {
+		line, err = r.readLine()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if line[0] != typeBulkStringsSign {
+			return nil, protocolErr("expected '$', got '" + string(line[0]) + "'")
+		}
+		s, err := r.readBulk(line)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if s == nil {
+			return nil, protocolErr("invalid bulk length")
+		}
+		args = append(args, *s)
+	}
+	return args, nil
+}
+
+// readInline 读取以空白分隔的内联命令，例如telnet直接输入的"PING\r\n"
+func (r *Reader) readInline() ([]string, error) {
+	line, err := r.readSlice()
+	if len(line) > MaxInlineLen {
+		return nil, protocolErr("too big inline request")
+	}
+	if err != nil {
+		if errors.Is(err, io.EOF) && len(line) > 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return strings.Fields(string(line)), nil
+}
+
+// readSlice 读取到换行为止。行可能超过bufio的缓冲区，累积读取直到换行或超过MaxInlineLen，
+// 调用方需检查返回的长度，避免不含换行的数据使内存无限增长
+func (r *Reader) readSlice() ([]byte, error) {
+	line, err := r.rd.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		buf := append([]byte(nil), line...)
+		for errors.Is(err, bufio.ErrBufferFull) && len(buf) <= MaxInlineLen {
+			line, err = r.rd.ReadSlice('\n')
+			buf = append(buf, line...)
+		}
+		line = buf
+	}
+	return line, err
+}
+
+// readLine 读取一行并去除结尾的\r\n，返回的切片在下一次读取前有效
+func (r *Reader) readLine() ([]byte, error) {
+	line, err := r.readSlice()
+	if len(line) > MaxInlineLen {
+		switch line[0] {
+		case typeArrSign:
+			return nil, protocolErr("too big mbulk count string")
+		case typeBulkStringsSign:
+			return nil, protocolErr("too big bulk count string")
+		default:
+			return nil, protocolErr("too big line")
+		}
+	}
+	if err != nil {
+		if errors.Is(err, io.EOF) && len(line) > 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, protocolErr("invalid line terminator")
+	}
+	return line[:len(line)-2], nil
+}
+
+// readValue 根据已读取的首行解码剩余部分
+func (r *Reader) readValue(line []byte) (any, error) {
+	body := line[1:]
+	switch line[0] {
+	case typeStrSign:
+		return string(body), nil
+	case typeErrSign:
+		return errors.New(string(body)), nil
+	case typeIntSign:
+		i, err := strconv.ParseInt(string(body), 10, 64)
+		if err != nil {
+			return nil, protocolErr("invalid integer " + strconv.Quote(string(body)))
+		}
+		return i, nil
+	case typeNullSign:
+		if len(body) != 0 {
+			return nil, protocolErr("invalid null")
+		}
+		return nil, nil
+	case typeBoolSign:
+		if len(body) != 1 || (body[0] != 't' && body[0] != 'f') {
+			return nil, protocolErr("invalid boolean")
+		}
+		return body[0] == 't', nil
+	case typeDoublesSign:
+		f, err := strconv.ParseFloat(string(body), 64)
+		if err != nil {
+			return nil, protocolErr("invalid double " + strconv.Quote(string(body)))
+		}
+		return f, nil
+	case typeBigNumbersSign:
+		bi, ok := new(big.Int).SetString(string(body), 10)
+		if !ok {
+			return nil, protocolErr("invalid big number " + strconv.Quote(string(body)))
+		}
+		return bi, nil
+	case typeBulkStringsSign:
+		s, err := r.readBulk(line)
+		if err != nil || s == nil {
+			return nil, err
+		}
+		return BulkStrings(*s), nil
+	case typeMultiErrSign:
+		s, err := r.readBulk(line)
+		if err != nil || s == nil {
+			return nil, err
+		}
+		return MultiErr{err: *s}, nil
+	case typeVervatimSign:
+		s, err := r.readBulk(line)
+		if err != nil || s == nil {
+			return nil, err
+		}
+		if len(*s) < 4 || (*s)[3] != ':' {
+			return nil, protocolErr("invalid verbatim string")
+		}
+		return Verbatim{Coding: (*s)[:3], Data: []byte((*s)[4:])}, nil
+	case typeArrSign, typeSetsSign, typePushesSign, typeMapsSign:
+		return r.readAggregate(line)
+	default:
+		return nil, protocolErr("unknown type '" + string(line[0]) + "'")
+	}
+}
+
+// readBulk 读取批量字符串的数据部分，长度为-1时返回nil
+func (r *Reader) readBulk(line []byte) (*string, error) {
+	n, err := strconv.ParseInt(string(line[1:]), 10, 64)
+	if err != nil || n < -1 || n > MaxBulkLen {
+		return nil, protocolErr("invalid bulk length")
+	}
+	if n == -1 {
+		return nil, nil
+	}
+	buf := make([]byte, n+2)
+	if _, err = io.ReadFull(r.rd, buf); err != nil {
+		return nil, err
+	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return nil, protocolErr("invalid bulk terminator")
+	}
+	s := string(buf[:n])
+	return &s, nil
+}
+
+// readAggregate 读取数组、集合、推送与Map类型
+func (r *Reader) readAggregate(line []byte) (any, error) {
+	sign := line[0]
+	n, err := strconv.ParseInt(string(line[1:]), 10, 64)
+	if err != nil || n < -1 || n > MaxMultiBulkLen {
+		return nil, protocolErr("invalid multibulk length")
+	}
+	if n == -1 {
+		return nil, nil
+	}
+	elems := n
+	if sign == typeMapsSign {
+		elems *= 2
+	}
+	vals := make([]any, 0, min(elems, 1024))
+	for i := int64(0); i < elems; i++ {
+		line, err = r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		v, err := r.readValue(line)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, v)
+	}
+	switch sign {
+	case typeSetsSign:
+		var ss Sets = gttype.NewHashSet[any]()
+		for _, v := range vals {
+			if !hashable(v) {
+				return nil, protocolErr("unhashable set element")
+			}
+			ss.Add(v)
+		}
+		return ss, nil
+	case typePushesSign:
+		var ps Pushes = gttype.NewHeap[any]()
+		for _, v := range vals {
+			ps.Insert(v)
+		}
+		return ps, nil
+	case typeMapsSign:
+		ms := make(Maps, n)
+		for i := 0; i < len(vals); i += 2 {
+			if !hashable(vals[i]) {
+				return nil, protocolErr("unhashable map key")
+			}
+			ms[vals[i]] = vals[i+1]
+		}
+		return ms, nil
+	default:
+		return Array(vals), nil
+	}
+}
+
+// hashable 判断值能否作为Go map的键，Array、Maps等切片/映射类型不能作为键
+func hashable(v any) bool {
+	if v == nil {
+		return true
+	}
+	return reflect.TypeOf(v).Comparable()
+}
+
+// unexpectedEOF 帧中途读到EOF说明数据被截断
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,240 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/big"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReader_ReadValue(t *testing.T) {
+	testCases := []struct {
+		name   string
+		row    []byte
+		res    any
+		before func() any
+	}{
+		{
+			name: "测试简单String",
+			row:  []byte("+OK\r\n"),
+			res:  "OK",
+		},
+		{
+			name: "测试Error",
+			row:  []byte("-ERR unknown\r\n"),
+			res:  errors.New("ERR unknown"),
+		},
+		{
+			name: "测试Int",
+			row:  []byte(":-2324\r\n"),
+			res:  int64(-2324),
+		},
+		{
+			name: "测试BulkString",
+			row:  []byte("$5\r\nhe\r\no\r\n"),
+			res:  BulkStrings("he\r\no"),
+		},
+		{
+			name: "测试空BulkString",
+			row:  []byte("$0\r\n\r\n"),
+			res:  BulkStrings(""),
+		},
+		{
+			name: "测试Nil BulkString",
+			row:  []byte("$-1\r\n"),
+			res:  nil,
+		},
+		{
+			name: "测试Nil Array",
+			row:  []byte("*-1\r\n"),
+			res:  nil,
+		},
+		{
+			name: "测试空Array",
+			row:  []byte("*0\r\n"),
+			res:  Array{},
+		},
+		{
+			name: "测试Nil",
+			row:  []byte("_\r\n"),
+			res:  nil,
+		},
+		{
+			name: "测试Bool",
+			row:  []byte("#t\r\n"),
+			res:  true,
+		},
+		{
+			name: "测试Double",
+			row:  []byte(",1.23\r\n"),
+			res:  1.23,
+		},
+		{
+			name: "测试大数",
+			row:  []byte("(3492890328409238509324850943850943825024385\r\n"),
+			before: func() any {
+				b, _ := new(big.Int).SetString("3492890328409238509324850943850943825024385", 10)
+				return b
+			},
+		},
+		{
+			name: "测试BulkErr",
+			row:  []byte("!3\r\nERR\r\n"),
+			res:  MultiErr{err: "ERR"},
+		},
+		{
+			name: "测试Verbatim",
+			row:  []byte("=15\r\ntxt:Some string\r\n"),
+			res:  Verbatim{Coding: "txt", Data: []byte("Some string")},
+		},
+		{
+			name: "测试Map",
+			row:  []byte("%2\r\n+first\r\n:1\r\n+second\r\n:2\r\n"),
+			res:  Maps{"first": int64(1), "second": int64(2)},
+		},
+		{
+			name: "测试嵌套Array",
+			row:  []byte("*2\r\n*1\r\n$3\r\nget\r\n:1\r\n"),
+			res:  Array{Array{BulkStrings("get")}, int64(1)},
+		},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			if v.before != nil {
+				v.res = v.before()
+			}
+			res, err := NewReader(bytes.NewReader(v.row)).ReadValue()
+			assert.NoError(t, err)
+			assert.Equal(t, v.res, res, "結果應該相同")
+		})
+	}
+}
+
+func TestReader_ReadValueInvalid(t *testing.T) {
+	testCases := []struct {
+		name string
+		row  []byte
+		err  error
+	}{
+		{
+			name: "测试未知类型",
+			row:  []byte("?1\r\n"),
+		},
+		{
+			name: "测试结尾不是\\r\\n",
+			row:  []byte("+OK\n"),
+		},
+		{
+			name: "测试BulkString长度不符",
+			row:  []byte("$3\r\nfoobar\r\n"),
+		},
+		{
+			name: "测试Int非数字",
+			row:  []byte(":a\r\n"),
+		},
+		{
+			name: "测试Map键不可哈希",
+			row:  []byte("%1\r\n*1\r\n:1\r\n:2\r\n"),
+		},
+		{
+			name: "测试帧被截断",
+			row:  []byte("*2\r\n$3\r\nget\r\n"),
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "测试空输入",
+			row:  []byte(""),
+			err:  io.EOF,
+		},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			_, err := NewReader(bytes.NewReader(v.row)).ReadValue()
+			if v.err != nil {
+				assert.ErrorIs(t, err, v.err)
+				return
+			}
+			var pe *ProtocolError
+			assert.ErrorAs(t, err, &pe)
+		})
+	}
+}
+
+func TestReader_PartialFrame(t *testing.T) {
+	// 逐字节读取，模拟命令被拆分到多个TCP分段
+	row := []byte("*3\r\n$3\r\nset\r\n$1\r\na\r\n$2000\r\n" + string(bytes.Repeat([]byte("x"), 2000)) + "\r\n+OK\r\n")
+	rd := NewReader(iotest.OneByteReader(bytes.NewReader(row)))
+	args, err := rd.ReadCommand()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"set", "a", string(bytes.Repeat([]byte("x"), 2000))}, args)
+	res, err := rd.ReadValue()
+	assert.NoError(t, err)
+	assert.Equal(t, "OK", res)
+	_, err = rd.ReadValue()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestReader_ReadCommand(t *testing.T) {
+	testCases := []struct {
+		name string
+		row  []byte
+		res  []string
+	}{
+		{
+			name: "测试批量字符串数组",
+			row:  []byte("*2\r\n$3\r\nget\r\n$1\r\na\r\n"),
+			res:  []string{"get", "a"},
+		},
+		{
+			name: "测试内联命令",
+			row:  []byte("set  a 1\r\n"),
+			res:  []string{"set", "a", "1"},
+		},
+		{
+			name: "测试空命令",
+			row:  []byte("*0\r\n"),
+			res:  []string{},
+		},
+		{
+			name: "测试超过缓冲区的内联命令",
+			row:  []byte("set a " + strings.Repeat("x", 8192) + "\r\n"),
+			res:  []string{"set", "a", strings.Repeat("x", 8192)},
+		},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			res, err := NewReader(bytes.NewReader(v.row)).ReadCommand()
+			assert.NoError(t, err)
+			assert.Equal(t, v.res, res, "結果應該相同")
+		})
+	}
+	_, err := NewReader(bytes.NewReader([]byte("*1\r\n:1\r\n"))).ReadCommand()
+	var pe *ProtocolError
+	assert.ErrorAs(t, err, &pe)
+
+	// 超过MaxInlineLen的内联命令
+	_, err = NewReader(bytes.NewReader([]byte("set a " + strings.Repeat("x", MaxInlineLen) + "\r\n"))).ReadCommand()
+	assert.ErrorAs(t, err, &pe)
+	assert.EqualError(t, err, "Protocol error: too big inline request")
+
+	// 长度行一直不出现换行时在MaxInlineLen处停止读取
+	_, err = NewReader(io.MultiReader(strings.NewReader("*"), endlessReader('1'))).ReadCommand()
+	assert.EqualError(t, err, "Protocol error: too big mbulk count string")
+	_, err = NewReader(io.MultiReader(strings.NewReader("*1\r\n$"), endlessReader('1'))).ReadCommand()
+	assert.EqualError(t, err, "Protocol error: too big bulk count string")
+}
+
+// endlessReader 无限地返回同一个字节
+type endlessReader byte
+
+func (r endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(r)
+	}
+	return len(p), nil
+}
@@ -612,7 +612,6 @@ func (r *respSvc) valid(resp []byte) (n int, b bool, err error) {
 	default:
 		return len(resp), false, errors.New(fmt.Sprintf("不支持的类型:%b", resp[0]))
 	}
-	return len(resp), false, nil
 }
 
 func NewRESP() RESP {
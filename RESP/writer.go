@@ -0,0 +1,267 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Proto2 RESP2协议，Redis客户端默认使用的版本
+	Proto2 = 2
+	// Proto3 RESP3协议，通过HELLO 3协商
+	Proto3 = 3
+)
+
+// Writer 将RESP值直接编码写入io.Writer，不保留中间缓冲，需显式调用Flush。
+// 在RESP2下会将RESP3独有的类型降级为RESP2中等价的表示:
+// Maps/Sets/Pushes降级为Array，Double/BigNumber/Verbatim降级为BulkStrings，
+// Bool降级为Int，Null降级为空批量字符串。
+// Array(nil)表示空数组(*-1)，长度为0的Array编码为*0
+type Writer struct {
+	wr    *bufio.Writer
+	proto int
+	num   []byte
+	// unsupported 编码当前值时遇到的第一个不支持的类型
+	unsupported error
+}
+
+// NewWriter 创建Writer，默认使用RESP2协议
+func NewWriter(wr io.Writer) *Writer {
+	bw, ok := wr.(*bufio.Writer)
+	if !ok {
+		bw = bufio.NewWriter(wr)
+	}
+	return &Writer{wr: bw, proto: Proto2, num: make([]byte, 0, 24)}
+}
+
+// SetProtocol 设置编码使用的协议版本
+func (w *Writer) SetProtocol(proto int) {
+	w.proto = proto
+}
+
+// Protocol 返回当前编码使用的协议版本
+func (w *Writer) Protocol() int {
+	return w.proto
+}
+
+// Flush 将缓冲区中的数据写入底层io.Writer
+func (w *Writer) Flush() error {
+	return w.wr.Flush()
+}
+
+// Buffered 返回缓冲区中尚未写出的字节数
+func (w *Writer) Buffered() int {
+	return w.wr.Buffered()
+}
+
+// Write 原样写入已编码的数据，用于转发已经是RESP格式的内容
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.wr.Write(p)
+}
+
+// WriteArrayHeader 写入数组头，调用方随后需写入n个元素
+func (w *Writer) WriteArrayHeader(n int) error {
+	return w.writeHeader(typeArrSign, int64(n))
+}
+
+// ErrUnsupportedType 值中含有无法编码的类型
+var ErrUnsupportedType = errors.New("unsupported type")
+
+// WriteValue 编码一个值，支持的类型与BuildingRedisExecuteRESP一致，
+// 另外支持[]byte(批量字符串)与无符号整数。
+// 不支持的类型以错误回复代替，使聚合类型的元素个数仍与数据一致，随后返回ErrUnsupportedType
+func (w *Writer) WriteValue(data any) error {
+	w.unsupported = nil
+	if err := w.writeValue(data); err != nil {
+		return err
+	}
+	return w.unsupported
+}
+
+func (w *Writer) writeValue(data any) error {
+	switch v := data.(type) {
+	case nil:
+		return w.writeNull(typeBulkStringsSign)
+	case Array:
+		if v == nil {
+			return w.writeNull(typeArrSign)
+		}
+		if err := w.writeHeader(typeArrSign, int64(len(v))); err != nil {
+			return err
+		}
+		for _, e := range v {
+			if err := w.writeValue(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	case BulkStrings:
+		return w.writeBulk(typeBulkStringsSign, string(v))
+	case []byte:
+		return w.writeBulk(typeBulkStringsSign, string(v))
+	case MultiErr:
+		if w.proto < Proto3 {
+			return w.writeSimple(typeErrSign, v.Error())
+		}
+		return w.writeBulk(typeMultiErrSign, v.Error())
+	case Verbatim:
+		if w.proto < Proto3 {
+			return w.writeBulk(typeBulkStringsSign, string(v.Data))
+		}
+		return w.writeBulk(typeVervatimSign, v.Coding+":"+string(v.Data))
+	case Maps:
+		sign, n := byte(typeMapsSign), int64(len(v))
+		if w.proto < Proto3 {
+			sign, n = typeArrSign, n*2
+		}
+		if err := w.writeHeader(sign, n); err != nil {
+			return err
+		}
+		for k, e := range v {
+			if err := w.writeValue(k); err != nil {
+				return err
+			}
+			if err := w.writeValue(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	case Sets:
+		sign := byte(typeSetsSign)
+		if w.proto < Proto3 {
+			sign = typeArrSign
+		}
+		if err := w.writeHeader(sign, int64(v.Size())); err != nil {
+			return err
+		}
+		for _, e := range v.GetData() {
+			if err := w.writeValue(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	case Pushes:
+		sign := byte(typePushesSign)
+		if w.proto < Proto3 {
+			sign = typeArrSign
+		}
+		if err := w.writeHeader(sign, int64(v.Size())); err != nil {
+			return err
+		}
+		var err error
+		v.ForEach(func(e any) {
+			if err == nil {
+				err = w.writeValue(e)
+			}
+		})
+		return err
+	case string:
+		return w.writeSimple(typeStrSign, v)
+	case error:
+		return w.writeSimple(typeErrSign, v.Error())
+	case int64, int32, int16, int8, int:
+		return w.writeHeader(typeIntSign, reflect.ValueOf(v).Int())
+	case uint64, uint32, uint16, uint8, uint:
+		u := reflect.ValueOf(v).Uint()
+		if u > math.MaxInt64 {
+			return w.writeBulk(typeBulkStringsSign, strconv.FormatUint(u, 10))
+		}
+		return w.writeHeader(typeIntSign, int64(u))
+	case bool:
+		if w.proto < Proto3 {
+			if v {
+				return w.writeHeader(typeIntSign, 1)
+			}
+			return w.writeHeader(typeIntSign, 0)
+		}
+		if v {
+			return w.writeSimple(typeBoolSign, "t")
+		}
+		return w.writeSimple(typeBoolSign, "f")
+	case float64, float32:
+		f := FormatDouble(reflect.ValueOf(v).Float())
+		if w.proto < Proto3 {
+			return w.writeBulk(typeBulkStringsSign, f)
+		}
+		return w.writeSimple(typeDoublesSign, f)
+	case *big.Int:
+		if w.proto < Proto3 {
+			return w.writeBulk(typeBulkStringsSign, v.String())
+		}
+		return w.writeSimple(typeBigNumbersSign, v.String())
+	default:
+		if w.unsupported == nil {
+			w.unsupported = fmt.Errorf("%w %T", ErrUnsupportedType, data)
+		}
+		return w.writeSimple(typeErrSign, fmt.Sprintf("ERR unsupported reply type %T", data))
+	}
+}
+
+// FormatDouble 按Redis的格式输出浮点数，无穷大输出为inf/-inf。
+// 与%.17g相同，十进制指数小于-4或不小于17时使用科学计数法，有效数字取能还原该值的最短形式
+func FormatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-4 || abs >= 1e17) {
+		return strconv.FormatFloat(f, 'e', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// writeHeader 写入"<sign><n>\r\n"
+func (w *Writer) writeHeader(sign byte, n int64) error {
+	w.num = append(w.num[:0], sign)
+	w.num = strconv.AppendInt(w.num, n, 10)
+	w.num = append(w.num, '\r', '\n')
+	_, err := w.wr.Write(w.num)
+	return err
+}
+
+// writeNull 写入空值，RESP2下根据sign区分空批量字符串与空数组
+func (w *Writer) writeNull(sign byte) error {
+	if w.proto >= Proto3 {
+		_, err := w.wr.WriteString("_\r\n")
+		return err
+	}
+	return w.writeHeader(sign, -1)
+}
+
+// writeSimple 写入简单类型，内容中的换行会被替换为空格以免破坏协议
+func (w *Writer) writeSimple(sign byte, s string) error {
+	if strings.ContainsAny(s, "\r\n") {
+		s = strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+	}
+	if err := w.wr.WriteByte(sign); err != nil {
+		return err
+	}
+	if _, err := w.wr.WriteString(s); err != nil {
+		return err
+	}
+	_, err := w.wr.WriteString("\r\n")
+	return err
+}
+
+// writeBulk 写入带长度前缀的类型
+func (w *Writer) writeBulk(sign byte, s string) error {
+	if err := w.writeHeader(sign, int64(len(s))); err != nil {
+		return err
+	}
+	if _, err := w.wr.WriteString(s); err != nil {
+		return err
+	}
+	_, err := w.wr.WriteString("\r\n")
+	return err
+}
@@ -0,0 +1,235 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+
+	gttype "github.com/BeginerAndProgresses/generalized-tools/type"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriter_WriteValue(t *testing.T) {
+	testCases := []struct {
+		name   string
+		proto  int
+		data   any
+		res    []byte
+		before func() any
+	}{
+		{
+			name: "测试Array",
+			data: Array{BulkStrings("3"), BulkStrings("2324")},
+			res:  []byte("*2\r\n$1\r\n3\r\n$4\r\n2324\r\n"),
+		},
+		{
+			name: "测试空Array",
+			data: Array{},
+			res:  []byte("*0\r\n"),
+		},
+		{
+			name: "测试Nil Array",
+			data: Array(nil),
+			res:  []byte("*-1\r\n"),
+		},
+		{
+			name: "测试BulkString",
+			data: BulkStrings("232"),
+			res:  []byte("$3\r\n232\r\n"),
+		},
+		{
+			name: "测试[]byte",
+			data: []byte("a\r\nb"),
+			res:  []byte("$4\r\na\r\nb\r\n"),
+		},
+		{
+			name: "测试RESP2 Nil",
+			data: nil,
+			res:  []byte("$-1\r\n"),
+		},
+		{
+			name:  "测试RESP3 Nil",
+			proto: Proto3,
+			data:  nil,
+			res:   []byte("_\r\n"),
+		},
+		{
+			name: "测试简单String",
+			data: "OK",
+			res:  []byte("+OK\r\n"),
+		},
+		{
+			name: "测试Error",
+			data: errors.New("ERR bad\r\nline"),
+			res:  []byte("-ERR bad  line\r\n"),
+		},
+		{
+			name: "测试Int",
+			data: -2324,
+			res:  []byte(":-2324\r\n"),
+		},
+		{
+			name: "测试RESP2 Bool",
+			data: true,
+			res:  []byte(":1\r\n"),
+		},
+		{
+			name:  "测试RESP3 Bool",
+			proto: Proto3,
+			data:  false,
+			res:   []byte("#f\r\n"),
+		},
+		{
+			name: "测试RESP2 Double",
+			data: 1.5,
+			res:  []byte("$3\r\n1.5\r\n"),
+		},
+		{
+			name:  "测试RESP3 Double",
+			proto: Proto3,
+			data:  math.Inf(-1),
+			res:   []byte(",-inf\r\n"),
+		},
+		{
+			name:  "测试RESP3 Map",
+			proto: Proto3,
+			data:  Maps{"first": int64(1)},
+			res:   []byte("%1\r\n+first\r\n:1\r\n"),
+		},
+		{
+			name: "测试RESP2 Map",
+			data: Maps{BulkStrings("first"): int64(1)},
+			res:  []byte("*2\r\n$5\r\nfirst\r\n:1\r\n"),
+		},
+		{
+			name:  "测试RESP3 Set",
+			proto: Proto3,
+			res:   []byte("~1\r\n$3\r\nfoo\r\n"),
+			before: func() any {
+				return gttype.NewHashSet[any]().Add(BulkStrings("foo"))
+			},
+		},
+		{
+			name: "测试RESP2 Push",
+			res:  []byte("*2\r\n$7\r\nmessage\r\n$1\r\na\r\n"),
+			before: func() any {
+				ps := gttype.NewHeap[any]()
+				ps.Insert(BulkStrings("message"))
+				ps.Insert(BulkStrings("a"))
+				return ps
+			},
+		},
+		{
+			name:  "测试RESP3 Push",
+			proto: Proto3,
+			res:   []byte(">1\r\n$7\r\nmessage\r\n"),
+			before: func() any {
+				ps := gttype.NewHeap[any]()
+				ps.Insert(BulkStrings("message"))
+				return ps
+			},
+		},
+		{
+			name:  "测试RESP3 Verbatim",
+			proto: Proto3,
+			data:  Verbatim{Coding: "txt", Data: []byte("Some string")},
+			res:   []byte("=15\r\ntxt:Some string\r\n"),
+		},
+		{
+			name: "测试RESP2 Verbatim",
+			data: Verbatim{Coding: "txt", Data: []byte("Some string")},
+			res:  []byte("$11\r\nSome string\r\n"),
+		},
+		{
+			name:  "测试RESP3 BulkErr",
+			proto: Proto3,
+			data:  MultiErr{err: "ERR"},
+			res:   []byte("!3\r\nERR\r\n"),
+		},
+		{
+			name:  "测试RESP3 大数",
+			proto: Proto3,
+			res:   []byte("(3492890328409238509324850943850943825024385\r\n"),
+			before: func() any {
+				b, _ := new(big.Int).SetString("3492890328409238509324850943850943825024385", 10)
+				return b
+			},
+		},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			if v.before != nil {
+				v.data = v.before()
+			}
+			var buf bytes.Buffer
+			w := NewWriter(&buf)
+			if v.proto != 0 {
+				w.SetProtocol(v.proto)
+			}
+			assert.NoError(t, w.WriteValue(v.data))
+			assert.Equal(t, 0, buf.Len(), "Flush之前不应写出")
+			assert.NoError(t, w.Flush())
+			assert.Equal(t, string(v.res), buf.String(), "結果應該相同")
+		})
+	}
+}
+
+func TestWriter_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetProtocol(Proto3)
+	data := Array{BulkStrings("set"), int64(1), 1.25, true, nil, Maps{"k": BulkStrings("v")}}
+	assert.NoError(t, w.WriteValue(data))
+	assert.NoError(t, w.Flush())
+	res, err := NewReader(&buf).ReadValue()
+	assert.NoError(t, err)
+	assert.Equal(t, data, res)
+}
+
+func TestWriter_Unsupported(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	// 不支持的元素以错误回复代替，数组的元素个数与流保持一致
+	err := w.WriteValue(Array{BulkStrings("a"), struct{}{}, int64(1)})
+	assert.ErrorIs(t, err, ErrUnsupportedType)
+	assert.NoError(t, w.Flush())
+	assert.Equal(t, "*3\r\n$1\r\na\r\n-ERR unsupported reply type struct {}\r\n:1\r\n", buf.String())
+
+	// 错误只对当次编码有效
+	buf.Reset()
+	assert.NoError(t, w.WriteValue(int64(2)))
+	assert.NoError(t, w.Flush())
+	assert.Equal(t, ":2\r\n", buf.String())
+}
+
+func TestFormatDouble(t *testing.T) {
+	testCases := []struct {
+		name string
+		f    float64
+		res  string
+	}{
+		{name: "测试整数", f: 3, res: "3"},
+		{name: "测试小数", f: 1.25, res: "1.25"},
+		{name: "测试最短表示", f: 0.30000000000000004, res: "0.30000000000000004"},
+		{name: "测试负数", f: -2.5, res: "-2.5"},
+		{name: "测试零", f: 0, res: "0"},
+		{name: "测试指数下限", f: 0.0001, res: "0.0001"},
+		{name: "测试小于指数下限", f: 0.00001, res: "1e-05"},
+		{name: "测试指数上限", f: 1e16, res: "10000000000000000"},
+		{name: "测试不小于指数上限", f: 1e17, res: "1e+17"},
+		{name: "测试极大值", f: 1e300, res: "1e+300"},
+		{name: "测试极小值", f: -1.5e-300, res: "-1.5e-300"},
+		{name: "测试最大值", f: math.MaxFloat64, res: "1.7976931348623157e+308"},
+		{name: "测试最小非规格化数", f: math.SmallestNonzeroFloat64, res: "5e-324"},
+		{name: "测试正无穷", f: math.Inf(1), res: "inf"},
+		{name: "测试负无穷", f: math.Inf(-1), res: "-inf"},
+		{name: "测试NaN", f: math.NaN(), res: "nan"},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert.Equal(t, v.res, FormatDouble(v.f))
+		})
+	}
+}
@@ -0,0 +1,756 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+)
+
+const (
+	CommentBgRewriteAOF = "BGREWRITEAOF"
+)
+
+const (
+	defaultAppendFilename = "appendonly.aof"
+	defaultAppendDirname  = "appendonlydir"
+
+	// appendfsync策略：每条写命令后fsync、每秒fsync一次、交给操作系统决定
+	aofFsyncAlways   = "always"
+	aofFsyncEverysec = "everysec"
+	aofFsyncNo       = "no"
+
+	// aofRewriteItemsPerCmd 重写时每条命令最多包含的元素个数
+	aofRewriteItemsPerCmd = 64
+)
+
+// 多文件AOF的文件名为"<appendfilename>.<seq>.<base|incr>.<rdb|aof>"，
+// 清单文件为"<appendfilename>.manifest"，都位于appenddirname目录中
+const (
+	aofManifestSuffix = ".manifest"
+	aofBaseSuffix     = ".base"
+	aofIncrSuffix     = ".incr"
+	rdbFormatSuffix   = ".rdb"
+	aofFormatSuffix   = ".aof"
+
+	// 清单中的文件类型：基础文件、已被重写取代等待删除的历史文件、增量文件
+	aofTypeBase    = 'b'
+	aofTypeHistory = 'h'
+	aofTypeIncr    = 'i'
+)
+
+// aofRewriteResult 后台重写的结果，name为写好的基础文件名，seq为其序号
+type aofRewriteResult struct {
+	name string
+	seq  int64
+	err  error
+}
+
+// aofInfo 清单中的一个文件
+type aofInfo struct {
+	name string
+	seq  int64
+	typ  byte
+}
+
+// aofManifest 多文件AOF的清单：载入时先载入基础文件，再按序号依次载入增量文件。
+// 重写时写入新的基础文件，写命令改为追加到新的增量文件，完成后由清单原子地切换
+type aofManifest struct {
+	base    *aofInfo
+	incrs   []*aofInfo
+	history []*aofInfo
+	// currBaseSeq、currIncrSeq 最近使用的基础文件与增量文件序号
+	currBaseSeq int64
+	currIncrSeq int64
+}
+
+// parseAOFManifest 解析清单，每行形如"file appendonly.aof.1.base.rdb seq 1 type b"
+func parseAOFManifest(data []byte) (*aofManifest, error) {
+	am := &aofManifest{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields)%2 != 0 {
+			return nil, fmt.Errorf("invalid AOF manifest file format at line %d", i+1)
+		}
+		info := &aofInfo{}
+		for j := 0; j < len(fields); j += 2 {
+			// 忽略未知的字段，便于以后扩展
+			switch val := fields[j+1]; fields[j] {
+			case "file":
+				info.name = val
+			case "seq":
+				seq, err := strconv.ParseInt(val, 10, 64)
+				if err != nil || seq <= 0 {
+					return nil, fmt.Errorf("invalid AOF manifest seq at line %d", i+1)
+				}
+				info.seq = seq
+			case "type":
+				if len(val) != 1 {
+					return nil, fmt.Errorf("invalid AOF manifest type at line %d", i+1)
+				}
+				info.typ = val[0]
+			}
+		}
+		if info.name == "" || info.seq == 0 || info.typ == 0 {
+			return nil, fmt.Errorf("invalid AOF manifest file format at line %d", i+1)
+		}
+		switch info.typ {
+		case aofTypeBase:
+			if am.base != nil {
+				return nil, errors.New("found duplicate base file information in AOF manifest")
+			}
+			am.base = info
+			am.currBaseSeq = info.seq
+		case aofTypeHistory:
+			am.history = append(am.history, info)
+		case aofTypeIncr:
+			if info.seq <= am.currIncrSeq {
+				return nil, errors.New("found a non-monotonic sequence number in AOF manifest")
+			}
+			am.incrs = append(am.incrs, info)
+			am.currIncrSeq = info.seq
+		default:
+			return nil, fmt.Errorf("unknown AOF file type at line %d", i+1)
+		}
+	}
+	if am.base == nil && len(am.incrs) == 0 {
+		return nil, errors.New("found an empty AOF manifest")
+	}
+	return am, nil
+}
+
+// bytes 编码清单，依次为基础文件、历史文件与增量文件
+func (am *aofManifest) bytes() []byte {
+	var buf bytes.Buffer
+	write := func(info *aofInfo) {
+		fmt.Fprintf(&buf, "file %s seq %d type %c\n", info.name, info.seq, info.typ)
+	}
+	if am.base != nil {
+		write(am.base)
+	}
+	for _, info := range am.history {
+		write(info)
+	}
+	for _, info := range am.incrs {
+		write(info)
+	}
+	return buf.Bytes()
+}
+
+// clone 复制清单，修改副本并持久化成功后再替换当前的清单
+func (am *aofManifest) clone() *aofManifest {
+	c := *am
+	c.incrs = slices.Clone(am.incrs)
+	c.history = slices.Clone(am.history)
+	return &c
+}
+
+// newIncr 添加一个新的增量文件
+func (am *aofManifest) newIncr(prefix string) *aofInfo {
+	am.currIncrSeq++
+	info := &aofInfo{
+		name: fmt.Sprintf("%s.%d%s%s", prefix, am.currIncrSeq, aofIncrSuffix, aofFormatSuffix),
+		seq:  am.currIncrSeq,
+		typ:  aofTypeIncr,
+	}
+	am.incrs = append(am.incrs, info)
+	return info
+}
+
+// lastIncr 返回正在追加写入的增量文件
+func (am *aofManifest) lastIncr() *aofInfo {
+	if len(am.incrs) == 0 {
+		return nil
+	}
+	return am.incrs[len(am.incrs)-1]
+}
+
+// installBase 以新的基础文件取代旧的基础文件以及序号不大于incrSeq的增量文件，被取代的文件移入history
+func (am *aofManifest) installBase(name string, seq, incrSeq int64) {
+	if am.base != nil {
+		am.history = append(am.history, &aofInfo{name: am.base.name, seq: am.base.seq, typ: aofTypeHistory})
+	}
+	am.base = &aofInfo{name: name, seq: seq, typ: aofTypeBase}
+	am.currBaseSeq = seq
+	incrs := am.incrs[:0]
+	for _, info := range am.incrs {
+		if info.seq <= incrSeq {
+			am.history = append(am.history, &aofInfo{name: info.name, seq: info.seq, typ: aofTypeHistory})
+		} else {
+			incrs = append(incrs, info)
+		}
+	}
+	am.incrs = incrs
+}
+
+// countingReader 记录已从底层读取的字节数，用于计算已解码命令在文件中的偏移
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// catAppendOnlyCommand 将命令编码为批量字符串数组，与客户端发送命令的格式相同
+func catAppendOnlyCommand(w *resp.Writer, args []string) error {
+	if err := w.WriteArrayHeader(len(args)); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if err := w.WriteValue(resp.BulkStrings(arg)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// aofDir 返回多文件AOF所在的目录
+func (s *Service) aofDir() string {
+	return filepath.Join(s.Dir, s.AppendDirname)
+}
+
+// aofFilePath 返回AOF目录中文件的路径
+func (s *Service) aofFilePath(name string) string {
+	return filepath.Join(s.aofDir(), name)
+}
+
+// aofManifestPath 返回清单文件的路径
+func (s *Service) aofManifestPath() string {
+	return s.aofFilePath(s.AppendFilename + aofManifestSuffix)
+}
+
+// aofBaseName 返回序号为seq的基础文件名
+func (s *Service) aofBaseName(seq int64) string {
+	format := rdbFormatSuffix
+	if s.AOFDisableRDBPreamble {
+		format = aofFormatSuffix
+	}
+	return fmt.Sprintf("%s.%d%s%s", s.AppendFilename, seq, aofBaseSuffix, format)
+}
+
+// readAOFManifest 读取清单文件，清单不存在但有旧版本的单个AOF文件时，
+// 先将其移入AOF目录作为基础文件。都不存在时返回os.ErrNotExist
+func (s *Service) readAOFManifest() (*aofManifest, error) {
+	data, err := os.ReadFile(s.aofManifestPath())
+	if err == nil {
+		return parseAOFManifest(data)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	legacy := filepath.Join(s.Dir, s.AppendFilename)
+	if _, err := os.Stat(legacy); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.aofDir(), 0755); err != nil {
+		return nil, err
+	}
+	// 先持久化清单再移动文件，中途失败时重启会报告文件缺失而不是静默地丢失数据
+	am := &aofManifest{base: &aofInfo{name: s.AppendFilename, seq: 1, typ: aofTypeBase}, currBaseSeq: 1}
+	if err := s.persistAOFManifest(am); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(legacy, s.aofFilePath(s.AppendFilename)); err != nil {
+		return nil, err
+	}
+	slog.Info("successfully migrated an old-style AOF into the AOF directory", "dir", s.aofDir())
+	return am, nil
+}
+
+// persistAOFManifest 原子地替换清单文件
+func (s *Service) persistAOFManifest(am *aofManifest) error {
+	return writeFileAtomic(s.aofManifestPath(), "temp-*.manifest", func(w io.Writer) error {
+		_, err := w.Write(am.bytes())
+		return err
+	})
+}
+
+// openAppendOnlyFile 开启AOF时打开最后一个增量文件用于追加写入，在载入数据之后、接受连接之前调用。
+// 首次开启AOF时先将当前数据写为基础文件，没有增量文件时创建新的增量文件
+func (s *Service) openAppendOnlyFile() error {
+	if !s.AppendOnly {
+		return nil
+	}
+	if err := os.MkdirAll(s.aofDir(), 0755); err != nil {
+		return err
+	}
+	am := s.aofManifest
+	if am == nil {
+		am = &aofManifest{}
+		name := s.aofBaseName(1)
+		if err := s.writeAOFBase(s.aofFilePath(name), s.db.snapshot(false)); err != nil {
+			return err
+		}
+		am.installBase(name, 1, 0)
+	} else {
+		am = am.clone()
+	}
+	incr := am.lastIncr()
+	if incr == nil {
+		incr = am.newIncr(s.AppendFilename)
+	}
+	f, err := os.OpenFile(s.aofFilePath(incr.name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if err := s.persistAOFManifest(am); err != nil {
+		f.Close()
+		return err
+	}
+	s.aofManifest = am
+	s.aofFile = f
+	s.aofLastFsync = time.Now()
+	s.deleteAOFHistoryFiles()
+	// 复制偏移量在有积压缓冲区时才会前进，WAITAOF依据偏移量判断写命令是否已fsync
+	s.createReplBacklog()
+	return nil
+}
+
+// openNewIncrAOF 创建新的增量文件并写入清单，之后的写命令追加到新文件
+func (s *Service) openNewIncrAOF() error {
+	am := s.aofManifest.clone()
+	incr := am.newIncr(s.AppendFilename)
+	f, err := os.OpenFile(s.aofFilePath(incr.name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if err := s.persistAOFManifest(am); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	// 旧文件写完并fsync后再切换，未能写出的部分留在缓冲区中写入新文件
+	s.flushAppendOnlyFile(true)
+	if err := s.aofFile.Close(); err != nil {
+		slog.Error("close AOF file error", "err", err)
+	}
+	s.aofManifest = am
+	s.aofFile = f
+	return nil
+}
+
+// deleteAOFHistoryFiles 删除已被重写取代的文件并从清单中移除
+func (s *Service) deleteAOFHistoryFiles() {
+	am := s.aofManifest
+	if am == nil || len(am.history) == 0 {
+		return
+	}
+	for _, info := range am.history {
+		if err := os.Remove(s.aofFilePath(info.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Error("remove AOF history file error", "file", info.name, "err", err)
+		}
+	}
+	am = am.clone()
+	am.history = nil
+	if err := s.persistAOFManifest(am); err != nil {
+		slog.Error("persist AOF manifest error", "err", err)
+		return
+	}
+	s.aofManifest = am
+}
+
+// feedAppendOnlyFile 将命令编码到AOF缓冲区，命令回复客户端之前由flushAppendOnlyFile写入文件
+func (s *Service) feedAppendOnlyFile(args []string) {
+	if s.aofFile == nil {
+		return
+	}
+	catAppendOnlyCommand(s.aofWr, args)
+	s.aofWr.Flush()
+}
+
+// flushAppendOnlyFile 将缓冲区写入AOF文件并按appendfsync策略fsync，force为true时总是同步fsync。
+// 写入或fsync失败时保留未写出的部分与未同步的标记，由serverCron重试，期间拒绝写命令
+func (s *Service) flushAppendOnlyFile(force bool) {
+	if s.aofFile == nil {
+		return
+	}
+	if s.aofBuf.Len() > 0 {
+		n, err := s.aofFile.Write(s.aofBuf.Bytes())
+		s.aofBuf.Next(n)
+		if n > 0 {
+			s.aofUnsynced = true
+		}
+		if err != nil {
+			if s.aofLastWriteErr == nil {
+				slog.Error("error writing to the AOF file", "err", err)
+			}
+			s.aofLastWriteErr = err
+			return
+		}
+		if s.aofLastWriteErr != nil {
+			slog.Info("AOF write error looks solved, can write again")
+			s.aofLastWriteErr = nil
+		}
+	}
+	if !s.aofUnsynced {
+		// 已写入的数据都已fsync，此后偏移量的增长不涉及AOF
+		if s.aofFsyncCh == nil {
+			s.aofFsyncedOffset = s.masterReplOffset
+		}
+		return
+	}
+	switch {
+	case force || s.AppendFsync == aofFsyncAlways:
+		// 等待进行中的后台fsync，之后的fsync才能覆盖全部已写入的数据
+		if s.aofFsyncCh != nil {
+			s.aofFsyncDone(<-s.aofFsyncCh)
+		}
+		if err := s.aofFile.Sync(); err != nil {
+			if s.aofLastWriteErr == nil {
+				slog.Error("can't fsync the AOF file", "err", err)
+			}
+			s.aofLastWriteErr = err
+			return
+		}
+		s.aofUnsynced = false
+		s.aofLastFsync = time.Now()
+		s.aofFsyncedOffset = s.masterReplOffset
+		s.aofFsyncSucceeded()
+	case s.AppendFsync == aofFsyncEverysec && s.aofFsyncCh == nil && time.Since(s.aofLastFsync) >= time.Second:
+		s.aofBackgroundFsync()
+	}
+}
+
+// aofBackgroundFsync 在后台goroutine中fsync，避免阻塞事件循环
+func (s *Service) aofBackgroundFsync() {
+	s.aofUnsynced = false
+	s.aofLastFsync = time.Now()
+	s.aofFsyncingOffset = s.masterReplOffset
+	ch := make(chan error, 1)
+	s.aofFsyncCh = ch
+	f := s.aofFile
+	go func() {
+		ch <- f.Sync()
+	}()
+}
+
+// aofFsyncDone 后台fsync结束后在事件循环中调用，失败时恢复未同步的标记，由serverCron再次fsync
+func (s *Service) aofFsyncDone(err error) {
+	s.aofFsyncCh = nil
+	if err != nil {
+		if s.aofLastWriteErr == nil {
+			slog.Error("can't fsync the AOF file in background", "err", err)
+		}
+		s.aofUnsynced = true
+		s.aofLastWriteErr = err
+		return
+	}
+	s.aofFsyncedOffset = s.aofFsyncingOffset
+	s.aofFsyncSucceeded()
+}
+
+// aofFsyncSucceeded fsync成功且缓冲区已全部写出时，清除此前写入或fsync失败的错误
+func (s *Service) aofFsyncSucceeded() {
+	if s.aofLastWriteErr != nil && s.aofBuf.Len() == 0 {
+		slog.Info("AOF fsync error looks solved, can write again")
+		s.aofLastWriteErr = nil
+	}
+}
+
+// stopAppendOnly 写出缓冲区、fsync并关闭AOF文件
+func (s *Service) stopAppendOnly() {
+	if s.aofFile == nil {
+		return
+	}
+	s.flushAppendOnlyFile(true)
+	if err := s.aofFile.Close(); err != nil {
+		slog.Error("close AOF file error", "err", err)
+	}
+	s.aofFile = nil
+}
+
+// loadAppendOnlyFiles 按清单依次载入基础文件与增量文件，没有AOF时返回os.ErrNotExist
+func (s *Service) loadAppendOnlyFiles() error {
+	am, err := s.readAOFManifest()
+	if err != nil {
+		return err
+	}
+	var files []*aofInfo
+	if am.base != nil {
+		files = append(files, am.base)
+	}
+	files = append(files, am.incrs...)
+	for i, info := range files {
+		// 只有最后一个文件允许末尾不完整，之前的文件在写入新文件前都已完整写出
+		if err := s.loadSingleAppendOnlyFile(s.aofFilePath(info.name), i == len(files)-1); err != nil {
+			// 不使用%w，清单中的文件缺失不能被当作没有AOF
+			return fmt.Errorf("%s: %v", info.name, err)
+		}
+	}
+	s.aofManifest = am
+	s.db.dirty = 0
+	return nil
+}
+
+// loadSingleAppendOnlyFile 载入一个AOF文件：以"REDIS"开头的部分按RDB载入，
+// 之后的命令按顺序重放，与客户端的命令走相同的执行路径。
+// last为true时文件末尾不完整的命令（例如写入时宕机）与未结束的事务会被截掉，否则返回错误
+func (s *Service) loadSingleAppendOnlyFile(path string, last bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	cr := &countingReader{r: f}
+	br := bufio.NewReader(cr)
+	if sig, _ := br.Peek(5); string(sig) == "REDIS" {
+		// 之后的增量命令可能修改已过期的键，保留它们，由载入完成后的过期处理删除
+		if err := rdbRead(br, func(kv rdbKeyValue) { s.db.loadKeyValue(kv, -1) }); err != nil {
+			return err
+		}
+	}
+
+	s.loading = true
+	defer func() { s.loading = false }()
+	fake := &Peer{proto: resp.Proto2}
+	rd := resp.NewReader(br)
+	// valid 最后一条完整命令结束处的偏移，multiStart 未结束事务的MULTI所在的偏移
+	valid := cr.n - int64(br.Buffered())
+	multiStart := valid
+	truncated := false
+	for {
+		args, err := rd.ReadCommand()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			truncated = true
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("bad file format reading the append only file at offset %d: %w", valid, err)
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if lookupCommand(args[0]) == nil {
+			return fmt.Errorf("unknown command '%s' reading the append only file", args[0])
+		}
+		if fake.mstate == nil {
+			multiStart = valid
+		}
+		s.processCommand(fake, args)
+		// 数据与写入时相同，命令不会阻塞，以防万一仍解除阻塞
+		s.unblockPeer(fake)
+		valid = cr.n - int64(br.Buffered())
+	}
+	if fake.mstate != nil {
+		slog.Warn("revert incomplete MULTI/EXEC transaction in AOF file", "offset", multiStart)
+		s.discardTransaction(fake)
+		valid, truncated = multiStart, true
+	}
+	if !truncated {
+		return nil
+	}
+	if !last {
+		return fmt.Errorf("unexpected end of file at offset %d", valid)
+	}
+	slog.Warn("short read while loading the AOF file, truncating", "file", path, "offset", valid)
+	return os.Truncate(path, valid)
+}
+
+// rewriteAppendOnlyFileBackground 开启AOF时先切换到新的增量文件，再在事件循环中生成快照，
+// 由后台goroutine写为新的基础文件，完成后通过aofRewriteCh通知事件循环
+func (s *Service) rewriteAppendOnlyFileBackground() error {
+	if err := os.MkdirAll(s.aofDir(), 0755); err != nil {
+		return err
+	}
+	if s.aofManifest == nil {
+		am, err := s.readAOFManifest()
+		if errors.Is(err, os.ErrNotExist) {
+			am, err = &aofManifest{}, nil
+		}
+		if err != nil {
+			return err
+		}
+		s.aofManifest = am
+	}
+	// 此前的增量文件都将被新的基础文件取代
+	s.aofRewriteIncrSeq = s.aofManifest.currIncrSeq
+	if s.aofFile != nil {
+		if err := s.openNewIncrAOF(); err != nil {
+			return err
+		}
+	}
+	entries := s.backgroundSnapshot()
+	seq := s.aofManifest.currBaseSeq + 1
+	name := s.aofBaseName(seq)
+	path := s.aofFilePath(name)
+	ch := make(chan aofRewriteResult, 1)
+	s.aofRewriteCh = ch
+	go func() {
+		ch <- aofRewriteResult{name: name, seq: seq, err: s.writeAOFBase(path, entries)}
+	}()
+	slog.Info("background append only file rewriting started")
+	return nil
+}
+
+// writeAOFBase 将快照写为基础文件，默认为RDB格式，关闭RDB前导时写为能重建数据的最少命令
+func (s *Service) writeAOFBase(path string, entries []rdbKeyValue) error {
+	if !s.AOFDisableRDBPreamble {
+		return writeFileAtomic(path, "temp-rewriteaof-bg-*.aof", func(w io.Writer) error {
+			return rdbWrite(w, entries, true)
+		})
+	}
+	return writeFileAtomic(path, "temp-rewriteaof-bg-*.aof", func(w io.Writer) error {
+		rw := resp.NewWriter(w)
+		for _, kv := range entries {
+			if err := rewriteKeyValue(rw, kv); err != nil {
+				return err
+			}
+		}
+		return rw.Flush()
+	})
+}
+
+// rewriteKeyValue 写入重建一个键所需的命令
+func rewriteKeyValue(w *resp.Writer, kv rdbKeyValue) error {
+	var err error
+	switch v := kv.val.(type) {
+	case []byte:
+		err = catAppendOnlyCommand(w, []string{CommentSet, kv.key, string(v)})
+	case *quicklist:
+		items := make([]string, 0, v.len())
+		v.iterRange(0, v.len()-1, func(item string) bool {
+			items = append(items, item)
+			return true
+		})
+		err = rewriteItems(w, CommentRPush, kv.key, items, 1)
+	case *setObj:
+		err = rewriteItems(w, CommentSAdd, kv.key, v.members(), 1)
+	case *zsetObj:
+		items := make([]string, 0, 2*v.size())
+		for x := v.zsl.tail; x != nil; x = x.backward {
+			items = append(items, resp.FormatDouble(x.score), x.member)
+		}
+		err = rewriteItems(w, CommentZAdd, kv.key, items, 2)
+	case hashObj:
+		items := make([]string, 0, 2*len(v))
+		for field, value := range v {
+			items = append(items, field, value)
+		}
+		err = rewriteItems(w, CommentHSet, kv.key, items, 2)
+	case *stream:
+		err = rewriteStream(w, kv.key, v)
+	}
+	if err != nil || kv.expire < 0 {
+		return err
+	}
+	return catAppendOnlyCommand(w, []string{CommentPExpireAt, kv.key, strconv.FormatInt(kv.expire, 10)})
+}
+
+// rewriteItems 将元素分批写为"cmd key item ..."命令，width为每个元素占用的参数个数
+func rewriteItems(w *resp.Writer, cmd, key string, items []string, width int) error {
+	for len(items) > 0 {
+		n := min(len(items), aofRewriteItemsPerCmd*width)
+		if err := catAppendOnlyCommand(w, append([]string{cmd, key}, items[:n]...)); err != nil {
+			return err
+		}
+		items = items[n:]
+	}
+	return nil
+}
+
+// rewriteStream 写入重建流所需的命令：逐条XADD，XSETID恢复曾添加过的最大ID，
+// 再依次重建消费者组、消费者以及待确认条目
+func rewriteStream(w *resp.Writer, key string, st *stream) error {
+	var cmds [][]string
+	if st.length > 0 {
+		for _, chunk := range st.chunks {
+			for _, e := range chunk.entries {
+				cmds = append(cmds, append([]string{CommentXAdd, key, e.id.String()}, e.fields...))
+			}
+		}
+	} else {
+		// 空流通过添加一个条目并立即按MAXLEN 0裁剪来创建
+		cmds = append(cmds, []string{CommentXAdd, key, "MAXLEN", "0", "0-1", "x", "y"})
+	}
+	cmds = append(cmds, []string{CommentXSetID, key, st.lastID.String(),
+		"ENTRIESADDED", strconv.FormatUint(st.entriesAdded, 10),
+		"MAXDELETEDID", st.maxDeletedID.String()})
+	for _, name := range sortedGroupNames(st.groups) {
+		cg := st.groups[name]
+		cmds = append(cmds, []string{CommentXGroup, "CREATE", key, name, cg.lastID.String()})
+		names := make([]string, 0, len(cg.consumers))
+		for cname := range cg.consumers {
+			names = append(names, cname)
+		}
+		slices.Sort(names)
+		for _, cname := range names {
+			c := cg.consumers[cname]
+			cmds = append(cmds, []string{CommentXGroup, "CREATECONSUMER", key, name, cname})
+			c.pel.ascend(streamMinID, func(id streamID, nack *streamNACK) bool {
+				cmds = append(cmds, []string{CommentXClaim, key, name, cname, "0", id.String(),
+					"TIME", strconv.FormatInt(nack.deliveryTime, 10),
+					"RETRYCOUNT", strconv.FormatInt(nack.deliveryCount, 10),
+					"JUSTID", "FORCE"})
+				return true
+			})
+		}
+	}
+	for _, argv := range cmds {
+		if err := catAppendOnlyCommand(w, argv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backgroundRewriteDone 后台重写结束后在事件循环中调用，通过原子地替换清单切换到新的基础文件
+func (s *Service) backgroundRewriteDone(res aofRewriteResult) {
+	s.aofRewriteCh = nil
+	s.db.releaseSnapshot()
+	err := res.err
+	if err == nil {
+		am := s.aofManifest.clone()
+		am.installBase(res.name, res.seq, s.aofRewriteIncrSeq)
+		if err = s.persistAOFManifest(am); err == nil {
+			s.aofManifest = am
+		} else {
+			os.Remove(s.aofFilePath(res.name))
+		}
+	}
+	s.lastAofRewriteErr = err
+	if err != nil {
+		slog.Error("background AOF rewrite error", "err", err)
+		return
+	}
+	s.deleteAOFHistoryFiles()
+	slog.Info("background AOF rewrite terminated with success")
+}
+
+func init() {
+	registerCommand(
+		&command{name: CommentBgRewriteAOF, arity: 1, flags: cmdAdmin, proc: bgrewriteaofCommand},
+	)
+}
+
+// bgrewriteaofCommand BGREWRITEAOF
+func bgrewriteaofCommand(s *Service, p *Peer, args []string) any {
+	if s.aofRewriteCh != nil {
+		return errors.New("ERR Background append only file rewriting already in progress")
+	}
+	if err := s.rewriteAppendOnlyFileBackground(); err != nil {
+		return fmt.Errorf("ERR %v", err)
+	}
+	return "Background append only file rewriting started"
+}
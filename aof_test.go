@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+	"github.com/stretchr/testify/assert"
+)
+
+// readAOFCommands 读取AOF文件中的全部命令
+func readAOFCommands(t *testing.T, path string) [][]string {
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	rd := resp.NewReader(f)
+	var cmds [][]string
+	for {
+		args, err := rd.ReadCommand()
+		if err == io.EOF {
+			return cmds
+		}
+		assert.NoError(t, err)
+		cmds = append(cmds, args)
+	}
+}
+
+// newAOFService 创建开启AOF的Service，载入已有的AOF并打开增量文件
+func newAOFService(t *testing.T, cfg Config) (*Service, *Peer) {
+	cfg.AppendOnly = true
+	cfg.AppendFsync = aofFsyncAlways
+	s := NewService(cfg)
+	assert.NoError(t, s.loadDataFromDisk())
+	assert.NoError(t, s.openAppendOnlyFile())
+	t.Cleanup(s.stopAppendOnly)
+	p, _ := newTestPeer(s)
+	return s, p
+}
+
+// aofIncrPath 返回正在追加写入的增量文件
+func aofIncrPath(s *Service) string {
+	return s.aofFilePath(s.aofManifest.lastIncr().name)
+}
+
+func TestAOFPropagate(t *testing.T) {
+	s, p := newAOFService(t, Config{Dir: t.TempDir()})
+	doCommand(s, p, "SET", "k", "v")
+	doCommand(s, p, "GET", "k")
+	// 没有修改数据的写命令不传播
+	doCommand(s, p, "DEL", "none")
+	doCommand(s, p, "SET", "t", "v", "EX", "100")
+	doCommand(s, p, "SADD", "s", "a")
+	doCommand(s, p, "SPOP", "s")
+	doCommand(s, p, "MULTI")
+	doCommand(s, p, "INCR", "n")
+	doCommand(s, p, "INCR", "n")
+	doCommand(s, p, "EXEC")
+	doCommand(s, p, "EXPIRE", "k", "-1")
+	doCommand(s, p, "INCRBYFLOAT", "f", "0.1")
+	id := doCommand(s, p, "XADD", "x", "*", "f", "v")
+
+	cmds := readAOFCommands(t, aofIncrPath(s))
+	when := s.db.getExpire("t")
+	assert.Equal(t, [][]string{
+		{"SET", "k", "v"},
+		{"SET", "t", "v", "PXAT", strconv.FormatInt(when, 10)},
+		{"SADD", "s", "a"},
+		{"SREM", "s", "a"},
+		{"MULTI"},
+		{"INCR", "n"},
+		{"INCR", "n"},
+		{"EXEC"},
+		{"DEL", "k"},
+		{"SET", "f", "0.1", "KEEPTTL"},
+		{"XADD", "x", string(id.(resp.BulkStrings)), "f", "v"},
+	}, cmds)
+
+	// 过期删除的键以DEL传播
+	doCommand(s, p, "SET", "e", "v", "PX", "1")
+	s.db.setExpire("e", 1)
+	doCommand(s, p, "GET", "e")
+	cmds = readAOFCommands(t, aofIncrPath(s))
+	assert.Equal(t, []string{"DEL", "e"}, cmds[len(cmds)-1])
+}
+
+func TestAOFFsyncError(t *testing.T) {
+	s, p := newAOFService(t, Config{Dir: t.TempDir()})
+	// 管道可以写入但不能fsync
+	r, w, err := os.Pipe()
+	assert.NoError(t, err)
+	defer r.Close()
+	defer w.Close()
+	f := s.aofFile
+	s.aofFile = w
+	runCommand(s, p, "SET", "k", "v")
+	assert.Error(t, s.aofLastWriteErr)
+	assert.True(t, s.aofUnsynced)
+	assert.ErrorContains(t, doCommand(s, p, "SET", "k", "v2").(error), "MISCONF")
+
+	// serverCron重试fsync，成功后恢复写命令
+	s.aofFile = f
+	s.serverCron()
+	assert.NoError(t, s.aofLastWriteErr)
+	assert.False(t, s.aofUnsynced)
+	assert.Equal(t, "OK", doCommand(s, p, "SET", "k", "v2"))
+}
+
+func TestAOFReplay(t *testing.T) {
+	dir := t.TempDir()
+	s, p := newAOFService(t, Config{Dir: dir})
+	doCommand(s, p, "SET", "k", "v", "EX", "100")
+	doCommand(s, p, "RPUSH", "l", "a", "b", "c")
+	doCommand(s, p, "LPOP", "l")
+	doCommand(s, p, "HSET", "h", "f", "v")
+	doCommand(s, p, "ZADD", "z", "1.5", "m")
+	doCommand(s, p, "XADD", "x", "*", "f", "v")
+	doCommand(s, p, "XGROUP", "CREATE", "x", "g", "0")
+	s.stopAppendOnly()
+
+	s2, p2 := newAOFService(t, Config{Dir: dir})
+	assert.Equal(t, []byte("v"), doCommand(s2, p2, "GET", "k"))
+	assert.Equal(t, s.db.getExpire("k"), s2.db.getExpire("k"))
+	assert.Equal(t, bulks("b", "c"), doCommand(s2, p2, "LRANGE", "l", "0", "-1"))
+	assert.Equal(t, resp.BulkStrings("v"), doCommand(s2, p2, "HGET", "h", "f"))
+	assert.Equal(t, 1.5, doCommand(s2, p2, "ZSCORE", "z", "m"))
+	assert.Equal(t, doCommand(s, p, "XRANGE", "x", "-", "+"), doCommand(s2, p2, "XRANGE", "x", "-", "+"))
+	assert.Equal(t, int64(0), s2.db.dirtyCount())
+
+	// 载入期间执行的命令不会再次写入AOF
+	assert.Len(t, readAOFCommands(t, aofIncrPath(s2)), 7)
+}
+
+func TestAOFReplayExpired(t *testing.T) {
+	dir := t.TempDir()
+	s, p := newAOFService(t, Config{Dir: dir})
+	doCommand(s, p, "SET", "k", "5", "PX", "150")
+	doCommand(s, p, "INCR", "k")
+	s.stopAppendOnly()
+	time.Sleep(200 * time.Millisecond)
+
+	// 截止时间在重启前已过，重放时仍在原来的值上执行，载入完成后才过期
+	s2, p2 := newAOFService(t, Config{Dir: dir})
+	assert.Equal(t, s.db.getExpire("k"), s2.db.getExpire("k"))
+	assert.Equal(t, nil, doCommand(s2, p2, "GET", "k"))
+	assert.Equal(t, int64(-2), doCommand(s2, p2, "PTTL", "k"))
+}
+
+func TestAOFTruncated(t *testing.T) {
+	complete := "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n"
+	partial := "*3\r\n$3\r\nSET\r\n$1\r\nb"
+	testCases := []struct {
+		name string
+		// incrs 各个增量文件的内容
+		incrs []string
+		// size 载入后最后一个文件的长度，-1表示载入失败
+		size int
+		keys int64
+	}{
+		{name: "测试完整文件", incrs: []string{complete}, size: len(complete), keys: 1},
+		{name: "测试末尾命令不完整", incrs: []string{complete + partial}, size: len(complete), keys: 1},
+		{name: "测试末尾事务未结束", incrs: []string{complete + "*1\r\n$5\r\nMULTI\r\n*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$1\r\n2\r\n"}, size: len(complete), keys: 1},
+		{name: "测试多个文件", incrs: []string{complete, "", partial}, size: 0, keys: 1},
+		{name: "测试中间的文件不完整", incrs: []string{complete + partial, complete}, size: -1},
+		{name: "测试格式错误", incrs: []string{"*1\r\n+SET\r\n" + complete}, size: -1},
+		{name: "测试未知命令", incrs: []string{"*1\r\n$3\r\nFOO\r\n"}, size: -1},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			dir := t.TempDir()
+			aofDir := filepath.Join(dir, defaultAppendDirname)
+			assert.NoError(t, os.Mkdir(aofDir, 0755))
+			var manifest, last string
+			for i, data := range v.incrs {
+				last = filepath.Join(aofDir, fmt.Sprintf("appendonly.aof.%d.incr.aof", i+1))
+				assert.NoError(t, os.WriteFile(last, []byte(data), 0644))
+				manifest += fmt.Sprintf("file appendonly.aof.%d.incr.aof seq %d type i\n", i+1, i+1)
+			}
+			assert.NoError(t, os.WriteFile(filepath.Join(aofDir, "appendonly.aof.manifest"), []byte(manifest), 0644))
+			s := NewService(Config{Dir: dir, AppendOnly: true})
+			err := s.loadDataFromDisk()
+			if v.size < 0 {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			p, _ := newTestPeer(s)
+			assert.Equal(t, v.keys, doCommand(s, p, "EXISTS", "a", "b"))
+			fi, err := os.Stat(last)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(v.size), fi.Size())
+		})
+	}
+}
+
+func TestAOFManifest(t *testing.T) {
+	testCases := []struct {
+		name string
+		data string
+		err  bool
+	}{
+		{name: "测试正常清单", data: "file appendonly.aof.1.base.rdb seq 1 type b\nfile appendonly.aof.1.incr.aof seq 1 type i\nfile appendonly.aof.2.incr.aof seq 2 type i\n"},
+		{name: "测试忽略未知字段", data: "file a.1.base.rdb seq 1 type b startoffset 0\n"},
+		{name: "测试空清单", data: "\n", err: true},
+		{name: "测试重复的基础文件", data: "file a seq 1 type b\nfile b seq 2 type b\n", err: true},
+		{name: "测试序号不递增", data: "file a seq 2 type i\nfile b seq 1 type i\n", err: true},
+		{name: "测试未知类型", data: "file a seq 1 type x\n", err: true},
+		{name: "测试缺少字段", data: "file a type i\n", err: true},
+		{name: "测试格式错误", data: "file a seq\n", err: true},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			am, err := parseAOFManifest([]byte(v.data))
+			if v.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			am2, err := parseAOFManifest(am.bytes())
+			assert.NoError(t, err)
+			assert.Equal(t, am, am2)
+		})
+	}
+}
+
+func TestAOFStartup(t *testing.T) {
+	// 首次开启AOF时以当前数据（此处从RDB载入）生成基础文件
+	dir := t.TempDir()
+	s := NewService(Config{Dir: dir})
+	p, _ := newTestPeer(s)
+	doCommand(s, p, "SET", "k", "v")
+	assert.NoError(t, s.rdbSave())
+	s2, p2 := newAOFService(t, Config{Dir: dir})
+	assert.Equal(t, "file appendonly.aof.1.base.rdb seq 1 type b\nfile appendonly.aof.1.incr.aof seq 1 type i\n", string(s2.aofManifest.bytes()))
+	doCommand(s2, p2, "SET", "k2", "v")
+	s2.stopAppendOnly()
+	assert.NoError(t, os.Remove(filepath.Join(dir, defaultDBFilename)))
+	s3, p3 := newAOFService(t, Config{Dir: dir})
+	assert.Equal(t, int64(2), doCommand(s3, p3, "EXISTS", "k", "k2"))
+
+	// 旧版本的单个AOF文件（带RDB前导）升级为基础文件
+	dir = t.TempDir()
+	var buf bytes.Buffer
+	assert.NoError(t, rdbWrite(&buf, []rdbKeyValue{{key: "a", val: []byte("1"), expire: -1}}, false))
+	buf.WriteString("*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$1\r\n2\r\n")
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, defaultAppendFilename), buf.Bytes(), 0644))
+	s4, p4 := newAOFService(t, Config{Dir: dir})
+	assert.Equal(t, int64(2), doCommand(s4, p4, "EXISTS", "a", "b"))
+	assert.Equal(t, "file appendonly.aof seq 1 type b\nfile appendonly.aof.1.incr.aof seq 1 type i\n", string(s4.aofManifest.bytes()))
+	_, err := os.Stat(filepath.Join(dir, defaultAppendFilename))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// 清单中的文件缺失时载入失败，而不是当作没有AOF
+	assert.NoError(t, os.Remove(s4.aofFilePath(defaultAppendFilename)))
+	assert.Error(t, NewService(Config{Dir: dir, AppendOnly: true}).loadDataFromDisk())
+}
+
+func TestBgrewriteaof(t *testing.T) {
+	testCases := []struct {
+		name string
+		cfg  Config
+		base string
+	}{
+		{name: "测试RDB前导", base: "appendonly.aof.2.base.rdb"},
+		{name: "测试命令格式", cfg: Config{AOFDisableRDBPreamble: true}, base: "appendonly.aof.2.base.aof"},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			v.cfg.Dir = t.TempDir()
+			s, p := newAOFService(t, v.cfg)
+			for i := 0; i < 100; i++ {
+				doCommand(s, p, "INCR", "n")
+			}
+			for i := 0; i < 100; i++ {
+				doCommand(s, p, "RPUSH", "l", "x")
+			}
+			doCommand(s, p, "SET", "k", "v", "EX", "100")
+			doCommand(s, p, "SADD", "s", "1", "2")
+			doCommand(s, p, "ZADD", "z", "1", "a", "2", "b")
+			doCommand(s, p, "HSET", "h", "f", "v")
+			doCommand(s, p, "XADD", "x", "1-0", "f", "v")
+			doCommand(s, p, "XADD", "x", "2-0", "f", "v")
+			doCommand(s, p, "XDEL", "x", "2-0")
+			doCommand(s, p, "XGROUP", "CREATE", "x", "g", "0")
+			doCommand(s, p, "XREADGROUP", "GROUP", "g", "c", "STREAMS", "x", ">")
+			doCommand(s, p, "XGROUP", "CREATE", "empty", "g", "$", "MKSTREAM")
+
+			assert.Equal(t, "Background append only file rewriting started", doCommand(s, p, "BGREWRITEAOF"))
+			assert.Equal(t, errors.New("ERR Background append only file rewriting already in progress"), doCommand(s, p, "BGREWRITEAOF"))
+			// 重写期间的写命令追加到新的增量文件
+			doCommand(s, p, "SET", "after", "v")
+			assert.Equal(t, [][]string{{"SET", "after", "v"}}, readAOFCommands(t, aofIncrPath(s)))
+			s.backgroundRewriteDone(<-s.aofRewriteCh)
+			assert.NoError(t, s.lastAofRewriteErr)
+			doCommand(s, p, "SET", "after2", "v")
+
+			// 旧的基础文件与增量文件已被删除
+			assert.Equal(t, "file "+v.base+" seq 2 type b\nfile appendonly.aof.2.incr.aof seq 2 type i\n", string(s.aofManifest.bytes()))
+			files, _ := filepath.Glob(filepath.Join(s.aofDir(), "*"))
+			assert.Len(t, files, 3)
+			if v.cfg.AOFDisableRDBPreamble {
+				cmds := readAOFCommands(t, s.aofFilePath(v.base))
+				assert.Less(t, len(cmds), 30)
+				assert.Contains(t, cmds, []string{"SET", "n", "100"})
+			}
+			s.stopAppendOnly()
+
+			s2, p2 := newAOFService(t, v.cfg)
+			for _, args := range [][]string{
+				{"GET", "n"},
+				{"LLEN", "l"},
+				{"PEXPIRETIME", "k"},
+				{"SMEMBERS", "s"},
+				{"ZRANGE", "z", "0", "-1", "WITHSCORES"},
+				{"HGETALL", "h"},
+				{"XRANGE", "x", "-", "+"},
+				{"XPENDING", "x", "g"},
+				{"EXISTS", "empty", "after", "after2"},
+			} {
+				assert.Equal(t, doCommand(s, p, args...), doCommand(s2, p2, args...), args)
+			}
+			// 被删除的最大ID也被保留
+			assert.Equal(t, errXAddIDTooSmall, doCommand(s2, p2, "XADD", "x", "2-0", "f", "v"))
+			// 累计添加的条目数与被删除的最大ID也被保留
+			st, err := s2.db.lookupStream("x")
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(2), st.entriesAdded)
+			assert.Equal(t, streamID{2, 0}, st.maxDeletedID)
+			st, err = s2.db.lookupStream("empty")
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(0), st.entriesAdded)
+			assert.Equal(t, streamID{}, st.maxDeletedID)
+		})
+	}
+
+	// 未开启AOF时只生成基础文件
+	s := NewService(Config{Dir: t.TempDir()})
+	p, _ := newTestPeer(s)
+	doCommand(s, p, "SET", "k", "v")
+	assert.Equal(t, "Background append only file rewriting started", doCommand(s, p, "BGREWRITEAOF"))
+	s.backgroundRewriteDone(<-s.aofRewriteCh)
+	assert.NoError(t, s.lastAofRewriteErr)
+	assert.Equal(t, "file appendonly.aof.1.base.rdb seq 1 type b\n", string(s.aofManifest.bytes()))
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"math"
+	"slices"
+)
+
+// 阻塞类型
+const (
+	blockList = iota + 1
+	blockStream
+	// blockWait WAIT/WAITAOF等待副本确认偏移量
+	blockWait
+)
+
+var (
+	errTimeoutNotFloat = errors.New("ERR timeout is not a float or out of range")
+	errTimeoutNegative = errors.New("ERR timeout is negative")
+	errTimeoutRange    = errors.New("ERR timeout is out of range")
+)
+
+// blockState peer阻塞时的状态
+type blockState struct {
+	typ int
+	// keys 阻塞的键以及peer在各个键等待队列中的位置
+	keys map[string]*list.Element
+	// timeout 超时时刻（unix毫秒），0表示永不超时
+	timeout int64
+	// timeoutReply 超时后回复给客户端的内容
+	timeoutReply any
+	// args 被阻塞的命令，键就绪后重新执行
+	args []string
+	// streamIDs XREAD阻塞时各个键上已读到的ID，有更大的ID时键就绪
+	streamIDs map[string]streamID
+	// group XREADGROUP阻塞时的消费者组名
+	group string
+	// offset WAIT等待确认的复制偏移量，numReplicas、numLocal 需要确认的副本数与本地AOF数，
+	// waitAOF 为true表示等待的是AOF的fsync而不是副本的接收
+	offset      int64
+	numReplicas int
+	numLocal    int
+	waitAOF     bool
+}
+
+// parseTimeout 解析以秒为单位的阻塞超时时间，返回超时时刻，0表示永不超时
+func parseTimeout(arg string) (int64, error) {
+	f, err := parseFloat(arg)
+	if err != nil {
+		return 0, errTimeoutNotFloat
+	}
+	if f < 0 {
+		return 0, errTimeoutNegative
+	}
+	ms := f * 1000
+	if ms > math.MaxInt64/2 {
+		return 0, errTimeoutRange
+	}
+	if ms == 0 {
+		return 0, nil
+	}
+	return mstime() + int64(math.Ceil(ms)), nil
+}
+
+// parseTimeoutMs 解析以毫秒为单位的阻塞超时时间，返回超时时刻，0表示永不超时
+func parseTimeoutMs(arg string) (int64, error) {
+	ms, err := parseInt(arg)
+	if err != nil {
+		return 0, errors.New("ERR timeout is not an integer or out of range")
+	}
+	if ms < 0 {
+		return 0, errTimeoutNegative
+	}
+	if ms == 0 {
+		return 0, nil
+	}
+	if ms > math.MaxInt64/2 {
+		return 0, errTimeoutRange
+	}
+	return mstime() + ms, nil
+}
+
+// blockForKeys 将peer阻塞在一组键上，按FIFO顺序加入各个键的等待队列
+func (s *Service) blockForKeys(p *Peer, typ int, keys []string, timeout int64, timeoutReply any, args []string) {
+	bs := &blockState{
+		typ:          typ,
+		keys:         make(map[string]*list.Element, len(keys)),
+		timeout:      timeout,
+		timeoutReply: timeoutReply,
+		args:         args,
+	}
+	for _, key := range keys {
+		if _, ok := bs.keys[key]; ok {
+			continue
+		}
+		q, ok := s.blockingKeys[key]
+		if !ok {
+			q = list.New()
+			s.blockingKeys[key] = q
+		}
+		bs.keys[key] = q.PushBack(p)
+	}
+	p.bstate = bs
+	s.blockedPeers[p] = struct{}{}
+}
+
+// unblockPeer 将peer从所有等待队列中移除，不写回复
+func (s *Service) unblockPeer(p *Peer) {
+	if p.bstate == nil {
+		return
+	}
+	for key, e := range p.bstate.keys {
+		q := s.blockingKeys[key]
+		q.Remove(e)
+		if q.Len() == 0 {
+			delete(s.blockingKeys, key)
+		}
+	}
+	if p.bstate.typ == blockWait {
+		s.clientsWaitingAcks = slices.DeleteFunc(s.clientsWaitingAcks, func(w *Peer) bool { return w == p })
+	}
+	p.bstate = nil
+	delete(s.blockedPeers, p)
+}
+
+// signalKeyAsReady 键上有新数据时调用，若有peer阻塞在该键上则在当前命令结束后处理
+func (s *Service) signalKeyAsReady(key string) {
+	if _, ok := s.blockingKeys[key]; !ok {
+		return
+	}
+	if _, ok := s.readyKeySet[key]; ok {
+		return
+	}
+	s.readyKeySet[key] = struct{}{}
+	s.readyKeys = append(s.readyKeys, key)
+}
+
+// keyReadyFor 判断键当前能否满足该阻塞类型的peer
+func (s *Service) keyReadyFor(bs *blockState, key string) bool {
+	switch bs.typ {
+	case blockList:
+		ql, err := s.db.lookupList(key)
+		return err == nil && ql != nil && ql.len() > 0
+	case blockStream:
+		st, err := s.db.lookupStream(key)
+		if err != nil {
+			return true
+		}
+		if bs.group == "" {
+			return st != nil && st.length > 0 && st.lastID.compare(bs.streamIDs[key]) > 0
+		}
+		// 键或消费者组被删除时也唤醒，重新执行的命令会回复NOGROUP错误
+		if st == nil || st.groups[bs.group] == nil {
+			return true
+		}
+		return st.length > 0 && st.lastID.compare(st.groups[bs.group].lastID) > 0
+	default:
+		return false
+	}
+}
+
+// handleClientsBlockedOnKeys 按FIFO顺序唤醒阻塞在就绪键上的peer并重新执行其命令，
+// 在事件循环每次处理完命令后调用
+func (s *Service) handleClientsBlockedOnKeys() {
+	for len(s.readyKeys) > 0 {
+		keys := s.readyKeys
+		s.readyKeys = nil
+		clear(s.readyKeySet)
+		for _, key := range keys {
+			q, ok := s.blockingKeys[key]
+			if !ok {
+				continue
+			}
+			// 重新执行的命令可能再次阻塞并排到队尾，最多处理当前队列长度次。
+			// 列表的队首无法满足时其后的peer也无法满足；流的各个peer等待的ID不同，需逐个判断
+			for n, e := q.Len(), q.Front(); n > 0 && e != nil; n-- {
+				next := e.Next()
+				p := e.Value.(*Peer)
+				if s.keyReadyFor(p.bstate, key) {
+					args := p.bstate.args
+					s.unblockPeer(p)
+					p.handleMSG(s, Message{peer: p, args: args})
+					s.processPending(p)
+				} else if p.bstate.typ == blockList {
+					break
+				}
+				e = next
+			}
+		}
+	}
+}
+
+// handleBlockedTimeouts 回复已超时的阻塞peer，由serverCron调用
+func (s *Service) handleBlockedTimeouts() {
+	now := mstime()
+	for p := range s.blockedPeers {
+		// 时刻精确到毫秒，到达超时时刻的那一毫秒内可能还未等满，下一毫秒才算超时
+		if p.bstate.timeout == 0 || p.bstate.timeout >= now {
+			continue
+		}
+		reply := p.bstate.timeoutReply
+		if p.bstate.typ == blockWait {
+			// WAIT超时时回复此刻已确认的数量
+			reply = s.waitReply(p.bstate)
+		}
+		s.unblockPeer(p)
+		p.addReply(reply)
+		p.flush()
+		s.processPending(p)
+	}
+}
+
+// processPending 依次执行peer阻塞期间收到的命令，直到再次阻塞
+func (s *Service) processPending(p *Peer) {
+	for len(p.pending) > 0 && p.bstate == nil {
+		msg := p.pending[0]
+		p.pending = p.pending[1:]
+		p.handleMSG(s, msg)
+	}
+}
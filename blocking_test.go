@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+	"github.com/stretchr/testify/assert"
+)
+
+// runCommand 模拟事件循环处理一条消息
+func runCommand(s *Service, p *Peer, args ...string) {
+	p.handleMSG(s, Message{peer: p, args: args})
+	s.handleClientsBlockedOnKeys()
+}
+
+func TestBlockingPopFIFO(t *testing.T) {
+	s := NewService(Config{})
+	p1, buf1 := newTestPeer(s)
+	p2, buf2 := newTestPeer(s)
+	p3, _ := newTestPeer(s)
+
+	runCommand(s, p1, "BLPOP", "a", "b", "0")
+	runCommand(s, p2, "BRPOP", "b", "0")
+	assert.NotNil(t, p1.bstate)
+	assert.NotNil(t, p2.bstate)
+	assert.Equal(t, 0, buf1.Len())
+
+	// 阻塞期间收到的命令在解除阻塞后执行
+	runCommand(s, p1, "PING")
+	assert.Equal(t, 0, buf1.Len())
+
+	// p1先阻塞在b上，应先被唤醒
+	runCommand(s, p3, "RPUSH", "b", "x")
+	assert.Nil(t, p1.bstate)
+	assert.Equal(t, "*2\r\n$1\r\nb\r\n$1\r\nx\r\n+PONG\r\n", buf1.String())
+	assert.NotNil(t, p2.bstate)
+	assert.Equal(t, 0, buf2.Len())
+	assert.Empty(t, s.blockingKeys["a"])
+
+	runCommand(s, p3, "RPUSH", "b", "y", "z")
+	assert.Nil(t, p2.bstate)
+	assert.Equal(t, "*2\r\n$1\r\nb\r\n$1\r\nz\r\n", buf2.String())
+	assert.Equal(t, int64(1), doCommand(s, p3, "LLEN", "b"))
+	assert.Empty(t, s.blockingKeys)
+	assert.Empty(t, s.blockedPeers)
+}
+
+func TestBlockingMoveChain(t *testing.T) {
+	s := NewService(Config{})
+	p1, buf1 := newTestPeer(s)
+	p2, buf2 := newTestPeer(s)
+	p3, _ := newTestPeer(s)
+
+	runCommand(s, p1, "BLMOVE", "src", "dst", "LEFT", "RIGHT", "0")
+	runCommand(s, p2, "BLMPOP", "0", "1", "dst", "LEFT", "COUNT", "2")
+	runCommand(s, p3, "LPUSH", "src", "v")
+	assert.Equal(t, "$1\r\nv\r\n", buf1.String())
+	assert.Equal(t, "*2\r\n$3\r\ndst\r\n*1\r\n$1\r\nv\r\n", buf2.String())
+	assert.Equal(t, int64(0), doCommand(s, p3, "EXISTS", "src", "dst"))
+}
+
+func TestBlockingPropagate(t *testing.T) {
+	s, p := newAOFService(t, Config{Dir: t.TempDir()})
+	p1, _ := newTestPeer(s)
+	p2, _ := newTestPeer(s)
+
+	// 唤醒后执行的阻塞命令同样以非阻塞的形式传播
+	runCommand(s, p1, "BRPOPLPUSH", "src", "dst", "0")
+	runCommand(s, p2, "BLMPOP", "0", "2", "none", "dst", "RIGHT", "COUNT", "5")
+	runCommand(s, p, "RPUSH", "src", "a")
+	runCommand(s, p, "RPUSH", "l", "x", "y", "z")
+	runCommand(s, p, "BLPOP", "none", "l", "0")
+	runCommand(s, p, "BRPOP", "l", "0")
+	runCommand(s, p, "BLMOVE", "l", "dst", "LEFT", "LEFT", "0")
+	assert.Equal(t, [][]string{
+		{"RPUSH", "src", "a"},
+		{"LMOVE", "src", "dst", "RIGHT", "LEFT"},
+		{"LMPOP", "1", "dst", "RIGHT", "COUNT", "1"},
+		{"RPUSH", "l", "x", "y", "z"},
+		{"LPOP", "l"},
+		{"RPOP", "l"},
+		{"LMOVE", "l", "dst", "LEFT", "LEFT"},
+	}, readAOFCommands(t, aofIncrPath(s)))
+}
+
+func TestBlockingTimeout(t *testing.T) {
+	s := NewService(Config{})
+	p1, buf1 := newTestPeer(s)
+	p2, buf2 := newTestPeer(s)
+	runCommand(s, p1, "BLPOP", "a", "0.01")
+	runCommand(s, p2, "BLMOVE", "a", "b", "LEFT", "LEFT", "0.01")
+	time.Sleep(20 * time.Millisecond)
+	s.handleBlockedTimeouts()
+	assert.Equal(t, "*-1\r\n", buf1.String())
+	assert.Equal(t, "$-1\r\n", buf2.String())
+	assert.Empty(t, s.blockingKeys)
+
+	assert.Equal(t, errTimeoutNegative, doCommand(s, p1, "BLPOP", "a", "-1"))
+	assert.Equal(t, errTimeoutNotFloat, doCommand(s, p1, "BLPOP", "a", "x"))
+}
+
+func TestBlockingDisconnect(t *testing.T) {
+	s := startTestService(t, Config{})
+	addr := s.ln.Addr().String()
+	c1, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	c1.Write([]byte("BLPOP q 0\r\n"))
+
+	c2, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	defer c2.Close()
+	c3, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	defer c3.Close()
+	c2.Write([]byte("BLPOP q 0\r\n"))
+	time.Sleep(50 * time.Millisecond)
+	// c1断开后应从等待队列中移除，数据交给c2
+	c1.Close()
+	time.Sleep(50 * time.Millisecond)
+	c3.Write([]byte("RPUSH q v\r\n"))
+
+	rd := resp.NewReader(c2)
+	c2.SetReadDeadline(time.Now().Add(time.Second))
+	res, err := rd.ReadValue()
+	assert.NoError(t, err)
+	assert.Equal(t, resp.Array{resp.BulkStrings("q"), resp.BulkStrings("v")}, res)
+}
+
+func TestBlockingXRead(t *testing.T) {
+	s := NewService(Config{})
+	p1, buf1 := newTestPeer(s)
+	p2, buf2 := newTestPeer(s)
+	p3, buf3 := newTestPeer(s)
+	doCommand(s, p3, "XADD", "x", "1-0", "a", "1")
+	doCommand(s, p3, "XGROUP", "CREATE", "x", "g", "$")
+
+	// $在阻塞时被替换为当前的最大ID，只读取之后添加的条目
+	runCommand(s, p1, "XREAD", "BLOCK", "0", "STREAMS", "x", "$")
+	runCommand(s, p2, "XREADGROUP", "GROUP", "g", "c", "BLOCK", "0", "STREAMS", "x", ">")
+	assert.NotNil(t, p1.bstate)
+	assert.NotNil(t, p2.bstate)
+
+	// 同一个键上的XREAD与XREADGROUP都被唤醒
+	runCommand(s, p3, "XADD", "x", "2-0", "b", "2")
+	assert.Nil(t, p1.bstate)
+	assert.Nil(t, p2.bstate)
+	want := "*1\r\n*2\r\n$1\r\nx\r\n*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n"
+	assert.Equal(t, want, buf1.String())
+	assert.Equal(t, want, buf2.String())
+	assert.Equal(t, int64(1), doCommand(s, p3, "XACK", "x", "g", "2-0"))
+
+	// 超时后回复空数组
+	buf3.Reset()
+	runCommand(s, p1, "XREAD", "BLOCK", "10", "STREAMS", "x", "2-0")
+	time.Sleep(20 * time.Millisecond)
+	s.handleBlockedTimeouts()
+	assert.Equal(t, want+"*-1\r\n", buf1.String())
+
+	// 消费者组被删除时阻塞的XREADGROUP回复NOGROUP错误
+	buf2.Reset()
+	runCommand(s, p2, "XREADGROUP", "GROUP", "g", "c", "BLOCK", "0", "STREAMS", "x", ">")
+	runCommand(s, p3, "XGROUP", "DESTROY", "x", "g")
+	assert.Equal(t, "-NOGROUP No such key 'x' or consumer group 'g' in XREADGROUP with GROUP option\r\n", buf2.String())
+	assert.Empty(t, s.blockingKeys)
+}
@@ -0,0 +1,1114 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+)
+
+const (
+	CommentCluster = "CLUSTER"
+)
+
+const (
+	// defaultClusterConfigFile 集群配置文件的默认文件名，位于Dir中
+	defaultClusterConfigFile = "nodes.conf"
+	// defaultClusterNodeTimeout 节点无响应多久（毫秒）后被认为可能下线
+	defaultClusterNodeTimeout = 15000
+	// clusterPortIncr 未指定集群总线地址时，总线端口为客户端端口加上该值
+	clusterPortIncr = 10000
+	// clusterFailReportValidityMult 下线报告在nodeTimeout的多少倍时间内有效
+	clusterFailReportValidityMult = 2
+	// clusterFailUndoTimeMult 负责槽的节点被标记为FAIL后，至少经过nodeTimeout的多少倍时间才能清除
+	clusterFailUndoTimeMult = 2
+)
+
+// 节点标志
+const (
+	// nodeMyself 节点是本节点
+	nodeMyself = 1 << iota
+	// nodeMaster 节点是主节点
+	nodeMaster
+	// nodePFail 本节点认为该节点可能下线（fail?）
+	nodePFail
+	// nodeFail 多数主节点认为该节点已下线
+	nodeFail
+	// nodeHandshake 正在握手，尚不知道节点的真实名称
+	nodeHandshake
+	// nodeNoAddr 不知道节点的地址
+	nodeNoAddr
+	// nodeMeet 连接建立后发送MEET而不是PING，使对方将本节点加入集群
+	nodeMeet
+)
+
+// nodeFlagNames 标志在CLUSTER NODES与配置文件中的名称，按输出顺序排列
+var nodeFlagNames = []struct {
+	flag int
+	name string
+}{
+	{nodeMyself, "myself"},
+	{nodeMaster, "master"},
+	{nodePFail, "fail?"},
+	{nodeFail, "fail"},
+	{nodeHandshake, "handshake"},
+	{nodeNoAddr, "noaddr"},
+}
+
+var (
+	errClusterDisabled = errors.New("ERR This instance has cluster support disabled")
+	errInvalidSlot     = errors.New("ERR Invalid or out of range slot")
+	errTryAgain        = errors.New("TRYAGAIN Multiple keys request during rehashing of slot")
+)
+
+// clusterNode 集群中的一个节点
+type clusterNode struct {
+	name  string
+	flags int
+	// ip、port、busPort 节点的地址、客户端端口与集群总线端口，本节点的ip在收到MEET时得知
+	ip      string
+	port    int
+	busPort int
+	// configEpoch 节点声明其负责的槽时使用的纪元，冲突时纪元大的一方胜出
+	configEpoch int64
+	// slots 节点负责的槽的位图，numSlots 负责的槽数
+	slots    [clusterSlots / 8]byte
+	numSlots int
+	// pingSent 最近一次发出且未收到PONG的PING的时刻，pongReceived 最近一次收到PONG的时刻（unix毫秒）
+	pingSent     int64
+	pongReceived int64
+	// failTime 被标记为FAIL的时刻，ctime 节点的创建时刻（unix毫秒）
+	failTime int64
+	ctime    int64
+	// link 本节点主动发起的到该节点的总线连接，connecting 正在连接
+	link       *clusterLink
+	connecting bool
+	// failReports 其他主节点报告该节点下线的时刻
+	failReports map[*clusterNode]int64
+}
+
+// clusterState 本节点所了解的集群状态
+type clusterState struct {
+	myself       *clusterNode
+	currentEpoch int64
+	// ok 全部槽都有正常的节点负责，且多数主节点可达
+	ok bool
+	// size 负责至少一个槽的主节点数
+	size  int
+	nodes map[string]*clusterNode
+	// slots 槽到负责该槽的节点的映射，未分配的槽为nil
+	slots [clusterSlots]*clusterNode
+	// migratingSlotsTo 本节点正在迁出的槽及其目标节点，importingSlotsFrom 正在导入的槽及其来源节点
+	migratingSlotsTo   [clusterSlots]*clusterNode
+	importingSlotsFrom [clusterSlots]*clusterNode
+	// busLn 集群总线的监听，inboundLinks 其他节点连接到本节点的总线连接
+	busLn        net.Listener
+	inboundLinks map[*clusterLink]struct{}
+	// todoSaveConfig 配置有变化，需要写入配置文件
+	todoSaveConfig bool
+	// statsMessagesSent、statsMessagesReceived 总线上发送与接收的消息数
+	statsMessagesSent     int64
+	statsMessagesReceived int64
+}
+
+func init() {
+	registerCommand(
+		&command{name: CommentCluster, arity: -2, proc: clusterCommand},
+	)
+}
+
+func createClusterNode(name string, flags int) *clusterNode {
+	if name == "" {
+		name = genRunID()
+	}
+	return &clusterNode{name: name, flags: flags, ctime: mstime(), failReports: make(map[*clusterNode]int64)}
+}
+
+func (n *clusterNode) hasFlag(flag int) bool {
+	return n.flags&flag != 0
+}
+
+// isFailing 节点被标记为fail?或fail
+func (n *clusterNode) isFailing() bool {
+	return n.hasFlag(nodePFail | nodeFail)
+}
+
+func (n *clusterNode) hasSlot(slot int) bool {
+	return n.slots[slot/8]&(1<<(slot%8)) != 0
+}
+
+func (n *clusterNode) setSlot(slot int) {
+	if !n.hasSlot(slot) {
+		n.slots[slot/8] |= 1 << (slot % 8)
+		n.numSlots++
+	}
+}
+
+func (n *clusterNode) clearSlot(slot int) {
+	if n.hasSlot(slot) {
+		n.slots[slot/8] &^= 1 << (slot % 8)
+		n.numSlots--
+	}
+}
+
+// addr 返回客户端连接该节点使用的ip:port
+func (n *clusterNode) addr() string {
+	return net.JoinHostPort(n.ip, strconv.Itoa(n.port))
+}
+
+// flagsString 返回逗号分隔的标志名称，没有标志时为noflags
+func (n *clusterNode) flagsString() string {
+	var names []string
+	for _, f := range nodeFlagNames {
+		if n.hasFlag(f.flag) {
+			names = append(names, f.name)
+		}
+	}
+	if len(names) == 0 {
+		return "noflags"
+	}
+	return strings.Join(names, ",")
+}
+
+// parseNodeFlags 解析flagsString的输出，未知的标志被忽略
+func parseNodeFlags(s string) int {
+	flags := 0
+	for _, name := range strings.Split(s, ",") {
+		for _, f := range nodeFlagNames {
+			if f.name == name {
+				flags |= f.flag
+			}
+		}
+	}
+	return flags
+}
+
+// clusterNodeTimeout 返回节点超时时间
+func (s *Service) clusterNodeTimeout() time.Duration {
+	return time.Duration(s.ClusterNodeTimeout) * time.Millisecond
+}
+
+// clusterConfigPath 返回集群配置文件的路径
+func (s *Service) clusterConfigPath() string {
+	return filepath.Join(s.Dir, s.ClusterConfigFile)
+}
+
+// clusterInit 载入集群配置文件，文件不存在时以新的名称创建本节点
+func (s *Service) clusterInit() error {
+	s.cluster = &clusterState{
+		nodes:        make(map[string]*clusterNode),
+		inboundLinks: make(map[*clusterLink]struct{}),
+	}
+	s.db.enableSlotIndex()
+	err := s.clusterLoadConfig(s.clusterConfigPath())
+	if errors.Is(err, os.ErrNotExist) {
+		s.cluster.myself = createClusterNode("", nodeMyself|nodeMaster)
+		s.clusterAddNode(s.cluster.myself)
+		slog.Info("no cluster configuration found, I'm " + s.cluster.myself.name)
+		s.cluster.todoSaveConfig = true
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("load cluster config %s: %w", s.clusterConfigPath(), err)
+	}
+	s.clusterUpdateState()
+	return s.clusterSaveConfigIfNeeded()
+}
+
+// clusterAddNode 将节点加入集群
+func (s *Service) clusterAddNode(n *clusterNode) {
+	s.cluster.nodes[n.name] = n
+}
+
+// clusterDelNode 将节点移出集群，释放它负责的槽、迁移状态、总线连接以及它发出的下线报告
+func (s *Service) clusterDelNode(n *clusterNode) {
+	c := s.cluster
+	for slot := range c.slots {
+		if c.slots[slot] == n {
+			s.clusterDelSlot(slot)
+		}
+		if c.migratingSlotsTo[slot] == n {
+			c.migratingSlotsTo[slot] = nil
+		}
+		if c.importingSlotsFrom[slot] == n {
+			c.importingSlotsFrom[slot] = nil
+		}
+	}
+	for _, other := range c.nodes {
+		delete(other.failReports, n)
+	}
+	if n.link != nil {
+		s.clusterFreeLink(n.link)
+	}
+	delete(c.nodes, n.name)
+	c.todoSaveConfig = true
+}
+
+// clusterRenameNode 握手完成后以节点的真实名称代替随机名称
+func (s *Service) clusterRenameNode(n *clusterNode, name string) {
+	slog.Info("renaming node", "from", n.name, "to", name)
+	delete(s.cluster.nodes, n.name)
+	n.name = name
+	s.clusterAddNode(n)
+}
+
+// clusterAddSlot 将槽分配给节点，槽须未被分配
+func (s *Service) clusterAddSlot(n *clusterNode, slot int) bool {
+	if s.cluster.slots[slot] != nil {
+		return false
+	}
+	n.setSlot(slot)
+	s.cluster.slots[slot] = n
+	return true
+}
+
+// clusterDelSlot 取消槽的分配
+func (s *Service) clusterDelSlot(slot int) bool {
+	n := s.cluster.slots[slot]
+	if n == nil {
+		return false
+	}
+	n.clearSlot(slot)
+	s.cluster.slots[slot] = nil
+	return true
+}
+
+// clusterStartHandshake 以随机名称加入地址为ip:port的节点，连接后通过PONG得知其真实名称。
+// 同一地址已在握手中时返回false
+func (s *Service) clusterStartHandshake(ip string, port, busPort int, meet bool) bool {
+	for _, n := range s.cluster.nodes {
+		if n.hasFlag(nodeHandshake) && n.ip == ip && n.port == port && n.busPort == busPort {
+			return false
+		}
+	}
+	flags := nodeHandshake
+	if meet {
+		flags |= nodeMeet
+	}
+	n := createClusterNode("", flags)
+	n.ip, n.port, n.busPort = ip, port, busPort
+	s.clusterAddNode(n)
+	return true
+}
+
+// clusterUpdateState 根据槽的分配与各节点的状态判断集群能否提供服务
+func (s *Service) clusterUpdateState() {
+	c := s.cluster
+	ok := true
+	for _, n := range c.slots {
+		if n == nil || n.hasFlag(nodeFail) {
+			ok = false
+			break
+		}
+	}
+	size, reachable := 0, 0
+	for _, n := range c.nodes {
+		if n.hasFlag(nodeMaster) && n.numSlots > 0 {
+			size++
+			if !n.isFailing() {
+				reachable++
+			}
+		}
+	}
+	c.size = size
+	// 处于少数派分区时停止服务，避免与多数派同时接受写入
+	if reachable < size/2+1 {
+		ok = false
+	}
+	if ok != c.ok {
+		c.ok = ok
+		slog.Info("cluster state changed", "state", clusterStateName(ok))
+	}
+}
+
+func clusterStateName(ok bool) string {
+	if ok {
+		return "ok"
+	}
+	return "fail"
+}
+
+// clusterNodeFailureReportsCount 清除过期的下线报告后返回有效的报告数
+func (s *Service) clusterNodeFailureReportsCount(n *clusterNode) int {
+	maxAge := s.ClusterNodeTimeout * clusterFailReportValidityMult
+	now := mstime()
+	for reporter, when := range n.failReports {
+		if now-when > maxAge {
+			delete(n.failReports, reporter)
+		}
+	}
+	return len(n.failReports)
+}
+
+// markNodeAsFailingIfNeeded 多数负责槽的主节点都认为节点可能下线时将其标记为FAIL，并通知其他节点
+func (s *Service) markNodeAsFailingIfNeeded(n *clusterNode) {
+	c := s.cluster
+	if !n.hasFlag(nodePFail) || n.hasFlag(nodeFail) {
+		return
+	}
+	failures := s.clusterNodeFailureReportsCount(n)
+	if c.myself.hasFlag(nodeMaster) {
+		failures++
+	}
+	if failures < c.size/2+1 {
+		return
+	}
+	slog.Info("marking node as failing (quorum reached)", "node", n.name)
+	n.flags = n.flags&^nodePFail | nodeFail
+	n.failTime = mstime()
+	s.clusterBroadcastFail(n)
+	s.clusterUpdateState()
+	c.todoSaveConfig = true
+}
+
+// clearNodeFailureIfNeeded 下线的节点重新可达时清除FAIL标志。负责槽的节点须经过一段时间，
+// 给其他节点接管它的槽的机会
+func (s *Service) clearNodeFailureIfNeeded(n *clusterNode) {
+	if !n.hasFlag(nodeFail) {
+		return
+	}
+	if n.numSlots > 0 && mstime()-n.failTime < s.ClusterNodeTimeout*clusterFailUndoTimeMult {
+		return
+	}
+	slog.Info("clear FAIL state for node, it is reachable again", "node", n.name)
+	n.flags &^= nodeFail
+	s.clusterUpdateState()
+	s.cluster.todoSaveConfig = true
+}
+
+// clusterHandleConfigEpochCollision 两个主节点的configEpoch相同时，名称较小的一方
+// 取得新的纪元，保证各主节点的configEpoch最终互不相同
+func (s *Service) clusterHandleConfigEpochCollision(sender *clusterNode) {
+	c := s.cluster
+	me := c.myself
+	if sender.configEpoch != me.configEpoch || !sender.hasFlag(nodeMaster) || !me.hasFlag(nodeMaster) {
+		return
+	}
+	if sender.name <= me.name {
+		return
+	}
+	c.currentEpoch++
+	me.configEpoch = c.currentEpoch
+	c.todoSaveConfig = true
+	slog.Info("configEpoch collision with node, configEpoch set", "node", sender.name, "epoch", me.configEpoch)
+}
+
+// clusterUpdateSlotsConfigWith 发送者以更大的纪元声明负责的槽时，改由发送者负责，
+// 本节点因此失去的槽中的键被删除。正在导入的槽由CLUSTER SETSLOT决定归属，不在此更新
+func (s *Service) clusterUpdateSlotsConfigWith(sender *clusterNode, epoch int64, slots []byte) {
+	c := s.cluster
+	var dirtySlots []int
+	changed := false
+	for slot := 0; slot < clusterSlots; slot++ {
+		if slots[slot/8]&(1<<(slot%8)) == 0 || c.importingSlotsFrom[slot] != nil {
+			continue
+		}
+		owner := c.slots[slot]
+		if owner == sender || (owner != nil && owner.configEpoch >= epoch) {
+			continue
+		}
+		if owner == c.myself {
+			if s.db.countKeysInSlot(slot) > 0 {
+				dirtySlots = append(dirtySlots, slot)
+			}
+			c.migratingSlotsTo[slot] = nil
+		}
+		s.clusterDelSlot(slot)
+		s.clusterAddSlot(sender, slot)
+		changed = true
+	}
+	if !changed {
+		return
+	}
+	for _, slot := range dirtySlots {
+		s.clusterDelKeysInSlot(slot)
+	}
+	s.clusterUpdateState()
+	c.todoSaveConfig = true
+}
+
+// clusterDelKeysInSlot 删除槽中的全部键，以DEL传播
+func (s *Service) clusterDelKeysInSlot(slot int) int {
+	keys := s.db.keysInSlot(slot, s.db.countKeysInSlot(slot))
+	for _, key := range keys {
+		if s.db.remove(key) {
+			s.alsoPropagate([]string{CommentDel, key})
+		}
+	}
+	if s.callDepth == 0 {
+		s.propagatePendingCommands()
+	}
+	return len(keys)
+}
+
+// clusterBeforeSleep 处理完事件后保存有变化的配置
+func (s *Service) clusterBeforeSleep() {
+	if s.cluster == nil {
+		return
+	}
+	if err := s.clusterSaveConfigIfNeeded(); err != nil {
+		slog.Error("save cluster config error", "err", err)
+	}
+}
+
+// clusterSaveConfigIfNeeded 配置有变化时写入配置文件
+func (s *Service) clusterSaveConfigIfNeeded() error {
+	if !s.cluster.todoSaveConfig {
+		return nil
+	}
+	s.cluster.todoSaveConfig = false
+	return s.clusterSaveConfig()
+}
+
+// clusterSaveConfig 以CLUSTER NODES的格式写入配置文件，最后一行保存纪元，替换是原子的
+func (s *Service) clusterSaveConfig() error {
+	return writeFileAtomic(s.clusterConfigPath(), "temp-*.conf", func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "%svars currentEpoch %d lastVoteEpoch 0\n",
+			s.clusterGenNodesDescription(nodeHandshake), s.cluster.currentEpoch)
+		return err
+	})
+}
+
+// clusterLoadConfig 载入配置文件，文件不存在时返回os.ErrNotExist
+func (s *Service) clusterLoadConfig(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	c := s.cluster
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "vars" {
+			for i := 1; i+1 < len(fields); i += 2 {
+				if fields[i] == "currentEpoch" {
+					if c.currentEpoch, err = strconv.ParseInt(fields[i+1], 10, 64); err != nil {
+						return fmt.Errorf("invalid currentEpoch %q", fields[i+1])
+					}
+				}
+			}
+			continue
+		}
+		if len(fields) < 8 {
+			return fmt.Errorf("unrecoverable error: corrupted cluster config file %q", sc.Text())
+		}
+		if err := s.clusterLoadNode(fields); err != nil {
+			return err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if c.myself == nil {
+		return errors.New("myself node not found in cluster config file")
+	}
+	return nil
+}
+
+// clusterLoadNode 载入配置文件中的一个节点：
+// <name> <ip:port@cport> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ...
+func (s *Service) clusterLoadNode(fields []string) error {
+	c := s.cluster
+	n := c.nodes[fields[0]]
+	if n == nil {
+		n = createClusterNode(fields[0], 0)
+		s.clusterAddNode(n)
+	}
+	hostPort, cport, ok := strings.Cut(fields[1], "@")
+	if !ok {
+		return fmt.Errorf("invalid node address %q", fields[1])
+	}
+	ip, port, err := splitHostPort(hostPort)
+	if err != nil {
+		return fmt.Errorf("invalid node address %q: %w", fields[1], err)
+	}
+	busPort, err := strconv.Atoi(cport)
+	if err != nil {
+		return fmt.Errorf("invalid node bus port %q", cport)
+	}
+	n.ip, n.port, n.busPort = ip, port, busPort
+	n.flags = parseNodeFlags(fields[2])
+	if n.hasFlag(nodeMyself) {
+		c.myself = n
+	}
+	// 重启后之前的PFAIL/FAIL状态需要重新确认
+	n.flags &^= nodePFail
+	if n.configEpoch, err = strconv.ParseInt(fields[6], 10, 64); err != nil {
+		return fmt.Errorf("invalid config epoch %q", fields[6])
+	}
+	for _, arg := range fields[8:] {
+		if strings.HasPrefix(arg, "[") {
+			if err := s.clusterLoadSlotMigration(arg); err != nil {
+				return err
+			}
+			continue
+		}
+		start, end, err := parseSlotRange(arg)
+		if err != nil {
+			return err
+		}
+		for slot := start; slot <= end; slot++ {
+			s.clusterAddSlot(n, slot)
+		}
+	}
+	return nil
+}
+
+// clusterLoadSlotMigration 载入本节点的迁移状态：[slot->-node]正在迁出，[slot-<-node]正在导入。
+// 引用的节点可能在配置文件中排在后面，此时先以名称创建
+func (s *Service) clusterLoadSlotMigration(arg string) error {
+	c := s.cluster
+	body, ok := strings.CutSuffix(arg[1:], "]")
+	slotStr, name, migrating := strings.Cut(body, "->-")
+	if !migrating {
+		slotStr, name, ok = strings.Cut(body, "-<-")
+	}
+	slot, err := strconv.Atoi(slotStr)
+	if !ok || err != nil || slot < 0 || slot >= clusterSlots || name == "" {
+		return fmt.Errorf("invalid slot migration state %q", arg)
+	}
+	n := c.nodes[name]
+	if n == nil {
+		n = createClusterNode(name, 0)
+		s.clusterAddNode(n)
+	}
+	if migrating {
+		c.migratingSlotsTo[slot] = n
+	} else {
+		c.importingSlotsFrom[slot] = n
+	}
+	return nil
+}
+
+// parseSlotRange 解析配置文件中的槽或槽区间start-end
+func parseSlotRange(arg string) (int, int, error) {
+	startStr, endStr, isRange := strings.Cut(arg, "-")
+	start, err := strconv.Atoi(startStr)
+	end := start
+	if err == nil && isRange {
+		end, err = strconv.Atoi(endStr)
+	}
+	if err != nil || start < 0 || end >= clusterSlots || start > end {
+		return 0, 0, fmt.Errorf("invalid slot range %q", arg)
+	}
+	return start, end, nil
+}
+
+// clusterGenNodesDescription 返回CLUSTER NODES格式的全部节点，跳过带有filter标志的节点
+func (s *Service) clusterGenNodesDescription(filter int) string {
+	nodes := make([]*clusterNode, 0, len(s.cluster.nodes))
+	for _, n := range s.cluster.nodes {
+		if !n.hasFlag(filter) {
+			nodes = append(nodes, n)
+		}
+	}
+	slices.SortFunc(nodes, func(a, b *clusterNode) int { return strings.Compare(a.name, b.name) })
+	var sb strings.Builder
+	for _, n := range nodes {
+		s.clusterGenNodeDescription(&sb, n)
+	}
+	return sb.String()
+}
+
+// clusterGenNodeDescription 写入一个节点的描述
+func (s *Service) clusterGenNodeDescription(sb *strings.Builder, n *clusterNode) {
+	linkState := "disconnected"
+	if n.hasFlag(nodeMyself) || n.link != nil {
+		linkState = "connected"
+	}
+	fmt.Fprintf(sb, "%s %s@%d %s - %d %d %d %s", n.name, n.addr(), n.busPort, n.flagsString(),
+		n.pingSent, n.pongReceived, n.configEpoch, linkState)
+	for _, r := range slotRanges(n) {
+		if r[0] == r[1] {
+			fmt.Fprintf(sb, " %d", r[0])
+		} else {
+			fmt.Fprintf(sb, " %d-%d", r[0], r[1])
+		}
+	}
+	// 只有本节点输出迁移状态
+	if n.hasFlag(nodeMyself) {
+		c := s.cluster
+		for slot := 0; slot < clusterSlots; slot++ {
+			if to := c.migratingSlotsTo[slot]; to != nil {
+				fmt.Fprintf(sb, " [%d->-%s]", slot, to.name)
+			} else if from := c.importingSlotsFrom[slot]; from != nil {
+				fmt.Fprintf(sb, " [%d-<-%s]", slot, from.name)
+			}
+		}
+	}
+	sb.WriteByte('\n')
+}
+
+// slotRanges 返回节点负责的连续槽区间
+func slotRanges(n *clusterNode) [][2]int {
+	var ranges [][2]int
+	start := -1
+	for slot := 0; slot <= clusterSlots; slot++ {
+		if slot < clusterSlots && n.hasSlot(slot) {
+			if start < 0 {
+				start = slot
+			}
+			continue
+		}
+		if start >= 0 {
+			ranges = append(ranges, [2]int{start, slot - 1})
+			start = -1
+		}
+	}
+	return ranges
+}
+
+// clusterRedirect 检查命令中的键是否由本节点负责，不是时返回重定向的错误：
+// 键分布在不同的槽时回复CROSSSLOT，槽属于其他节点时回复MOVED
+func (s *Service) clusterRedirect(p *Peer, cmd *command, args []string) error {
+	var keys []string
+	if cmd.name == CommentExec && p.mstate != nil {
+		// 事务中的全部键须属于同一个槽
+		for _, qc := range p.mstate.commands {
+			keys = append(keys, qc.cmd.keys(qc.args)...)
+		}
+	} else {
+		keys = cmd.keys(args)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	err := s.clusterCheckKeys(p, cmd, keys)
+	if err != nil && cmd.name == CommentExec {
+		s.discardTransaction(p)
+	}
+	return err
+}
+
+// clusterCheckKeys 返回键所属的槽不由本节点负责时的错误。槽正在迁出且有键已不在本节点时
+// 以ASK重定向到目标节点，槽正在导入时执行过ASKING的命令由本节点执行
+func (s *Service) clusterCheckKeys(p *Peer, cmd *command, keys []string) error {
+	c := s.cluster
+	slot := keyHashSlot(keys[0])
+	multipleKeys := false
+	for _, key := range keys[1:] {
+		if keyHashSlot(key) != slot {
+			return errCrossSlot
+		}
+		if key != keys[0] {
+			multipleKeys = true
+		}
+	}
+	n := c.slots[slot]
+	if n == nil {
+		return errors.New("CLUSTERDOWN Hash slot not served")
+	}
+	if !c.ok {
+		return errors.New("CLUSTERDOWN The cluster is down")
+	}
+	migrating := n == c.myself && c.migratingSlotsTo[slot] != nil
+	importing := c.importingSlotsFrom[slot] != nil
+	// 迁移中的槽由MIGRATE自身搬运，总是在本节点执行
+	if (migrating || importing) && cmd.name != CommentMigrate {
+		missing := 0
+		for _, key := range keys {
+			if !s.db.exists(key) {
+				missing++
+			}
+		}
+		if migrating && missing > 0 {
+			if multipleKeys && missing < len(keys) {
+				return errTryAgain
+			}
+			return fmt.Errorf("ASK %d %s", slot, c.migratingSlotsTo[slot].addr())
+		}
+		if importing && (p.asking || cmd.hasFlag(cmdAsking)) {
+			if multipleKeys && missing > 0 {
+				return errTryAgain
+			}
+			return nil
+		}
+	}
+	if n != c.myself {
+		return fmt.Errorf("MOVED %d %s", slot, n.addr())
+	}
+	return nil
+}
+
+// clusterRedirectBlockedClients 阻塞在键上的客户端，键所属的槽已不由本节点负责或集群不可用时
+// 解除阻塞并回复重定向，由客户端到新的节点上重试
+func (s *Service) clusterRedirectBlockedClients() {
+	c := s.cluster
+	for p := range s.blockedPeers {
+		for key := range p.bstate.keys {
+			slot := keyHashSlot(key)
+			n := c.slots[slot]
+			var err error
+			switch {
+			case !c.ok:
+				err = errors.New("CLUSTERDOWN The cluster is down")
+			case n == nil:
+				err = errors.New("CLUSTERDOWN Hash slot not served")
+			case n != c.myself && c.importingSlotsFrom[slot] == nil:
+				err = fmt.Errorf("MOVED %d %s", slot, n.addr())
+			}
+			if err != nil {
+				s.unblockPeer(p)
+				p.addReply(err)
+				p.flush()
+				s.processPending(p)
+				break
+			}
+		}
+	}
+}
+
+// clusterCommand CLUSTER subcommand [arguments ...]
+func clusterCommand(s *Service, p *Peer, args []string) any {
+	if s.cluster == nil {
+		return errClusterDisabled
+	}
+	c := s.cluster
+	sub := strings.ToUpper(args[1])
+	switch {
+	case sub == "INFO" && len(args) == 2:
+		return resp.Verbatim{Coding: "txt", Data: []byte(s.clusterGenInfo())}
+	case sub == "MYID" && len(args) == 2:
+		return resp.BulkStrings(c.myself.name)
+	case sub == "NODES" && len(args) == 2:
+		return resp.Verbatim{Coding: "txt", Data: []byte(s.clusterGenNodesDescription(0))}
+	case sub == "SLOTS" && len(args) == 2:
+		return s.clusterSlotsReply(p)
+	case sub == "SHARDS" && len(args) == 2:
+		return s.clusterShardsReply(p)
+	case sub == "KEYSLOT" && len(args) == 3:
+		return int64(keyHashSlot(args[2]))
+	case sub == "COUNTKEYSINSLOT" && len(args) == 3:
+		slot, err := parseSlot(args[2])
+		if err != nil {
+			return err
+		}
+		return int64(s.db.countKeysInSlot(slot))
+	case sub == "GETKEYSINSLOT" && len(args) == 4:
+		slot, err := parseSlot(args[2])
+		if err != nil {
+			return err
+		}
+		count, err := parseInt(args[3])
+		if err != nil || count < 0 {
+			return errors.New("ERR Invalid number of keys")
+		}
+		res := resp.Array{}
+		for _, key := range s.db.keysInSlot(slot, int(count)) {
+			res = append(res, resp.BulkStrings(key))
+		}
+		return res
+	case (sub == "ADDSLOTS" || sub == "DELSLOTS") && len(args) >= 3:
+		slots := make([]int, 0, len(args)-2)
+		for _, arg := range args[2:] {
+			slot, err := parseSlot(arg)
+			if err != nil {
+				return err
+			}
+			slots = append(slots, slot)
+		}
+		return s.clusterAddDelSlots(slots, sub == "ADDSLOTS")
+	case (sub == "ADDSLOTSRANGE" || sub == "DELSLOTSRANGE") && len(args) >= 4 && len(args)%2 == 0:
+		var slots []int
+		for i := 2; i < len(args); i += 2 {
+			start, err := parseSlot(args[i])
+			if err != nil {
+				return err
+			}
+			end, err := parseSlot(args[i+1])
+			if err != nil {
+				return err
+			}
+			if start > end {
+				return fmt.Errorf("ERR start slot number %d is greater than end slot number %d", start, end)
+			}
+			for slot := start; slot <= end; slot++ {
+				slots = append(slots, slot)
+			}
+		}
+		return s.clusterAddDelSlots(slots, sub == "ADDSLOTSRANGE")
+	case sub == "MEET" && (len(args) == 4 || len(args) == 5):
+		port, err := parseInt(args[3])
+		if err != nil || port < 0 || port > 65535 {
+			return fmt.Errorf("ERR Invalid base port specified: %s", args[3])
+		}
+		busPort := port + clusterPortIncr
+		if len(args) == 5 {
+			if busPort, err = parseInt(args[4]); err != nil || busPort < 0 || busPort > 65535 {
+				return fmt.Errorf("ERR Invalid bus port specified: %s", args[4])
+			}
+		}
+		ip := net.ParseIP(args[2])
+		if ip == nil || port == 0 || busPort == 0 {
+			return fmt.Errorf("ERR Invalid node address specified: %s:%s", args[2], args[3])
+		}
+		s.clusterStartHandshake(ip.String(), int(port), int(busPort), true)
+		return "OK"
+	case sub == "SETSLOT" && len(args) >= 4:
+		return s.clusterSetSlot(args[2:])
+	case sub == "BUMPEPOCH" && len(args) == 2:
+		if s.clusterBumpConfigEpochWithoutConsensus() {
+			return fmt.Sprintf("BUMPED %d", c.myself.configEpoch)
+		}
+		return fmt.Sprintf("STILL %d", c.myself.configEpoch)
+	case sub == "SAVECONFIG" && len(args) == 2:
+		if err := s.clusterSaveConfig(); err != nil {
+			return fmt.Errorf("ERR error saving the cluster node config: %v", err)
+		}
+		return "OK"
+	default:
+		return fmt.Errorf("ERR unknown subcommand or wrong number of arguments for '%.128s'. Try CLUSTER HELP.", args[1])
+	}
+}
+
+// clusterSetSlot CLUSTER SETSLOT slot IMPORTING node-id|MIGRATING node-id|STABLE|NODE node-id
+// 迁移槽时先在目标节点上IMPORTING、在源节点上MIGRATING，MIGRATE完全部的键后
+// 在两个节点上NODE将槽分配给目标节点
+func (s *Service) clusterSetSlot(args []string) any {
+	c := s.cluster
+	slot, err := parseSlot(args[0])
+	if err != nil {
+		return err
+	}
+	action := strings.ToUpper(args[1])
+	switch {
+	case action == "MIGRATING" && len(args) == 3:
+		if c.slots[slot] != c.myself {
+			return fmt.Errorf("ERR I'm not the owner of hash slot %d", slot)
+		}
+		n := c.nodes[args[2]]
+		if n == nil {
+			return fmt.Errorf("ERR I don't know about node %s", args[2])
+		}
+		c.migratingSlotsTo[slot] = n
+	case action == "IMPORTING" && len(args) == 3:
+		if c.slots[slot] == c.myself {
+			return fmt.Errorf("ERR I'm already the owner of hash slot %d", slot)
+		}
+		n := c.nodes[args[2]]
+		if n == nil {
+			return fmt.Errorf("ERR I don't know about node %s", args[2])
+		}
+		c.importingSlotsFrom[slot] = n
+	case action == "STABLE" && len(args) == 2:
+		c.migratingSlotsTo[slot] = nil
+		c.importingSlotsFrom[slot] = nil
+	case action == "NODE" && len(args) == 3:
+		n := c.nodes[args[2]]
+		if n == nil {
+			return fmt.Errorf("ERR Unknown node %s", args[2])
+		}
+		keys := s.db.countKeysInSlot(slot)
+		if c.slots[slot] == c.myself && n != c.myself && keys != 0 {
+			return fmt.Errorf("ERR Can't assign hashslot %d to a different node while I still hold keys for this hash slot.", slot)
+		}
+		// 键已全部迁出时结束迁出状态
+		if keys == 0 && c.migratingSlotsTo[slot] != nil {
+			c.migratingSlotsTo[slot] = nil
+		}
+		s.clusterDelSlot(slot)
+		s.clusterAddSlot(n, slot)
+		// 导入完成后取得新的纪元并立即广播，使其他节点接受本节点对该槽的声明
+		if n == c.myself && c.importingSlotsFrom[slot] != nil {
+			c.importingSlotsFrom[slot] = nil
+			if s.clusterBumpConfigEpochWithoutConsensus() {
+				slog.Info("configEpoch updated after importing slot", "slot", slot, "epoch", c.myself.configEpoch)
+			}
+			s.clusterBroadcastPong()
+		}
+	default:
+		return errors.New("ERR Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP")
+	}
+	s.clusterUpdateState()
+	c.todoSaveConfig = true
+	return "OK"
+}
+
+// clusterBumpConfigEpochWithoutConsensus 本节点的configEpoch为0或不是集群中最大的纪元时，
+// 不经其他节点同意直接取得新的纪元，返回是否取得
+func (s *Service) clusterBumpConfigEpochWithoutConsensus() bool {
+	c := s.cluster
+	maxEpoch := c.currentEpoch
+	for _, n := range c.nodes {
+		maxEpoch = max(maxEpoch, n.configEpoch)
+	}
+	me := c.myself
+	if me.configEpoch != 0 && me.configEpoch == maxEpoch {
+		return false
+	}
+	c.currentEpoch++
+	me.configEpoch = c.currentEpoch
+	c.todoSaveConfig = true
+	return true
+}
+
+// parseSlot 解析槽号
+func parseSlot(arg string) (int, error) {
+	slot, err := parseInt(arg)
+	if err != nil || slot < 0 || slot >= clusterSlots {
+		return 0, errInvalidSlot
+	}
+	return int(slot), nil
+}
+
+// clusterAddDelSlots 将槽分配给本节点或取消分配，先检查全部槽，有错误时不做任何修改
+func (s *Service) clusterAddDelSlots(slots []int, add bool) any {
+	c := s.cluster
+	seen := make(map[int]bool, len(slots))
+	for _, slot := range slots {
+		if add && c.slots[slot] != nil {
+			return fmt.Errorf("ERR Slot %d is already busy", slot)
+		}
+		if !add && c.slots[slot] == nil {
+			return fmt.Errorf("ERR Slot %d is already unassigned", slot)
+		}
+		if seen[slot] {
+			return fmt.Errorf("ERR Slot %d specified multiple times", slot)
+		}
+		seen[slot] = true
+	}
+	for _, slot := range slots {
+		if add {
+			s.clusterAddSlot(c.myself, slot)
+		} else {
+			s.clusterDelSlot(slot)
+		}
+	}
+	s.clusterUpdateState()
+	c.todoSaveConfig = true
+	return "OK"
+}
+
+// clusterGenInfo CLUSTER INFO
+func (s *Service) clusterGenInfo() string {
+	c := s.cluster
+	assigned, ok, pfail, fail := 0, 0, 0, 0
+	for _, n := range c.slots {
+		if n == nil {
+			continue
+		}
+		assigned++
+		switch {
+		case n.hasFlag(nodeFail):
+			fail++
+		case n.hasFlag(nodePFail):
+			pfail++
+		default:
+			ok++
+		}
+	}
+	return fmt.Sprintf("cluster_state:%s\r\ncluster_slots_assigned:%d\r\ncluster_slots_ok:%d\r\n"+
+		"cluster_slots_pfail:%d\r\ncluster_slots_fail:%d\r\ncluster_known_nodes:%d\r\ncluster_size:%d\r\n"+
+		"cluster_current_epoch:%d\r\ncluster_my_epoch:%d\r\n"+
+		"cluster_stats_messages_sent:%d\r\ncluster_stats_messages_received:%d\r\n",
+		clusterStateName(c.ok), assigned, ok, pfail, fail, len(c.nodes), c.size,
+		c.currentEpoch, c.myself.configEpoch, c.statsMessagesSent, c.statsMessagesReceived)
+}
+
+// clusterNodeIP 返回告知客户端的节点地址，本节点的地址未知时使用客户端连接的本地地址
+func (s *Service) clusterNodeIP(p *Peer, n *clusterNode) string {
+	if n.ip == "" && n == s.cluster.myself && p.conn != nil {
+		ip, _, _ := net.SplitHostPort(p.conn.LocalAddr().String())
+		return ip
+	}
+	return n.ip
+}
+
+// clusterMasters 返回负责槽的主节点，按第一个槽排序
+func (s *Service) clusterMasters() []*clusterNode {
+	var masters []*clusterNode
+	seen := make(map[*clusterNode]bool)
+	for _, n := range s.cluster.slots {
+		if n != nil && !seen[n] {
+			seen[n] = true
+			masters = append(masters, n)
+		}
+	}
+	return masters
+}
+
+// clusterSlotsReply CLUSTER SLOTS 每个连续的槽区间回复[start, end, [ip, port, id, metadata]]
+func (s *Service) clusterSlotsReply(p *Peer) resp.Array {
+	c := s.cluster
+	res := resp.Array{}
+	start := -1
+	for slot := 0; slot <= clusterSlots; slot++ {
+		if start >= 0 && (slot == clusterSlots || c.slots[slot] != c.slots[start]) {
+			n := c.slots[start]
+			res = append(res, resp.Array{
+				int64(start), int64(slot - 1),
+				resp.Array{resp.BulkStrings(s.clusterNodeIP(p, n)), int64(n.port), resp.BulkStrings(n.name), resp.Maps{}},
+			})
+			start = -1
+		}
+		if start < 0 && slot < clusterSlots && c.slots[slot] != nil {
+			start = slot
+		}
+	}
+	return res
+}
+
+// clusterShardsReply CLUSTER SHARDS 每个分片回复负责的槽区间与其中的节点
+func (s *Service) clusterShardsReply(p *Peer) resp.Array {
+	res := resp.Array{}
+	for _, n := range s.clusterMasters() {
+		slots := resp.Array{}
+		for _, r := range slotRanges(n) {
+			slots = append(slots, int64(r[0]), int64(r[1]))
+		}
+		health := "online"
+		if n.isFailing() {
+			health = "fail"
+		}
+		ip := s.clusterNodeIP(p, n)
+		offset := int64(0)
+		if n == s.cluster.myself {
+			offset = s.masterReplOffset
+		}
+		res = append(res, resp.Maps{
+			resp.BulkStrings("slots"): slots,
+			resp.BulkStrings("nodes"): resp.Array{resp.Maps{
+				resp.BulkStrings("id"):                 resp.BulkStrings(n.name),
+				resp.BulkStrings("port"):               int64(n.port),
+				resp.BulkStrings("ip"):                 resp.BulkStrings(ip),
+				resp.BulkStrings("endpoint"):           resp.BulkStrings(ip),
+				resp.BulkStrings("role"):               resp.BulkStrings("master"),
+				resp.BulkStrings("replication-offset"): offset,
+				resp.BulkStrings("health"):             resp.BulkStrings(health),
+			}},
+		})
+	}
+	return res
+}
+
+// genClusterInfo INFO cluster
+func (s *Service) genClusterInfo(sb *strings.Builder) {
+	fmt.Fprintf(sb, "cluster_enabled:%d\r\n", boolToInt(s.cluster != nil))
+}
+
+// clusterRandomNodes 返回随机排列的节点，跳过本节点以及带有filter标志的节点
+func (s *Service) clusterRandomNodes(filter int) []*clusterNode {
+	nodes := make([]*clusterNode, 0, len(s.cluster.nodes))
+	for _, n := range s.cluster.nodes {
+		if n != s.cluster.myself && !n.hasFlag(filter) {
+			nodes = append(nodes, n)
+		}
+	}
+	rand.Shuffle(len(nodes), func(i, j int) { nodes[i], nodes[j] = nodes[j], nodes[i] })
+	return nodes
+}
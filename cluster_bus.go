@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+)
+
+// 集群总线的消息类型。消息编码为由字符串组成的RESP数组：
+// type sender ip port cport flags currentEpoch configEpoch slots [附加字段 ...]，
+// PING/PONG/MEET的附加字段为若干组gossip，FAIL的附加字段为下线节点的名称
+const (
+	clusterMsgPing = "PING"
+	clusterMsgPong = "PONG"
+	clusterMsgMeet = "MEET"
+	clusterMsgFail = "FAIL"
+)
+
+const (
+	// clusterHeaderLen 消息头的字段数
+	clusterHeaderLen = 9
+	// clusterGossipLen 每组gossip的字段数：name ip port cport flags ping-sent pong-received
+	clusterGossipLen = 7
+)
+
+// clusterLink 两个节点之间的总线连接，只在事件循环中写入
+type clusterLink struct {
+	conn net.Conn
+	rd   *resp.Reader
+	wr   *resp.Writer
+	// node 主动连接的节点，其他节点连接到本节点时为nil
+	node  *clusterNode
+	ctime int64
+	// freed 连接已释放，之后读到的消息被丢弃
+	freed bool
+}
+
+// clusterLinkEvent 总线连接建立：node不为nil时是主动连接的结果，否则是接受的连接
+type clusterLinkEvent struct {
+	node *clusterNode
+	conn net.Conn
+	err  error
+}
+
+// clusterBusMsg 从总线连接上读到的一条消息，err不为空时表示连接已断开
+type clusterBusMsg struct {
+	link *clusterLink
+	args []string
+	err  error
+}
+
+// clusterMsgHeader 解析后的消息头
+type clusterMsgHeader struct {
+	typ          string
+	sender       string
+	ip           string
+	port         int
+	busPort      int
+	flags        int
+	currentEpoch int64
+	configEpoch  int64
+	slots        []byte
+}
+
+// clusterListen 监听集群总线，未指定地址时端口为客户端端口加上clusterPortIncr
+func (s *Service) clusterListen() error {
+	c := s.cluster
+	port := s.ln.Addr().(*net.TCPAddr).Port
+	addr := s.ClusterBusAddr
+	if addr == "" {
+		host, _, _ := net.SplitHostPort(s.ListenAddr)
+		addr = net.JoinHostPort(host, strconv.Itoa(port+clusterPortIncr))
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	c.busLn = ln
+	me := c.myself
+	busPort := ln.Addr().(*net.TCPAddr).Port
+	if me.port != port || me.busPort != busPort {
+		me.port, me.busPort = port, busPort
+		c.todoSaveConfig = true
+	}
+	go s.clusterAcceptLoop(ln)
+	slog.Info("cluster bus running", "addr", ln.Addr(), "myself", me.name)
+	return nil
+}
+
+// clusterAcceptLoop 接受其他节点的总线连接
+func (s *Service) clusterAcceptLoop(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Error("cluster bus accept error", "err", err)
+			}
+			return
+		}
+		select {
+		case s.clusterLinkCh <- clusterLinkEvent{conn: conn}:
+		case <-s.quitPeerCh:
+			conn.Close()
+			return
+		}
+	}
+}
+
+// clusterConnectNode 在后台goroutine中连接节点的总线端口
+func (s *Service) clusterConnectNode(n *clusterNode) {
+	n.connecting = true
+	addr := net.JoinHostPort(n.ip, strconv.Itoa(n.busPort))
+	timeout := s.clusterNodeTimeout()
+	go func() {
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		select {
+		case s.clusterLinkCh <- clusterLinkEvent{node: n, conn: conn, err: err}:
+		case <-s.quitPeerCh:
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}()
+}
+
+// clusterLinkConnected 总线连接建立后在事件循环中调用，开始读取消息。
+// 主动建立的连接先发送PING（或MEET）
+func (s *Service) clusterLinkConnected(ev clusterLinkEvent) {
+	c := s.cluster
+	n := ev.node
+	if n != nil {
+		n.connecting = false
+		if ev.err != nil {
+			slog.Debug("connect to cluster node failed", "node", n.name, "err", ev.err)
+			// 连接不上的节点同样从此刻开始计算超时
+			if n.pingSent == 0 {
+				n.pingSent = mstime()
+			}
+			return
+		}
+		// 连接期间节点已被移除
+		if c.nodes[n.name] != n {
+			ev.conn.Close()
+			return
+		}
+	}
+	link := &clusterLink{
+		conn:  ev.conn,
+		rd:    resp.NewReader(ev.conn),
+		wr:    resp.NewWriter(ev.conn),
+		node:  n,
+		ctime: mstime(),
+	}
+	go s.clusterReadLink(link)
+	if n == nil {
+		c.inboundLinks[link] = struct{}{}
+		return
+	}
+	n.link = link
+	typ := clusterMsgPing
+	if n.hasFlag(nodeMeet) {
+		typ = clusterMsgMeet
+		n.flags &^= nodeMeet
+	}
+	s.clusterSendPing(link, typ)
+}
+
+// clusterReadLink 读取总线连接上的消息直到连接断开
+func (s *Service) clusterReadLink(link *clusterLink) {
+	for {
+		args, err := link.rd.ReadCommand()
+		select {
+		case s.clusterMsgCh <- clusterBusMsg{link: link, args: args, err: err}:
+		case <-s.quitPeerCh:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// clusterFreeLink 关闭总线连接，节点的连接在下一次clusterCron时重建
+func (s *Service) clusterFreeLink(link *clusterLink) {
+	if link.freed {
+		return
+	}
+	link.freed = true
+	link.conn.Close()
+	if link.node != nil && link.node.link == link {
+		link.node.link = nil
+	}
+	delete(s.cluster.inboundLinks, link)
+}
+
+// clusterFreeAllLinks 退出时关闭全部总线连接
+func (s *Service) clusterFreeAllLinks() {
+	if s.cluster == nil {
+		return
+	}
+	for _, n := range s.cluster.nodes {
+		if n.link != nil {
+			s.clusterFreeLink(n.link)
+		}
+	}
+	for link := range s.cluster.inboundLinks {
+		s.clusterFreeLink(link)
+	}
+}
+
+// clusterSendMessage 向总线连接写出一条消息，对方长时间不读取时释放连接
+func (s *Service) clusterSendMessage(link *clusterLink, args []string) {
+	if link.freed {
+		return
+	}
+	err := catAppendOnlyCommand(link.wr, args)
+	if err == nil {
+		link.conn.SetWriteDeadline(time.Now().Add(s.clusterNodeTimeout()))
+		err = link.wr.Flush()
+	}
+	if err != nil {
+		slog.Debug("write to cluster link error", "err", err)
+		s.clusterFreeLink(link)
+		return
+	}
+	s.cluster.statsMessagesSent++
+}
+
+// clusterBuildHeader 返回以本节点的信息填充的消息头
+func (s *Service) clusterBuildHeader(typ string) []string {
+	c := s.cluster
+	me := c.myself
+	return []string{
+		typ, me.name, me.ip, strconv.Itoa(me.port), strconv.Itoa(me.busPort),
+		strconv.Itoa(me.flags &^ nodeMyself), strconv.FormatInt(c.currentEpoch, 10),
+		strconv.FormatInt(me.configEpoch, 10), string(me.slots[:]),
+	}
+}
+
+// parseClusterMsgHeader 解析消息头，字段不完整或格式错误时返回错误
+func parseClusterMsgHeader(args []string) (*clusterMsgHeader, error) {
+	if len(args) < clusterHeaderLen || len(args[8]) != clusterSlots/8 {
+		return nil, errors.New("invalid cluster message header")
+	}
+	h := &clusterMsgHeader{typ: strings.ToUpper(args[0]), sender: args[1], ip: args[2], slots: []byte(args[8])}
+	var errs [5]error
+	h.port, errs[0] = strconv.Atoi(args[3])
+	h.busPort, errs[1] = strconv.Atoi(args[4])
+	h.flags, errs[2] = strconv.Atoi(args[5])
+	h.currentEpoch, errs[3] = strconv.ParseInt(args[6], 10, 64)
+	h.configEpoch, errs[4] = strconv.ParseInt(args[7], 10, 64)
+	if err := errors.Join(errs[:]...); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// clusterSendPing 发送PING、PONG或MEET，附带部分已知节点的gossip，
+// 可能下线的节点总是包含在内，使下线报告尽快传播
+func (s *Service) clusterSendPing(link *clusterLink, typ string) {
+	c := s.cluster
+	if link.node != nil && typ != clusterMsgPong && link.node.pingSent == 0 {
+		link.node.pingSent = mstime()
+	}
+	args := s.clusterBuildHeader(typ)
+	wanted := max(3, len(c.nodes)/10)
+	added := 0
+	for _, n := range s.clusterRandomNodes(nodeHandshake | nodeNoAddr) {
+		if n == link.node {
+			continue
+		}
+		if added >= wanted && !n.hasFlag(nodePFail) {
+			continue
+		}
+		args = append(args, n.name, n.ip, strconv.Itoa(n.port), strconv.Itoa(n.busPort),
+			strconv.Itoa(n.flags), strconv.FormatInt(n.pingSent, 10), strconv.FormatInt(n.pongReceived, 10))
+		added++
+	}
+	s.clusterSendMessage(link, args)
+}
+
+// clusterBroadcastFail 通知全部节点n已下线
+func (s *Service) clusterBroadcastFail(n *clusterNode) {
+	args := append(s.clusterBuildHeader(clusterMsgFail), n.name)
+	for _, other := range s.cluster.nodes {
+		if other.link != nil && !other.hasFlag(nodeHandshake) {
+			s.clusterSendMessage(other.link, args)
+		}
+	}
+}
+
+// clusterBroadcastPong 向全部已连接的节点发送PONG，使其尽快得知本节点配置的变化
+func (s *Service) clusterBroadcastPong() {
+	for _, n := range s.cluster.nodes {
+		if n.link != nil && !n.hasFlag(nodeHandshake) {
+			s.clusterSendPing(n.link, clusterMsgPong)
+		}
+	}
+}
+
+// clusterHandleBusMsg 在事件循环中处理总线上读到的消息，连接断开时释放连接
+func (s *Service) clusterHandleBusMsg(msg clusterBusMsg) {
+	if msg.link.freed {
+		return
+	}
+	if msg.err != nil {
+		s.clusterFreeLink(msg.link)
+		return
+	}
+	s.cluster.statsMessagesReceived++
+	if err := s.clusterProcessPacket(msg.link, msg.args); err != nil {
+		slog.Warn("invalid cluster bus message", "err", err)
+		s.clusterFreeLink(msg.link)
+	}
+}
+
+// clusterProcessPacket 处理一条总线消息
+func (s *Service) clusterProcessPacket(link *clusterLink, args []string) error {
+	c := s.cluster
+	h, err := parseClusterMsgHeader(args)
+	if err != nil {
+		return err
+	}
+	sender := c.nodes[h.sender]
+	if sender != nil && sender.hasFlag(nodeHandshake) {
+		sender = nil
+	}
+	if sender != nil {
+		if h.currentEpoch > c.currentEpoch {
+			c.currentEpoch = h.currentEpoch
+			c.todoSaveConfig = true
+		}
+		if h.configEpoch > sender.configEpoch {
+			sender.configEpoch = h.configEpoch
+			c.todoSaveConfig = true
+		}
+	}
+
+	switch h.typ {
+	case clusterMsgPing, clusterMsgMeet:
+		// 通过MEET得知本节点被其他节点看到的地址，尚不知道自己的地址时通过任意的PING得知
+		if h.typ == clusterMsgMeet || c.myself.ip == "" {
+			ip, _, _ := net.SplitHostPort(link.conn.LocalAddr().String())
+			if ip != c.myself.ip {
+				c.myself.ip = ip
+				c.todoSaveConfig = true
+				slog.Info("IP address for this node updated", "ip", ip)
+			}
+		}
+		// 未知的节点发来MEET时开始握手，握手完成前不处理它的其他信息
+		if sender == nil && h.typ == clusterMsgMeet {
+			ip := h.ip
+			if ip == "" {
+				ip, _, _ = net.SplitHostPort(link.conn.RemoteAddr().String())
+			}
+			s.clusterStartHandshake(ip, h.port, h.busPort, false)
+			s.clusterProcessGossipSection(nil, args[clusterHeaderLen:])
+			c.todoSaveConfig = true
+		}
+		s.clusterSendPing(link, clusterMsgPong)
+	case clusterMsgPong:
+	case clusterMsgFail:
+		if sender != nil && len(args) > clusterHeaderLen {
+			failing := c.nodes[args[clusterHeaderLen]]
+			if failing != nil && !failing.hasFlag(nodeFail|nodeMyself) {
+				slog.Info("FAIL message received about node", "from", sender.name, "node", failing.name)
+				failing.flags = failing.flags&^nodePFail | nodeFail
+				failing.failTime = mstime()
+				s.clusterUpdateState()
+				c.todoSaveConfig = true
+			}
+		}
+		return nil
+	default:
+		return errors.New("unknown cluster message type " + h.typ)
+	}
+
+	if n := link.node; n != nil && (h.typ == clusterMsgPong || h.typ == clusterMsgPing) {
+		if n.hasFlag(nodeHandshake) {
+			// 握手完成：节点已知时删除握手用的节点，否则以真实名称代替随机名称
+			if sender != nil {
+				s.clusterDelNode(n)
+				return nil
+			}
+			s.clusterRenameNode(n, h.sender)
+			n.flags = n.flags&^nodeHandshake | h.flags&nodeMaster
+			c.todoSaveConfig = true
+			sender = n
+		} else if n.name != h.sender {
+			// 地址上的节点已不是原来的节点
+			slog.Info("node address now belongs to another node, freeing link", "node", n.name, "other", h.sender)
+			n.flags |= nodeNoAddr
+			s.clusterFreeLink(link)
+			return nil
+		}
+	}
+	if n := link.node; n != nil && h.typ == clusterMsgPong {
+		n.pongReceived = mstime()
+		n.pingSent = 0
+		if n.hasFlag(nodePFail) {
+			n.flags &^= nodePFail
+			s.clusterUpdateState()
+		} else {
+			s.clearNodeFailureIfNeeded(n)
+		}
+	}
+	if sender == nil {
+		return nil
+	}
+	if !bytes.Equal(sender.slots[:], h.slots) {
+		s.clusterUpdateSlotsConfigWith(sender, h.configEpoch, h.slots)
+	}
+	s.clusterHandleConfigEpochCollision(sender)
+	s.clusterProcessGossipSection(sender, args[clusterHeaderLen:])
+	return nil
+}
+
+// clusterProcessGossipSection 处理消息中关于其他节点的gossip：主节点报告的下线状态计入
+// 下线报告，未知的节点开始握手
+func (s *Service) clusterProcessGossipSection(sender *clusterNode, gossip []string) {
+	c := s.cluster
+	for ; len(gossip) >= clusterGossipLen; gossip = gossip[clusterGossipLen:] {
+		name, ip := gossip[0], gossip[1]
+		port, err1 := strconv.Atoi(gossip[2])
+		busPort, err2 := strconv.Atoi(gossip[3])
+		flags, err3 := strconv.Atoi(gossip[4])
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		n := c.nodes[name]
+		if n != nil {
+			if sender == nil || !sender.hasFlag(nodeMaster) || n == c.myself {
+				continue
+			}
+			if flags&(nodePFail|nodeFail) != 0 {
+				n.failReports[sender] = mstime()
+				s.markNodeAsFailingIfNeeded(n)
+			} else {
+				delete(n.failReports, sender)
+			}
+			continue
+		}
+		if flags&nodeNoAddr == 0 && ip != "" && sender != nil {
+			s.clusterStartHandshake(ip, port, busPort, false)
+		}
+	}
+}
+
+// clusterCron 每次serverCron时执行：重建断开的连接，定期PING其他节点并检测下线
+func (s *Service) clusterCron() {
+	c := s.cluster
+	now := mstime()
+	timeout := s.ClusterNodeTimeout
+	handshakeTimeout := max(timeout, 1000)
+
+	for _, n := range s.clusterRandomNodes(nodeNoAddr) {
+		if n.hasFlag(nodeHandshake) && now-n.ctime > handshakeTimeout {
+			slog.Debug("handshake timeout, removing node", "addr", n.addr())
+			s.clusterDelNode(n)
+			continue
+		}
+		if n.link == nil && !n.connecting {
+			s.clusterConnectNode(n)
+		}
+	}
+
+	// 每秒从随机的5个节点中选出最久没有收到PONG的节点发送PING
+	if s.cronloops%serverHz == 0 {
+		var oldest *clusterNode
+		candidates := 0
+		for _, n := range s.clusterRandomNodes(nodeHandshake | nodeNoAddr) {
+			if candidates == 5 {
+				break
+			}
+			if n.link == nil || n.pingSent != 0 {
+				continue
+			}
+			candidates++
+			if oldest == nil || n.pongReceived < oldest.pongReceived {
+				oldest = n
+			}
+		}
+		if oldest != nil {
+			s.clusterSendPing(oldest.link, clusterMsgPing)
+		}
+	}
+
+	for _, n := range s.clusterRandomNodes(nodeHandshake | nodeNoAddr) {
+		// PING长时间没有回复时重建连接，排除连接本身的问题
+		if n.link != nil && n.pingSent != 0 && now-n.link.ctime > timeout && now-n.pingSent > timeout/2 {
+			s.clusterFreeLink(n.link)
+		}
+		// 超过nodeTimeout的一半没有收到PONG时立即PING，保证能及时发现下线
+		if n.link != nil && n.pingSent == 0 && now-n.pongReceived > timeout/2 {
+			s.clusterSendPing(n.link, clusterMsgPing)
+			continue
+		}
+		if n.pingSent != 0 && now-n.pingSent > timeout && !n.isFailing() {
+			slog.Info("node might be failing", "node", n.name)
+			n.flags |= nodePFail
+			s.clusterUpdateState()
+		}
+	}
+	for _, n := range c.nodes {
+		s.markNodeAsFailingIfNeeded(n)
+	}
+	s.clusterUpdateState()
+	s.clusterRedirectBlockedClients()
+}
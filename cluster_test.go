@@ -0,0 +1,534 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestClusterService 创建开启集群模式但不监听端口的服务
+func newTestClusterService(t *testing.T) *Service {
+	s := NewService(Config{ClusterEnabled: true, Dir: t.TempDir()})
+	assert.NoError(t, s.clusterInit())
+	return s
+}
+
+// clusterInfoField 返回CLUSTER INFO中字段的值
+func clusterInfoField(c *testClient, field string) string {
+	res := c.do(CommentCluster, "INFO")
+	for _, line := range strings.Split(string(res.(resp.BulkStrings)), "\r\n") {
+		if v, ok := strings.CutPrefix(line, field+":"); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+func TestCommandKeys(t *testing.T) {
+	testCases := []struct {
+		name string
+		args []string
+		keys []string
+	}{
+		{name: "测试单个键", args: []string{"GET", "k"}, keys: []string{"k"}},
+		{name: "测试全部参数都是键", args: []string{"DEL", "a", "b", "c"}, keys: []string{"a", "b", "c"}},
+		{name: "测试末尾的超时参数", args: []string{"BLPOP", "a", "b", "0"}, keys: []string{"a", "b"}},
+		{name: "测试numkeys", args: []string{"LMPOP", "2", "a", "b", "LEFT"}, keys: []string{"a", "b"}},
+		{name: "测试不含键的命令", args: []string{"PING"}, keys: nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.keys, lookupCommand(tc.args[0]).keys(tc.args))
+		})
+	}
+}
+
+func TestClusterRedirect(t *testing.T) {
+	s := newTestClusterService(t)
+	p, _ := newTestPeer(s)
+	other := createClusterNode("", nodeMaster)
+	other.ip, other.port = "127.0.0.1", 7001
+	s.clusterAddNode(other)
+
+	// 未分配全部槽时集群不可用
+	assert.Equal(t, errors.New("CLUSTERDOWN Hash slot not served"), doCommand(s, p, "GET", "foo"))
+	assert.Equal(t, "OK", doCommand(s, p, CommentCluster, "ADDSLOTSRANGE", "0", "8191"))
+	assert.Equal(t, errors.New("CLUSTERDOWN The cluster is down"), doCommand(s, p, "GET", "bar"))
+	for slot := 8192; slot < clusterSlots; slot++ {
+		s.clusterAddSlot(other, slot)
+	}
+	s.clusterUpdateState()
+
+	// foo属于12182，bar属于5061，b属于3300
+	testCases := []struct {
+		name string
+		args []string
+		res  any
+	}{
+		{
+			name: "测试本节点负责的槽",
+			args: []string{"SET", "bar", "1"},
+			res:  "OK",
+		},
+		{
+			name: "测试其他节点负责的槽",
+			args: []string{"GET", "foo"},
+			res:  errors.New("MOVED 12182 127.0.0.1:7001"),
+		},
+		{
+			name: "测试键属于不同的槽",
+			args: []string{"DEL", "bar", "foo"},
+			res:  errCrossSlot,
+		},
+		{
+			name: "测试分片频道属于不同的槽",
+			args: []string{"SSUBSCRIBE", "bar", "foo"},
+			res:  errCrossSlot,
+		},
+		{
+			name: "测试hashtag中的键属于本节点",
+			args: []string{"SET", "{bar}1", "a"},
+			res:  "OK",
+		},
+		{
+			name: "测试hashtag使键属于同一个槽",
+			args: []string{"EXISTS", "bar", "{bar}1", "{bar}2"},
+			res:  int64(2),
+		},
+		{
+			name: "测试不含键的命令",
+			args: []string{"PING"},
+			res:  "PONG",
+		},
+		{
+			name: "测试KEYSLOT",
+			args: []string{CommentCluster, "KEYSLOT", "{foo}bar"},
+			res:  int64(12182),
+		},
+		{
+			name: "测试COUNTKEYSINSLOT",
+			args: []string{CommentCluster, "COUNTKEYSINSLOT", "5061"},
+			res:  int64(2),
+		},
+		{
+			name: "测试ADDSLOTS已分配的槽",
+			args: []string{CommentCluster, "ADDSLOTS", "1"},
+			res:  errors.New("ERR Slot 1 is already busy"),
+		},
+		{
+			name: "测试ADDSLOTS槽超出范围",
+			args: []string{CommentCluster, "ADDSLOTS", "16384"},
+			res:  errInvalidSlot,
+		},
+		{
+			name: "测试DELSLOTS重复的槽",
+			args: []string{CommentCluster, "DELSLOTS", "1", "1"},
+			res:  errors.New("ERR Slot 1 specified multiple times"),
+		},
+		{
+			name: "测试ADDSLOTSRANGE区间颠倒",
+			args: []string{CommentCluster, "ADDSLOTSRANGE", "10", "1"},
+			res:  errors.New("ERR start slot number 10 is greater than end slot number 1"),
+		},
+		{
+			name: "测试MEET地址无效",
+			args: []string{CommentCluster, "MEET", "nohost", "7000"},
+			res:  errors.New("ERR Invalid node address specified: nohost:7000"),
+		},
+		{
+			name: "测试集群模式下不能REPLICAOF",
+			args: []string{CommentReplicaOf, "127.0.0.1", "7000"},
+			res:  errors.New("ERR REPLICAOF not allowed in cluster mode."),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.res, doCommand(s, p, tc.args...))
+		})
+	}
+
+	// 事务中的全部键须属于同一个槽，EXEC出错时事务被丢弃
+	assert.Equal(t, "OK", doCommand(s, p, "MULTI"))
+	assert.Equal(t, "QUEUED", doCommand(s, p, "SET", "b", "a"))
+	assert.Equal(t, "QUEUED", doCommand(s, p, "SET", "bar", "a"))
+	assert.Equal(t, errCrossSlot, doCommand(s, p, "EXEC"))
+	assert.Nil(t, p.mstate)
+
+	// 其他节点以更大的纪元声明槽时接管该槽，本节点中该槽的键被删除
+	other.configEpoch = 1
+	slots := other.slots
+	slots[5061/8] |= 1 << (5061 % 8)
+	s.clusterUpdateSlotsConfigWith(other, other.configEpoch, slots[:])
+	assert.Equal(t, errors.New("MOVED 5061 127.0.0.1:7001"), doCommand(s, p, "GET", "bar"))
+	assert.Equal(t, 0, s.db.countKeysInSlot(5061))
+	assert.Equal(t, int64(0), doCommand(s, p, CommentCluster, "COUNTKEYSINSLOT", "5061"))
+}
+
+func TestClusterDisabled(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	assert.Equal(t, errClusterDisabled, doCommand(s, p, CommentCluster, "INFO"))
+	// 未开启集群模式时不检查键所属的槽
+	assert.Equal(t, int64(0), doCommand(s, p, "EXISTS", "foo", "bar"))
+}
+
+func TestClusterConfig(t *testing.T) {
+	s := newTestClusterService(t)
+	p, _ := newTestPeer(s)
+	other := createClusterNode("", nodeMaster|nodePFail)
+	other.ip, other.port, other.busPort, other.configEpoch = "127.0.0.1", 7001, 17001, 3
+	s.clusterAddNode(other)
+	s.clusterAddSlot(other, 100)
+	s.cluster.currentEpoch = 5
+	assert.Equal(t, "OK", doCommand(s, p, CommentCluster, "ADDSLOTSRANGE", "0", "99", "200", "300"))
+	assert.Equal(t, "OK", doCommand(s, p, CommentCluster, "SAVECONFIG"))
+
+	// 重启后载入同样的节点与槽，PFAIL状态需要重新确认
+	loaded := NewService(Config{ClusterEnabled: true, Dir: s.Dir})
+	assert.NoError(t, loaded.clusterInit())
+	other.flags &^= nodePFail
+	assert.Equal(t, s.clusterGenNodesDescription(0), loaded.clusterGenNodesDescription(0))
+	assert.Equal(t, s.cluster.myself.name, loaded.cluster.myself.name)
+	assert.Equal(t, int64(5), loaded.cluster.currentEpoch)
+	assert.Equal(t, 201, loaded.cluster.myself.numSlots)
+	assert.Contains(t, loaded.clusterGenNodesDescription(0),
+		fmt.Sprintf("%s 127.0.0.1:7001@17001 master - 0 0 3 disconnected 100\n", other.name))
+}
+
+func TestClusterMultiNode(t *testing.T) {
+	var nodes []*Service
+	var clients []*testClient
+	for i := 0; i < 3; i++ {
+		s := startTestService(t, Config{
+			Dir:                t.TempDir(),
+			ClusterEnabled:     true,
+			ClusterBusAddr:     "127.0.0.1:0",
+			ClusterNodeTimeout: 1000,
+		})
+		nodes = append(nodes, s)
+		clients = append(clients, dialTestService(t, s))
+	}
+	port := func(s *Service) int { return s.ln.Addr().(*net.TCPAddr).Port }
+	busPort := func(s *Service) int { return s.cluster.busLn.Addr().(*net.TCPAddr).Port }
+	ranges := [][2]string{{"0", "5460"}, {"5461", "10922"}, {"10923", "16383"}}
+	for i, c := range clients {
+		assert.Equal(t, "OK", c.do(CommentCluster, "ADDSLOTSRANGE", ranges[i][0], ranges[i][1]))
+	}
+	for _, s := range nodes[1:] {
+		assert.Equal(t, "OK", clients[0].do(CommentCluster, "MEET", "127.0.0.1",
+			strconv.Itoa(port(s)), strconv.Itoa(busPort(s))))
+	}
+	for _, c := range clients {
+		assert.Eventually(t, func() bool {
+			return clusterInfoField(c, "cluster_state") == "ok" && clusterInfoField(c, "cluster_known_nodes") == "3"
+		}, 5*time.Second, 20*time.Millisecond)
+	}
+
+	// foo属于12182，由第三个节点负责
+	addr := fmt.Sprintf("127.0.0.1:%d", port(nodes[2]))
+	assert.Equal(t, errors.New("MOVED 12182 "+addr), clients[0].do("SET", "foo", "1"))
+	assert.Equal(t, "OK", clients[2].do("SET", "foo", "1"))
+	assert.Equal(t, errCrossSlot, clients[2].do("EXISTS", "foo", "bar"))
+	assert.Equal(t, "3", clusterInfoField(clients[1], "cluster_size"))
+
+	ids := make([]resp.BulkStrings, len(clients))
+	for i, c := range clients {
+		ids[i] = c.do(CommentCluster, "MYID").(resp.BulkStrings)
+	}
+	slots := clients[1].do(CommentCluster, "SLOTS").(resp.Array)
+	assert.Len(t, slots, 3)
+	for i, r := range slots {
+		assert.Equal(t, resp.Array{
+			mustParseInt(ranges[i][0]), mustParseInt(ranges[i][1]),
+			resp.Array{resp.BulkStrings("127.0.0.1"), int64(port(nodes[i])), ids[i], resp.Array{}},
+		}, r)
+	}
+	lines := strings.Split(strings.TrimSpace(string(clients[0].do(CommentCluster, "NODES").(resp.BulkStrings))), "\n")
+	assert.Len(t, lines, 3)
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		assert.Equal(t, "connected", fields[7])
+		assert.Contains(t, []string{"master", "myself,master"}, fields[2])
+	}
+
+	// 一个节点停止后，其余两个主节点达成多数将其标记为FAIL，集群不再可用
+	nodes[2].Close()
+	for _, c := range clients[:2] {
+		assert.Eventually(t, func() bool {
+			return clusterInfoField(c, "cluster_state") == "fail"
+		}, 5*time.Second, 50*time.Millisecond)
+		assert.Contains(t, string(c.do(CommentCluster, "NODES").(resp.BulkStrings)), string(ids[2])+" "+addr+"@")
+		assert.Equal(t, errors.New("CLUSTERDOWN The cluster is down"), c.do("GET", "bar"))
+	}
+	assert.Contains(t, string(clients[0].do(CommentCluster, "NODES").(resp.BulkStrings)), "master,fail ")
+}
+
+func TestClusterSetSlot(t *testing.T) {
+	s := newTestClusterService(t)
+	p, _ := newTestPeer(s)
+	other := createClusterNode("", nodeMaster)
+	other.ip, other.port, other.busPort = "127.0.0.1", 7001, 17001
+	s.clusterAddNode(other)
+	assert.Equal(t, "OK", doCommand(s, p, CommentCluster, "ADDSLOTSRANGE", "0", "8191"))
+	for slot := 8192; slot < clusterSlots; slot++ {
+		s.clusterAddSlot(other, slot)
+	}
+	s.clusterUpdateState()
+	doCommand(s, p, "SET", "bar", "1")
+
+	// bar与{bar}1属于5061，由本节点负责；foo属于12182，由other负责
+	testCases := []struct {
+		name string
+		args []string
+		res  any
+	}{
+		{
+			name: "测试迁出不属于本节点的槽",
+			args: []string{CommentCluster, "SETSLOT", "12182", "MIGRATING", other.name},
+			res:  errors.New("ERR I'm not the owner of hash slot 12182"),
+		},
+		{
+			name: "测试导入本节点的槽",
+			args: []string{CommentCluster, "SETSLOT", "5061", "IMPORTING", other.name},
+			res:  errors.New("ERR I'm already the owner of hash slot 5061"),
+		},
+		{
+			name: "测试未知的节点",
+			args: []string{CommentCluster, "SETSLOT", "5061", "MIGRATING", "nonode"},
+			res:  errors.New("ERR I don't know about node nonode"),
+		},
+		{
+			name: "测试未知的动作",
+			args: []string{CommentCluster, "SETSLOT", "5061", "FOO"},
+			res:  errors.New("ERR Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP"),
+		},
+		{
+			name: "测试MIGRATING",
+			args: []string{CommentCluster, "SETSLOT", "5061", "MIGRATING", other.name},
+			res:  "OK",
+		},
+		{
+			name: "测试迁出中的槽键存在时在本节点执行",
+			args: []string{"GET", "bar"},
+			res:  []byte("1"),
+		},
+		{
+			name: "测试迁出中的槽键不存在时ASK",
+			args: []string{"GET", "{bar}1"},
+			res:  errors.New("ASK 5061 127.0.0.1:7001"),
+		},
+		{
+			name: "测试迁出中的槽部分键不存在",
+			args: []string{"EXISTS", "bar", "{bar}1"},
+			res:  errTryAgain,
+		},
+		{
+			name: "测试重复的键不算多个键",
+			args: []string{"EXISTS", "{bar}1", "{bar}1"},
+			res:  errors.New("ASK 5061 127.0.0.1:7001"),
+		},
+		{
+			name: "测试仍有键时不能分配给其他节点",
+			args: []string{CommentCluster, "SETSLOT", "5061", "NODE", other.name},
+			res:  errors.New("ERR Can't assign hashslot 5061 to a different node while I still hold keys for this hash slot."),
+		},
+		{
+			name: "测试分配给未知的节点",
+			args: []string{CommentCluster, "SETSLOT", "5061", "NODE", "nonode"},
+			res:  errors.New("ERR Unknown node nonode"),
+		},
+		{
+			name: "测试IMPORTING",
+			args: []string{CommentCluster, "SETSLOT", "12182", "IMPORTING", other.name},
+			res:  "OK",
+		},
+		{
+			name: "测试导入中的槽未执行ASKING时MOVED",
+			args: []string{"SET", "foo", "1"},
+			res:  errors.New("MOVED 12182 127.0.0.1:7001"),
+		},
+		{
+			name: "测试RESTORE-ASKING可以访问导入中的槽",
+			args: []string{CommentRestoreAsking, "foo", "0", string(doCommand(s, p, CommentDump, "bar").(resp.BulkStrings))},
+			res:  "OK",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.res, doCommand(s, p, tc.args...))
+		})
+	}
+
+	// ASKING只对下一条命令有效
+	p.handleMSG(s, Message{peer: p, args: []string{CommentAsking}})
+	assert.True(t, p.asking)
+	p.handleMSG(s, Message{peer: p, args: []string{"GET", "foo"}})
+	assert.False(t, p.asking)
+	assert.Equal(t, errors.New("MOVED 12182 127.0.0.1:7001"), doCommand(s, p, "GET", "foo"))
+	p.asking = true
+	assert.Equal(t, errTryAgain, doCommand(s, p, "EXISTS", "foo", "{foo}1"))
+
+	// 迁移状态随配置保存，重启后恢复
+	assert.NoError(t, s.clusterSaveConfig())
+	loaded := NewService(Config{ClusterEnabled: true, Dir: s.Dir})
+	assert.NoError(t, loaded.clusterInit())
+	assert.Equal(t, s.clusterGenNodesDescription(0), loaded.clusterGenNodesDescription(0))
+	assert.Contains(t, loaded.clusterGenNodesDescription(0), fmt.Sprintf(" [5061->-%s] [12182-<-%s]\n", other.name, other.name))
+
+	// 导入完成后本节点取得新的纪元
+	epoch := s.cluster.currentEpoch
+	assert.Equal(t, "OK", doCommand(s, p, CommentCluster, "SETSLOT", "12182", "NODE", s.cluster.myself.name))
+	assert.Nil(t, s.cluster.importingSlotsFrom[12182])
+	assert.Equal(t, s.cluster.myself, s.cluster.slots[12182])
+	assert.Equal(t, epoch+1, s.cluster.myself.configEpoch)
+	assert.Equal(t, fmt.Sprintf("STILL %d", epoch+1), doCommand(s, p, CommentCluster, "BUMPEPOCH"))
+
+	// 键迁走后可以把槽分配给其他节点，迁出状态随之结束
+	doCommand(s, p, "DEL", "bar")
+	assert.Equal(t, "OK", doCommand(s, p, CommentCluster, "SETSLOT", "5061", "NODE", other.name))
+	assert.Nil(t, s.cluster.migratingSlotsTo[5061])
+	assert.Equal(t, errors.New("MOVED 5061 127.0.0.1:7001"), doCommand(s, p, "GET", "bar"))
+	assert.Equal(t, "OK", doCommand(s, p, CommentCluster, "SETSLOT", "5061", "STABLE"))
+}
+
+// clusterTestClient 按MOVED与ASK重定向的测试客户端
+type clusterTestClient struct {
+	t     *testing.T
+	addr  string
+	conns map[string]*testClient
+}
+
+func newClusterTestClient(t *testing.T, addr string) *clusterTestClient {
+	return &clusterTestClient{t: t, addr: addr, conns: make(map[string]*testClient)}
+}
+
+// do 执行命令，收到MOVED时改为连接新节点，收到ASK时在目标节点上先执行ASKING再重试
+func (c *clusterTestClient) do(args ...string) any {
+	addr, asking := c.addr, false
+	for {
+		tc, ok := c.conns[addr]
+		if !ok {
+			tc = dialTestAddr(c.t, addr)
+			c.conns[addr] = tc
+		}
+		if asking {
+			assert.Equal(c.t, "OK", tc.do(CommentAsking))
+		}
+		res := tc.do(args...)
+		err, ok := res.(error)
+		if !ok {
+			return res
+		}
+		fields := strings.Fields(err.Error())
+		switch fields[0] {
+		case "MOVED":
+			addr, asking = fields[2], false
+			c.addr = addr
+		case "ASK":
+			addr, asking = fields[2], true
+		case "TRYAGAIN":
+			time.Sleep(time.Millisecond)
+		default:
+			return res
+		}
+	}
+}
+
+func TestClusterMigrateSlot(t *testing.T) {
+	var nodes []*Service
+	var clients []*testClient
+	for i := 0; i < 2; i++ {
+		s := startTestService(t, Config{
+			Dir:                t.TempDir(),
+			ClusterEnabled:     true,
+			ClusterBusAddr:     "127.0.0.1:0",
+			ClusterNodeTimeout: 1000,
+		})
+		nodes = append(nodes, s)
+		clients = append(clients, dialTestService(t, s))
+	}
+	port := func(s *Service) string { return strconv.Itoa(s.ln.Addr().(*net.TCPAddr).Port) }
+	busPort := strconv.Itoa(nodes[1].cluster.busLn.Addr().(*net.TCPAddr).Port)
+	assert.Equal(t, "OK", clients[0].do(CommentCluster, "ADDSLOTSRANGE", "0", "8191"))
+	assert.Equal(t, "OK", clients[1].do(CommentCluster, "ADDSLOTSRANGE", "8192", "16383"))
+	assert.Equal(t, "OK", clients[0].do(CommentCluster, "MEET", "127.0.0.1", port(nodes[1]), busPort))
+	for _, c := range clients {
+		assert.Eventually(t, func() bool {
+			return clusterInfoField(c, "cluster_state") == "ok" && clusterInfoField(c, "cluster_known_nodes") == "2"
+		}, 5*time.Second, 20*time.Millisecond)
+	}
+	ids := make([]string, len(clients))
+	for i, c := range clients {
+		ids[i] = string(c.do(CommentCluster, "MYID").(resp.BulkStrings))
+	}
+
+	// 写入者在迁移期间持续对槽5061中的键做INCR，跟随MOVED与ASK重定向
+	const numKeys = 50
+	counts := make([]int64, numKeys)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		cc := newClusterTestClient(t, "127.0.0.1:"+port(nodes[0]))
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				if i > 2*numKeys {
+					return
+				}
+			default:
+			}
+			k := i % numKeys
+			if _, ok := cc.do("INCR", fmt.Sprintf("{bar}%d", k)).(int64); ok {
+				counts[k]++
+			}
+		}
+	}()
+
+	const slot = "5061"
+	assert.Eventually(t, func() bool {
+		return clients[0].do(CommentCluster, "COUNTKEYSINSLOT", slot).(int64) == numKeys
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "OK", clients[1].do(CommentCluster, "SETSLOT", slot, "IMPORTING", ids[0]))
+	assert.Equal(t, "OK", clients[0].do(CommentCluster, "SETSLOT", slot, "MIGRATING", ids[1]))
+	for {
+		res := clients[0].do(CommentCluster, "GETKEYSINSLOT", slot, "7").(resp.Array)
+		if len(res) == 0 {
+			break
+		}
+		args := []string{CommentMigrate, "127.0.0.1", port(nodes[1]), "", "0", "5000", "KEYS"}
+		for _, key := range res {
+			args = append(args, string(key.(resp.BulkStrings)))
+		}
+		assert.Equal(t, "OK", clients[0].do(args...))
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, "OK", clients[1].do(CommentCluster, "SETSLOT", slot, "NODE", ids[1]))
+	assert.Equal(t, "OK", clients[0].do(CommentCluster, "SETSLOT", slot, "NODE", ids[1]))
+	close(stop)
+	wg.Wait()
+
+	// 迁移期间的写入没有丢失，槽中的键全部位于目标节点
+	assert.Equal(t, int64(0), clients[0].do(CommentCluster, "COUNTKEYSINSLOT", slot))
+	assert.Equal(t, int64(numKeys), clients[1].do(CommentCluster, "COUNTKEYSINSLOT", slot))
+	for k := 0; k < numKeys; k++ {
+		assert.Equal(t, resp.BulkStrings(strconv.FormatInt(counts[k], 10)), clients[1].do("GET", fmt.Sprintf("{bar}%d", k)))
+	}
+	assert.Equal(t, errors.New("MOVED 5061 127.0.0.1:"+port(nodes[1])), clients[0].do("GET", "bar"))
+	// 目标节点的新纪元大于源节点，两个节点最终对槽的归属达成一致且没有残留的迁移状态
+	for _, c := range clients {
+		assert.Eventually(t, func() bool {
+			nodes := string(c.do(CommentCluster, "NODES").(resp.BulkStrings))
+			return !strings.Contains(nodes, "[") && strings.Contains(nodes, " 5061 8192-16383\n") && strings.Contains(nodes, " 0-5060 5062-8191\n")
+		}, 5*time.Second, 20*time.Millisecond)
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+)
+
+const (
+	CommentSet     = "SET"
+	CommentPing    = "PING"
+	CommentEcho    = "ECHO"
+	CommentHello   = "HELLO"
+	CommentQuit    = "QUIT"
+	CommentCommand = "COMMAND"
+)
+
+const (
+	redisVersion = "7.2.0"
+)
+
+// 命令标志
+const (
+	// cmdWrite 命令可能修改数据
+	cmdWrite = 1 << iota
+	// cmdReadonly 命令只读取数据
+	cmdReadonly
+	// cmdAdmin 管理类命令
+	cmdAdmin
+	// cmdFast 时间复杂度为O(1)或O(log(N))的命令
+	cmdFast
+	// cmdBlocking 命令可能阻塞客户端
+	cmdBlocking
+	// cmdAsking 集群模式下如同先执行了ASKING，可以访问正在导入的槽
+	cmdAsking
+	// cmdSentinel 哨兵模式下可用的命令，cmdOnlySentinel 只在哨兵模式下可用的命令
+	cmdSentinel
+	cmdOnlySentinel
+)
+
+// commandFunc 命令的执行函数，args[0]为命令名，返回值为写回客户端的回复
+type commandFunc func(s *Service, p *Peer, args []string) any
+
+// command 命令表中的一项
+type command struct {
+	name string
+	// arity 参数个数（含命令名），负数表示至少需要-arity个参数
+	arity int
+	flags int
+	// firstKey、lastKey、keyStep 描述参数中键的位置，firstKey为0表示命令不含键，
+	// lastKey为负数表示从末尾倒数
+	firstKey int
+	lastKey  int
+	keyStep  int
+	// getkeys 键的位置无法用firstKey、lastKey、keyStep描述时使用
+	getkeys func(args []string) []string
+	proc    commandFunc
+}
+
+// noReplyType 表示命令已自行处理回复（例如阻塞命令），无需再写回
+type noReplyType struct{}
+
+var noReply any = noReplyType{}
+
+var (
+	errSyntax = errors.New("ERR syntax error")
+)
+
+// commandTable 命令名（大写）到命令的映射，由各文件的init注册
+var commandTable = make(map[string]*command)
+
+func registerCommand(cmds ...*command) {
+	for _, cmd := range cmds {
+		commandTable[cmd.name] = cmd
+	}
+}
+
+func lookupCommand(name string) *command {
+	return commandTable[strings.ToUpper(name)]
+}
+
+func (c *command) checkArity(argc int) bool {
+	return (c.arity > 0 && argc == c.arity) || (c.arity < 0 && argc >= -c.arity)
+}
+
+func (c *command) hasFlag(flag int) bool {
+	return c.flags&flag != 0
+}
+
+// keys 返回命令参数中的键
+func (c *command) keys(args []string) []string {
+	if c.getkeys != nil {
+		return c.getkeys(args)
+	}
+	if c.firstKey <= 0 || c.firstKey >= len(args) {
+		return nil
+	}
+	last := c.lastKey
+	if last < 0 {
+		last += len(args)
+	}
+	step := max(c.keyStep, 1)
+	var keys []string
+	for i := c.firstKey; i <= last && i < len(args); i += step {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+func unknownCommandErr(args []string) error {
+	var sb strings.Builder
+	for _, arg := range args[1:] {
+		if sb.Len() >= 128 {
+			break
+		}
+		fmt.Fprintf(&sb, "'%.128s' ", arg)
+	}
+	return fmt.Errorf("ERR unknown command '%.128s', with args beginning with: %s", args[0], sb.String())
+}
+
+func wrongArityErr(name string) error {
+	return fmt.Errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(name))
+}
+
+// processCommand 查找命令并检查参数个数，通过后执行
+func (s *Service) processCommand(p *Peer, args []string) any {
+	cmd := lookupCommand(args[0])
+	// 哨兵模式下只提供监控与发现相关的命令
+	if cmd == nil || (s.sentinel != nil && !cmd.hasFlag(cmdSentinel)) || (s.sentinel == nil && cmd.hasFlag(cmdOnlySentinel)) {
+		p.flagTransaction()
+		return unknownCommandErr(args)
+	}
+	if !cmd.checkArity(len(args)) {
+		p.flagTransaction()
+		return wrongArityErr(cmd.name)
+	}
+	// RESP2下订阅模式的连接只能收发订阅相关的消息，RESP3通过推送类型区分消息与回复
+	if p.proto < resp.Proto3 && p.subscriptionCount() > 0 && !allowedInSubscribeMode(cmd.name) {
+		p.flagTransaction()
+		return subscribeModeErr(cmd.name)
+	}
+	// 集群模式下键不由本节点负责时重定向客户端，主节点发来的复制流与载入的命令除外
+	if s.cluster != nil && !p.master && !s.loading {
+		if err := s.clusterRedirect(p, cmd, args); err != nil {
+			p.flagTransaction()
+			return err
+		}
+	}
+	// AOF写入失败时拒绝写命令，避免修改只保存在内存中
+	if cmd.hasFlag(cmdWrite) && s.aofLastWriteErr != nil {
+		p.flagTransaction()
+		return fmt.Errorf("MISCONF Errors writing to the AOF file: %v", s.aofLastWriteErr)
+	}
+	// 只读副本拒绝普通客户端的写命令，主节点发来的复制流除外
+	if cmd.hasFlag(cmdWrite) && s.masterHost != "" && !p.master && !s.loading {
+		p.flagTransaction()
+		return errors.New("READONLY You can't write against a read only replica.")
+	}
+	// 事务中的命令排队，由EXEC统一执行
+	if p.mstate != nil && !execImmediately(cmd.name) {
+		p.mstate.commands = append(p.mstate.commands, queuedCommand{cmd: cmd, args: args})
+		return "QUEUED"
+	}
+	return s.call(p, cmd, args)
+}
+
+// call 执行命令。写命令修改了数据时将其传播到AOF，EXEC中执行的命令
+// 先暂存在alsoPropagate中，最外层的命令结束后一起传播
+func (s *Service) call(p *Peer, cmd *command, args []string) any {
+	dirty := s.db.dirtyCount()
+	expired := s.statExpiredKeys
+	prevPropagateAs, prevClient := s.propagateAs, s.currentClient
+	s.propagateAs, s.currentClient = nil, p
+	s.callDepth++
+	reply := cmd.proc(s, p, args)
+	s.callDepth--
+	s.currentClient = prevClient
+	propagateAs := s.propagateAs
+	s.propagateAs = prevPropagateAs
+
+	// 命令执行期间因过期删除的键已经单独传播了DEL，不计入命令自身的修改
+	dirty = s.db.dirtyCount() - dirty - (s.statExpiredKeys - expired)
+	if cmd.hasFlag(cmdWrite) && dirty > 0 {
+		if propagateAs != nil {
+			for _, argv := range propagateAs {
+				s.alsoPropagate(argv)
+			}
+		} else {
+			s.alsoPropagate(args)
+		}
+	}
+	if s.callDepth == 0 {
+		s.propagatePendingCommands()
+		p.woff = s.masterReplOffset
+	}
+	return reply
+}
+
+// alsoPropagate 暂存需要传播的命令，载入持久化文件期间不传播
+func (s *Service) alsoPropagate(args []string) {
+	if s.loading {
+		return
+	}
+	s.pendingPropagate = append(s.pendingPropagate, args)
+}
+
+// rewritePropagate 使当前命令以cmds代替自身传播，用于把随机、依赖当前时间的命令
+// 改写为确定性的形式，例如SPOP改写为SREM、相对过期时间改写为PEXPIREAT
+func (s *Service) rewritePropagate(cmds ...[]string) {
+	s.propagateAs = append([][]string{}, cmds...)
+}
+
+// propagatePendingCommands 传播最外层命令执行期间暂存的命令，
+// 多于一条时以MULTI/EXEC包裹，保证载入时同样原子地执行
+func (s *Service) propagatePendingCommands() {
+	cmds := s.pendingPropagate
+	s.pendingPropagate = nil
+	switch len(cmds) {
+	case 0:
+		return
+	case 1:
+		s.propagate(cmds[0])
+	default:
+		s.propagate([]string{CommentMulti})
+		for _, argv := range cmds {
+			s.propagate(argv)
+		}
+		s.propagate([]string{CommentExec})
+	}
+	s.flushAppendOnlyFile(false)
+}
+
+// propagate 将命令写入AOF并发给副本。副本只转发主节点的复制流，
+// 自身执行命令产生的修改（例如惰性过期的DEL）只写入AOF
+func (s *Service) propagate(args []string) {
+	s.feedAppendOnlyFile(args)
+	if s.masterHost == "" {
+		s.replicationFeedReplicas(args)
+	}
+}
+
+// expirePolicy 载入持久化文件期间不处理过期，与Redis一致，重放的命令看到的数据与写入时相同。
+// 副本上的键只由主节点传播的DEL删除：主节点的复制流总是看到保存的值，普通客户端看不到已过期的键
+func (s *Service) expirePolicy() int {
+	switch {
+	case s.loading:
+		return expireKeep
+	case s.masterHost == "":
+		return expireDelete
+	case s.currentClient != nil && s.currentClient.master:
+		return expireKeep
+	default:
+		return expireHide
+	}
+}
+
+// propagateExpire 键因过期被删除时以DEL传播，不在命令中时（主动过期）立即传播
+func (s *Service) propagateExpire(key string) {
+	s.statExpiredKeys++
+	s.alsoPropagate([]string{CommentDel, key})
+	if s.callDepth == 0 {
+		s.propagatePendingCommands()
+	}
+}
+
+func init() {
+	registerCommand(
+		&command{name: CommentPing, arity: -1, flags: cmdFast | cmdSentinel, proc: pingCommand},
+		&command{name: CommentEcho, arity: 2, flags: cmdFast, proc: echoCommand},
+		&command{name: CommentHello, arity: -1, flags: cmdFast | cmdSentinel, proc: helloCommand},
+		&command{name: CommentQuit, arity: -1, flags: cmdFast | cmdSentinel, proc: quitCommand},
+		&command{name: CommentCommand, arity: -1, flags: cmdSentinel, proc: commandCommand},
+	)
+}
+
+// pingCommand PING [message]
+func pingCommand(s *Service, p *Peer, args []string) any {
+	if len(args) > 2 {
+		return wrongArityErr(args[0])
+	}
+	// RESP2订阅模式下以数组回复，便于客户端与推送消息区分
+	if p.proto < resp.Proto3 && p.subscriptionCount() > 0 {
+		msg := ""
+		if len(args) == 2 {
+			msg = args[1]
+		}
+		return resp.Array{resp.BulkStrings("pong"), resp.BulkStrings(msg)}
+	}
+	if len(args) == 2 {
+		return resp.BulkStrings(args[1])
+	}
+	return "PONG"
+}
+
+// echoCommand ECHO message
+func echoCommand(s *Service, p *Peer, args []string) any {
+	return resp.BulkStrings(args[1])
+}
+
+// quitCommand QUIT 回复后关闭连接
+func quitCommand(s *Service, p *Peer, args []string) any {
+	p.closeAfterReply = true
+	return "OK"
+}
+
+// helloCommand HELLO [protover [AUTH username password] [SETNAME clientname]]
+func helloCommand(s *Service, p *Peer, args []string) any {
+	proto := p.proto
+	if len(args) > 1 {
+		ver, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return errors.New("ERR Protocol version is not an integer or out of range")
+		}
+		if ver != resp.Proto2 && ver != resp.Proto3 {
+			return errors.New("NOPROTO unsupported protocol version")
+		}
+		proto = int(ver)
+	}
+	name := p.name
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "AUTH" && i+2 < len(args):
+			// 未配置密码时default用户接受任意密码
+			i += 2
+		case opt == "SETNAME" && i+1 < len(args):
+			if strings.ContainsAny(args[i+1], " \n") {
+				return errors.New("ERR Client names cannot contain spaces, newlines or special characters.")
+			}
+			name = args[i+1]
+			i++
+		default:
+			return fmt.Errorf("ERR Syntax error in HELLO option '%s'", args[i])
+		}
+	}
+	p.setProtocol(proto)
+	p.name = name
+	role := "master"
+	if s.masterHost != "" {
+		role = "replica"
+	}
+	mode := "standalone"
+	if s.cluster != nil {
+		mode = "cluster"
+	}
+	if s.sentinel != nil {
+		mode = "sentinel"
+	}
+	return resp.Maps{
+		resp.BulkStrings("server"):  resp.BulkStrings("redis"),
+		resp.BulkStrings("version"): resp.BulkStrings(redisVersion),
+		resp.BulkStrings("proto"):   int64(proto),
+		resp.BulkStrings("id"):      p.id,
+		resp.BulkStrings("mode"):    resp.BulkStrings(mode),
+		resp.BulkStrings("role"):    resp.BulkStrings(role),
+		resp.BulkStrings("modules"): resp.Array{},
+	}
+}
+
+// commandCommand COMMAND [COUNT|DOCS|INFO [command ...]]
+func commandCommand(s *Service, p *Peer, args []string) any {
+	if len(args) == 1 {
+		res := make(resp.Array, 0, len(commandTable))
+		for _, cmd := range commandTable {
+			res = append(res, cmd.info())
+		}
+		return res
+	}
+	switch strings.ToUpper(args[1]) {
+	case "COUNT":
+		return int64(len(commandTable))
+	case "DOCS":
+		return resp.Maps{}
+	case "INFO":
+		res := make(resp.Array, 0, len(args)-2)
+		for _, name := range args[2:] {
+			if cmd := lookupCommand(name); cmd != nil {
+				res = append(res, cmd.info())
+			} else {
+				res = append(res, nil)
+			}
+		}
+		return res
+	default:
+		return fmt.Errorf("ERR unknown subcommand '%.128s'. Try COMMAND HELP.", args[1])
+	}
+}
+
+// info 返回COMMAND INFO格式的命令描述
+func (c *command) info() resp.Array {
+	flags := resp.Array{}
+	if c.hasFlag(cmdWrite) {
+		flags = append(flags, "write")
+	}
+	if c.hasFlag(cmdReadonly) {
+		flags = append(flags, "readonly")
+	}
+	if c.hasFlag(cmdAdmin) {
+		flags = append(flags, "admin")
+	}
+	if c.hasFlag(cmdFast) {
+		flags = append(flags, "fast")
+	}
+	if c.hasFlag(cmdBlocking) {
+		flags = append(flags, "blocking")
+	}
+	if c.hasFlag(cmdAsking) {
+		flags = append(flags, "asking")
+	}
+	if c.hasFlag(cmdSentinel) {
+		flags = append(flags, "sentinel")
+	}
+	if c.hasFlag(cmdOnlySentinel) {
+		flags = append(flags, "only_sentinel")
+	}
+	return resp.Array{
+		resp.BulkStrings(strings.ToLower(c.name)),
+		int64(c.arity),
+		flags,
+		int64(c.firstKey),
+		int64(c.lastKey),
+		int64(c.keyStep),
+	}
+}
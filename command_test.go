@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestPeer 创建不带连接的peer，回复写入返回的缓冲区
+func newTestPeer(s *Service) (*Peer, *bytes.Buffer) {
+	var buf bytes.Buffer
+	s.nextPeerID++
+	p := &Peer{id: s.nextPeerID, wr: resp.NewWriter(&buf), proto: resp.Proto2}
+	s.peers[p] = true
+	return p, &buf
+}
+
+// doCommand 直接在当前goroutine中执行命令并返回回复
+func doCommand(s *Service, p *Peer, args ...string) any {
+	return s.processCommand(p, args)
+}
+
+// startTestService 在回环地址的随机端口上启动服务，测试结束时关闭
+func startTestService(t *testing.T, cfg Config) *Service {
+	cfg.ListenAddr = "127.0.0.1:0"
+	s := NewService(cfg)
+	if cfg.ClusterEnabled {
+		assert.NoError(t, s.clusterInit())
+	}
+	if cfg.Sentinel {
+		assert.NoError(t, s.sentinelInit())
+	}
+	assert.NoError(t, s.openAppendOnlyFile())
+	assert.NoError(t, s.listen())
+	go s.acceptLoop()
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestProcessCommand(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	testCases := []struct {
+		name string
+		args []string
+		res  any
+	}{
+		{
+			name: "测试PING",
+			args: []string{"ping"},
+			res:  "PONG",
+		},
+		{
+			name: "测试PING带参数",
+			args: []string{"PING", "hi"},
+			res:  resp.BulkStrings("hi"),
+		},
+		{
+			name: "测试ECHO参数个数错误",
+			args: []string{"echo"},
+			res:  errors.New("ERR wrong number of arguments for 'echo' command"),
+		},
+		{
+			name: "测试未知命令",
+			args: []string{"foo", "a", "b"},
+			res:  errors.New("ERR unknown command 'foo', with args beginning with: 'a' 'b' "),
+		},
+		{
+			name: "测试HELLO协议版本错误",
+			args: []string{"HELLO", "4"},
+			res:  errors.New("NOPROTO unsupported protocol version"),
+		},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert.Equal(t, v.res, doCommand(s, p, v.args...))
+		})
+	}
+}
+
+func TestServiceLoopback(t *testing.T) {
+	s := startTestService(t, Config{})
+	conn, err := net.Dial("tcp", s.ln.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	// 命令被拆分成两次写入
+	conn.Write([]byte("*2\r\n$4\r\nECHO\r\n$5\r\nhe"))
+	time.Sleep(10 * time.Millisecond)
+	conn.Write([]byte("llo\r\nHELLO 3\r\n"))
+
+	rd := resp.NewReader(conn)
+	res, err := rd.ReadValue()
+	assert.NoError(t, err)
+	assert.Equal(t, resp.BulkStrings("hello"), res)
+	res, err = rd.ReadValue()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(resp.Proto3), res.(resp.Maps)[resp.BulkStrings("proto")])
+}
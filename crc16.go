@@ -0,0 +1,42 @@
+package main
+
+import "strings"
+
+// clusterSlots 集群的哈希槽数
+const clusterSlots = 16384
+
+// crc16Table CRC16-CCITT(XMODEM)查表，多项式0x1021
+var crc16Table = func() (t [256]uint16) {
+	for i := range t {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		t[i] = crc
+	}
+	return t
+}()
+
+// crc16 计算CRC16-CCITT(XMODEM)，与Redis集群使用的算法一致
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
+
+// keyHashSlot 计算键所属的哈希槽。键中含有非空的{hashtag}时只对hashtag求值，
+// 使相关的键落在同一个槽中
+func keyHashSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) & (clusterSlots - 1)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyHashSlot(t *testing.T) {
+	assert.Equal(t, uint16(0x31c3), crc16("123456789"))
+	testCases := []struct {
+		name string
+		key  string
+		slot int
+	}{
+		{name: "测试普通键", key: "foo", slot: 12182},
+		{name: "测试hashtag", key: "{user1000}.following", slot: keyHashSlot("user1000")},
+		{name: "测试空hashtag", key: "foo{}{bar}", slot: int(crc16("foo{}{bar}")) & (clusterSlots - 1)},
+		{name: "测试第一个hashtag", key: "foo{{bar}}zap", slot: keyHashSlot("{bar")},
+		{name: "测试未闭合", key: "foo{bar", slot: int(crc16("foo{bar")) & (clusterSlots - 1)},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert.Equal(t, v.slot, keyHashSlot(v.key))
+		})
+	}
+}
@@ -0,0 +1,12 @@
+package main
+
+import "hash/crc64"
+
+// crc64JonesTable RDB校验和使用的CRC-64/Jones（反射多项式0x95ac9329ac4bc9b5），
+// 与标准库的区别在于初值为0且结果不取反
+var crc64JonesTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+// crc64Jones 在crc的基础上继续计算p的校验和，与Redis的crc64(crc, p, len)一致
+func crc64Jones(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, crc64JonesTable, p)
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCRC64Jones(t *testing.T) {
+	assert.Equal(t, uint64(0xe9c6d914c4b8d9ca), crc64Jones(0, []byte("123456789")))
+	// 分段计算与一次计算结果相同
+	crc := crc64Jones(0, []byte("12345"))
+	assert.Equal(t, uint64(0xe9c6d914c4b8d9ca), crc64Jones(crc, []byte("6789")))
+}
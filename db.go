@@ -0,0 +1,326 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+)
+
+const (
+	CommentDel    = "DEL"
+	CommentExists = "EXISTS"
+	CommentType   = "TYPE"
+	CommentObject = "OBJECT"
+)
+
+var (
+	errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+)
+
+// keyspace 内存键空间。只在Service.loop中访问，不需要加锁；
+// 持久化、复制等后台goroutine只读取在事件循环中生成的快照，快照与键空间共享值，
+// 进行中的快照存在时，值在第一次被访问前复制一份（写时复制），此后只修改副本
+type keyspace struct {
+	dict map[string]any
+	// expires 设置了过期时间的键，值为过期时刻的unix毫秒时间戳
+	expires map[string]int64
+	// dirty 自上次持久化以来的修改次数
+	dirty int64
+	// watchers 被WATCH的键及监视它的peer数，versions 被监视的键的版本号，每次修改加1
+	watchers map[string]int
+	versions map[string]uint64
+	// onExpire 键因过期被删除后调用，用于传播DEL
+	onExpire func(key string)
+	// expirePolicy 返回惰性过期时对已过期键的处理方式，为nil时总是删除
+	expirePolicy func() int
+	// slotKeys 集群模式下每个哈希槽中的键，未开启集群时为nil
+	slotKeys []map[string]struct{}
+	// snapshots 进行中的后台快照数，snapshotEpoch 每生成一个后台快照加1，
+	// owned 生成快照后已复制或重新写入、不再与快照共享的键及当时的epoch
+	snapshots     int
+	snapshotEpoch uint64
+	owned         map[string]uint64
+}
+
+func newKeyspace() *keyspace {
+	return &keyspace{
+		dict:    make(map[string]any),
+		expires: make(map[string]int64),
+
+		watchers: make(map[string]int),
+		versions: make(map[string]uint64),
+	}
+}
+
+// lookup 查找键对应的值，已过期的键会在此时被惰性删除。
+// 返回的值不与任何快照共享，调用方可以原地修改
+func (ks *keyspace) lookup(key string) (any, bool) {
+	if ks.expireIfNeeded(key) {
+		return nil, false
+	}
+	val, ok := ks.dict[key]
+	if ok {
+		val = ks.copyOnWrite(key, val)
+	}
+	return val, ok
+}
+
+// copyOnWrite 有进行中的后台快照且值可能与快照共享时复制值并替换键空间中的值
+func (ks *keyspace) copyOnWrite(key string, val any) any {
+	if ks.snapshots == 0 || ks.owned[key] == ks.snapshotEpoch {
+		return val
+	}
+	val = cloneValue(val)
+	ks.dict[key] = val
+	ks.markOwned(key)
+	return val
+}
+
+// markOwned 记录键的值是在最近一个快照之后生成的，不需要再复制
+func (ks *keyspace) markOwned(key string) {
+	if ks.snapshots == 0 {
+		return
+	}
+	if ks.owned == nil {
+		ks.owned = make(map[string]uint64)
+	}
+	ks.owned[key] = ks.snapshotEpoch
+}
+
+// set 写入键值，覆盖原有的值并清除过期时间
+func (ks *keyspace) set(key string, val any) {
+	ks.dict[key] = val
+	delete(ks.expires, key)
+	ks.slotAdd(key)
+	ks.markOwned(key)
+	ks.signalModifiedKey(key)
+}
+
+// setKeepTTL 写入键值，保留原有的过期时间
+func (ks *keyspace) setKeepTTL(key string, val any) {
+	ks.dict[key] = val
+	ks.slotAdd(key)
+	ks.markOwned(key)
+	ks.signalModifiedKey(key)
+}
+
+// delete 删除键，返回键是否存在
+func (ks *keyspace) delete(key string) bool {
+	ks.expireIfNeeded(key)
+	return ks.remove(key)
+}
+
+// remove 删除键及其过期时间，不检查是否已过期
+func (ks *keyspace) remove(key string) bool {
+	_, ok := ks.dict[key]
+	delete(ks.dict, key)
+	delete(ks.expires, key)
+	if ok {
+		ks.slotDel(key)
+	}
+	if ok {
+		ks.signalModifiedKey(key)
+	}
+	return ok
+}
+
+// exists 判断键是否存在
+func (ks *keyspace) exists(key string) bool {
+	_, ok := ks.lookup(key)
+	return ok
+}
+
+// size 返回键的个数
+func (ks *keyspace) size() int {
+	return len(ks.dict)
+}
+
+// empty 删除全部键，被WATCH的键视为被修改，返回删除的键数
+func (ks *keyspace) empty() int {
+	n := len(ks.dict)
+	for key := range ks.dict {
+		if ks.watchers[key] > 0 {
+			ks.versions[key]++
+		}
+	}
+	ks.dict = make(map[string]any)
+	ks.expires = make(map[string]int64)
+	ks.owned = nil
+	if ks.slotKeys != nil {
+		ks.enableSlotIndex()
+	}
+	ks.dirty += int64(n)
+	return n
+}
+
+// enableSlotIndex 开启集群模式时建立哈希槽到键的索引
+func (ks *keyspace) enableSlotIndex() {
+	ks.slotKeys = make([]map[string]struct{}, clusterSlots)
+	for key := range ks.dict {
+		ks.slotAdd(key)
+	}
+}
+
+// slotAdd、slotDel 维护哈希槽到键的索引
+func (ks *keyspace) slotAdd(key string) {
+	if ks.slotKeys == nil {
+		return
+	}
+	slot := keyHashSlot(key)
+	if ks.slotKeys[slot] == nil {
+		ks.slotKeys[slot] = make(map[string]struct{})
+	}
+	ks.slotKeys[slot][key] = struct{}{}
+}
+
+func (ks *keyspace) slotDel(key string) {
+	if ks.slotKeys == nil {
+		return
+	}
+	slot := keyHashSlot(key)
+	delete(ks.slotKeys[slot], key)
+	if len(ks.slotKeys[slot]) == 0 {
+		ks.slotKeys[slot] = nil
+	}
+}
+
+// countKeysInSlot 返回哈希槽中的键数
+func (ks *keyspace) countKeysInSlot(slot int) int {
+	if ks.slotKeys == nil {
+		return 0
+	}
+	return len(ks.slotKeys[slot])
+}
+
+// keysInSlot 返回哈希槽中至多count个键
+func (ks *keyspace) keysInSlot(slot, count int) []string {
+	if ks.slotKeys == nil {
+		return nil
+	}
+	keys := make([]string, 0, min(count, len(ks.slotKeys[slot])))
+	for key := range ks.slotKeys[slot] {
+		if len(keys) == count {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// dirtyCount 返回自上次持久化以来的修改次数
+func (ks *keyspace) dirtyCount() int64 {
+	return ks.dirty
+}
+
+// signalModifiedKey 键被修改后调用，原地修改值的命令需要显式调用
+func (ks *keyspace) signalModifiedKey(key string) {
+	ks.dirty++
+	if ks.watchers[key] > 0 {
+		ks.versions[key]++
+	}
+}
+
+func init() {
+	registerCommand(
+		&command{name: CommentDel, arity: -2, flags: cmdWrite, firstKey: 1, lastKey: -1, keyStep: 1, proc: delCommand},
+		&command{name: CommentType, arity: 2, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: typeCommand},
+		&command{name: CommentExists, arity: -2, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: -1, keyStep: 1, proc: existsCommand},
+		&command{name: CommentObject, arity: -2, flags: cmdReadonly, firstKey: 2, lastKey: 2, keyStep: 1, proc: objectCommand},
+	)
+}
+
+// delCommand DEL key [key ...]
+func delCommand(s *Service, p *Peer, args []string) any {
+	var n int64
+	for _, key := range args[1:] {
+		if s.db.delete(key) {
+			n++
+		}
+	}
+	return n
+}
+
+// existsCommand EXISTS key [key ...] 重复的键会被重复计数
+func existsCommand(s *Service, p *Peer, args []string) any {
+	var n int64
+	for _, key := range args[1:] {
+		if s.db.exists(key) {
+			n++
+		}
+	}
+	return n
+}
+
+// typeName 返回值的类型名称
+func typeName(val any) string {
+	switch val.(type) {
+	case []byte:
+		return "string"
+	case *quicklist:
+		return "list"
+	case hashObj:
+		return "hash"
+	case *setObj:
+		return "set"
+	case *zsetObj:
+		return "zset"
+	case *stream:
+		return "stream"
+	default:
+		return "none"
+	}
+}
+
+// typeCommand TYPE key
+func typeCommand(s *Service, p *Peer, args []string) any {
+	val, ok := s.db.lookup(args[1])
+	if !ok {
+		return "none"
+	}
+	return typeName(val)
+}
+
+// objectEncoding 返回值的内部编码名称
+func objectEncoding(val any) string {
+	switch v := val.(type) {
+	case []byte:
+		if _, err := parseInt(string(v)); err == nil {
+			return "int"
+		}
+		if len(v) <= 44 {
+			return "embstr"
+		}
+		return "raw"
+	case *quicklist:
+		return "quicklist"
+	case hashObj:
+		return "hashtable"
+	case *setObj:
+		return v.encoding()
+	case *zsetObj:
+		return "skiplist"
+	case *stream:
+		return "stream"
+	default:
+		return "unknown"
+	}
+}
+
+// objectCommand OBJECT ENCODING key
+func objectCommand(s *Service, p *Peer, args []string) any {
+	switch strings.ToUpper(args[1]) {
+	case "ENCODING":
+		if len(args) != 3 {
+			return wrongArityErr("object|encoding")
+		}
+		val, ok := s.db.lookup(args[2])
+		if !ok {
+			return nil
+		}
+		return resp.BulkStrings(objectEncoding(val))
+	default:
+		return fmt.Errorf("ERR unknown subcommand '%.128s'. Try OBJECT HELP.", args[1])
+	}
+}
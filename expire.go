@@ -0,0 +1,265 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CommentExpire      = "EXPIRE"
+	CommentPExpire     = "PEXPIRE"
+	CommentExpireAt    = "EXPIREAT"
+	CommentPExpireAt   = "PEXPIREAT"
+	CommentTTL         = "TTL"
+	CommentPTTL        = "PTTL"
+	CommentPersist     = "PERSIST"
+	CommentExpireTime  = "EXPIRETIME"
+	CommentPExpireTime = "PEXPIRETIME"
+)
+
+const (
+	// activeExpireKeysPerLoop 主动过期每轮抽样的键数
+	activeExpireKeysPerLoop = 20
+	// activeExpireAcceptableStale 一轮抽样中过期键占比超过该值时继续下一轮
+	activeExpireAcceptableStale = 25
+	// activeExpireCycleDuration 每次主动过期的最长耗时
+	activeExpireCycleDuration = 25 * time.Millisecond
+)
+
+// 惰性过期时对已过期键的处理方式
+const (
+	// expireDelete 删除并通知onExpire
+	expireDelete = iota
+	// expireKeep 不删除，照常返回，载入持久化文件时重放的命令需要看到写入时的数据
+	expireKeep
+	// expireHide 不删除，但视为不存在，用于副本上的普通客户端
+	expireHide
+)
+
+// EXPIRE系列命令的选项
+const (
+	expireNX = 1 << iota
+	expireXX
+	expireGT
+	expireLT
+)
+
+// mstime 返回当前的unix毫秒时间戳
+func mstime() int64 {
+	return time.Now().UnixMilli()
+}
+
+// getExpire 返回键的过期时刻，没有设置过期时间时返回-1
+func (ks *keyspace) getExpire(key string) int64 {
+	when, ok := ks.expires[key]
+	if !ok {
+		return -1
+	}
+	return when
+}
+
+// setExpire 为已存在的键设置过期时刻
+func (ks *keyspace) setExpire(key string, when int64) {
+	if _, ok := ks.dict[key]; ok {
+		ks.expires[key] = when
+	}
+	ks.signalModifiedKey(key)
+}
+
+// persist 移除键的过期时间，返回是否移除成功
+func (ks *keyspace) persist(key string) bool {
+	_, ok := ks.expires[key]
+	delete(ks.expires, key)
+	if ok {
+		ks.signalModifiedKey(key)
+	}
+	return ok
+}
+
+// expireIfNeeded 按expirePolicy处理已过期的键，返回键是否应视为不存在
+func (ks *keyspace) expireIfNeeded(key string) bool {
+	when := ks.getExpire(key)
+	if when < 0 || when > mstime() {
+		return false
+	}
+	policy := expireDelete
+	if ks.expirePolicy != nil {
+		policy = ks.expirePolicy()
+	}
+	switch policy {
+	case expireKeep:
+		return false
+	case expireHide:
+		return true
+	}
+	return ks.deleteExpired(key)
+}
+
+// deleteExpired 删除已过期的键并通知onExpire
+func (ks *keyspace) deleteExpired(key string) bool {
+	if !ks.remove(key) {
+		return false
+	}
+	if ks.onExpire != nil {
+		ks.onExpire(key)
+	}
+	return true
+}
+
+// activeExpireCycle 从设置了过期时间的键中随机抽样并删除已过期的键，
+// 抽样中过期键比例较高时继续抽样，直到比例下降或超出时间限制
+func (ks *keyspace) activeExpireCycle() {
+	start := time.Now()
+	for time.Since(start) < activeExpireCycleDuration {
+		now := mstime()
+		var sampled, expired []string
+		// map的遍历起点是随机的，取前N个即可视为随机抽样
+		for key, when := range ks.expires {
+			if len(sampled) == activeExpireKeysPerLoop {
+				break
+			}
+			sampled = append(sampled, key)
+			if when <= now {
+				expired = append(expired, key)
+			}
+		}
+		for _, key := range expired {
+			ks.deleteExpired(key)
+		}
+		if len(sampled) == 0 || len(expired)*100/len(sampled) <= activeExpireAcceptableStale {
+			return
+		}
+	}
+}
+
+func init() {
+	registerCommand(
+		&command{name: CommentExpire, arity: -3, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: expireCommand},
+		&command{name: CommentPExpire, arity: -3, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: expireCommand},
+		&command{name: CommentExpireAt, arity: -3, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: expireCommand},
+		&command{name: CommentPExpireAt, arity: -3, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: expireCommand},
+		&command{name: CommentTTL, arity: 2, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: ttlCommand},
+		&command{name: CommentPTTL, arity: 2, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: ttlCommand},
+		&command{name: CommentExpireTime, arity: 2, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: ttlCommand},
+		&command{name: CommentPExpireTime, arity: 2, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: ttlCommand},
+		&command{name: CommentPersist, arity: 2, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: persistCommand},
+	)
+}
+
+func invalidExpireErr(name string) error {
+	return fmt.Errorf("ERR invalid expire time in '%s' command", strings.ToLower(name))
+}
+
+// parseExpireTime 将EX/PX/EXAT/PXAT或EXPIRE系列命令的参数转换为绝对的毫秒时间戳，
+// unit为1000表示参数以秒为单位，absolute表示参数本身已是绝对时间
+func parseExpireTime(name, arg string, unit int64, absolute bool) (int64, error) {
+	when, err := parseInt(arg)
+	if err != nil {
+		return 0, err
+	}
+	if when > math.MaxInt64/unit || when < math.MinInt64/unit {
+		return 0, invalidExpireErr(name)
+	}
+	when *= unit
+	if !absolute {
+		now := mstime()
+		if when > math.MaxInt64-now {
+			return 0, invalidExpireErr(name)
+		}
+		when += now
+	}
+	return when, nil
+}
+
+// expireCommand EXPIRE|PEXPIRE|EXPIREAT|PEXPIREAT key time [NX|XX|GT|LT]
+func expireCommand(s *Service, p *Peer, args []string) any {
+	flags := 0
+	for _, arg := range args[3:] {
+		switch strings.ToUpper(arg) {
+		case "NX":
+			flags |= expireNX
+		case "XX":
+			flags |= expireXX
+		case "GT":
+			flags |= expireGT
+		case "LT":
+			flags |= expireLT
+		default:
+			return fmt.Errorf("ERR Unsupported option %s", arg)
+		}
+	}
+	if flags&expireNX != 0 && flags&(expireXX|expireGT|expireLT) != 0 {
+		return errors.New("ERR NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if flags&expireGT != 0 && flags&expireLT != 0 {
+		return errors.New("ERR GT and LT options at the same time are not compatible")
+	}
+
+	name := strings.ToUpper(args[0])
+	unit := int64(1000)
+	if name == CommentPExpire || name == CommentPExpireAt {
+		unit = 1
+	}
+	when, err := parseExpireTime(name, args[2], unit, name == CommentExpireAt || name == CommentPExpireAt)
+	if err != nil {
+		return err
+	}
+
+	key := args[1]
+	if !s.db.exists(key) {
+		return int64(0)
+	}
+	cur := s.db.getExpire(key)
+	switch {
+	case flags&expireNX != 0 && cur != -1,
+		flags&expireXX != 0 && cur == -1,
+		// 没有过期时间视为无限大
+		flags&expireGT != 0 && (cur == -1 || when <= cur),
+		flags&expireLT != 0 && cur != -1 && when >= cur:
+		return int64(0)
+	}
+	if when <= mstime() {
+		s.db.remove(key)
+		s.rewritePropagate([]string{CommentDel, key})
+		return int64(1)
+	}
+	s.db.setExpire(key, when)
+	s.rewritePropagate([]string{CommentPExpireAt, key, strconv.FormatInt(when, 10)})
+	return int64(1)
+}
+
+// ttlCommand TTL|PTTL|EXPIRETIME|PEXPIRETIME key
+// 键不存在返回-2，没有过期时间返回-1
+func ttlCommand(s *Service, p *Peer, args []string) any {
+	key := args[1]
+	if !s.db.exists(key) {
+		return int64(-2)
+	}
+	when := s.db.getExpire(key)
+	if when == -1 {
+		return int64(-1)
+	}
+	switch strings.ToUpper(args[0]) {
+	case CommentTTL:
+		return (max(when-mstime(), 0) + 500) / 1000
+	case CommentPTTL:
+		return max(when-mstime(), 0)
+	case CommentExpireTime:
+		return when / 1000
+	default:
+		return when
+	}
+}
+
+// persistCommand PERSIST key
+func persistCommand(s *Service, p *Peer, args []string) any {
+	key := args[1]
+	if s.db.exists(key) && s.db.persist(key) {
+		return int64(1)
+	}
+	return int64(0)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpireCommands(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	testCases := []struct {
+		name string
+		args []string
+		res  any
+	}{
+		{name: "测试TTL不存在", args: []string{"TTL", "k"}, res: int64(-2)},
+		{name: "测试EXPIRE不存在", args: []string{"EXPIRE", "k", "10"}, res: int64(0)},
+		{name: "测试SET", args: []string{"SET", "k", "v"}, res: "OK"},
+		{name: "测试TTL无过期时间", args: []string{"TTL", "k"}, res: int64(-1)},
+		{name: "测试EXPIRE XX", args: []string{"EXPIRE", "k", "10", "XX"}, res: int64(0)},
+		{name: "测试EXPIRE GT无过期时间", args: []string{"EXPIRE", "k", "10", "GT"}, res: int64(0)},
+		{name: "测试EXPIRE NX", args: []string{"EXPIRE", "k", "100", "NX"}, res: int64(1)},
+		{name: "测试TTL", args: []string{"TTL", "k"}, res: int64(100)},
+		{name: "测试EXPIRE LT", args: []string{"EXPIRE", "k", "200", "LT"}, res: int64(0)},
+		{name: "测试EXPIRE GT", args: []string{"EXPIRE", "k", "200", "GT"}, res: int64(1)},
+		{name: "测试APPEND保留TTL", args: []string{"APPEND", "k", "v"}, res: int64(2)},
+		{name: "测试TTL保留", args: []string{"TTL", "k"}, res: int64(200)},
+		{name: "测试SET清除TTL", args: []string{"SET", "k", "v"}, res: "OK"},
+		{name: "测试TTL已清除", args: []string{"TTL", "k"}, res: int64(-1)},
+		{name: "测试EXPIREAT", args: []string{"EXPIREAT", "k", future}, res: int64(1)},
+		{name: "测试EXPIRETIME", args: []string{"EXPIRETIME", "k"}, res: mustParseInt(future)},
+		{name: "测试PEXPIREAT", args: []string{"PEXPIREAT", "k", "4102444800999"}, res: int64(1)},
+		{name: "测试EXPIRETIME舍去毫秒", args: []string{"EXPIRETIME", "k"}, res: int64(4102444800)},
+		{name: "测试PEXPIRETIME", args: []string{"PEXPIRETIME", "k"}, res: int64(4102444800999)},
+		{name: "测试PERSIST", args: []string{"PERSIST", "k"}, res: int64(1)},
+		{name: "测试PERSIST无过期时间", args: []string{"PERSIST", "k"}, res: int64(0)},
+		{name: "测试SET EX", args: []string{"SET", "k", "v", "EX", "10"}, res: "OK"},
+		{name: "测试SET KEEPTTL", args: []string{"SET", "k", "v2", "KEEPTTL"}, res: "OK"},
+		{name: "测试KEEPTTL保留", args: []string{"TTL", "k"}, res: int64(10)},
+		{name: "测试SET EX非法", args: []string{"SET", "k", "v", "EX", "0"}, res: errors.New("ERR invalid expire time in 'set' command")},
+		{name: "测试SET EX与PX", args: []string{"SET", "k", "v", "EX", "1", "PX", "1"}, res: errSyntax},
+		{name: "测试SET EX空值", args: []string{"SET", "k", "v", "EX", ""}, res: errors.New("ERR invalid expire time in 'set' command")},
+		{name: "测试SET EX空值与GET", args: []string{"SET", "k", "v", "EX", "", "GET"}, res: errors.New("ERR invalid expire time in 'set' command")},
+		{name: "测试SET EX缺少参数", args: []string{"SET", "k", "v", "GET", "EX"}, res: errSyntax},
+		{name: "测试EXPIRE选项冲突", args: []string{"EXPIRE", "k", "1", "NX", "XX"}, res: errors.New("ERR NX and XX, GT or LT options at the same time are not compatible")},
+		{name: "测试EXPIRE溢出", args: []string{"EXPIRE", "k", "9223372036854775807"}, res: errors.New("ERR invalid expire time in 'expire' command")},
+		{name: "测试PEXPIRE过去时间删除", args: []string{"PEXPIRE", "k", "-1"}, res: int64(1)},
+		{name: "测试已删除", args: []string{"EXISTS", "k"}, res: int64(0)},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert.Equal(t, v.res, doCommand(s, p, v.args...))
+		})
+	}
+}
+
+func TestPTTL(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	doCommand(s, p, "SET", "k", "v", "PX", "10000")
+	assert.InDelta(t, 10000, doCommand(s, p, "PTTL", "k"), 100)
+}
+
+func TestLazyAndActiveExpire(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	doCommand(s, p, "SET", "lazy", "v", "PX", "1")
+	for i := 0; i < 100; i++ {
+		doCommand(s, p, "SET", "active"+strconv.Itoa(i), "v", "PX", "1")
+	}
+	doCommand(s, p, "SET", "keep", "v", "EX", "100")
+	time.Sleep(5 * time.Millisecond)
+
+	assert.Equal(t, nil, doCommand(s, p, "GET", "lazy"))
+	s.db.activeExpireCycle()
+	assert.Equal(t, 1, s.db.size())
+	assert.Equal(t, []byte("v"), doCommand(s, p, "GET", "keep"))
+}
+
+func TestReplicaExpire(t *testing.T) {
+	s := NewService(Config{})
+	s.masterHost = "127.0.0.1"
+	p, _ := newTestPeer(s)
+	master, _ := newTestPeer(s)
+	master.master = true
+	doCommand(s, master, "SET", "k", "5", "PX", "1")
+	time.Sleep(5 * time.Millisecond)
+
+	// 普通客户端看不到已过期的键，但副本不会自行删除
+	assert.Equal(t, nil, doCommand(s, p, "GET", "k"))
+	assert.Equal(t, int64(-2), doCommand(s, p, "PTTL", "k"))
+	assert.Equal(t, 1, s.db.size())
+	assert.Equal(t, int64(0), s.statExpiredKeys)
+
+	// 主节点的复制流看到保存的值
+	assert.Equal(t, int64(6), doCommand(s, master, "INCR", "k"))
+	assert.Equal(t, int64(1), doCommand(s, master, "DEL", "k"))
+	assert.Equal(t, 0, s.db.size())
+}
+
+func mustParseInt(s string) int64 {
+	i, _ := parseInt(s)
+	return i
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+)
+
+const (
+	CommentInfo = "INFO"
+)
+
+// infoSection INFO中的一节，gen向sb写入该节的内容
+type infoSection struct {
+	name string
+	gen  func(s *Service, sb *strings.Builder)
+	// sentinel 哨兵模式下是否输出，onlySentinel 只在哨兵模式下输出
+	sentinel     bool
+	onlySentinel bool
+}
+
+// infoSections 按输出顺序排列的各节，default与all都输出全部
+var infoSections = []infoSection{
+	{name: "server", gen: (*Service).genServerInfo, sentinel: true},
+	{name: "clients", gen: (*Service).genClientsInfo, sentinel: true},
+	{name: "persistence", gen: (*Service).genPersistenceInfo},
+	{name: "stats", gen: (*Service).genStatsInfo, sentinel: true},
+	{name: "replication", gen: (*Service).genReplicationInfo},
+	{name: "cluster", gen: (*Service).genClusterInfo},
+	{name: "sentinel", gen: (*Service).genSentinelInfo, sentinel: true, onlySentinel: true},
+	{name: "keyspace", gen: (*Service).genKeyspaceInfo},
+}
+
+func init() {
+	registerCommand(
+		&command{name: CommentInfo, arity: -1, flags: cmdSentinel, proc: infoCommand},
+	)
+}
+
+// infoCommand INFO [section [section ...]]
+func infoCommand(s *Service, p *Peer, args []string) any {
+	want := make(map[string]bool)
+	for _, arg := range args[1:] {
+		want[strings.ToLower(arg)] = true
+	}
+	all := len(want) == 0 || want["all"] || want["default"] || want["everything"]
+	var sb strings.Builder
+	for _, sec := range infoSections {
+		if !all && !want[sec.name] {
+			continue
+		}
+		if (s.sentinel != nil && !sec.sentinel) || (s.sentinel == nil && sec.onlySentinel) {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+		fmt.Fprintf(&sb, "# %s%s\r\n", strings.ToUpper(sec.name[:1]), sec.name[1:])
+		sec.gen(s, &sb)
+	}
+	return resp.Verbatim{Coding: "txt", Data: []byte(sb.String())}
+}
+
+// genServerInfo INFO server
+func (s *Service) genServerInfo(sb *strings.Builder) {
+	port := 0
+	if s.ln != nil {
+		if addr, ok := s.ln.Addr().(*net.TCPAddr); ok {
+			port = addr.Port
+		}
+	}
+	mode := "standalone"
+	if s.cluster != nil {
+		mode = "cluster"
+	}
+	if s.sentinel != nil {
+		mode = "sentinel"
+	}
+	uptime := int64(time.Since(s.startTime).Seconds())
+	fmt.Fprintf(sb, "redis_version:%s\r\nredis_mode:%s\r\nos:%s %s\r\ngo_version:%s\r\n"+
+		"process_id:%d\r\nrun_id:%s\r\ntcp_port:%d\r\nuptime_in_seconds:%d\r\nhz:%d\r\n",
+		redisVersion, mode, runtime.GOOS, runtime.GOARCH, runtime.Version(),
+		os.Getpid(), s.runID, port, uptime, serverHz)
+}
+
+// genClientsInfo INFO clients
+func (s *Service) genClientsInfo(sb *strings.Builder) {
+	pubsub := 0
+	for p := range s.peers {
+		if p.subscriptionCount() > 0 {
+			pubsub++
+		}
+	}
+	fmt.Fprintf(sb, "connected_clients:%d\r\nblocked_clients:%d\r\npubsub_clients:%d\r\nwatching_clients:%d\r\n",
+		len(s.peers)-len(s.replicas), len(s.blockedPeers), pubsub, s.watchingPeers())
+}
+
+// watchingPeers 返回WATCH了键的peer数
+func (s *Service) watchingPeers() int {
+	n := 0
+	for p := range s.peers {
+		if len(p.watched) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// genPersistenceInfo INFO persistence
+func (s *Service) genPersistenceInfo(sb *strings.Builder) {
+	status := func(err error) string {
+		if err != nil {
+			return "err"
+		}
+		return "ok"
+	}
+	fmt.Fprintf(sb, "loading:%d\r\nrdb_changes_since_last_save:%d\r\nrdb_bgsave_in_progress:%d\r\n"+
+		"rdb_last_save_time:%d\r\nrdb_last_bgsave_status:%s\r\naof_enabled:%d\r\n"+
+		"aof_rewrite_in_progress:%d\r\naof_last_bgrewrite_status:%s\r\naof_last_write_status:%s\r\n",
+		boolToInt(s.loading), s.db.dirtyCount(), boolToInt(s.rdbBgsaveCh != nil),
+		s.lastSave, status(s.lastBgsaveErr), boolToInt(s.AppendOnly),
+		boolToInt(s.aofRewriteCh != nil), status(s.lastAofRewriteErr), status(s.aofLastWriteErr))
+}
+
+// genStatsInfo INFO stats
+func (s *Service) genStatsInfo(sb *strings.Builder) {
+	// 没有fork，latest_fork_usec为生成后台快照时事件循环停顿的时间
+	fmt.Fprintf(sb, "expired_keys:%d\r\nlatest_fork_usec:%d\r\nsync_full:%d\r\nsync_partial_ok:%d\r\nsync_partial_err:%d\r\n"+
+		"pubsub_channels:%d\r\npubsub_patterns:%d\r\npubsubshard_channels:%d\r\n",
+		s.statExpiredKeys, s.statSnapshotUsec, s.statSyncFull, s.statSyncPartialOK, s.statSyncPartialErr,
+		len(s.pubsubChannels), len(s.pubsubPatterns), len(s.pubsubShardChannels))
+}
+
+// genKeyspaceInfo INFO keyspace 只有一个数据库，没有键时不输出
+func (s *Service) genKeyspaceInfo(sb *strings.Builder) {
+	keys, expires := len(s.db.dict), len(s.db.expires)
+	if keys > 0 {
+		fmt.Fprintf(sb, "db0:keys=%d,expires=%d,avg_ttl=0\r\n", keys, expires)
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
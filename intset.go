@@ -0,0 +1,52 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+)
+
+// setMaxIntsetEntries 整数集合最多保存的元素个数，超过后转换为哈希表编码
+const setMaxIntsetEntries = 512
+
+// intset 元素全部为整数的小集合的紧凑编码，按升序保存，查找为O(log(N))
+type intset []int64
+
+// find 二分查找v，返回v应处的下标以及是否存在
+func (is intset) find(v int64) (int, bool) {
+	return slices.BinarySearch(is, v)
+}
+
+// has 判断v是否在集合中
+func (is intset) has(v int64) bool {
+	_, ok := is.find(v)
+	return ok
+}
+
+// add 插入v并保持有序，返回是否新增
+func (is *intset) add(v int64) bool {
+	i, ok := is.find(v)
+	if ok {
+		return false
+	}
+	*is = slices.Insert(*is, i, v)
+	return true
+}
+
+// remove 删除v，返回是否存在
+func (is *intset) remove(v int64) bool {
+	i, ok := is.find(v)
+	if !ok {
+		return false
+	}
+	*is = slices.Delete(*is, i, i+1)
+	return true
+}
+
+// strings 以字符串形式返回全部元素
+func (is intset) strings() []string {
+	res := make([]string, len(is))
+	for i, v := range is {
+		res[i] = strconv.FormatInt(v, 10)
+	}
+	return res
+}
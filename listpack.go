@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// listpack是Redis紧凑编码使用的序列化格式，RDB中的列表、流等以该格式保存：
+// 4字节总长度 + 2字节元素个数 + 元素... + 0xFF，
+// 每个元素为 编码与数据 + 反向长度(backlen)，整数形式的字符串按整数编码
+const (
+	lpHeaderSize = 6
+	lpEOF        = 0xFF
+	// lpNumElementsUnknown 元素个数超过65535时头部记录的值
+	lpNumElementsUnknown = 65535
+)
+
+var errBadListpack = errors.New("invalid listpack")
+
+// listpackBuilder 逐个追加元素构造listpack
+type listpackBuilder struct {
+	buf []byte
+	n   int
+}
+
+func newListpackBuilder() *listpackBuilder {
+	return &listpackBuilder{buf: make([]byte, lpHeaderSize, 64)}
+}
+
+// lpStringToInt 判断字符串是否为规范形式的整数，与Redis的string2ll一致
+func lpStringToInt(s string) (int64, bool) {
+	if len(s) == 0 || len(s) > 20 {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != s {
+		return 0, false
+	}
+	return v, true
+}
+
+// appendString 追加字符串元素
+func (lb *listpackBuilder) appendString(s string) {
+	if v, ok := lpStringToInt(s); ok {
+		lb.appendInt(v)
+		return
+	}
+	start := len(lb.buf)
+	switch n := len(s); {
+	case n < 64:
+		lb.buf = append(lb.buf, 0x80|byte(n))
+	case n < 4096:
+		lb.buf = append(lb.buf, 0xE0|byte(n>>8), byte(n))
+	default:
+		lb.buf = append(lb.buf, 0xF0)
+		lb.buf = binary.LittleEndian.AppendUint32(lb.buf, uint32(n))
+	}
+	lb.buf = append(lb.buf, s...)
+	lb.appendBacklen(len(lb.buf) - start)
+}
+
+// appendInt 追加整数元素，按取值范围选择最短的编码
+func (lb *listpackBuilder) appendInt(v int64) {
+	start := len(lb.buf)
+	switch {
+	case v >= 0 && v <= 127:
+		lb.buf = append(lb.buf, byte(v))
+	case v >= -4096 && v <= 4095:
+		u := uint16(v) & 0x1FFF
+		lb.buf = append(lb.buf, 0xC0|byte(u>>8), byte(u))
+	case v >= -32768 && v <= 32767:
+		lb.buf = append(lb.buf, 0xF1)
+		lb.buf = binary.LittleEndian.AppendUint16(lb.buf, uint16(v))
+	case v >= -8388608 && v <= 8388607:
+		u := uint32(v)
+		lb.buf = append(lb.buf, 0xF2, byte(u), byte(u>>8), byte(u>>16))
+	case v >= -2147483648 && v <= 2147483647:
+		lb.buf = append(lb.buf, 0xF3)
+		lb.buf = binary.LittleEndian.AppendUint32(lb.buf, uint32(v))
+	default:
+		lb.buf = append(lb.buf, 0xF4)
+		lb.buf = binary.LittleEndian.AppendUint64(lb.buf, uint64(v))
+	}
+	lb.appendBacklen(len(lb.buf) - start)
+}
+
+// appendBacklen 追加元素长度的反向编码，从后向前每字节7位，除最低位字节外最高位置1
+func (lb *listpackBuilder) appendBacklen(l int) {
+	switch {
+	case l <= 127:
+		lb.buf = append(lb.buf, byte(l))
+	case l < 16383:
+		lb.buf = append(lb.buf, byte(l>>7), byte(l&127)|128)
+	case l < 2097151:
+		lb.buf = append(lb.buf, byte(l>>14), byte((l>>7)&127)|128, byte(l&127)|128)
+	case l < 268435455:
+		lb.buf = append(lb.buf, byte(l>>21), byte((l>>14)&127)|128, byte((l>>7)&127)|128, byte(l&127)|128)
+	default:
+		lb.buf = append(lb.buf, byte(l>>28), byte((l>>21)&127)|128, byte((l>>14)&127)|128,
+			byte((l>>7)&127)|128, byte(l&127)|128)
+	}
+	lb.n++
+}
+
+// bytes 写入头部与结束符，返回完整的listpack
+func (lb *listpackBuilder) bytes() []byte {
+	buf := append(lb.buf, lpEOF)
+	binary.LittleEndian.PutUint32(buf, uint32(len(buf)))
+	binary.LittleEndian.PutUint16(buf[4:], uint16(min(lb.n, lpNumElementsUnknown)))
+	return buf
+}
+
+// backlenSize 返回长度为l的元素的backlen字节数
+func backlenSize(l int) int {
+	switch {
+	case l <= 127:
+		return 1
+	case l < 16383:
+		return 2
+	case l < 2097151:
+		return 3
+	case l < 268435455:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// listpackEntries 解码listpack中的全部元素，整数按十进制字符串返回
+func listpackEntries(lp []byte) ([]string, error) {
+	if len(lp) < lpHeaderSize+1 || int(binary.LittleEndian.Uint32(lp)) != len(lp) || lp[len(lp)-1] != lpEOF {
+		return nil, errBadListpack
+	}
+	var res []string
+	p := lp[lpHeaderSize : len(lp)-1]
+	for len(p) > 0 {
+		var s string
+		var n int
+		switch b := p[0]; {
+		case b&0x80 == 0:
+			s, n = strconv.FormatInt(int64(b), 10), 1
+		case b&0xC0 == 0x80:
+			n = 1 + int(b&0x3F)
+			if n > len(p) {
+				return nil, errBadListpack
+			}
+			s = string(p[1:n])
+		case b&0xE0 == 0xC0:
+			if len(p) < 2 {
+				return nil, errBadListpack
+			}
+			u := uint16(b&0x1F)<<8 | uint16(p[1])
+			v := int64(u)
+			if u >= 1<<12 {
+				v -= 1 << 13
+			}
+			s, n = strconv.FormatInt(v, 10), 2
+		case b&0xF0 == 0xE0:
+			if len(p) < 2 {
+				return nil, errBadListpack
+			}
+			n = 2 + (int(b&0x0F)<<8 | int(p[1]))
+			if n > len(p) {
+				return nil, errBadListpack
+			}
+			s = string(p[2:n])
+		case b == 0xF0:
+			if len(p) < 5 {
+				return nil, errBadListpack
+			}
+			n = 5 + int(binary.LittleEndian.Uint32(p[1:]))
+			if n > len(p) {
+				return nil, errBadListpack
+			}
+			s = string(p[5:n])
+		case b >= 0xF1 && b <= 0xF4:
+			size := [...]int{2, 3, 4, 8}[b-0xF1]
+			if len(p) < 1+size {
+				return nil, errBadListpack
+			}
+			var u uint64
+			for i := size; i >= 1; i-- {
+				u = u<<8 | uint64(p[i])
+			}
+			// 按位宽做符号扩展
+			shift := 64 - 8*size
+			s, n = strconv.FormatInt(int64(u<<shift)>>shift, 10), 1+size
+		default:
+			return nil, errBadListpack
+		}
+		n += backlenSize(n)
+		if n > len(p) {
+			return nil, errBadListpack
+		}
+		res = append(res, s)
+		p = p[n:]
+	}
+	return res, nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListpack(t *testing.T) {
+	lb := newListpackBuilder()
+	lb.appendString("hello")
+	assert.Equal(t, []byte{14, 0, 0, 0, 1, 0, 0x85, 'h', 'e', 'l', 'l', 'o', 6, 0xFF}, lb.bytes())
+
+	vals := []string{"0", "127", "128", "-1", "4095", "-4096", "32767", "-32768", "8388607",
+		"-8388608", "2147483647", "-2147483648", "9223372036854775807", "-9223372036854775808",
+		"007", "+1", "", strings.Repeat("a", 63), strings.Repeat("b", 64), strings.Repeat("c", 5000)}
+	lb = newListpackBuilder()
+	for _, v := range vals {
+		lb.appendString(v)
+	}
+	res, err := listpackEntries(lb.bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, vals, res)
+
+	_, err = listpackEntries([]byte{7, 0, 0, 0, 0, 0, 0})
+	assert.Equal(t, errBadListpack, err)
+}
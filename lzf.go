@@ -0,0 +1,49 @@
+package main
+
+import "errors"
+
+var errBadLZF = errors.New("invalid LZF compressed string")
+
+// lzfDecompress 解压RDB中LZF压缩的字符串，outLen为解压后的长度。
+// 控制字节小于32时其后为ctrl+1字节的字面量，否则为对已输出内容的回溯引用：
+// 高3位为长度-2（为7时再读1字节累加），低5位与下一字节组成偏移-1
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			n := ctrl + 1
+			if i+n > len(in) {
+				return nil, errBadLZF
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, errBadLZF
+			}
+			n += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errBadLZF
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errBadLZF
+		}
+		// 引用区间可能与正在输出的内容重叠，需逐字节复制
+		for j := 0; j < n+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != outLen {
+		return nil, errBadLZF
+	}
+	return out, nil
+}
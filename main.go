@@ -1,63 +1,500 @@
 package main
 
 import (
+	"bytes"
+	"container/list"
+	"errors"
+	"flag"
+	"fmt"
 	"log/slog"
 	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
 )
 
 const (
 	defaultListenAddr = ":5001"
+	// serverHz serverCron每秒执行的次数
+	serverHz = 10
 )
 
 type Config struct {
 	ListenAddr string
+	// Dir 持久化文件所在的目录，DBFilename RDB文件名
+	Dir        string
+	DBFilename string
+	// AppendOnly 开启AOF持久化，AppendDirname AOF文件所在的目录（位于Dir中），
+	// AppendFilename AOF文件名的前缀，AppendFsync AOF的fsync策略：always、everysec或no
+	AppendOnly     bool
+	AppendDirname  string
+	AppendFilename string
+	AppendFsync    string
+	// AOFDisableRDBPreamble 重写时基础文件写为命令而不是RDB
+	AOFDisableRDBPreamble bool
+	// ReplicaOf 启动时作为副本连接的主节点地址host:port，为空时作为主节点启动
+	ReplicaOf string
+	// ReplBacklogSize 复制积压缓冲区的大小（字节）
+	ReplBacklogSize int
+	// ClientOutputBufferLimitPubsub、ClientOutputBufferLimitReplica 订阅者、副本的输出缓冲区中
+	// 尚未写出的数据超过该字节数时断开连接
+	ClientOutputBufferLimitPubsub  int
+	ClientOutputBufferLimitReplica int
+	// ClusterEnabled 以集群模式启动，ClusterConfigFile 集群配置文件名（位于Dir中），
+	// ClusterBusAddr 集群总线的监听地址，为空时端口为客户端端口加10000，
+	// ClusterNodeTimeout 节点无响应多久（毫秒）后被认为可能下线
+	ClusterEnabled     bool
+	ClusterConfigFile  string
+	ClusterBusAddr     string
+	ClusterNodeTimeout int64
+	// Sentinel 以哨兵模式启动，SentinelConfigFile 哨兵配置文件名（位于Dir中），
+	// SentinelMonitor 启动时监控的主节点"name host port quorum"，已在配置文件中时忽略，
+	// SentinelDownAfter、SentinelFailoverTimeout 新监控的主节点无响应多久（毫秒）后被认为主观下线、
+	// 故障转移的超时（毫秒）
+	Sentinel                bool
+	SentinelConfigFile      string
+	SentinelMonitor         string
+	SentinelDownAfter       int64
+	SentinelFailoverTimeout int64
+}
+
+// validate 检查配置项的取值
+func (cfg *Config) validate() error {
+	switch cfg.AppendFsync {
+	case aofFsyncAlways, aofFsyncEverysec, aofFsyncNo:
+	default:
+		return fmt.Errorf("invalid appendfsync %q, must be one of always, everysec, no", cfg.AppendFsync)
+	}
+	if cfg.ReplicaOf != "" {
+		if _, _, err := splitHostPort(cfg.ReplicaOf); err != nil {
+			return fmt.Errorf("invalid replicaof %q: %w", cfg.ReplicaOf, err)
+		}
+	}
+	if cfg.ClusterEnabled && cfg.ReplicaOf != "" {
+		return errors.New("replicaof directive not allowed in cluster mode")
+	}
+	if cfg.Sentinel && (cfg.ClusterEnabled || cfg.ReplicaOf != "") {
+		return errors.New("sentinel mode can't be combined with cluster mode or replicaof")
+	}
+	if cfg.SentinelMonitor != "" {
+		if _, err := parseSentinelMonitor(strings.Fields(cfg.SentinelMonitor)); err != nil {
+			return fmt.Errorf("invalid sentinel monitor %q: %w", cfg.SentinelMonitor, err)
+		}
+	}
+	return nil
 }
 
 type Service struct {
 	Config
+	db         *keyspace
 	peers      map[*Peer]bool
 	ln         net.Listener
 	addPeerCh  chan *Peer
+	delPeerCh  chan *Peer
 	quitPeerCh chan struct{}
-	msgCh      chan []byte
+	closeOnce  sync.Once
+	msgCh      chan Message
+	nextPeerID int64
+
+	// blockingKeys 键到阻塞在该键上的peer队列（FIFO）
+	blockingKeys map[string]*list.List
+	// blockedPeers 所有处于阻塞状态的peer，用于检查超时
+	blockedPeers map[*Peer]struct{}
+	// readyKeys 当前命令执行期间有新数据的阻塞键
+	readyKeys   []string
+	readyKeySet map[string]struct{}
+
+	// pubsubChannels、pubsubPatterns 频道（模式）到订阅者的映射
+	pubsubChannels map[string]map[*Peer]struct{}
+	pubsubPatterns map[string]map[*Peer]struct{}
+	// pubsubShardChannels 分片频道到订阅者的映射
+	pubsubShardChannels map[string]map[*Peer]struct{}
+
+	// lastSave 最近一次成功保存RDB的时刻（unix秒）
+	lastSave int64
+	// rdbBgsaveCh 后台保存结束时传回结果，为nil表示没有进行中的后台保存
+	rdbBgsaveCh chan error
+	// rdbBgsaveScheduled BGSAVE SCHEDULE 在当前后台保存结束后再执行一次
+	rdbBgsaveScheduled bool
+	// dirtyBeforeBgsave 开始后台保存时的修改次数，保存成功后从dirty中扣除
+	dirtyBeforeBgsave int64
+	lastBgsaveErr     error
+
+	// loading 正在载入持久化文件，载入期间执行的命令不会再次传播
+	loading bool
+	// callDepth 命令执行的嵌套层数，EXEC中执行的命令为2
+	callDepth int
+	// currentClient 正在执行命令的peer，不在命令中时为nil
+	currentClient *Peer
+	// pendingPropagate 最外层命令执行期间暂存的待传播命令
+	pendingPropagate [][]string
+	// propagateAs 当前命令改写后的传播形式，为nil时按原样传播
+	propagateAs [][]string
+	// statExpiredKeys 因过期被删除的键数
+	statExpiredKeys int64
+	// statSnapshotUsec 最近一次生成后台快照时事件循环停顿的微秒数
+	statSnapshotUsec int64
+
+	// aofFile 追加写入的AOF文件，未开启AOF时为nil
+	aofFile *os.File
+	// aofBuf 已编码但尚未写入文件的命令，aofWr向其中编码
+	aofBuf bytes.Buffer
+	aofWr  *resp.Writer
+	// aofUnsynced 有已写入文件但尚未fsync的数据
+	aofUnsynced  bool
+	aofLastFsync time.Time
+	// aofFsyncCh 后台fsync结束时传回结果，为nil表示没有进行中的后台fsync
+	aofFsyncCh      chan error
+	aofLastWriteErr error
+	// aofFsyncedOffset 已fsync到AOF的复制偏移量，aofFsyncingOffset 进行中的后台fsync完成后可达到的偏移量
+	aofFsyncedOffset  int64
+	aofFsyncingOffset int64
+	// aofManifest 当前生效的AOF清单，没有AOF时为nil
+	aofManifest *aofManifest
+	// aofRewriteCh 后台重写结束时传回结果，为nil表示没有进行中的重写
+	aofRewriteCh chan aofRewriteResult
+	// aofRewriteIncrSeq 重写开始时最后一个增量文件的序号，不大于它的增量文件已包含在新的基础文件中
+	aofRewriteIncrSeq int64
+	lastAofRewriteErr error
+
+	// runID 每次启动随机生成的运行ID，startTime 启动时刻
+	runID     string
+	startTime time.Time
+	// cronloops serverCron执行的次数
+	cronloops int64
+
+	// replID 当前的复制ID，replID2为切换前的复制ID，偏移量小于secondReplOffset时仍可据此部分重同步
+	replID           string
+	replID2          string
+	secondReplOffset int64
+	// masterReplOffset 复制流的偏移量，主节点为已产生的字节数，副本为已处理的字节数
+	masterReplOffset int64
+	// replBacklog 复制积压缓冲区，第一个副本开始同步前为nil
+	replBacklog *replBacklog
+	// replBuf 编码待发送给副本的命令，replWr向其中编码
+	replBuf bytes.Buffer
+	replWr  *resp.Writer
+	// replicas 已发起同步的副本，按同步的先后排列
+	replicas []*Peer
+	// replSnapshotCh 为全量同步生成的快照编码完成时传回结果
+	replSnapshotCh chan replSnapshotResult
+	// masterHost、masterPort 本节点作为副本时主节点的地址，masterHost为空表示本节点是主节点
+	masterHost string
+	masterPort int
+	replState  int
+	// master 与主节点之间的连接
+	master *Peer
+	// replHandshakeCh 与主节点握手、同步的结果，replGen每次发起连接时递增，用于丢弃过期的结果
+	replHandshakeCh chan *replHandshake
+	replGen         int64
+	// replAckedAOFOffset 最近一次ACK中报告给主节点的AOF偏移量
+	replAckedAOFOffset int64
+	// replDownSince 与主节点的连接断开的时刻（unix秒），为0表示成为副本后尚未连接过主节点
+	replDownSince int64
+	// clientsWaitingAcks 阻塞在WAIT/WAITAOF上的peer，getAckFromReplicas 有新的等待者，
+	// 需要向副本发送REPLCONF GETACK
+	clientsWaitingAcks []*Peer
+	getAckFromReplicas bool
+	// statSyncFull、statSyncPartialOK、statSyncPartialErr 全量同步、部分重同步成功与失败的次数
+	statSyncFull       int64
+	statSyncPartialOK  int64
+	statSyncPartialErr int64
+
+	// cluster 集群状态，未开启集群模式时为nil
+	cluster *clusterState
+	// clusterLinkCh 总线连接建立时传回连接，clusterMsgCh 传回总线连接上读到的消息
+	clusterLinkCh chan clusterLinkEvent
+	clusterMsgCh  chan clusterBusMsg
+	// migrateSockets MIGRATE到各目标实例的缓存连接，键为host:port
+	migrateSockets map[string]*migrateSocket
+
+	// sentinel 哨兵状态，未以哨兵模式启动时为nil
+	sentinel *sentinelState
+	// sentinelLinkCh 到被监控实例的连接建立时传回连接，sentinelReplyCh 传回连接上读到的回复
+	sentinelLinkCh  chan sentinelLinkEvent
+	sentinelReplyCh chan sentinelReply
 }
 
 func NewService(cfg Config) *Service {
 	if len(cfg.ListenAddr) == 0 {
 		cfg.ListenAddr = defaultListenAddr
 	}
-	return &Service{
+	if len(cfg.Dir) == 0 {
+		cfg.Dir = "."
+	}
+	if len(cfg.DBFilename) == 0 {
+		cfg.DBFilename = defaultDBFilename
+	}
+	if len(cfg.AppendDirname) == 0 {
+		cfg.AppendDirname = defaultAppendDirname
+	}
+	if len(cfg.AppendFilename) == 0 {
+		cfg.AppendFilename = defaultAppendFilename
+	}
+	if len(cfg.AppendFsync) == 0 {
+		cfg.AppendFsync = aofFsyncEverysec
+	}
+	if cfg.ReplBacklogSize <= 0 {
+		cfg.ReplBacklogSize = defaultReplBacklogSize
+	}
+	if cfg.ClientOutputBufferLimitPubsub <= 0 {
+		cfg.ClientOutputBufferLimitPubsub = defaultClientOutputBufferLimitPubsub
+	}
+	if cfg.ClientOutputBufferLimitReplica <= 0 {
+		cfg.ClientOutputBufferLimitReplica = defaultClientOutputBufferLimitReplica
+	}
+	if len(cfg.ClusterConfigFile) == 0 {
+		cfg.ClusterConfigFile = defaultClusterConfigFile
+	}
+	if cfg.ClusterNodeTimeout <= 0 {
+		cfg.ClusterNodeTimeout = defaultClusterNodeTimeout
+	}
+	if len(cfg.SentinelConfigFile) == 0 {
+		cfg.SentinelConfigFile = defaultSentinelConfigFile
+	}
+	if cfg.SentinelDownAfter <= 0 {
+		cfg.SentinelDownAfter = defaultSentinelDownAfter
+	}
+	if cfg.SentinelFailoverTimeout <= 0 {
+		cfg.SentinelFailoverTimeout = defaultSentinelFailoverTimeout
+	}
+	s := &Service{
 		Config:     cfg,
+		db:         newKeyspace(),
 		peers:      make(map[*Peer]bool),
 		addPeerCh:  make(chan *Peer),
+		delPeerCh:  make(chan *Peer),
 		quitPeerCh: make(chan struct{}),
+		msgCh:      make(chan Message),
+
+		blockingKeys: make(map[string]*list.List),
+		blockedPeers: make(map[*Peer]struct{}),
+		readyKeySet:  make(map[string]struct{}),
+
+		pubsubChannels: make(map[string]map[*Peer]struct{}),
+		pubsubPatterns: make(map[string]map[*Peer]struct{}),
+
+		pubsubShardChannels: make(map[string]map[*Peer]struct{}),
+
+		lastSave: time.Now().Unix(),
+
+		runID:     genRunID(),
+		startTime: time.Now(),
+
+		replID:          genRunID(),
+		replSnapshotCh:  make(chan replSnapshotResult),
+		replHandshakeCh: make(chan *replHandshake),
+
+		clusterLinkCh: make(chan clusterLinkEvent),
+		clusterMsgCh:  make(chan clusterBusMsg),
+
+		migrateSockets: make(map[string]*migrateSocket),
+
+		sentinelLinkCh:  make(chan sentinelLinkEvent),
+		sentinelReplyCh: make(chan sentinelReply),
 	}
+	s.aofWr = resp.NewWriter(&s.aofBuf)
+	s.replWr = resp.NewWriter(&s.replBuf)
+	s.clearReplID2()
+	s.db.onExpire = s.propagateExpire
+	s.db.expirePolicy = s.expirePolicy
+	return s
 }
 
+// Start 载入持久化的数据后开始监听并接受连接
 func (s *Service) Start() error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	if s.ClusterEnabled {
+		if err := s.clusterInit(); err != nil {
+			return err
+		}
+	}
+	// 哨兵不保存数据
+	if s.Sentinel {
+		if err := s.sentinelInit(); err != nil {
+			return err
+		}
+		if err := s.listen(); err != nil {
+			return err
+		}
+		return s.acceptLoop()
+	}
+	if err := s.loadDataFromDisk(); err != nil {
+		return err
+	}
+	if err := s.openAppendOnlyFile(); err != nil {
+		return err
+	}
+	if s.ReplicaOf != "" {
+		// 事件循环启动后由replicationCron连接主节点
+		s.masterHost, s.masterPort, _ = splitHostPort(s.ReplicaOf)
+		s.replState = replStateConnect
+	}
+	if err := s.listen(); err != nil {
+		return err
+	}
+	return s.acceptLoop()
+}
+
+// listen 监听端口并启动事件循环
+func (s *Service) listen() error {
 	listen, err := net.Listen("tcp", s.ListenAddr)
 	if err != nil {
 		return err
 	}
 	s.ln = listen
+	if s.cluster != nil {
+		if err := s.clusterListen(); err != nil {
+			listen.Close()
+			return err
+		}
+	}
 	go s.loop()
-	slog.Info("service running", "start", s.ListenAddr)
-	return s.acceptLoop()
+	slog.Info("service running", "start", listen.Addr())
+	return nil
+}
+
+// Close 停止接受新连接并退出事件循环，可以重复调用
+func (s *Service) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.quitPeerCh)
+		if s.cluster != nil && s.cluster.busLn != nil {
+			s.cluster.busLn.Close()
+		}
+		err = s.ln.Close()
+	})
+	return err
 }
 
+// loop 事件循环，所有命令都在该goroutine中串行执行
 func (s *Service) loop() {
+	ticker := time.NewTicker(time.Second / serverHz)
+	defer ticker.Stop()
 	for {
 		select {
+		case <-ticker.C:
+			s.serverCron()
+		case err := <-s.rdbBgsaveCh:
+			s.backgroundSaveDone(err)
+		case res := <-s.aofRewriteCh:
+			s.backgroundRewriteDone(res)
+		case err := <-s.aofFsyncCh:
+			s.aofFsyncDone(err)
+			s.processClientsWaitingReplicas()
+		case res := <-s.replSnapshotCh:
+			s.replicaSnapshotDone(res)
+		case h := <-s.replHandshakeCh:
+			s.replicationHandshakeDone(h)
+		case ev := <-s.clusterLinkCh:
+			s.clusterLinkConnected(ev)
+			s.clusterBeforeSleep()
+		case msg := <-s.clusterMsgCh:
+			s.clusterHandleBusMsg(msg)
+			s.clusterBeforeSleep()
+		case ev := <-s.sentinelLinkCh:
+			s.sentinelLinkConnected(ev)
+		case r := <-s.sentinelReplyCh:
+			s.sentinelHandleReply(r)
 		case peer := <-s.addPeerCh:
+			s.nextPeerID++
+			peer.id = s.nextPeerID
 			s.peers[peer] = true
+		case peer := <-s.delPeerCh:
+			s.removePeer(peer)
 		case <-s.quitPeerCh:
+			for peer := range s.peers {
+				s.removePeer(peer)
+			}
+			s.clusterFreeAllLinks()
+			s.sentinelFreeAllLinks()
+			for addr := range s.migrateSockets {
+				s.migrateCloseSocket(addr)
+			}
+			s.stopAppendOnly()
 			return
 		// 接收到消息
-		case <-s.msgCh:
+		case msg := <-s.msgCh:
+			msg.peer.handleMSG(s, msg)
+			s.handleClientsBlockedOnKeys()
+			s.processClientsWaitingReplicas()
+			s.clusterBeforeSleep()
+		}
+	}
+}
+
+// serverCron 周期性任务，在事件循环中执行
+func (s *Service) serverCron() {
+	s.cronloops++
+	// 副本上的键由主节点传播的DEL删除，不主动过期
+	if s.masterHost == "" {
+		s.db.activeExpireCycle()
+	}
+	s.handleBlockedTimeouts()
+	s.flushAppendOnlyFile(false)
+	if s.cronloops%serverHz == 0 {
+		s.migrateCloseTimedoutSockets()
+		s.replicationCron()
+	} else if s.master != nil && s.aofFsyncedOffset != s.replAckedAOFOffset {
+		// AOF的fsync有进展时立即报告，不必等到下一次定时ACK
+		s.replicationSendAck()
+	}
+	s.processClientsWaitingReplicas()
+	if s.cluster != nil {
+		s.clusterCron()
+		s.clusterBeforeSleep()
+	}
+	if s.sentinel != nil {
+		s.sentinelTimer()
+	}
+}
 
-		default:
+// removePeer 连接断开后释放peer占用的资源
+func (s *Service) removePeer(peer *Peer) {
+	delete(s.peers, peer)
+	s.unblockPeer(peer)
+	s.pubsubRemovePeer(peer)
+	s.unwatchAllKeys(peer)
+	if peer.replica != nil {
+		s.replicas = slices.DeleteFunc(s.replicas, func(r *Peer) bool { return r == peer })
+	}
+	if peer == s.master {
+		s.replicationHandleMasterDisconnection()
+	}
+	peer.close()
+}
 
-		}
+// checkOutputBufferLimit 输出缓冲区超过所属类别的限制时关闭连接，之后readLoop出错，
+// 由事件循环照常移除peer。只有副本与订阅者受限制，普通客户端总会读取自己的回复
+func (s *Service) checkOutputBufferLimit(p *Peer) {
+	if p.out == nil {
+		return
+	}
+	var class string
+	var n, limit int
+	switch {
+	case p.replica != nil:
+		// 等待快照期间暂存的复制流同样计入，快照本身不计入
+		class, n, limit = "replica", p.out.pending()+p.replica.pending.Len(), s.ClientOutputBufferLimitReplica
+	case p.subscriptionCount() > 0:
+		class, n, limit = "pubsub", p.out.pending(), s.ClientOutputBufferLimitPubsub
+	default:
+		return
+	}
+	if n <= limit {
+		return
+	}
+	slog.Warn("client output buffer limit reached, closing connection",
+		"id", p.id, "class", class, "pending", n, "limit", limit)
+	p.close()
+	if p.replica != nil {
+		p.replica.pending = bytes.Buffer{}
 	}
 }
 
@@ -65,6 +502,9 @@ func (s *Service) acceptLoop() error {
 	for {
 		conn, err := s.ln.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
 			slog.Error("accept error", "err", err)
 			continue
 		}
@@ -73,17 +513,61 @@ func (s *Service) acceptLoop() error {
 }
 
 func (s *Service) handleConn(conn net.Conn) {
-	peer := NewPeer(conn, s.msgCh)
-	s.addPeerCh <- peer
+	peer := NewPeer(conn, s.msgCh, s.quitPeerCh)
+	select {
+	case s.addPeerCh <- peer:
+	case <-s.quitPeerCh:
+		peer.close()
+		return
+	}
 	slog.Info("new peer connected", "remoteAddr", conn.RemoteAddr())
-	go func() {
-		err := peer.readLoop()
-		if err != nil {
-			slog.Error("readLoop error", "err", err)
-		}
-	}()
+	go s.readPeer(peer)
+}
+
+// readPeer 读取peer的命令直到连接断开，之后通知事件循环移除peer
+func (s *Service) readPeer(peer *Peer) {
+	err := peer.readLoop()
+	if err != nil {
+		slog.Error("readLoop error", "err", err)
+	}
+	select {
+	case s.delPeerCh <- peer:
+	case <-s.quitPeerCh:
+	}
+}
+
+// parseFlags 从命令行参数读取配置
+func parseFlags(args []string) (Config, error) {
+	var cfg Config
+	fs := flag.NewFlagSet("goredis", flag.ContinueOnError)
+	fs.StringVar(&cfg.ListenAddr, "addr", defaultListenAddr, "client listen address")
+	fs.StringVar(&cfg.Dir, "dir", ".", "working directory for persistence and cluster config files")
+	fs.BoolVar(&cfg.AppendOnly, "appendonly", false, "enable append only file persistence")
+	fs.StringVar(&cfg.AppendFsync, "appendfsync", aofFsyncEverysec, "fsync policy: always, everysec or no")
+	fs.StringVar(&cfg.ReplicaOf, "replicaof", "", "start as a replica of host:port")
+	fs.IntVar(&cfg.ClientOutputBufferLimitPubsub, "client-output-buffer-limit-pubsub", defaultClientOutputBufferLimitPubsub,
+		"bytes of pending output after which a pubsub client is disconnected")
+	fs.IntVar(&cfg.ClientOutputBufferLimitReplica, "client-output-buffer-limit-replica", defaultClientOutputBufferLimitReplica,
+		"bytes of pending output after which a replica is disconnected")
+	fs.BoolVar(&cfg.ClusterEnabled, "cluster-enabled", false, "start in cluster mode")
+	fs.StringVar(&cfg.ClusterConfigFile, "cluster-config-file", defaultClusterConfigFile, "cluster config file name in dir")
+	fs.StringVar(&cfg.ClusterBusAddr, "cluster-bus-addr", "", "cluster bus listen address, defaults to client port + 10000")
+	fs.Int64Var(&cfg.ClusterNodeTimeout, "cluster-node-timeout", defaultClusterNodeTimeout, "cluster node timeout in milliseconds")
+	fs.BoolVar(&cfg.Sentinel, "sentinel", false, "start in sentinel mode")
+	fs.StringVar(&cfg.SentinelConfigFile, "sentinel-config-file", defaultSentinelConfigFile, "sentinel config file name in dir")
+	fs.StringVar(&cfg.SentinelMonitor, "sentinel-monitor", "", `master to monitor: "name host port quorum"`)
+	fs.Int64Var(&cfg.SentinelDownAfter, "sentinel-down-after", defaultSentinelDownAfter, "milliseconds without reply before an instance is subjectively down")
+	fs.Int64Var(&cfg.SentinelFailoverTimeout, "sentinel-failover-timeout", defaultSentinelFailoverTimeout, "failover timeout in milliseconds")
+	err := fs.Parse(args)
+	return cfg, err
 }
 
 func main() {
-	NewService(Config{}).Start()
+	cfg, err := parseFlags(os.Args[1:])
+	if err != nil {
+		os.Exit(2)
+	}
+	if err := NewService(cfg).Start(); err != nil {
+		slog.Error("service start error", "err", err)
+	}
 }
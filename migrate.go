@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+)
+
+const (
+	CommentDump          = "DUMP"
+	CommentRestore       = "RESTORE"
+	CommentRestoreAsking = "RESTORE-ASKING"
+	CommentMigrate       = "MIGRATE"
+	CommentAsking        = "ASKING"
+)
+
+const (
+	// migrateSocketCacheTTL 缓存的MIGRATE连接空闲多久（秒）后关闭
+	migrateSocketCacheTTL = 10
+	// migrateDefaultTimeout MIGRATE的timeout不大于0时使用的超时（毫秒）
+	migrateDefaultTimeout = 1000
+)
+
+var (
+	errBadDumpPayload = errors.New("ERR DUMP payload version or checksum are wrong")
+	errBusyKey        = errors.New("BUSYKEY Target key name already exists.")
+)
+
+// migrateSocket MIGRATE到某个目标实例的缓存连接
+type migrateSocket struct {
+	conn    net.Conn
+	rd      *resp.Reader
+	wr      *resp.Writer
+	lastUse time.Time
+}
+
+func init() {
+	registerCommand(
+		&command{name: CommentDump, arity: 2, flags: cmdReadonly, firstKey: 1, lastKey: 1, keyStep: 1, proc: dumpCommand},
+		&command{name: CommentRestore, arity: -4, flags: cmdWrite, firstKey: 1, lastKey: 1, keyStep: 1, proc: restoreCommand},
+		&command{name: CommentRestoreAsking, arity: -4, flags: cmdWrite | cmdAsking, firstKey: 1, lastKey: 1, keyStep: 1, proc: restoreCommand},
+		&command{name: CommentMigrate, arity: -6, flags: cmdWrite, getkeys: migrateKeys, proc: migrateCommand},
+		&command{name: CommentAsking, arity: 1, flags: cmdFast, proc: askingCommand},
+	)
+}
+
+// createDumpPayload 序列化值：RDB类型字节与值的RDB编码，后跟2字节的RDB版本
+// 与8字节的CRC64校验和，均为小端序
+func createDumpPayload(val any) ([]byte, error) {
+	typ, err := rdbObjectType(val)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	e := &rdbEncoder{w: &buf}
+	e.writeByte(typ)
+	e.saveObject(val)
+	e.write(binary.LittleEndian.AppendUint16(nil, rdbVersion))
+	if e.err != nil {
+		return nil, e.err
+	}
+	return binary.LittleEndian.AppendUint64(buf.Bytes(), e.crc), nil
+}
+
+// verifyDumpPayload 检查DUMP数据的版本与校验和
+func verifyDumpPayload(payload []byte) bool {
+	if len(payload) < 10 {
+		return false
+	}
+	footer := payload[len(payload)-10:]
+	if binary.LittleEndian.Uint16(footer) > rdbVersion {
+		return false
+	}
+	crc := binary.LittleEndian.Uint64(footer[2:])
+	return crc64Jones(0, payload[:len(payload)-8]) == crc
+}
+
+// loadDumpPayload 检查并反序列化DUMP数据
+func loadDumpPayload(payload []byte) (any, error) {
+	if !verifyDumpPayload(payload) {
+		return nil, errBadDumpPayload
+	}
+	d := &rdbDecoder{r: bufio.NewReader(bytes.NewReader(payload[1 : len(payload)-10]))}
+	val, err := d.loadObject(payload[0])
+	if err != nil {
+		return nil, errors.New("ERR Bad data format")
+	}
+	return val, nil
+}
+
+// dumpCommand DUMP key
+func dumpCommand(s *Service, p *Peer, args []string) any {
+	val, ok := s.db.lookup(args[1])
+	if !ok {
+		return nil
+	}
+	payload, err := createDumpPayload(val)
+	if err != nil {
+		return fmt.Errorf("ERR %v", err)
+	}
+	return resp.BulkStrings(payload)
+}
+
+// restoreCommand RESTORE key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency]
+// 相对的ttl改写为ABSTTL传播，使副本与AOF得到相同的过期时刻
+func restoreCommand(s *Service, p *Peer, args []string) any {
+	key := args[1]
+	var replace, absTTL bool
+	for i := 4; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absTTL = true
+		case "IDLETIME":
+			// 未记录空闲时间，只检查参数
+			if i+1 >= len(args) {
+				return errSyntax
+			}
+			i++
+			idle, err := parseInt(args[i])
+			if err != nil {
+				return err
+			}
+			if idle < 0 {
+				return errors.New("ERR Invalid IDLETIME value, must be >= 0")
+			}
+		case "FREQ":
+			// 未记录访问频率，只检查参数
+			if i+1 >= len(args) {
+				return errSyntax
+			}
+			i++
+			freq, err := parseInt(args[i])
+			if err != nil {
+				return err
+			}
+			if freq < 0 || freq > 255 {
+				return errors.New("ERR Invalid FREQ value, must be >= 0 and <= 255")
+			}
+		default:
+			return errSyntax
+		}
+	}
+	ttl, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	if ttl < 0 {
+		return errors.New("ERR Invalid TTL value, must be >= 0")
+	}
+	if !replace && s.db.exists(key) {
+		return errBusyKey
+	}
+	val, err := loadDumpPayload([]byte(args[3]))
+	if err != nil {
+		return err
+	}
+	if ttl > 0 && !absTTL {
+		ttl += mstime()
+	}
+	// 过期时刻已过时不创建键，REPLACE覆盖的旧值同样被删除
+	if ttl > 0 && ttl <= mstime() {
+		if s.db.delete(key) {
+			s.rewritePropagate([]string{CommentDel, key})
+		}
+		return "OK"
+	}
+	s.db.set(key, val)
+	if ttl > 0 {
+		s.db.setExpire(key, ttl)
+		s.rewritePropagate([]string{CommentRestore, key, strconv.FormatInt(ttl, 10), args[3], "REPLACE", "ABSTTL"})
+	} else {
+		s.rewritePropagate([]string{CommentRestore, key, "0", args[3], "REPLACE"})
+	}
+	s.signalKeyAsReady(key)
+	return "OK"
+}
+
+// askingCommand ASKING 使下一条命令可以访问本节点正在导入的槽
+func askingCommand(s *Service, p *Peer, args []string) any {
+	if s.cluster == nil {
+		return errClusterDisabled
+	}
+	p.asking = true
+	return "OK"
+}
+
+// migrateKeys 返回MIGRATE参数中的键：key不为空时为key，否则为KEYS之后的全部参数
+func migrateKeys(args []string) []string {
+	if args[3] != "" {
+		return args[3:4]
+	}
+	for i := 6; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "AUTH":
+			i++
+		case "AUTH2":
+			i += 2
+		case "KEYS":
+			return args[i+1:]
+		}
+	}
+	return nil
+}
+
+// migrateOptions MIGRATE的选项
+type migrateOptions struct {
+	copy, replace bool
+	auth          []string
+	keys          []string
+}
+
+// parseMigrateOptions 解析MIGRATE host port key|"" destination-db timeout之后的选项
+func parseMigrateOptions(args []string) (migrateOptions, error) {
+	opt := migrateOptions{keys: args[3:4]}
+	for i := 6; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COPY":
+			opt.copy = true
+		case "REPLACE":
+			opt.replace = true
+		case "AUTH":
+			if i+1 >= len(args) {
+				return opt, errSyntax
+			}
+			opt.auth = []string{"AUTH", args[i+1]}
+			i++
+		case "AUTH2":
+			if i+2 >= len(args) {
+				return opt, errSyntax
+			}
+			opt.auth = []string{"AUTH", args[i+1], args[i+2]}
+			i += 2
+		case "KEYS":
+			if args[3] != "" {
+				return opt, errors.New("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			opt.keys = args[i+1:]
+			i = len(args)
+		default:
+			return opt, errSyntax
+		}
+	}
+	return opt, nil
+}
+
+// migrateCommand MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE]
+// [AUTH password] [AUTH2 username password] [KEYS key [key ...]]
+// 在事件循环中同步地把键以RESTORE发给目标实例，目标确认后删除本地的键（COPY除外）。
+// 迁移期间本节点不处理其他命令，因此键不会在发送后、删除前被修改
+func migrateCommand(s *Service, p *Peer, args []string) any {
+	opt, err := parseMigrateOptions(args)
+	if err != nil {
+		return err
+	}
+	timeout, err := parseInt(args[5])
+	if err != nil {
+		return err
+	}
+	if timeout <= 0 {
+		timeout = migrateDefaultTimeout
+	}
+	db, err := parseInt(args[4])
+	if err != nil {
+		return err
+	}
+	if db != 0 {
+		return errors.New("ERR DB index is out of range")
+	}
+
+	type migrateKey struct {
+		key     string
+		payload []byte
+		ttl     int64
+	}
+	var entries []migrateKey
+	now := mstime()
+	for _, key := range opt.keys {
+		val, ok := s.db.lookup(key)
+		if !ok {
+			continue
+		}
+		var ttl int64
+		if when := s.db.getExpire(key); when >= 0 {
+			ttl = max(when-now, 1)
+		}
+		payload, err := createDumpPayload(val)
+		if err != nil {
+			return fmt.Errorf("ERR %v", err)
+		}
+		entries = append(entries, migrateKey{key: key, payload: payload, ttl: ttl})
+	}
+	if len(entries) == 0 {
+		return "NOKEY"
+	}
+
+	addr := net.JoinHostPort(args[1], args[2])
+	restore := CommentRestore
+	if s.cluster != nil {
+		restore = CommentRestoreAsking
+	}
+	// 缓存的连接可能已被对方关闭，尚未删除任何键时重试一次
+	for retry := 0; ; retry++ {
+		ms, err := s.migrateGetSocket(addr, time.Duration(timeout)*time.Millisecond)
+		if err != nil {
+			return fmt.Errorf("IOERR error or timeout connecting to the client")
+		}
+		ms.conn.SetDeadline(time.Now().Add(time.Duration(timeout) * time.Millisecond))
+		cmds := make([][]string, 0, len(entries)+1)
+		if opt.auth != nil {
+			cmds = append(cmds, opt.auth)
+		}
+		for _, e := range entries {
+			cmd := []string{restore, e.key, strconv.FormatInt(e.ttl, 10), string(e.payload)}
+			if opt.replace {
+				cmd = append(cmd, "REPLACE")
+			}
+			cmds = append(cmds, cmd)
+		}
+		for _, cmd := range cmds {
+			err = catAppendOnlyCommand(ms.wr, cmd)
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = ms.wr.Flush()
+		}
+		if err != nil {
+			s.migrateCloseSocket(addr)
+			if retry == 0 && !isTimeout(err) {
+				continue
+			}
+			return errors.New("IOERR error or timeout writing to target instance")
+		}
+
+		var replyErr, readErr error
+		var deleted []string
+		for i := range cmds {
+			var reply any
+			if reply, readErr = ms.rd.ReadValue(); readErr != nil {
+				break
+			}
+			if e, ok := reply.(error); ok {
+				if replyErr == nil {
+					replyErr = fmt.Errorf("ERR Target instance replied with error: %s", e.Error())
+				}
+				continue
+			}
+			if opt.auth != nil && i == 0 {
+				continue
+			}
+			if !opt.copy {
+				key := cmds[i][1]
+				if s.db.delete(key) {
+					deleted = append(deleted, key)
+				}
+			}
+		}
+		if len(deleted) > 0 {
+			s.rewritePropagate(append([]string{CommentDel}, deleted...))
+		}
+		if readErr != nil {
+			s.migrateCloseSocket(addr)
+			if retry == 0 && len(deleted) == 0 && replyErr == nil && !isTimeout(readErr) {
+				continue
+			}
+			return errors.New("IOERR error or timeout reading to target instance")
+		}
+		ms.conn.SetDeadline(time.Time{})
+		ms.lastUse = time.Now()
+		if replyErr != nil {
+			return replyErr
+		}
+		return "OK"
+	}
+}
+
+// isTimeout 判断是否为超时错误，超时后不再重试
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// migrateGetSocket 返回到目标实例的缓存连接，没有时新建
+func (s *Service) migrateGetSocket(addr string, timeout time.Duration) (*migrateSocket, error) {
+	if ms, ok := s.migrateSockets[addr]; ok {
+		return ms, nil
+	}
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		slog.Warn("MIGRATE connect error", "addr", addr, "err", err)
+		return nil, err
+	}
+	ms := &migrateSocket{conn: conn, rd: resp.NewReader(conn), wr: resp.NewWriter(conn), lastUse: time.Now()}
+	s.migrateSockets[addr] = ms
+	return ms, nil
+}
+
+// migrateCloseSocket 关闭到目标实例的缓存连接
+func (s *Service) migrateCloseSocket(addr string) {
+	if ms, ok := s.migrateSockets[addr]; ok {
+		ms.conn.Close()
+		delete(s.migrateSockets, addr)
+	}
+}
+
+// migrateCloseTimedoutSockets 关闭空闲超过migrateSocketCacheTTL的缓存连接，在serverCron中调用
+func (s *Service) migrateCloseTimedoutSockets() {
+	for addr, ms := range s.migrateSockets {
+		if time.Since(ms.lastUse) > migrateSocketCacheTTL*time.Second {
+			s.migrateCloseSocket(addr)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDumpRestore(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	testCases := []struct {
+		name  string
+		setup []string
+		check []string
+		res   any
+	}{
+		{
+			name:  "测试字符串",
+			setup: []string{"SET", "src", "hello"},
+			check: []string{"GET", "dst"},
+			res:   []byte("hello"),
+		},
+		{
+			name:  "测试列表",
+			setup: []string{"RPUSH", "src", "a", "b", "c"},
+			check: []string{"LRANGE", "dst", "0", "-1"},
+			res:   resp.Array{resp.BulkStrings("a"), resp.BulkStrings("b"), resp.BulkStrings("c")},
+		},
+		{
+			name:  "测试哈希",
+			setup: []string{"HSET", "src", "f", "v"},
+			check: []string{"HGET", "dst", "f"},
+			res:   resp.BulkStrings("v"),
+		},
+		{
+			name:  "测试整数集合",
+			setup: []string{"SADD", "src", "1", "2", "3"},
+			check: []string{"SCARD", "dst"},
+			res:   int64(3),
+		},
+		{
+			name:  "测试有序集合",
+			setup: []string{"ZADD", "src", "1", "a", "2", "b"},
+			check: []string{"ZRANGE", "dst", "0", "-1", "WITHSCORES"},
+			res:   resp.Array{resp.BulkStrings("a"), float64(1), resp.BulkStrings("b"), float64(2)},
+		},
+		{
+			name:  "测试流",
+			setup: []string{"XADD", "src", "1-1", "f", "v"},
+			check: []string{"XLEN", "dst"},
+			res:   int64(1),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			doCommand(s, p, "DEL", "src", "dst")
+			doCommand(s, p, tc.setup...)
+			payload, ok := doCommand(s, p, CommentDump, "src").(resp.BulkStrings)
+			assert.True(t, ok)
+			assert.Equal(t, "OK", doCommand(s, p, CommentRestore, "dst", "0", string(payload)))
+			assert.Equal(t, tc.res, doCommand(s, p, tc.check...))
+		})
+	}
+
+	doCommand(s, p, "SET", "k", "v")
+	payload := string(doCommand(s, p, CommentDump, "k").(resp.BulkStrings))
+	// 版本号之后为CRC64校验和
+	corrupted := []byte(payload)
+	corrupted[len(corrupted)-1] ^= 0xff
+	testCases2 := []struct {
+		name string
+		args []string
+		res  any
+	}{
+		{name: "测试DUMP不存在的键", args: []string{CommentDump, "nokey"}, res: nil},
+		{name: "测试目标键已存在", args: []string{CommentRestore, "k", "0", payload}, res: errBusyKey},
+		{name: "测试REPLACE", args: []string{CommentRestore, "k", "0", payload, "REPLACE"}, res: "OK"},
+		{name: "测试校验和错误", args: []string{CommentRestore, "k2", "0", string(corrupted)}, res: errBadDumpPayload},
+		{name: "测试数据过短", args: []string{CommentRestore, "k2", "0", "abc"}, res: errBadDumpPayload},
+		{name: "测试负的TTL", args: []string{CommentRestore, "k2", "-1", payload}, res: errors.New("ERR Invalid TTL value, must be >= 0")},
+		{name: "测试未知选项", args: []string{CommentRestore, "k2", "0", payload, "FOO"}, res: errSyntax},
+		{name: "测试相对TTL", args: []string{CommentRestore, "k2", "100000", payload, "IDLETIME", "10"}, res: "OK"},
+		{name: "测试TTL", args: []string{"TTL", "k2"}, res: int64(100)},
+		{name: "测试ABSTTL已过期", args: []string{CommentRestore, "k3", "1", payload, "ABSTTL"}, res: "OK"},
+		{name: "测试已过期的键不被创建", args: []string{"EXISTS", "k3"}, res: int64(0)},
+		{name: "测试ASKING未开启集群", args: []string{CommentAsking}, res: errClusterDisabled},
+	}
+	for _, tc := range testCases2 {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.res, doCommand(s, p, tc.args...))
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	src := startTestService(t, Config{})
+	dst := startTestService(t, Config{})
+	sc, dc := dialTestService(t, src), dialTestService(t, dst)
+	host, port, _ := net.SplitHostPort(dst.ln.Addr().String())
+
+	sc.do("SET", "a", "1")
+	sc.do("SET", "b", "2", "PX", "100000")
+	sc.do("RPUSH", "c", "x", "y")
+	dc.do("SET", "c", "old")
+
+	testCases := []struct {
+		name string
+		args []string
+		res  any
+	}{
+		{name: "测试键不存在", args: []string{"nokey", "0", "1000"}, res: "NOKEY"},
+		{name: "测试迁移单个键", args: []string{"a", "0", "1000"}, res: "OK"},
+		{name: "测试KEYS时key须为空", args: []string{"a", "0", "1000", "KEYS", "b"}, res: errors.New("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")},
+		{name: "测试db超出范围", args: []string{"b", "1", "1000"}, res: errors.New("ERR DB index is out of range")},
+		{name: "测试COPY保留本地的键", args: []string{"b", "0", "1000", "COPY"}, res: "OK"},
+		{name: "测试目标键已存在", args: []string{"", "0", "1000", "KEYS", "b", "c"}, res: errors.New("ERR Target instance replied with error: BUSYKEY Target key name already exists.")},
+		{name: "测试REPLACE", args: []string{"", "0", "1000", "REPLACE", "KEYS", "b", "c", "nokey"}, res: "OK"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := append([]string{CommentMigrate, host, port, tc.args[0]}, tc.args[1:]...)
+			assert.Equal(t, tc.res, sc.do(args...))
+		})
+	}
+	assert.Equal(t, int64(0), sc.do("EXISTS", "a", "b", "c"))
+	assert.Equal(t, resp.BulkStrings("1"), dc.do("GET", "a"))
+	assert.Equal(t, resp.Array{resp.BulkStrings("x"), resp.BulkStrings("y")}, dc.do("LRANGE", "c", "0", "-1"))
+	ttl := dc.do("PTTL", "b").(int64)
+	assert.True(t, ttl > 90000 && ttl <= 100000, "ttl %d", ttl)
+	assert.Len(t, src.migrateSockets, 1)
+
+	// 目标不可达
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	closedPort := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+	ln.Close()
+	sc.do("SET", "a", "1")
+	assert.Equal(t, errors.New("IOERR error or timeout connecting to the client"),
+		sc.do(CommentMigrate, "127.0.0.1", closedPort, "a", "0", "100"))
+	assert.Equal(t, int64(1), sc.do("EXISTS", "a"))
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+)
+
+const (
+	CommentMulti   = "MULTI"
+	CommentExec    = "EXEC"
+	CommentDiscard = "DISCARD"
+	CommentWatch   = "WATCH"
+	CommentUnwatch = "UNWATCH"
+)
+
+var (
+	errNestedMulti = errors.New("ERR MULTI calls can not be nested")
+	errExecAbort   = errors.New("EXECABORT Transaction discarded because of previous errors.")
+)
+
+// multiState peer处于MULTI中时的事务状态
+type multiState struct {
+	commands []queuedCommand
+	// aborted 排队期间出现了命令不存在、参数个数错误等错误，EXEC时放弃整个事务
+	aborted bool
+}
+
+// queuedCommand 排队等待EXEC执行的命令
+type queuedCommand struct {
+	cmd  *command
+	args []string
+}
+
+func init() {
+	registerCommand(
+		&command{name: CommentMulti, arity: 1, flags: cmdFast, proc: multiCommand},
+		&command{name: CommentExec, arity: 1, proc: execCommand},
+		&command{name: CommentDiscard, arity: 1, flags: cmdFast, proc: discardCommand},
+		&command{name: CommentWatch, arity: -2, flags: cmdFast, firstKey: 1, lastKey: -1, keyStep: 1, proc: watchCommand},
+		&command{name: CommentUnwatch, arity: 1, flags: cmdFast, proc: unwatchCommand},
+	)
+}
+
+// execImmediately 事务中不排队、立即执行的命令
+func execImmediately(name string) bool {
+	switch name {
+	case CommentMulti, CommentExec, CommentDiscard, CommentWatch, CommentQuit:
+		return true
+	}
+	return false
+}
+
+// flagTransaction 排队期间命令出错时标记事务，EXEC将回复EXECABORT
+func (p *Peer) flagTransaction() {
+	if p.mstate != nil {
+		p.mstate.aborted = true
+	}
+}
+
+// discardTransaction 退出事务并丢弃已排队的命令
+func (s *Service) discardTransaction(p *Peer) {
+	p.mstate = nil
+	s.unwatchAllKeys(p)
+}
+
+// watchKey 记录键当前的版本号，已过期的键先删除，使之后的过期不会被误认为修改
+func (s *Service) watchKey(p *Peer, key string) {
+	if _, ok := p.watched[key]; ok {
+		return
+	}
+	if p.watched == nil {
+		p.watched = make(map[string]uint64)
+	}
+	s.db.expireIfNeeded(key)
+	ks := s.db
+	ks.watchers[key]++
+	p.watched[key] = ks.versions[key]
+}
+
+// unwatchAllKeys 取消peer监视的全部键
+func (s *Service) unwatchAllKeys(p *Peer) {
+	ks := s.db
+	for key := range p.watched {
+		if ks.watchers[key]--; ks.watchers[key] <= 0 {
+			delete(ks.watchers, key)
+			delete(ks.versions, key)
+		}
+	}
+	p.watched = nil
+}
+
+// watchedKeysModified 判断监视的键自WATCH以来是否被修改或已过期
+func (s *Service) watchedKeysModified(p *Peer) bool {
+	for key, version := range p.watched {
+		s.db.expireIfNeeded(key)
+		cur := s.db.versions[key]
+		if cur != version {
+			return true
+		}
+	}
+	return false
+}
+
+// multiCommand MULTI
+func multiCommand(s *Service, p *Peer, args []string) any {
+	if p.mstate != nil {
+		return errNestedMulti
+	}
+	p.mstate = &multiState{}
+	return "OK"
+}
+
+// discardCommand DISCARD
+func discardCommand(s *Service, p *Peer, args []string) any {
+	if p.mstate == nil {
+		return errors.New("ERR DISCARD without MULTI")
+	}
+	s.discardTransaction(p)
+	return "OK"
+}
+
+// execCommand EXEC 依次执行排队的命令并以一个数组回复各命令的结果。
+// 事务在事件循环中一次执行完，期间不会执行其他连接的命令；
+// 会阻塞的命令不会阻塞，直接得到超时时的回复
+func execCommand(s *Service, p *Peer, args []string) any {
+	if p.mstate == nil {
+		return errors.New("ERR EXEC without MULTI")
+	}
+	ms := p.mstate
+	modified := s.watchedKeysModified(p)
+	s.discardTransaction(p)
+	if ms.aborted {
+		return errExecAbort
+	}
+	// 监视的键被修改时放弃事务，回复空数组
+	if modified {
+		return resp.Array(nil)
+	}
+	res := make(resp.Array, 0, len(ms.commands))
+	for _, qc := range ms.commands {
+		reply := s.call(p, qc.cmd, qc.args)
+		if p.bstate != nil {
+			reply = p.bstate.timeoutReply
+			s.unblockPeer(p)
+		}
+		res = append(res, reply)
+	}
+	return res
+}
+
+// watchCommand WATCH key [key ...]
+func watchCommand(s *Service, p *Peer, args []string) any {
+	if p.mstate != nil {
+		return errors.New("ERR WATCH inside MULTI is not allowed")
+	}
+	for _, key := range args[1:] {
+		s.watchKey(p, key)
+	}
+	return "OK"
+}
+
+// unwatchCommand UNWATCH
+func unwatchCommand(s *Service, p *Peer, args []string) any {
+	s.unwatchAllKeys(p)
+	return "OK"
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMulti(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	testCases := []struct {
+		name string
+		args []string
+		res  any
+	}{
+		{name: "测试EXEC无MULTI", args: []string{"EXEC"}, res: errors.New("ERR EXEC without MULTI")},
+		{name: "测试DISCARD无MULTI", args: []string{"DISCARD"}, res: errors.New("ERR DISCARD without MULTI")},
+		{name: "测试MULTI", args: []string{"MULTI"}, res: "OK"},
+		{name: "测试MULTI嵌套", args: []string{"MULTI"}, res: errNestedMulti},
+		{name: "测试排队SET", args: []string{"SET", "k", "v"}, res: "QUEUED"},
+		{name: "测试排队INCR", args: []string{"INCR", "k"}, res: "QUEUED"},
+		{name: "测试排队BLPOP", args: []string{"BLPOP", "l", "0"}, res: "QUEUED"},
+		{name: "测试排队GET", args: []string{"GET", "k"}, res: "QUEUED"},
+		{name: "测试EXEC", args: []string{"EXEC"}, res: resp.Array{"OK", errNotInteger, resp.Array(nil), []byte("v")}},
+		{name: "测试DISCARD", args: []string{"MULTI"}, res: "OK"},
+		{name: "测试DISCARD排队", args: []string{"DEL", "k"}, res: "QUEUED"},
+		{name: "测试DISCARD丢弃", args: []string{"DISCARD"}, res: "OK"},
+		{name: "测试DISCARD后", args: []string{"EXISTS", "k"}, res: int64(1)},
+		{name: "测试EXECABORT", args: []string{"MULTI"}, res: "OK"},
+		{name: "测试EXECABORT排队", args: []string{"DEL", "k"}, res: "QUEUED"},
+		{name: "测试EXECABORT未知命令", args: []string{"FOO"}, res: unknownCommandErr([]string{"FOO"})},
+		{name: "测试EXECABORT参数个数", args: []string{"GET"}, res: wrongArityErr("GET")},
+		{name: "测试EXECABORT", args: []string{"EXEC"}, res: errExecAbort},
+		{name: "测试EXECABORT未执行", args: []string{"EXISTS", "k"}, res: int64(1)},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert.Equal(t, v.res, doCommand(s, p, v.args...))
+		})
+	}
+	assert.Nil(t, p.bstate)
+	assert.Empty(t, s.blockingKeys)
+}
+
+func TestMultiAtomic(t *testing.T) {
+	s := NewService(Config{})
+	p1, buf1 := newTestPeer(s)
+	p2, buf2 := newTestPeer(s)
+	runCommand(s, p2, "BLPOP", "l", "0")
+
+	// 事务执行完之后才唤醒阻塞的peer
+	runCommand(s, p1, "MULTI")
+	runCommand(s, p1, "RPUSH", "l", "a")
+	runCommand(s, p1, "LPOP", "l")
+	runCommand(s, p1, "RPUSH", "l", "b")
+	runCommand(s, p1, "EXEC")
+	assert.Equal(t, "+OK\r\n+QUEUED\r\n+QUEUED\r\n+QUEUED\r\n*3\r\n:1\r\n$1\r\na\r\n:1\r\n", buf1.String())
+	assert.Equal(t, "*2\r\n$1\r\nl\r\n$1\r\nb\r\n", buf2.String())
+}
+
+func TestWatch(t *testing.T) {
+	s := NewService(Config{})
+	p1, _ := newTestPeer(s)
+	p2, _ := newTestPeer(s)
+	doCommand(s, p1, "SET", "k", "1")
+
+	// 未被修改时正常执行
+	assert.Equal(t, "OK", doCommand(s, p1, "WATCH", "k", "other"))
+	doCommand(s, p1, "MULTI")
+	assert.Equal(t, errors.New("ERR WATCH inside MULTI is not allowed"), doCommand(s, p1, "WATCH", "k"))
+	doCommand(s, p1, "INCR", "k")
+	assert.Equal(t, resp.Array{int64(2)}, doCommand(s, p1, "EXEC"))
+	assert.Empty(t, s.db.watchers)
+
+	// 其他连接修改了监视的键
+	doCommand(s, p1, "WATCH", "k")
+	doCommand(s, p2, "SET", "k", "10")
+	doCommand(s, p1, "MULTI")
+	doCommand(s, p1, "INCR", "k")
+	assert.Equal(t, resp.Array(nil), doCommand(s, p1, "EXEC"))
+	assert.Equal(t, []byte("10"), doCommand(s, p1, "GET", "k"))
+
+	// 写入不存在的键也视为修改
+	doCommand(s, p1, "WATCH", "other")
+	doCommand(s, p2, "LPUSH", "other", "a")
+	doCommand(s, p1, "MULTI")
+	assert.Equal(t, resp.Array(nil), doCommand(s, p1, "EXEC"))
+
+	// UNWATCH后不再检查
+	doCommand(s, p1, "WATCH", "k")
+	doCommand(s, p2, "SET", "k", "1")
+	assert.Equal(t, "OK", doCommand(s, p1, "UNWATCH"))
+	doCommand(s, p1, "MULTI")
+	assert.Equal(t, resp.Array{}, doCommand(s, p1, "EXEC"))
+
+	// 键在WATCH之后过期
+	doCommand(s, p1, "SET", "k", "1", "PX", "10")
+	doCommand(s, p1, "WATCH", "k")
+	time.Sleep(20 * time.Millisecond)
+	doCommand(s, p1, "MULTI")
+	assert.Equal(t, resp.Array(nil), doCommand(s, p1, "EXEC"))
+
+	// 连接断开时取消监视
+	doCommand(s, p2, "WATCH", "k")
+	s.removePeer(p2)
+	assert.Empty(t, s.db.watchers)
+	assert.Empty(t, s.db.versions)
+}
@@ -1,38 +1,329 @@
 package main
 
 import (
+	"errors"
+	"log/slog"
 	"net"
+	"strings"
+	"sync"
+	"time"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
 )
 
 const (
-	CommentSet = "SET"
+	// peerWriteTimeout 向连接写出数据时连续无进展的最长时间，超时后关闭连接
+	peerWriteTimeout = 60 * time.Second
+	// peerWriteChunk 每次写入连接的最大字节数，每次写入前重新设置超时
+	peerWriteChunk = 64 * 1024
+	// peerOutputChunk 输出缓冲区中新分配的块的最小容量
+	peerOutputChunk = 16 * 1024
 )
 
+// Message 由peer读取到的一条完整命令，err不为空时表示连接出现了协议错误
+type Message struct {
+	peer *Peer
+	args []string
+	err  error
+}
+
 type Peer struct {
-	conn  net.Conn
-	msgCh chan []byte
+	conn net.Conn
+	rd   *resp.Reader
+	// wr 将回复编码到out中，out为nil时（测试与AOF载入用的peer）直接写到wr的底层
+	wr    *resp.Writer
+	out   *peerOutput
+	msgCh chan Message
+	// quit 服务退出时关闭，读写连接的goroutine随之结束
+	quit <-chan struct{}
+
+	id   int64
+	name string
+	// proto 客户端通过HELLO协商的协议版本
+	proto int
+	// closeAfterReply 写完当前回复后关闭连接
+	closeAfterReply bool
+	// bstate 阻塞状态，为nil表示未阻塞
+	bstate *blockState
+	// pending 阻塞期间收到的命令，解除阻塞后依次执行
+	pending []Message
+	// mstate 事务状态，为nil表示不在MULTI中
+	mstate *multiState
+	// watched WATCH的键及监视时的版本号
+	watched map[string]uint64
+	// pubsubChannels、pubsubPatterns 订阅的频道与模式
+	pubsubChannels map[string]struct{}
+	pubsubPatterns map[string]struct{}
+	// pubsubShardChannels 订阅的分片频道，与普通频道分开保存
+	pubsubShardChannels map[string]struct{}
+	// replica 连接是本节点的副本时的复制状态，为nil表示不是副本
+	replica *replicaState
+	// replListeningPort、replAddr 副本通过REPLCONF告知的监听端口与地址
+	replListeningPort int
+	replAddr          string
+	// master 连接是本节点的主节点，执行其发来的命令但不回复
+	master bool
+	// woff 最近一条命令执行后的复制偏移量，WAIT等待副本确认到该偏移量
+	woff int64
+	// asking 执行过ASKING，下一条命令可以访问本节点正在导入的槽
+	asking bool
 }
 
-func NewPeer(conn net.Conn, msg chan []byte) *Peer {
+// NewPeer 创建连接对应的peer，并启动将输出缓冲区写到连接上的goroutine
+func NewPeer(conn net.Conn, msg chan Message, quit <-chan struct{}) *Peer {
+	out := newPeerOutput(conn)
+	go out.writeLoop(quit)
 	return &Peer{conn: conn,
+		rd:    resp.NewReader(conn),
+		wr:    resp.NewWriter(out),
+		out:   out,
 		msgCh: msg,
+		quit:  quit,
+		proto: resp.Proto2,
 	}
 }
 
+// readLoop 从连接中逐条解码命令，跨多个TCP分段的命令会在Reader内部拼接完整
 func (p *Peer) readLoop() error {
-	buf := make([]byte, 1024)
 	for {
-		n, err := p.conn.Read(buf)
+		args, err := p.rd.ReadCommand()
 		if err != nil {
+			var pe *resp.ProtocolError
+			if errors.As(err, &pe) {
+				p.send(Message{peer: p, err: err})
+			}
 			return err
 		}
-		//fmt.Println("readLoop", string(buf[:n]))
-		msgBuf := make([]byte, n)
-		copy(msgBuf, buf[:n])
-		p.msgCh <- msgBuf
+		if len(args) == 0 {
+			continue
+		}
+		if !p.send(Message{peer: p, args: args}) {
+			return nil
+		}
+	}
+}
+
+// send 将命令交给事件循环，服务已退出时返回false
+func (p *Peer) send(msg Message) bool {
+	select {
+	case p.msgCh <- msg:
+		return true
+	case <-p.quit:
+		return false
+	}
+}
+
+// handleMSG 执行一条命令并将回复写回客户端，在Service.loop中调用
+func (p *Peer) handleMSG(s *Service, msg Message) {
+	// 已断开的主节点连接上残留的命令不再执行
+	if p.master && p != s.master {
+		return
+	}
+	if p.bstate != nil {
+		p.pending = append(p.pending, msg)
+		return
+	}
+	if msg.err != nil {
+		p.addReply(errors.New("ERR " + msg.err.Error()))
+		p.closeAfterReply = true
+	} else {
+		reply := s.processCommand(p, msg.args)
+		// ASKING只对紧随其后的一条命令有效
+		if !strings.EqualFold(msg.args[0], CommentAsking) {
+			p.asking = false
+		}
+		if p.master {
+			s.replicationProxyMasterStream(msg.args)
+		} else if _, ok := reply.(noReplyType); !ok {
+			p.addReply(reply)
+		}
+	}
+	p.flush()
+	s.checkOutputBufferLimit(p)
+}
+
+// addReply 将回复编码到写缓冲区，由flush统一写出
+func (p *Peer) addReply(reply any) {
+	if p.wr == nil {
+		return
+	}
+	if err := p.wr.WriteValue(reply); err != nil {
+		slog.Error("write reply error", "id", p.id, "err", err)
+	}
+}
+
+// flush 将缓冲区中的回复交给输出goroutine写出，不会阻塞事件循环
+func (p *Peer) flush() {
+	if p.wr == nil {
+		return
+	}
+	if err := p.wr.Flush(); err != nil {
+		slog.Error("flush reply error", "id", p.id, "err", err)
+	}
+	if p.out == nil {
+		if p.closeAfterReply && p.conn != nil {
+			p.conn.Close()
+		}
+		return
+	}
+	if p.closeAfterReply {
+		p.out.closeAfterWrite()
+		return
+	}
+	p.out.wakeup()
+}
+
+// close 关闭连接，输出缓冲区中尚未写出的数据被丢弃
+func (p *Peer) close() {
+	if p.out != nil {
+		p.out.close()
+	} else if p.conn != nil {
+		p.conn.Close()
+	}
+}
+
+// outputChunk 输出缓冲区中的一块数据，limited为false时不计入输出缓冲区限制
+type outputChunk struct {
+	b       []byte
+	limited bool
+}
+
+// peerOutput 连接的输出缓冲区。事件循环只把数据追加到缓冲区，由writeLoop在独立的goroutine中
+// 写到连接上，读得慢的客户端不会阻塞事件循环
+type peerOutput struct {
+	conn net.Conn
+	wake chan struct{}
+
+	mu     sync.Mutex
+	chunks []outputChunk
+	// size 尚未写出且计入限制的字节数
+	size int
+	// closing 写完缓冲区后关闭连接，closed 连接已关闭，之后追加的数据被丢弃
+	closing bool
+	closed  bool
+}
+
+func newPeerOutput(conn net.Conn) *peerOutput {
+	return &peerOutput{conn: conn, wake: make(chan struct{}, 1)}
+}
+
+// Write 复制b追加到缓冲区，供resp.Writer使用，总是成功
+func (o *peerOutput) Write(b []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return len(b), nil
+	}
+	if n := len(o.chunks); n > 0 && o.chunks[n-1].limited && cap(o.chunks[n-1].b)-len(o.chunks[n-1].b) >= len(b) {
+		o.chunks[n-1].b = append(o.chunks[n-1].b, b...)
+	} else {
+		buf := make([]byte, len(b), max(len(b), peerOutputChunk))
+		copy(buf, b)
+		o.chunks = append(o.chunks, outputChunk{b: buf, limited: true})
+	}
+	o.size += len(b)
+	return len(b), nil
+}
+
+// appendUnlimited 不复制地追加b，且不计入输出缓冲区限制，调用方之后不能再修改b
+func (o *peerOutput) appendUnlimited(b []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.closed && len(b) > 0 {
+		o.chunks = append(o.chunks, outputChunk{b: b})
+	}
+}
+
+// pending 返回尚未写出且计入限制的字节数
+func (o *peerOutput) pending() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.size
+}
+
+func (o *peerOutput) wakeup() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// closeAfterWrite 写完缓冲区中已有的数据后关闭连接
+func (o *peerOutput) closeAfterWrite() {
+	o.mu.Lock()
+	o.closing = true
+	o.mu.Unlock()
+	o.wakeup()
+}
+
+// close 立即关闭连接并丢弃缓冲区，可以重复调用
+func (o *peerOutput) close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return
+	}
+	o.closed = true
+	o.chunks, o.size = nil, 0
+	o.conn.Close()
+	o.wakeup()
+}
+
+// writeLoop 将缓冲区中的数据依次写到连接上，写入失败或超时时关闭连接
+func (o *peerOutput) writeLoop(quit <-chan struct{}) {
+	for {
+		select {
+		case <-o.wake:
+		case <-quit:
+			o.close()
+			return
+		}
+		for {
+			o.mu.Lock()
+			if o.closed || len(o.chunks) == 0 {
+				done := o.closed || o.closing
+				o.mu.Unlock()
+				if done {
+					o.close()
+					return
+				}
+				break
+			}
+			c := o.chunks[0]
+			o.chunks[0] = outputChunk{}
+			o.chunks = o.chunks[1:]
+			o.mu.Unlock()
+
+			if err := o.writeChunk(c.b); err != nil {
+				slog.Warn("write to peer error, closing connection", "remoteAddr", o.conn.RemoteAddr(), "err", err)
+				o.close()
+				return
+			}
+			if c.limited {
+				o.mu.Lock()
+				o.size = max(o.size-len(c.b), 0)
+				o.mu.Unlock()
+			}
+		}
 	}
 }
 
-func (p *Peer) handleMSG(msg []byte) {
+// writeChunk 分段写出b，每段都必须在peerWriteTimeout内写完
+func (o *peerOutput) writeChunk(b []byte) error {
+	for len(b) > 0 {
+		n := min(len(b), peerWriteChunk)
+		o.conn.SetWriteDeadline(time.Now().Add(peerWriteTimeout))
+		if _, err := o.conn.Write(b[:n]); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
 
+func (p *Peer) setProtocol(proto int) {
+	p.proto = proto
+	if p.wr != nil {
+		p.wr.SetProtocol(proto)
+	}
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerOutput(t *testing.T) {
+	// net.Pipe没有缓冲，对端不读时写入会一直阻塞
+	server, client := net.Pipe()
+	defer client.Close()
+	quit := make(chan struct{})
+	defer close(quit)
+	p := NewPeer(server, make(chan Message), quit)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			p.addReply("OK")
+			p.flush()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("flush blocked on a peer that is not reading")
+	}
+	assert.Equal(t, 500, p.out.pending())
+
+	// 写完缓冲区后关闭连接
+	p.closeAfterReply = true
+	p.addReply("BYE")
+	p.flush()
+	data, err := io.ReadAll(client)
+	assert.NoError(t, err)
+	assert.Len(t, data, 506)
+	assert.Equal(t, "+BYE\r\n", string(data[500:]))
+	assert.Equal(t, 0, p.out.pending())
+}
+
+func TestPeerReadLoopQuit(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	quit := make(chan struct{})
+	// 没有事件循环接收命令
+	p := NewPeer(server, make(chan Message), quit)
+	errCh := make(chan error)
+	go func() { errCh <- p.readLoop() }()
+
+	_, err := client.Write([]byte("PING\r\n"))
+	assert.NoError(t, err)
+	close(quit)
+	select {
+	case err := <-errCh:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("readLoop did not return after quit")
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+	gttype "github.com/BeginerAndProgresses/generalized-tools/type"
+)
+
+const (
+	CommentSubscribe    = "SUBSCRIBE"
+	CommentUnsubscribe  = "UNSUBSCRIBE"
+	CommentPSubscribe   = "PSUBSCRIBE"
+	CommentPUnsubscribe = "PUNSUBSCRIBE"
+	CommentPublish      = "PUBLISH"
+	CommentPubsub       = "PUBSUB"
+	CommentSSubscribe   = "SSUBSCRIBE"
+	CommentSUnsubscribe = "SUNSUBSCRIBE"
+	CommentSPublish     = "SPUBLISH"
+)
+
+// defaultClientOutputBufferLimitPubsub 订阅者输出缓冲区的默认上限，与Redis的client-output-buffer-limit pubsub硬限制一致
+const defaultClientOutputBufferLimitPubsub = 32 << 20
+
+var errCrossSlot = errors.New("CROSSSLOT Keys in request don't hash to the same slot")
+
+// pubsubType 描述一类频道订阅：回复中使用的消息名以及订阅关系保存的位置。
+// 普通频道与分片频道的订阅状态相互独立，但订阅、退订的流程相同
+type pubsubType struct {
+	subscribeMsg   string
+	unsubscribeMsg string
+	messageMsg     string
+	// serverChannels 频道到订阅者的映射
+	serverChannels func(s *Service) map[string]map[*Peer]struct{}
+	// peerChannels peer订阅的频道，create为true时不存在则创建
+	peerChannels func(p *Peer, create bool) map[string]struct{}
+	// count 回复中的订阅数
+	count func(p *Peer) int
+}
+
+var pubsubClassic = &pubsubType{
+	subscribeMsg:   "subscribe",
+	unsubscribeMsg: "unsubscribe",
+	messageMsg:     "message",
+	serverChannels: func(s *Service) map[string]map[*Peer]struct{} { return s.pubsubChannels },
+	peerChannels: func(p *Peer, create bool) map[string]struct{} {
+		if p.pubsubChannels == nil && create {
+			p.pubsubChannels = make(map[string]struct{})
+		}
+		return p.pubsubChannels
+	},
+	count: func(p *Peer) int { return len(p.pubsubChannels) + len(p.pubsubPatterns) },
+}
+
+// pubsubShard 分片频道，频道名与键一样按CRC16映射到哈希槽，消息只在槽所在的分片内传播
+var pubsubShard = &pubsubType{
+	subscribeMsg:   "ssubscribe",
+	unsubscribeMsg: "sunsubscribe",
+	messageMsg:     "smessage",
+	serverChannels: func(s *Service) map[string]map[*Peer]struct{} { return s.pubsubShardChannels },
+	peerChannels: func(p *Peer, create bool) map[string]struct{} {
+		if p.pubsubShardChannels == nil && create {
+			p.pubsubShardChannels = make(map[string]struct{})
+		}
+		return p.pubsubShardChannels
+	},
+	count: func(p *Peer) int { return len(p.pubsubShardChannels) },
+}
+
+func init() {
+	registerCommand(
+		&command{name: CommentSubscribe, arity: -2, flags: cmdSentinel, proc: subscribeCommand},
+		&command{name: CommentUnsubscribe, arity: -1, flags: cmdSentinel, proc: unsubscribeCommand},
+		&command{name: CommentPSubscribe, arity: -2, flags: cmdSentinel, proc: psubscribeCommand},
+		&command{name: CommentPUnsubscribe, arity: -1, flags: cmdSentinel, proc: punsubscribeCommand},
+		&command{name: CommentPublish, arity: 3, flags: cmdFast | cmdSentinel, proc: publishCommand},
+		&command{name: CommentPubsub, arity: -2, proc: pubsubCommand},
+		&command{name: CommentSSubscribe, arity: -2, firstKey: 1, lastKey: -1, keyStep: 1, proc: ssubscribeCommand},
+		&command{name: CommentSUnsubscribe, arity: -1, firstKey: 1, lastKey: -1, keyStep: 1, proc: sunsubscribeCommand},
+		&command{name: CommentSPublish, arity: 3, flags: cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: spublishCommand},
+	)
+}
+
+// newPushes 按顺序构造推送消息，RESP2下由Writer降级为数组
+func newPushes(vals ...any) resp.Pushes {
+	ps := gttype.NewHeap[any]()
+	for _, v := range vals {
+		ps.Insert(v)
+	}
+	return ps
+}
+
+// subscriptionCount 返回peer订阅的频道、模式与分片频道总数，大于0时处于订阅模式
+func (p *Peer) subscriptionCount() int {
+	return len(p.pubsubChannels) + len(p.pubsubPatterns) + len(p.pubsubShardChannels)
+}
+
+// allowedInSubscribeMode RESP2订阅模式下允许执行的命令
+func allowedInSubscribeMode(name string) bool {
+	switch name {
+	case CommentSubscribe, CommentUnsubscribe, CommentPSubscribe, CommentPUnsubscribe,
+		CommentSSubscribe, CommentSUnsubscribe, CommentPing, CommentQuit:
+		return true
+	}
+	return false
+}
+
+func subscribeModeErr(name string) error {
+	return fmt.Errorf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", strings.ToLower(name))
+}
+
+// pubsubSubscribe 订阅频道，已订阅时只回复确认消息
+func (s *Service) pubsubSubscribe(p *Peer, typ *pubsubType, channel string) {
+	chans := typ.peerChannels(p, true)
+	if _, ok := chans[channel]; !ok {
+		chans[channel] = struct{}{}
+		subs := typ.serverChannels(s)
+		if subs[channel] == nil {
+			subs[channel] = make(map[*Peer]struct{})
+		}
+		subs[channel][p] = struct{}{}
+	}
+	p.addReply(newPushes(resp.BulkStrings(typ.subscribeMsg), resp.BulkStrings(channel), int64(typ.count(p))))
+}
+
+// pubsubUnsubscribe 退订频道，notify为false时不回复（连接断开时）
+func (s *Service) pubsubUnsubscribe(p *Peer, typ *pubsubType, channel string, notify bool) {
+	chans := typ.peerChannels(p, false)
+	if _, ok := chans[channel]; ok {
+		delete(chans, channel)
+		subs := typ.serverChannels(s)
+		delete(subs[channel], p)
+		if len(subs[channel]) == 0 {
+			delete(subs, channel)
+		}
+	}
+	if notify {
+		p.addReply(newPushes(resp.BulkStrings(typ.unsubscribeMsg), resp.BulkStrings(channel), int64(typ.count(p))))
+	}
+}
+
+// pubsubUnsubscribeAll 退订peer订阅的全部频道，未订阅任何频道时也回复一条确认消息
+func (s *Service) pubsubUnsubscribeAll(p *Peer, typ *pubsubType, notify bool) {
+	chans := typ.peerChannels(p, false)
+	if len(chans) == 0 {
+		if notify {
+			p.addReply(newPushes(resp.BulkStrings(typ.unsubscribeMsg), nil, int64(typ.count(p))))
+		}
+		return
+	}
+	for _, channel := range sortedChannels(chans) {
+		s.pubsubUnsubscribe(p, typ, channel, notify)
+	}
+}
+
+// pubsubSubscribePattern 订阅模式
+func (s *Service) pubsubSubscribePattern(p *Peer, pattern string) {
+	if p.pubsubPatterns == nil {
+		p.pubsubPatterns = make(map[string]struct{})
+	}
+	if _, ok := p.pubsubPatterns[pattern]; !ok {
+		p.pubsubPatterns[pattern] = struct{}{}
+		if s.pubsubPatterns[pattern] == nil {
+			s.pubsubPatterns[pattern] = make(map[*Peer]struct{})
+		}
+		s.pubsubPatterns[pattern][p] = struct{}{}
+	}
+	p.addReply(newPushes(resp.BulkStrings("psubscribe"), resp.BulkStrings(pattern), int64(pubsubClassic.count(p))))
+}
+
+// pubsubUnsubscribePattern 退订模式
+func (s *Service) pubsubUnsubscribePattern(p *Peer, pattern string, notify bool) {
+	if _, ok := p.pubsubPatterns[pattern]; ok {
+		delete(p.pubsubPatterns, pattern)
+		delete(s.pubsubPatterns[pattern], p)
+		if len(s.pubsubPatterns[pattern]) == 0 {
+			delete(s.pubsubPatterns, pattern)
+		}
+	}
+	if notify {
+		p.addReply(newPushes(resp.BulkStrings("punsubscribe"), resp.BulkStrings(pattern), int64(pubsubClassic.count(p))))
+	}
+}
+
+// pubsubUnsubscribeAllPatterns 退订peer订阅的全部模式
+func (s *Service) pubsubUnsubscribeAllPatterns(p *Peer, notify bool) {
+	if len(p.pubsubPatterns) == 0 {
+		if notify {
+			p.addReply(newPushes(resp.BulkStrings("punsubscribe"), nil, int64(pubsubClassic.count(p))))
+		}
+		return
+	}
+	for _, pattern := range sortedChannels(p.pubsubPatterns) {
+		s.pubsubUnsubscribePattern(p, pattern, notify)
+	}
+}
+
+// pubsubRemovePeer 连接断开时清理peer的全部订阅
+func (s *Service) pubsubRemovePeer(p *Peer) {
+	s.pubsubUnsubscribeAll(p, pubsubClassic, false)
+	s.pubsubUnsubscribeAllPatterns(p, false)
+	s.pubsubUnsubscribeAll(p, pubsubShard, false)
+}
+
+// pubsubPublish 向频道及匹配的模式的订阅者推送消息，返回接收到消息的订阅者数
+func (s *Service) pubsubPublish(typ *pubsubType, channel, message string) int64 {
+	var receivers int64
+	for p := range typ.serverChannels(s)[channel] {
+		p.addReply(newPushes(resp.BulkStrings(typ.messageMsg), resp.BulkStrings(channel), resp.BulkStrings(message)))
+		p.flush()
+		s.checkOutputBufferLimit(p)
+		receivers++
+	}
+	if typ != pubsubClassic {
+		return receivers
+	}
+	for pattern, subs := range s.pubsubPatterns {
+		if !stringMatch(pattern, channel, false) {
+			continue
+		}
+		for p := range subs {
+			p.addReply(newPushes(resp.BulkStrings("pmessage"), resp.BulkStrings(pattern),
+				resp.BulkStrings(channel), resp.BulkStrings(message)))
+			p.flush()
+			s.checkOutputBufferLimit(p)
+			receivers++
+		}
+	}
+	return receivers
+}
+
+// sortedChannels 按字典序返回频道名，使退订回复的顺序稳定
+func sortedChannels(chans map[string]struct{}) []string {
+	res := make([]string, 0, len(chans))
+	for channel := range chans {
+		res = append(res, channel)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// subscribeCommand SUBSCRIBE channel [channel ...]
+func subscribeCommand(s *Service, p *Peer, args []string) any {
+	for _, channel := range args[1:] {
+		s.pubsubSubscribe(p, pubsubClassic, channel)
+	}
+	return noReply
+}
+
+// unsubscribeCommand UNSUBSCRIBE [channel [channel ...]] 不带参数时退订全部频道
+func unsubscribeCommand(s *Service, p *Peer, args []string) any {
+	if len(args) == 1 {
+		s.pubsubUnsubscribeAll(p, pubsubClassic, true)
+		return noReply
+	}
+	for _, channel := range args[1:] {
+		s.pubsubUnsubscribe(p, pubsubClassic, channel, true)
+	}
+	return noReply
+}
+
+// psubscribeCommand PSUBSCRIBE pattern [pattern ...]
+func psubscribeCommand(s *Service, p *Peer, args []string) any {
+	for _, pattern := range args[1:] {
+		s.pubsubSubscribePattern(p, pattern)
+	}
+	return noReply
+}
+
+// punsubscribeCommand PUNSUBSCRIBE [pattern [pattern ...]] 不带参数时退订全部模式
+func punsubscribeCommand(s *Service, p *Peer, args []string) any {
+	if len(args) == 1 {
+		s.pubsubUnsubscribeAllPatterns(p, true)
+		return noReply
+	}
+	for _, pattern := range args[1:] {
+		s.pubsubUnsubscribePattern(p, pattern, true)
+	}
+	return noReply
+}
+
+// publishCommand PUBLISH channel message
+func publishCommand(s *Service, p *Peer, args []string) any {
+	return s.pubsubPublish(pubsubClassic, args[1], args[2])
+}
+
+// checkSameSlot 集群模式下检查全部频道是否映射到同一个哈希槽，未开启集群时不限制
+func (s *Service) checkSameSlot(channels []string) error {
+	if s.cluster == nil {
+		return nil
+	}
+	for _, channel := range channels[1:] {
+		if keyHashSlot(channel) != keyHashSlot(channels[0]) {
+			return errCrossSlot
+		}
+	}
+	return nil
+}
+
+// ssubscribeCommand SSUBSCRIBE shardchannel [shardchannel ...] 全部频道须属于同一个哈希槽
+func ssubscribeCommand(s *Service, p *Peer, args []string) any {
+	if err := s.checkSameSlot(args[1:]); err != nil {
+		return err
+	}
+	for _, channel := range args[1:] {
+		s.pubsubSubscribe(p, pubsubShard, channel)
+	}
+	return noReply
+}
+
+// sunsubscribeCommand SUNSUBSCRIBE [shardchannel [shardchannel ...]] 不带参数时退订全部分片频道
+func sunsubscribeCommand(s *Service, p *Peer, args []string) any {
+	if len(args) == 1 {
+		s.pubsubUnsubscribeAll(p, pubsubShard, true)
+		return noReply
+	}
+	if err := s.checkSameSlot(args[1:]); err != nil {
+		return err
+	}
+	for _, channel := range args[1:] {
+		s.pubsubUnsubscribe(p, pubsubShard, channel, true)
+	}
+	return noReply
+}
+
+// spublishCommand SPUBLISH shardchannel message
+func spublishCommand(s *Service, p *Peer, args []string) any {
+	return s.pubsubPublish(pubsubShard, args[1], args[2])
+}
+
+// pubsubCommand PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT |
+// SHARDCHANNELS [pattern] | SHARDNUMSUB [shardchannel ...]
+func pubsubCommand(s *Service, p *Peer, args []string) any {
+	sub := strings.ToUpper(args[1])
+	switch {
+	case sub == "CHANNELS" && len(args) <= 3:
+		return pubsubChannelsReply(s.pubsubChannels, args[2:])
+	case sub == "NUMSUB":
+		return pubsubNumsubReply(s.pubsubChannels, args[2:])
+	case sub == "NUMPAT" && len(args) == 2:
+		return int64(len(s.pubsubPatterns))
+	case sub == "SHARDCHANNELS" && len(args) <= 3:
+		return pubsubChannelsReply(s.pubsubShardChannels, args[2:])
+	case sub == "SHARDNUMSUB":
+		return pubsubNumsubReply(s.pubsubShardChannels, args[2:])
+	default:
+		return fmt.Errorf("ERR unknown subcommand or wrong number of arguments for '%.128s'. Try PUBSUB HELP.", args[1])
+	}
+}
+
+// pubsubChannelsReply 返回有订阅者且匹配可选模式的频道
+func pubsubChannelsReply(subs map[string]map[*Peer]struct{}, pattern []string) resp.Array {
+	res := resp.Array{}
+	for channel := range subs {
+		if len(pattern) == 0 || stringMatch(pattern[0], channel, false) {
+			res = append(res, resp.BulkStrings(channel))
+		}
+	}
+	return res
+}
+
+// pubsubNumsubReply 返回各个频道的订阅者数，频道与数目交替排列
+func pubsubNumsubReply(subs map[string]map[*Peer]struct{}, channels []string) resp.Array {
+	res := make(resp.Array, 0, len(channels)*2)
+	for _, channel := range channels {
+		res = append(res, resp.BulkStrings(channel), int64(len(subs[channel])))
+	}
+	return res
+}
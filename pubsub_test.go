@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPubsub(t *testing.T) {
+	s := NewService(Config{})
+	p1, buf1 := newTestPeer(s)
+	p2, buf2 := newTestPeer(s)
+	p3, buf3 := newTestPeer(s)
+	p2.setProtocol(resp.Proto3)
+
+	runCommand(s, p1, "SUBSCRIBE", "news", "sport")
+	assert.Equal(t, "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n*3\r\n$9\r\nsubscribe\r\n$5\r\nsport\r\n:2\r\n", buf1.String())
+	runCommand(s, p2, "PSUBSCRIBE", "n*")
+	assert.Equal(t, ">3\r\n$10\r\npsubscribe\r\n$2\r\nn*\r\n:1\r\n", buf2.String())
+	buf1.Reset()
+	buf2.Reset()
+
+	// 频道与模式的订阅者都能收到消息
+	assert.Equal(t, int64(2), doCommand(s, p3, "PUBLISH", "news", "hi"))
+	assert.Equal(t, "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$2\r\nhi\r\n", buf1.String())
+	assert.Equal(t, ">4\r\n$8\r\npmessage\r\n$2\r\nn*\r\n$4\r\nnews\r\n$2\r\nhi\r\n", buf2.String())
+	assert.Equal(t, int64(0), doCommand(s, p3, "PUBLISH", "other", "hi"))
+
+	assert.ElementsMatch(t, bulks("news", "sport"), doCommand(s, p3, "PUBSUB", "CHANNELS"))
+	assert.Equal(t, bulks("sport"), doCommand(s, p3, "PUBSUB", "CHANNELS", "s*"))
+	assert.Equal(t, resp.Array{resp.BulkStrings("news"), int64(1), resp.BulkStrings("none"), int64(0)}, doCommand(s, p3, "PUBSUB", "NUMSUB", "news", "none"))
+	assert.Equal(t, int64(1), doCommand(s, p3, "PUBSUB", "NUMPAT"))
+
+	// RESP2订阅模式下只允许订阅相关命令，RESP3不受限制
+	assert.Equal(t, subscribeModeErr("GET"), doCommand(s, p1, "GET", "k"))
+	assert.Equal(t, bulks("pong", ""), doCommand(s, p1, "PING"))
+	assert.Equal(t, "PONG", doCommand(s, p2, "PING"))
+	assert.Equal(t, nil, doCommand(s, p2, "GET", "k"))
+
+	buf1.Reset()
+	runCommand(s, p1, "UNSUBSCRIBE")
+	assert.Equal(t, "*3\r\n$11\r\nunsubscribe\r\n$4\r\nnews\r\n:1\r\n*3\r\n$11\r\nunsubscribe\r\n$5\r\nsport\r\n:0\r\n", buf1.String())
+	assert.Equal(t, "PONG", doCommand(s, p1, "PING"))
+	buf1.Reset()
+	runCommand(s, p1, "UNSUBSCRIBE")
+	assert.Equal(t, "*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:0\r\n", buf1.String())
+	assert.Empty(t, s.pubsubChannels)
+
+	// 断开连接后订阅被清理
+	s.removePeer(p2)
+	assert.Empty(t, s.pubsubPatterns)
+	assert.Equal(t, 0, buf3.Len())
+}
+
+func TestPubsubOutputBufferLimit(t *testing.T) {
+	s := NewService(Config{ClientOutputBufferLimitPubsub: 1024})
+	// 订阅者不读取连接，消息堆积在输出缓冲区中
+	server, client := net.Pipe()
+	defer client.Close()
+	quit := make(chan struct{})
+	defer close(quit)
+	sub := NewPeer(server, s.msgCh, quit)
+	s.peers[sub] = true
+	runCommand(s, sub, "SUBSCRIBE", "news")
+	p, _ := newTestPeer(s)
+
+	msg := strings.Repeat("x", 600)
+	assert.Equal(t, int64(1), doCommand(s, p, "PUBLISH", "news", msg))
+	assert.Greater(t, sub.out.pending(), 600)
+	// 超过限制后连接被关闭，缓冲区被丢弃
+	assert.Equal(t, int64(1), doCommand(s, p, "PUBLISH", "news", msg))
+	assert.Equal(t, 0, sub.out.pending())
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := io.ReadAll(client)
+	assert.NoError(t, err)
+
+	// 普通客户端不受限制
+	server2, client2 := net.Pipe()
+	defer client2.Close()
+	normal := NewPeer(server2, s.msgCh, quit)
+	runCommand(s, normal, "ECHO", strings.Repeat("x", 2048))
+	assert.Greater(t, normal.out.pending(), 2048)
+}
+
+func TestShardedPubsub(t *testing.T) {
+	s := NewService(Config{})
+	p1, buf1 := newTestPeer(s)
+	p2, _ := newTestPeer(s)
+
+	runCommand(s, p1, "SSUBSCRIBE", "{u}a", "{u}b")
+	assert.Equal(t, "*3\r\n$10\r\nssubscribe\r\n$4\r\n{u}a\r\n:1\r\n*3\r\n$10\r\nssubscribe\r\n$4\r\n{u}b\r\n:2\r\n", buf1.String())
+	runCommand(s, p1, "SUBSCRIBE", "{u}a")
+	buf1.Reset()
+
+	// 分片频道与普通频道互不影响
+	assert.Equal(t, int64(1), doCommand(s, p2, "SPUBLISH", "{u}a", "hi"))
+	assert.Equal(t, "*3\r\n$8\r\nsmessage\r\n$4\r\n{u}a\r\n$2\r\nhi\r\n", buf1.String())
+	assert.Equal(t, int64(0), doCommand(s, p2, "SPUBLISH", "{u}c", "hi"))
+	assert.Equal(t, bulks("{u}a"), doCommand(s, p2, "PUBSUB", "CHANNELS"))
+	assert.ElementsMatch(t, bulks("{u}a", "{u}b"), doCommand(s, p2, "PUBSUB", "SHARDCHANNELS"))
+	assert.Equal(t, resp.Array{resp.BulkStrings("{u}b"), int64(1)}, doCommand(s, p2, "PUBSUB", "SHARDNUMSUB", "{u}b"))
+
+	buf1.Reset()
+	runCommand(s, p1, "SUNSUBSCRIBE")
+	assert.Equal(t, "*3\r\n$12\r\nsunsubscribe\r\n$4\r\n{u}a\r\n:1\r\n*3\r\n$12\r\nsunsubscribe\r\n$4\r\n{u}b\r\n:0\r\n", buf1.String())
+	assert.Empty(t, s.pubsubShardChannels)
+	assert.Equal(t, 1, p1.subscriptionCount())
+
+	// 未开启集群模式时分片频道不要求属于同一个槽
+	runCommand(s, p2, "SSUBSCRIBE", "a", "b")
+	assert.Equal(t, 2, p2.subscriptionCount())
+	runCommand(s, p2, "SUNSUBSCRIBE", "a", "b")
+	assert.Equal(t, 0, p2.subscriptionCount())
+}
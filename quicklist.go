@@ -0,0 +1,334 @@
+package main
+
+// quicklistNodeSize 每个节点最多保存的元素个数
+const quicklistNodeSize = 128
+
+// quicklistNode 双向链表的节点，每个节点以切片保存一段连续的元素
+type quicklistNode struct {
+	prev, next *quicklistNode
+	entries    []string
+}
+
+// quicklist 列表类型的底层实现：由定长块组成的双端队列，
+// 两端的插入删除为O(1)，按下标访问只需遍历节点而不必遍历元素
+type quicklist struct {
+	head, tail *quicklistNode
+	count      int
+}
+
+func newQuicklist() *quicklist {
+	return &quicklist{}
+}
+
+// len 返回元素个数
+func (ql *quicklist) len() int {
+	return ql.count
+}
+
+// pushHead 在表头插入元素
+func (ql *quicklist) pushHead(v string) {
+	if ql.head == nil || len(ql.head.entries) >= quicklistNodeSize {
+		n := &quicklistNode{entries: make([]string, 0, 8)}
+		ql.linkBefore(ql.head, n)
+	}
+	h := ql.head
+	h.entries = append(h.entries, "")
+	copy(h.entries[1:], h.entries)
+	h.entries[0] = v
+	ql.count++
+}
+
+// pushTail 在表尾插入元素
+func (ql *quicklist) pushTail(v string) {
+	if ql.tail == nil || len(ql.tail.entries) >= quicklistNodeSize {
+		n := &quicklistNode{entries: make([]string, 0, 8)}
+		ql.linkAfter(ql.tail, n)
+	}
+	ql.tail.entries = append(ql.tail.entries, v)
+	ql.count++
+}
+
+// popHead 弹出表头元素
+func (ql *quicklist) popHead() (string, bool) {
+	if ql.count == 0 {
+		return "", false
+	}
+	v := ql.head.entries[0]
+	ql.deleteAt(ql.head, 0)
+	return v, true
+}
+
+// popTail 弹出表尾元素
+func (ql *quicklist) popTail() (string, bool) {
+	if ql.count == 0 {
+		return "", false
+	}
+	v := ql.tail.entries[len(ql.tail.entries)-1]
+	ql.deleteAt(ql.tail, len(ql.tail.entries)-1)
+	return v, true
+}
+
+// index 返回下标为i的元素，支持负数下标
+func (ql *quicklist) index(i int) (string, bool) {
+	n, off := ql.locate(i)
+	if n == nil {
+		return "", false
+	}
+	return n.entries[off], true
+}
+
+// set 替换下标为i的元素，支持负数下标
+func (ql *quicklist) set(i int, v string) bool {
+	n, off := ql.locate(i)
+	if n == nil {
+		return false
+	}
+	n.entries[off] = v
+	return true
+}
+
+// iterRange 按顺序遍历下标在[start, end]之间的元素，下标需已规范化为非负数，
+// fn返回false时停止遍历
+func (ql *quicklist) iterRange(start, end int, fn func(v string) bool) {
+	n, off := ql.locate(start)
+	for i := start; n != nil && i <= end; n, off = n.next, 0 {
+		for ; off < len(n.entries) && i <= end; off, i = off+1, i+1 {
+			if !fn(n.entries[off]) {
+				return
+			}
+		}
+	}
+}
+
+// iterReverse 从表尾向表头遍历，fn返回false时停止遍历
+func (ql *quicklist) iterReverse(fn func(v string) bool) {
+	for n := ql.tail; n != nil; n = n.prev {
+		for i := len(n.entries) - 1; i >= 0; i-- {
+			if !fn(n.entries[i]) {
+				return
+			}
+		}
+	}
+}
+
+// insert 在第一个等于pivot的元素之前或之后插入v，pivot不存在时返回false
+func (ql *quicklist) insert(pivot, v string, after bool) bool {
+	for n := ql.head; n != nil; n = n.next {
+		for i, e := range n.entries {
+			if e != pivot {
+				continue
+			}
+			if after {
+				i++
+			}
+			ql.insertAt(n, i, v)
+			return true
+		}
+	}
+	return false
+}
+
+// remove 删除等于v的元素，count>0时从表头开始删除最多count个，
+// count<0时从表尾开始删除最多-count个，count为0时删除全部，返回删除的个数
+func (ql *quicklist) remove(v string, count int) int {
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+	removed := 0
+	n := ql.head
+	if count < 0 {
+		n = ql.tail
+	}
+	for n != nil && (limit == 0 || removed < limit) {
+		next := n.next
+		if count < 0 {
+			next = n.prev
+		}
+		mask := make([]bool, len(n.entries))
+		hit := false
+		for j := range n.entries {
+			i := j
+			if count < 0 {
+				i = len(n.entries) - 1 - j
+			}
+			if n.entries[i] == v && (limit == 0 || removed < limit) {
+				mask[i] = true
+				removed++
+				hit = true
+			}
+		}
+		if hit {
+			kept := n.entries[:0]
+			for i, e := range n.entries {
+				if !mask[i] {
+					kept = append(kept, e)
+				}
+			}
+			ql.count -= len(n.entries) - len(kept)
+			clear(n.entries[len(kept):])
+			n.entries = kept
+			if len(kept) == 0 {
+				ql.unlink(n)
+			}
+		}
+		n = next
+	}
+	return removed
+}
+
+// trim 只保留下标在[start, end]之间的元素，下标需已规范化为非负数
+func (ql *quicklist) trim(start, end int) {
+	if start > end || start >= ql.count {
+		*ql = quicklist{}
+		return
+	}
+	ql.deleteRange(end+1, ql.count-end-1)
+	ql.deleteRange(0, start)
+}
+
+// deleteRange 从下标start开始删除count个元素
+func (ql *quicklist) deleteRange(start, count int) {
+	if count <= 0 {
+		return
+	}
+	n, off := ql.locate(start)
+	for n != nil && count > 0 {
+		next := n.next
+		del := min(len(n.entries)-off, count)
+		if del == len(n.entries) {
+			ql.count -= del
+			ql.unlink(n)
+		} else {
+			old := len(n.entries)
+			n.entries = append(n.entries[:off], n.entries[off+del:]...)
+			clear(n.entries[len(n.entries):old])
+			ql.count -= del
+		}
+		count -= del
+		n, off = next, 0
+	}
+}
+
+// locate 根据下标找到所在的节点与节点内偏移，从距离较近的一端开始查找
+func (ql *quicklist) locate(i int) (*quicklistNode, int) {
+	if i < 0 {
+		i += ql.count
+	}
+	if i < 0 || i >= ql.count {
+		return nil, 0
+	}
+	if i < ql.count/2 {
+		for n := ql.head; n != nil; n = n.next {
+			if i < len(n.entries) {
+				return n, i
+			}
+			i -= len(n.entries)
+		}
+		return nil, 0
+	}
+	i = ql.count - 1 - i
+	for n := ql.tail; n != nil; n = n.prev {
+		if i < len(n.entries) {
+			return n, len(n.entries) - 1 - i
+		}
+		i -= len(n.entries)
+	}
+	return nil, 0
+}
+
+// insertAt 在节点n的偏移i处插入元素，节点已满时先拆分
+func (ql *quicklist) insertAt(n *quicklistNode, i int, v string) {
+	if len(n.entries) >= quicklistNodeSize {
+		half := len(n.entries) / 2
+		right := &quicklistNode{entries: append(make([]string, 0, quicklistNodeSize), n.entries[half:]...)}
+		clear(n.entries[half:])
+		n.entries = n.entries[:half]
+		ql.linkAfter(n, right)
+		if i > half {
+			n, i = right, i-half
+		}
+	}
+	n.entries = append(n.entries, "")
+	copy(n.entries[i+1:], n.entries[i:])
+	n.entries[i] = v
+	ql.count++
+}
+
+// deleteAt 删除节点n中偏移为i的元素，节点为空时从链表中移除
+func (ql *quicklist) deleteAt(n *quicklistNode, i int) {
+	copy(n.entries[i:], n.entries[i+1:])
+	n.entries[len(n.entries)-1] = ""
+	n.entries = n.entries[:len(n.entries)-1]
+	ql.count--
+	if len(n.entries) == 0 {
+		ql.unlink(n)
+	}
+}
+
+// linkBefore 将n插入到at之前，at为nil时插入到表头
+func (ql *quicklist) linkBefore(at, n *quicklistNode) {
+	if at == nil {
+		at = ql.head
+	}
+	n.next = at
+	if at != nil {
+		n.prev = at.prev
+		if at.prev != nil {
+			at.prev.next = n
+		}
+		at.prev = n
+	}
+	if ql.head == at {
+		ql.head = n
+	}
+	if ql.tail == nil {
+		ql.tail = n
+	}
+}
+
+// linkAfter 将n插入到at之后，at为nil时插入到表尾
+func (ql *quicklist) linkAfter(at, n *quicklistNode) {
+	if at == nil {
+		at = ql.tail
+	}
+	n.prev = at
+	if at != nil {
+		n.next = at.next
+		if at.next != nil {
+			at.next.prev = n
+		}
+		at.next = n
+	}
+	if ql.tail == at {
+		ql.tail = n
+	}
+	if ql.head == nil {
+		ql.head = n
+	}
+}
+
+// unlink 从链表中移除节点
+func (ql *quicklist) unlink(n *quicklistNode) {
+	if n.prev != nil {
+		n.prev.next = n.next
+	} else {
+		ql.head = n.next
+	}
+	if n.next != nil {
+		n.next.prev = n.prev
+	} else {
+		ql.tail = n.prev
+	}
+	n.prev, n.next = nil, nil
+}
+
+// clone 深拷贝列表，用于生成持久化快照
+func (ql *quicklist) clone() *quicklist {
+	c := newQuicklist()
+	for n := ql.head; n != nil; n = n.next {
+		c.linkAfter(nil, &quicklistNode{entries: append([]string(nil), n.entries...)})
+	}
+	c.count = ql.count
+	return c
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func quicklistValues(ql *quicklist) []string {
+	res := []string{}
+	ql.iterRange(0, ql.len()-1, func(v string) bool {
+		res = append(res, v)
+		return true
+	})
+	return res
+}
+
+func TestQuicklist(t *testing.T) {
+	ql := newQuicklist()
+	var expect []string
+	// 超过单个节点容量，覆盖节点拆分与跨节点访问
+	for i := 0; i < 1000; i++ {
+		v := strconv.Itoa(i)
+		if i%2 == 0 {
+			ql.pushTail(v)
+			expect = append(expect, v)
+		} else {
+			ql.pushHead(v)
+			expect = append([]string{v}, expect...)
+		}
+	}
+	assert.Equal(t, expect, quicklistValues(ql))
+	for _, i := range []int{0, 127, 128, 500, 999, -1, -500} {
+		v, ok := ql.index(i)
+		assert.True(t, ok)
+		if i < 0 {
+			i += len(expect)
+		}
+		assert.Equal(t, expect[i], v)
+	}
+
+	assert.True(t, ql.insert(expect[300], "x", true))
+	expect = append(expect[:301], append([]string{"x"}, expect[301:]...)...)
+	assert.Equal(t, expect, quicklistValues(ql))
+
+	ql.trim(100, 800)
+	expect = expect[100:801]
+	assert.Equal(t, expect, quicklistValues(ql))
+	assert.Equal(t, len(expect), ql.len())
+
+	v, _ := ql.popHead()
+	assert.Equal(t, expect[0], v)
+	v, _ = ql.popTail()
+	assert.Equal(t, expect[len(expect)-1], v)
+	expect = expect[1 : len(expect)-1]
+	assert.Equal(t, expect, quicklistValues(ql))
+}
+
+func TestQuicklistRemove(t *testing.T) {
+	ql := newQuicklist()
+	for i := 0; i < 300; i++ {
+		ql.pushTail(strconv.Itoa(i % 3))
+	}
+	assert.Equal(t, 2, ql.remove("0", 2))
+	v, _ := ql.index(0)
+	assert.Equal(t, "1", v)
+	assert.Equal(t, 3, ql.remove("2", -3))
+	v, _ = ql.index(-1)
+	assert.Equal(t, "1", v)
+	assert.Equal(t, 98, ql.remove("0", 0))
+	assert.Equal(t, 197, ql.len())
+	assert.Equal(t, 197, len(quicklistValues(ql)))
+}
@@ -0,0 +1,1355 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CommentSave     = "SAVE"
+	CommentBgsave   = "BGSAVE"
+	CommentLastSave = "LASTSAVE"
+)
+
+const (
+	defaultDBFilename = "dump.rdb"
+	// rdbVersion 写入的RDB版本，与Redis 7.2一致
+	rdbVersion = 11
+)
+
+// RDB值类型
+const (
+	rdbTypeString           = 0
+	rdbTypeList             = 1
+	rdbTypeSet              = 2
+	rdbTypeZset             = 3
+	rdbTypeHash             = 4
+	rdbTypeZset2            = 5
+	rdbTypeSetIntset        = 11
+	rdbTypeStreamListpacks  = 15
+	rdbTypeHashListpack     = 16
+	rdbTypeZsetListpack     = 17
+	rdbTypeListQuicklist2   = 18
+	rdbTypeStreamListpacks2 = 19
+	rdbTypeSetListpack      = 20
+	rdbTypeStreamListpacks3 = 21
+)
+
+// RDB操作码
+const (
+	rdbOpcodeFunction2    = 245
+	rdbOpcodeModuleAux    = 247
+	rdbOpcodeIdle         = 248
+	rdbOpcodeFreq         = 249
+	rdbOpcodeAux          = 250
+	rdbOpcodeResizeDB     = 251
+	rdbOpcodeExpireTimeMs = 252
+	rdbOpcodeExpireTime   = 253
+	rdbOpcodeSelectDB     = 254
+	rdbOpcodeEOF          = 255
+)
+
+// 长度编码：首字节高2位为00、01时分别为6位、14位长度，0x80、0x81后跟32位、64位大端长度，
+// 高2位为11时低6位表示特殊编码的字符串
+const (
+	rdb6BitLen  = 0
+	rdb14BitLen = 1
+	rdb32BitLen = 0x80
+	rdb64BitLen = 0x81
+	rdbEncVal   = 3
+
+	rdbEncInt8  = 0
+	rdbEncInt16 = 1
+	rdbEncInt32 = 2
+	rdbEncLZF   = 3
+)
+
+const (
+	quicklistNodeContainerPlain  = 1
+	quicklistNodeContainerPacked = 2
+
+	streamItemFlagDeleted    = 1
+	streamItemFlagSameFields = 2
+)
+
+// streamEntriesReadUnknown 消费者组已读条目数未知
+const streamEntriesReadUnknown = math.MaxUint64
+
+var errBadRDB = errors.New("bad RDB format")
+
+// rdbKeyValue RDB中的一个键，expire为过期时刻（unix毫秒），-1表示不过期
+type rdbKeyValue struct {
+	key    string
+	val    any
+	expire int64
+}
+
+// rdbEncoder 按RDB格式编码，同时计算已写出内容的CRC64
+type rdbEncoder struct {
+	w   io.Writer
+	crc uint64
+	err error
+}
+
+func (e *rdbEncoder) write(p []byte) {
+	if e.err != nil {
+		return
+	}
+	e.crc = crc64Jones(e.crc, p)
+	_, e.err = e.w.Write(p)
+}
+
+func (e *rdbEncoder) writeByte(b byte) {
+	e.write([]byte{b})
+}
+
+// saveLen 写入长度编码
+func (e *rdbEncoder) saveLen(n uint64) {
+	switch {
+	case n < 1<<6:
+		e.writeByte(byte(n) | rdb6BitLen<<6)
+	case n < 1<<14:
+		e.write([]byte{byte(n>>8) | rdb14BitLen<<6, byte(n)})
+	case n <= math.MaxUint32:
+		e.write(binary.BigEndian.AppendUint32([]byte{rdb32BitLen}, uint32(n)))
+	default:
+		e.write(binary.BigEndian.AppendUint64([]byte{rdb64BitLen}, n))
+	}
+}
+
+// saveMillis 写入8字节小端的毫秒时间戳
+func (e *rdbEncoder) saveMillis(ms int64) {
+	e.write(binary.LittleEndian.AppendUint64(nil, uint64(ms)))
+}
+
+// saveBinaryDouble 写入8字节小端的IEEE754双精度浮点数
+func (e *rdbEncoder) saveBinaryDouble(f float64) {
+	e.write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(f)))
+}
+
+// saveString 写入字符串，能表示为32位以内整数的短字符串按整数编码
+func (e *rdbEncoder) saveString(s string) {
+	if len(s) <= 11 {
+		if v, ok := lpStringToInt(s); ok {
+			switch {
+			case v >= math.MinInt8 && v <= math.MaxInt8:
+				e.write([]byte{rdbEncVal<<6 | rdbEncInt8, byte(v)})
+				return
+			case v >= math.MinInt16 && v <= math.MaxInt16:
+				e.write(binary.LittleEndian.AppendUint16([]byte{rdbEncVal<<6 | rdbEncInt16}, uint16(v)))
+				return
+			case v >= math.MinInt32 && v <= math.MaxInt32:
+				e.write(binary.LittleEndian.AppendUint32([]byte{rdbEncVal<<6 | rdbEncInt32}, uint32(v)))
+				return
+			}
+		}
+	}
+	e.saveLen(uint64(len(s)))
+	e.write([]byte(s))
+}
+
+// saveStreamID 写入16字节大端的流ID，用作listpack节点的键与待确认条目的ID
+func (e *rdbEncoder) saveStreamID(id streamID) {
+	e.write(streamIDBytes(id))
+}
+
+func streamIDBytes(id streamID) []byte {
+	buf := binary.BigEndian.AppendUint64(make([]byte, 0, 16), id.ms)
+	return binary.BigEndian.AppendUint64(buf, id.seq)
+}
+
+// saveAux 写入辅助字段
+func (e *rdbEncoder) saveAux(key, val string) {
+	e.writeByte(rdbOpcodeAux)
+	e.saveString(key)
+	e.saveString(val)
+}
+
+// rdbObjectType 返回值写入RDB时使用的类型
+func rdbObjectType(val any) (byte, error) {
+	switch v := val.(type) {
+	case []byte:
+		return rdbTypeString, nil
+	case *quicklist:
+		return rdbTypeListQuicklist2, nil
+	case *setObj:
+		if v.dict == nil {
+			return rdbTypeSetIntset, nil
+		}
+		return rdbTypeSet, nil
+	case *zsetObj:
+		return rdbTypeZset2, nil
+	case hashObj:
+		return rdbTypeHash, nil
+	case *stream:
+		return rdbTypeStreamListpacks3, nil
+	default:
+		return 0, fmt.Errorf("unknown value type %T", val)
+	}
+}
+
+// saveObject 写入值，不含类型字节
+func (e *rdbEncoder) saveObject(val any) {
+	switch v := val.(type) {
+	case []byte:
+		e.saveString(string(v))
+	case *quicklist:
+		// 每个节点写为一个listpack
+		nodes := 0
+		for n := v.head; n != nil; n = n.next {
+			nodes++
+		}
+		e.saveLen(uint64(nodes))
+		for n := v.head; n != nil; n = n.next {
+			lb := newListpackBuilder()
+			for _, entry := range n.entries {
+				lb.appendString(entry)
+			}
+			e.saveLen(quicklistNodeContainerPacked)
+			e.saveString(string(lb.bytes()))
+		}
+	case *setObj:
+		if v.dict == nil {
+			e.saveString(string(intsetBytes(v.ints)))
+			return
+		}
+		e.saveLen(uint64(len(v.dict)))
+		for m := range v.dict {
+			e.saveString(m)
+		}
+	case *zsetObj:
+		// 与Redis一致从表尾开始写
+		e.saveLen(uint64(v.size()))
+		for x := v.zsl.tail; x != nil; x = x.backward {
+			e.saveString(x.member)
+			e.saveBinaryDouble(x.score)
+		}
+	case hashObj:
+		e.saveLen(uint64(len(v)))
+		for field, value := range v {
+			e.saveString(field)
+			e.saveString(value)
+		}
+	case *stream:
+		e.saveStream(v)
+	}
+}
+
+// saveStream 按RDB_TYPE_STREAM_LISTPACKS_3格式写入流。每个块写为一个listpack节点，
+// 节点以第一个条目为主条目，字段名与主条目相同的条目只保存值
+func (e *rdbEncoder) saveStream(st *stream) {
+	e.saveLen(uint64(len(st.chunks)))
+	for _, chunk := range st.chunks {
+		master := chunk.entries[0]
+		masterFields := make([]string, 0, len(master.fields)/2)
+		for i := 0; i < len(master.fields); i += 2 {
+			masterFields = append(masterFields, master.fields[i])
+		}
+		lb := newListpackBuilder()
+		lb.appendInt(int64(len(chunk.entries)))
+		lb.appendInt(0)
+		lb.appendInt(int64(len(masterFields)))
+		for _, f := range masterFields {
+			lb.appendString(f)
+		}
+		lb.appendInt(0)
+		for _, entry := range chunk.entries {
+			same := len(entry.fields) == 2*len(masterFields)
+			for i := 0; same && i < len(masterFields); i++ {
+				same = entry.fields[2*i] == masterFields[i]
+			}
+			flags := int64(0)
+			if same {
+				flags = streamItemFlagSameFields
+			}
+			lb.appendInt(flags)
+			lb.appendInt(int64(entry.id.ms - master.id.ms))
+			lb.appendInt(int64(entry.id.seq - master.id.seq))
+			n := len(entry.fields) / 2
+			if same {
+				for i := 1; i < len(entry.fields); i += 2 {
+					lb.appendString(entry.fields[i])
+				}
+				lb.appendInt(int64(n + 3))
+			} else {
+				lb.appendInt(int64(n))
+				for _, f := range entry.fields {
+					lb.appendString(f)
+				}
+				lb.appendInt(int64(2*n + 4))
+			}
+		}
+		e.saveString(string(streamIDBytes(master.id)))
+		e.saveString(string(lb.bytes()))
+	}
+
+	first, _ := st.first()
+	e.saveLen(uint64(st.length))
+	e.saveLen(st.lastID.ms)
+	e.saveLen(st.lastID.seq)
+	e.saveLen(first.id.ms)
+	e.saveLen(first.id.seq)
+	e.saveLen(st.maxDeletedID.ms)
+	e.saveLen(st.maxDeletedID.seq)
+	e.saveLen(st.entriesAdded)
+
+	e.saveLen(uint64(len(st.groups)))
+	for _, name := range sortedGroupNames(st.groups) {
+		cg := st.groups[name]
+		e.saveString(name)
+		e.saveLen(cg.lastID.ms)
+		e.saveLen(cg.lastID.seq)
+		if cg.entriesRead < 0 {
+			e.saveLen(streamEntriesReadUnknown)
+		} else {
+			e.saveLen(uint64(cg.entriesRead))
+		}
+		e.saveLen(uint64(cg.pel.len()))
+		cg.pel.ascend(streamMinID, func(id streamID, nack *streamNACK) bool {
+			e.saveStreamID(id)
+			e.saveMillis(nack.deliveryTime)
+			e.saveLen(uint64(nack.deliveryCount))
+			return true
+		})
+		e.saveLen(uint64(len(cg.consumers)))
+		for _, c := range cg.consumers {
+			e.saveString(c.name)
+			e.saveMillis(c.seenTime)
+			e.saveMillis(c.activeTime)
+			e.saveLen(uint64(c.pel.len()))
+			c.pel.ascend(streamMinID, func(id streamID, _ *streamNACK) bool {
+				e.saveStreamID(id)
+				return true
+			})
+		}
+	}
+}
+
+// sortedGroupNames 按字典序返回消费者组名
+func sortedGroupNames(groups map[string]*streamCG) []string {
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// intsetBytes 按Redis的intset内存布局编码：4字节编码宽度、4字节元素个数、升序的小端整数
+func intsetBytes(is intset) []byte {
+	width := 2
+	for _, v := range is {
+		switch {
+		case v < math.MinInt32 || v > math.MaxInt32:
+			width = 8
+		case (v < math.MinInt16 || v > math.MaxInt16) && width < 4:
+			width = 4
+		}
+	}
+	buf := binary.LittleEndian.AppendUint32(nil, uint32(width))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(is)))
+	for _, v := range is {
+		switch width {
+		case 2:
+			buf = binary.LittleEndian.AppendUint16(buf, uint16(v))
+		case 4:
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(v))
+		default:
+			buf = binary.LittleEndian.AppendUint64(buf, uint64(v))
+		}
+	}
+	return buf
+}
+
+// saveKeyValue 写入一个键，包括过期时间、类型、键名与值
+func (e *rdbEncoder) saveKeyValue(kv rdbKeyValue) {
+	typ, err := rdbObjectType(kv.val)
+	if err != nil {
+		if e.err == nil {
+			e.err = err
+		}
+		return
+	}
+	if kv.expire >= 0 {
+		e.writeByte(rdbOpcodeExpireTimeMs)
+		e.saveMillis(kv.expire)
+	}
+	e.writeByte(typ)
+	e.saveString(kv.key)
+	e.saveObject(kv.val)
+}
+
+// rdbWrite 将一组键写为完整的RDB：头部、辅助字段、数据库0的全部键、EOF与CRC64校验和，
+// aofBase表示写的是多文件AOF的基础文件
+func rdbWrite(w io.Writer, entries []rdbKeyValue, aofBase bool) error {
+	e := &rdbEncoder{w: w}
+	e.write([]byte(fmt.Sprintf("REDIS%04d", rdbVersion)))
+	e.saveAux("redis-ver", redisVersion)
+	e.saveAux("redis-bits", strconv.Itoa(strconv.IntSize))
+	e.saveAux("ctime", strconv.FormatInt(time.Now().Unix(), 10))
+	if aofBase {
+		e.saveAux("aof-base", "1")
+	} else {
+		e.saveAux("aof-base", "0")
+	}
+
+	expires := 0
+	for _, kv := range entries {
+		if kv.expire >= 0 {
+			expires++
+		}
+	}
+	e.writeByte(rdbOpcodeSelectDB)
+	e.saveLen(0)
+	e.writeByte(rdbOpcodeResizeDB)
+	e.saveLen(uint64(len(entries)))
+	e.saveLen(uint64(expires))
+	for _, kv := range entries {
+		e.saveKeyValue(kv)
+	}
+	e.writeByte(rdbOpcodeEOF)
+	if e.err != nil {
+		return e.err
+	}
+	_, err := w.Write(binary.LittleEndian.AppendUint64(nil, e.crc))
+	return err
+}
+
+// rdbDecoder 解码RDB，同时计算已读取内容的CRC64
+type rdbDecoder struct {
+	r   *bufio.Reader
+	crc uint64
+}
+
+func (d *rdbDecoder) read(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		return nil, err
+	}
+	d.crc = crc64Jones(d.crc, buf)
+	return buf, nil
+}
+
+func (d *rdbDecoder) readByte() (byte, error) {
+	buf, err := d.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return buf[0], nil
+}
+
+// loadLenEnc 读取长度编码，encoded为true时n为特殊编码的类型
+func (d *rdbDecoder) loadLenEnc() (n uint64, encoded bool, err error) {
+	b, err := d.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch {
+	case b>>6 == rdb6BitLen:
+		return uint64(b & 0x3F), false, nil
+	case b>>6 == rdb14BitLen:
+		b2, err := d.readByte()
+		return uint64(b&0x3F)<<8 | uint64(b2), false, err
+	case b>>6 == rdbEncVal:
+		return uint64(b & 0x3F), true, nil
+	case b == rdb32BitLen:
+		buf, err := d.read(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(buf)), false, nil
+	case b == rdb64BitLen:
+		buf, err := d.read(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(buf), false, nil
+	default:
+		return 0, false, errBadRDB
+	}
+}
+
+func (d *rdbDecoder) loadLen() (uint64, error) {
+	n, encoded, err := d.loadLenEnc()
+	if err == nil && encoded {
+		err = errBadRDB
+	}
+	return n, err
+}
+
+// loadInt 读取长度编码的整数，用于元素个数等需要转为int的场景
+func (d *rdbDecoder) loadInt() (int, error) {
+	n, err := d.loadLen()
+	if err == nil && n > math.MaxInt32 {
+		err = errBadRDB
+	}
+	return int(n), err
+}
+
+// loadString 读取字符串，支持整数编码与LZF压缩
+func (d *rdbDecoder) loadString() (string, error) {
+	n, encoded, err := d.loadLenEnc()
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		if n > math.MaxInt32 {
+			return "", errBadRDB
+		}
+		buf, err := d.read(int(n))
+		return string(buf), err
+	}
+	switch n {
+	case rdbEncInt8:
+		b, err := d.readByte()
+		return strconv.Itoa(int(int8(b))), err
+	case rdbEncInt16:
+		buf, err := d.read(2)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(buf)))), nil
+	case rdbEncInt32:
+		buf, err := d.read(4)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(buf)))), nil
+	case rdbEncLZF:
+		clen, err := d.loadInt()
+		if err != nil {
+			return "", err
+		}
+		ulen, err := d.loadInt()
+		if err != nil {
+			return "", err
+		}
+		buf, err := d.read(clen)
+		if err != nil {
+			return "", err
+		}
+		out, err := lzfDecompress(buf, ulen)
+		return string(out), err
+	default:
+		return "", errBadRDB
+	}
+}
+
+func (d *rdbDecoder) loadMillis() (int64, error) {
+	buf, err := d.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(buf)), nil
+}
+
+func (d *rdbDecoder) loadBinaryDouble() (float64, error) {
+	buf, err := d.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf)), nil
+}
+
+// loadDoubleValue 读取旧格式(RDB_TYPE_ZSET)中以字符串保存的分值
+func (d *rdbDecoder) loadDoubleValue() (float64, error) {
+	n, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	buf, err := d.read(int(n))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(buf), 64)
+}
+
+func (d *rdbDecoder) loadStreamID() (streamID, error) {
+	buf, err := d.read(16)
+	if err != nil {
+		return streamID{}, err
+	}
+	return streamID{binary.BigEndian.Uint64(buf), binary.BigEndian.Uint64(buf[8:])}, nil
+}
+
+// loadStrings 读取n个字符串
+func (d *rdbDecoder) loadStrings(n int) ([]string, error) {
+	res := make([]string, 0, min(n, 1024))
+	for i := 0; i < n; i++ {
+		s, err := d.loadString()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, s)
+	}
+	return res, nil
+}
+
+// loadListpack 读取以字符串保存的listpack并解码全部元素
+func (d *rdbDecoder) loadListpack() ([]string, error) {
+	lp, err := d.loadString()
+	if err != nil {
+		return nil, err
+	}
+	return listpackEntries([]byte(lp))
+}
+
+// loadObject 按类型读取值
+func (d *rdbDecoder) loadObject(typ byte) (any, error) {
+	switch typ {
+	case rdbTypeString:
+		s, err := d.loadString()
+		return []byte(s), err
+	case rdbTypeList, rdbTypeListQuicklist2:
+		n, err := d.loadInt()
+		if err != nil {
+			return nil, err
+		}
+		ql := newQuicklist()
+		for i := 0; i < n; i++ {
+			if typ == rdbTypeList {
+				s, err := d.loadString()
+				if err != nil {
+					return nil, err
+				}
+				ql.pushTail(s)
+				continue
+			}
+			container, err := d.loadLen()
+			if err != nil {
+				return nil, err
+			}
+			var entries []string
+			if container == quicklistNodeContainerPlain {
+				s, err := d.loadString()
+				if err != nil {
+					return nil, err
+				}
+				entries = []string{s}
+			} else if entries, err = d.loadListpack(); err != nil {
+				return nil, err
+			}
+			for _, s := range entries {
+				ql.pushTail(s)
+			}
+		}
+		return ql, nil
+	case rdbTypeSet, rdbTypeSetListpack, rdbTypeSetIntset:
+		var members []string
+		var err error
+		switch typ {
+		case rdbTypeSet:
+			var n int
+			if n, err = d.loadInt(); err == nil {
+				members, err = d.loadStrings(n)
+			}
+		case rdbTypeSetListpack:
+			members, err = d.loadListpack()
+		default:
+			members, err = d.loadIntset()
+		}
+		if err != nil {
+			return nil, err
+		}
+		so := newSetObj()
+		for _, m := range members {
+			so.add(m)
+		}
+		return so, nil
+	case rdbTypeZset, rdbTypeZset2, rdbTypeZsetListpack:
+		zs := newZsetObj()
+		if typ == rdbTypeZsetListpack {
+			entries, err := d.loadListpack()
+			if err != nil {
+				return nil, err
+			}
+			if len(entries)%2 != 0 {
+				return nil, errBadRDB
+			}
+			for i := 0; i < len(entries); i += 2 {
+				score, err := strconv.ParseFloat(entries[i+1], 64)
+				if err != nil {
+					return nil, errBadRDB
+				}
+				zs.add(score, entries[i], 0)
+			}
+			return zs, nil
+		}
+		n, err := d.loadInt()
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < n; i++ {
+			member, err := d.loadString()
+			if err != nil {
+				return nil, err
+			}
+			var score float64
+			if typ == rdbTypeZset2 {
+				score, err = d.loadBinaryDouble()
+			} else {
+				score, err = d.loadDoubleValue()
+			}
+			if err != nil {
+				return nil, err
+			}
+			zs.add(score, member, 0)
+		}
+		return zs, nil
+	case rdbTypeHash, rdbTypeHashListpack:
+		var pairs []string
+		var err error
+		if typ == rdbTypeHash {
+			var n int
+			if n, err = d.loadInt(); err == nil {
+				pairs, err = d.loadStrings(2 * n)
+			}
+		} else {
+			pairs, err = d.loadListpack()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(pairs)%2 != 0 {
+			return nil, errBadRDB
+		}
+		h := make(hashObj, len(pairs)/2)
+		for i := 0; i < len(pairs); i += 2 {
+			h[pairs[i]] = pairs[i+1]
+		}
+		return h, nil
+	case rdbTypeStreamListpacks, rdbTypeStreamListpacks2, rdbTypeStreamListpacks3:
+		return d.loadStream(typ)
+	default:
+		return nil, fmt.Errorf("unsupported RDB value type %d", typ)
+	}
+}
+
+// loadIntset 读取intset编码的集合
+func (d *rdbDecoder) loadIntset() ([]string, error) {
+	s, err := d.loadString()
+	if err != nil {
+		return nil, err
+	}
+	buf := []byte(s)
+	if len(buf) < 8 {
+		return nil, errBadRDB
+	}
+	width := int(binary.LittleEndian.Uint32(buf))
+	n := int(binary.LittleEndian.Uint32(buf[4:]))
+	if (width != 2 && width != 4 && width != 8) || len(buf) != 8+width*n {
+		return nil, errBadRDB
+	}
+	res := make([]string, 0, n)
+	for p := buf[8:]; len(p) > 0; p = p[width:] {
+		var v int64
+		switch width {
+		case 2:
+			v = int64(int16(binary.LittleEndian.Uint16(p)))
+		case 4:
+			v = int64(int32(binary.LittleEndian.Uint32(p)))
+		default:
+			v = int64(binary.LittleEndian.Uint64(p))
+		}
+		res = append(res, strconv.FormatInt(v, 10))
+	}
+	return res, nil
+}
+
+// loadStream 读取流，兼容RDB_TYPE_STREAM_LISTPACKS的三个版本
+func (d *rdbDecoder) loadStream(typ byte) (*stream, error) {
+	st := newStream()
+	nodes, err := d.loadInt()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < nodes; i++ {
+		key, err := d.loadString()
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != 16 {
+			return nil, errBadRDB
+		}
+		master := streamID{binary.BigEndian.Uint64([]byte(key)), binary.BigEndian.Uint64([]byte(key[8:]))}
+		lp, err := d.loadListpack()
+		if err != nil {
+			return nil, err
+		}
+		if err := st.loadListpackNode(master, lp); err != nil {
+			return nil, err
+		}
+	}
+
+	var meta [8]uint64
+	nmeta := 3
+	if typ >= rdbTypeStreamListpacks2 {
+		nmeta = 8
+	}
+	for i := 0; i < nmeta; i++ {
+		if meta[i], err = d.loadLen(); err != nil {
+			return nil, err
+		}
+	}
+	if int(meta[0]) != st.length {
+		return nil, errBadRDB
+	}
+	st.lastID = streamID{meta[1], meta[2]}
+	// 旧版本没有记录这两项，沿用载入条目时累计的长度与0-0
+	if typ >= rdbTypeStreamListpacks2 {
+		st.maxDeletedID = streamID{meta[5], meta[6]}
+		st.entriesAdded = meta[7]
+	}
+
+	groups, err := d.loadInt()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < groups; i++ {
+		name, err := d.loadString()
+		if err != nil {
+			return nil, err
+		}
+		var lastID streamID
+		if lastID.ms, err = d.loadLen(); err != nil {
+			return nil, err
+		}
+		if lastID.seq, err = d.loadLen(); err != nil {
+			return nil, err
+		}
+		entriesRead := uint64(streamEntriesReadUnknown)
+		if typ >= rdbTypeStreamListpacks2 {
+			if entriesRead, err = d.loadLen(); err != nil {
+				return nil, err
+			}
+		}
+		cg := st.createGroup(name, lastID)
+		if cg == nil {
+			return nil, errBadRDB
+		}
+		if entriesRead <= math.MaxInt64 {
+			cg.entriesRead = int64(entriesRead)
+		}
+		pelSize, err := d.loadInt()
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j < pelSize; j++ {
+			id, err := d.loadStreamID()
+			if err != nil {
+				return nil, err
+			}
+			nack := &streamNACK{}
+			if nack.deliveryTime, err = d.loadMillis(); err != nil {
+				return nil, err
+			}
+			count, err := d.loadLen()
+			if err != nil {
+				return nil, err
+			}
+			nack.deliveryCount = int64(count)
+			cg.pel.insert(id, nack)
+		}
+		consumers, err := d.loadInt()
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j < consumers; j++ {
+			cname, err := d.loadString()
+			if err != nil {
+				return nil, err
+			}
+			c := cg.lookupConsumer(cname, true)
+			if c.seenTime, err = d.loadMillis(); err != nil {
+				return nil, err
+			}
+			c.activeTime = c.seenTime
+			if typ >= rdbTypeStreamListpacks3 {
+				if c.activeTime, err = d.loadMillis(); err != nil {
+					return nil, err
+				}
+			}
+			n, err := d.loadInt()
+			if err != nil {
+				return nil, err
+			}
+			// 消费者的待确认条目必须已在组的待确认列表中
+			for k := 0; k < n; k++ {
+				id, err := d.loadStreamID()
+				if err != nil {
+					return nil, err
+				}
+				nack := cg.pel.get(id)
+				if nack == nil || nack.consumer != nil {
+					return nil, errBadRDB
+				}
+				nack.consumer = c
+				c.pel.insert(id, nack)
+			}
+		}
+		for _, nack := range cg.pel.nacks {
+			if nack.consumer == nil {
+				return nil, errBadRDB
+			}
+		}
+	}
+	return st, nil
+}
+
+// loadListpackNode 解析一个listpack节点中的条目并追加到流中，跳过已标记删除的条目
+func (st *stream) loadListpackNode(master streamID, lp []string) error {
+	next := func() (int64, error) {
+		if len(lp) == 0 {
+			return 0, errBadRDB
+		}
+		v, err := strconv.ParseInt(lp[0], 10, 64)
+		lp = lp[1:]
+		if err != nil {
+			return 0, errBadRDB
+		}
+		return v, nil
+	}
+	take := func(n int64) ([]string, error) {
+		if n < 0 || n > int64(len(lp)) {
+			return nil, errBadRDB
+		}
+		res := lp[:n]
+		lp = lp[n:]
+		return res, nil
+	}
+	// 主条目：有效条目数、删除条目数、字段数、字段名、结束标记0
+	if _, err := next(); err != nil {
+		return err
+	}
+	if _, err := next(); err != nil {
+		return err
+	}
+	nfields, err := next()
+	if err != nil {
+		return err
+	}
+	masterFields, err := take(nfields)
+	if err != nil {
+		return err
+	}
+	if _, err := next(); err != nil {
+		return err
+	}
+	for len(lp) > 0 {
+		var vals [3]int64
+		for i := range vals {
+			if vals[i], err = next(); err != nil {
+				return err
+			}
+		}
+		flags := vals[0]
+		id := streamID{master.ms + uint64(vals[1]), master.seq + uint64(vals[2])}
+		var fields []string
+		if flags&streamItemFlagSameFields != 0 {
+			values, err := take(int64(len(masterFields)))
+			if err != nil {
+				return err
+			}
+			fields = make([]string, 0, 2*len(values))
+			for i, v := range values {
+				fields = append(fields, masterFields[i], v)
+			}
+		} else {
+			n, err := next()
+			if err != nil {
+				return err
+			}
+			pairs, err := take(2 * n)
+			if err != nil {
+				return err
+			}
+			fields = append([]string(nil), pairs...)
+		}
+		if _, err := next(); err != nil {
+			return err
+		}
+		if flags&streamItemFlagDeleted != 0 {
+			continue
+		}
+		if st.length > 0 && id.compare(st.lastID) <= 0 {
+			return errBadRDB
+		}
+		st.append(id, fields)
+	}
+	return nil
+}
+
+// rdbRead 解码完整的RDB，对每个键调用fn，最后校验CRC64。
+// r为*bufio.Reader时直接使用，RDB之后的数据仍可以从r中继续读取
+func rdbRead(r io.Reader, fn func(kv rdbKeyValue)) error {
+	d := &rdbDecoder{r: bufio.NewReader(r)}
+	header, err := d.read(9)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(string(header), "REDIS") {
+		return errors.New("wrong signature trying to load DB from file")
+	}
+	ver, err := strconv.Atoi(string(header[5:]))
+	if err != nil || ver < 1 || ver > rdbVersion {
+		return fmt.Errorf("can't handle RDB format version %s", header[5:])
+	}
+	expire := int64(-1)
+	for {
+		typ, err := d.readByte()
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case rdbOpcodeExpireTimeMs:
+			if expire, err = d.loadMillis(); err != nil {
+				return err
+			}
+			continue
+		case rdbOpcodeExpireTime:
+			buf, err := d.read(4)
+			if err != nil {
+				return err
+			}
+			expire = int64(binary.LittleEndian.Uint32(buf)) * 1000
+			continue
+		case rdbOpcodeFreq:
+			if _, err := d.readByte(); err != nil {
+				return err
+			}
+			continue
+		case rdbOpcodeIdle:
+			if _, err := d.loadLen(); err != nil {
+				return err
+			}
+			continue
+		case rdbOpcodeSelectDB:
+			db, err := d.loadLen()
+			if err != nil {
+				return err
+			}
+			if db != 0 {
+				return fmt.Errorf("FATAL: Data file was created with a Redis server configured to handle more than 1 databases")
+			}
+			continue
+		case rdbOpcodeResizeDB:
+			if _, err := d.loadLen(); err != nil {
+				return err
+			}
+			if _, err := d.loadLen(); err != nil {
+				return err
+			}
+			continue
+		case rdbOpcodeAux:
+			if _, err := d.loadString(); err != nil {
+				return err
+			}
+			if _, err := d.loadString(); err != nil {
+				return err
+			}
+			continue
+		case rdbOpcodeModuleAux, rdbOpcodeFunction2:
+			return fmt.Errorf("unsupported RDB opcode %d", typ)
+		case rdbOpcodeEOF:
+			if ver < 5 {
+				return nil
+			}
+			expected := d.crc
+			buf, err := d.read(8)
+			if err != nil {
+				return err
+			}
+			// 校验和为0表示保存时关闭了校验
+			if sum := binary.LittleEndian.Uint64(buf); sum != 0 && sum != expected {
+				return errors.New("wrong RDB checksum")
+			}
+			return nil
+		}
+		key, err := d.loadString()
+		if err != nil {
+			return err
+		}
+		val, err := d.loadObject(typ)
+		if err != nil {
+			return err
+		}
+		fn(rdbKeyValue{key: key, val: val, expire: expire})
+		expire = -1
+	}
+}
+
+// snapshot 返回键空间中全部的键，值与键空间共享，不做深拷贝。
+// background为true时快照将交给其他goroutine编码，此后键空间对值写时复制，
+// 生成快照的停顿只与键数有关，复制值的开销分摊到之后第一次访问各个键时；用完后需调用releaseSnapshot
+func (ks *keyspace) snapshot(background bool) []rdbKeyValue {
+	if background {
+		ks.snapshots++
+		ks.snapshotEpoch++
+	}
+	res := make([]rdbKeyValue, 0, len(ks.dict))
+	for key, val := range ks.dict {
+		expire, ok := ks.expires[key]
+		if !ok {
+			expire = -1
+		}
+		res = append(res, rdbKeyValue{key: key, val: val, expire: expire})
+	}
+	return res
+}
+
+// releaseSnapshot 后台快照编码结束，没有进行中的快照时不再需要写时复制
+func (ks *keyspace) releaseSnapshot() {
+	ks.snapshots--
+	if ks.snapshots == 0 {
+		ks.owned = nil
+	}
+}
+
+// cloneValue 深拷贝值
+func cloneValue(val any) any {
+	switch v := val.(type) {
+	case []byte:
+		return append([]byte(nil), v...)
+	case *quicklist:
+		return v.clone()
+	case *setObj:
+		return v.clone()
+	case *zsetObj:
+		return v.clone()
+	case hashObj:
+		return maps.Clone(v)
+	case *stream:
+		return v.clone()
+	default:
+		return val
+	}
+}
+
+// loadKeyValue 将RDB中的键写入键空间，已过期的键与空的聚合类型被丢弃，now小于0时保留已过期的键
+func (ks *keyspace) loadKeyValue(kv rdbKeyValue, now int64) {
+	if kv.expire >= 0 && kv.expire <= now {
+		return
+	}
+	switch v := kv.val.(type) {
+	case *quicklist:
+		if v.len() == 0 {
+			return
+		}
+	case *setObj:
+		if v.size() == 0 {
+			return
+		}
+	case *zsetObj:
+		if v.size() == 0 {
+			return
+		}
+	case hashObj:
+		if len(v) == 0 {
+			return
+		}
+	}
+	ks.dict[kv.key] = kv.val
+	ks.slotAdd(kv.key)
+	ks.markOwned(kv.key)
+	if kv.expire >= 0 {
+		ks.expires[kv.key] = kv.expire
+	} else {
+		delete(ks.expires, kv.key)
+	}
+}
+
+// rdbSaveFile 写入RDB文件，替换是原子的
+func rdbSaveFile(path string, entries []rdbKeyValue) error {
+	return writeFileAtomic(path, "temp-*.rdb", func(w io.Writer) error {
+		return rdbWrite(w, entries, false)
+	})
+}
+
+// writeFileAtomic 先写入同目录下的临时文件，fsync后重命名为目标文件，保证替换是原子的
+func writeFileAtomic(path, pattern string, write func(w io.Writer) error) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), pattern)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	bw := bufio.NewWriter(f)
+	if err = write(bw); err != nil {
+		return err
+	}
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// rdbLoadFile 读取RDB文件写入键空间，文件不存在时返回os.ErrNotExist
+func (ks *keyspace) rdbLoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	now := mstime()
+	return rdbRead(f, func(kv rdbKeyValue) {
+		ks.loadKeyValue(kv, now)
+	})
+}
+
+// rdbFilename 返回RDB文件的路径
+func (s *Service) rdbFilename() string {
+	return filepath.Join(s.Dir, s.DBFilename)
+}
+
+// loadDataFromDisk 启动时载入数据，开启AOF时以AOF为准，没有AOF或未开启AOF时载入RDB文件，
+// 文件都不存在时以空数据启动
+func (s *Service) loadDataFromDisk() error {
+	start := time.Now()
+	if s.AppendOnly {
+		err := s.loadAppendOnlyFiles()
+		if err == nil {
+			slog.Info("DB loaded from append only file", "keys", s.db.size(), "elapsed", time.Since(start))
+			return nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("load append only file: %w", err)
+		}
+	}
+	path := s.rdbFilename()
+	err := s.db.rdbLoadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("load %s: %w", path, err)
+	}
+	slog.Info("DB loaded from disk", "file", path, "keys", s.db.size(), "elapsed", time.Since(start))
+	return nil
+}
+
+// rdbSave 在事件循环中同步保存
+func (s *Service) rdbSave() error {
+	if err := rdbSaveFile(s.rdbFilename(), s.db.snapshot(false)); err != nil {
+		slog.Error("failed saving the DB", "err", err)
+		return err
+	}
+	s.db.dirty = 0
+	s.lastSave = time.Now().Unix()
+	s.lastBgsaveErr = nil
+	slog.Info("DB saved on disk")
+	return nil
+}
+
+// rdbSaveBackground 在事件循环中生成快照，由后台goroutine编码并写入文件，
+// 完成后通过rdbBgsaveCh通知事件循环
+func (s *Service) rdbSaveBackground() {
+	entries := s.backgroundSnapshot()
+	s.dirtyBeforeBgsave = s.db.dirtyCount()
+	ch := make(chan error, 1)
+	s.rdbBgsaveCh = ch
+	path := s.rdbFilename()
+	go func() {
+		ch <- rdbSaveFile(path, entries)
+	}()
+	slog.Info("background saving started")
+}
+
+// backgroundSnapshot 生成交给后台goroutine编码的快照，并记录事件循环为此停顿的时间
+func (s *Service) backgroundSnapshot() []rdbKeyValue {
+	start := time.Now()
+	entries := s.db.snapshot(true)
+	s.statSnapshotUsec = time.Since(start).Microseconds()
+	return entries
+}
+
+// backgroundSaveDone 后台保存结束后在事件循环中调用
+func (s *Service) backgroundSaveDone(err error) {
+	s.rdbBgsaveCh = nil
+	s.db.releaseSnapshot()
+	s.lastBgsaveErr = err
+	if err != nil {
+		slog.Error("background saving error", "err", err)
+	} else {
+		s.db.dirty -= s.dirtyBeforeBgsave
+		s.lastSave = time.Now().Unix()
+		slog.Info("background saving terminated with success")
+	}
+	if s.rdbBgsaveScheduled {
+		s.rdbBgsaveScheduled = false
+		s.rdbSaveBackground()
+	}
+}
+
+func init() {
+	registerCommand(
+		&command{name: CommentSave, arity: 1, flags: cmdAdmin, proc: saveCommand},
+		&command{name: CommentBgsave, arity: -1, flags: cmdAdmin, proc: bgsaveCommand},
+		&command{name: CommentLastSave, arity: 1, flags: cmdFast, proc: lastsaveCommand},
+	)
+}
+
+// saveCommand SAVE
+func saveCommand(s *Service, p *Peer, args []string) any {
+	if s.rdbBgsaveCh != nil {
+		return errors.New("ERR Background save already in progress")
+	}
+	if err := s.rdbSave(); err != nil {
+		return fmt.Errorf("ERR %v", err)
+	}
+	return "OK"
+}
+
+// bgsaveCommand BGSAVE [SCHEDULE]
+func bgsaveCommand(s *Service, p *Peer, args []string) any {
+	schedule := false
+	if len(args) > 1 {
+		if len(args) != 2 || strings.ToUpper(args[1]) != "SCHEDULE" {
+			return errSyntax
+		}
+		schedule = true
+	}
+	if s.rdbBgsaveCh != nil {
+		if schedule {
+			s.rdbBgsaveScheduled = true
+			return "Background saving scheduled"
+		}
+		return errors.New("ERR Background save already in progress")
+	}
+	s.rdbSaveBackground()
+	return "Background saving started"
+}
+
+// lastsaveCommand LASTSAVE 返回最近一次成功保存的unix时间戳
+func lastsaveCommand(s *Service, p *Peer, args []string) any {
+	return s.lastSave
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRDBEncoding(t *testing.T) {
+	testCases := []struct {
+		name string
+		fn   func(e *rdbEncoder)
+		res  []byte
+	}{
+		{name: "测试6位长度", fn: func(e *rdbEncoder) { e.saveLen(10) }, res: []byte{0x0A}},
+		{name: "测试14位长度", fn: func(e *rdbEncoder) { e.saveLen(700) }, res: []byte{0x42, 0xBC}},
+		{name: "测试32位长度", fn: func(e *rdbEncoder) { e.saveLen(17000) }, res: []byte{0x80, 0, 0, 0x42, 0x68}},
+		{name: "测试64位长度", fn: func(e *rdbEncoder) { e.saveLen(1 << 32) }, res: []byte{0x81, 0, 0, 0, 1, 0, 0, 0, 0}},
+		{name: "测试int8字符串", fn: func(e *rdbEncoder) { e.saveString("-1") }, res: []byte{0xC0, 0xFF}},
+		{name: "测试int16字符串", fn: func(e *rdbEncoder) { e.saveString("1000") }, res: []byte{0xC1, 0xE8, 0x03}},
+		{name: "测试int32字符串", fn: func(e *rdbEncoder) { e.saveString("100000") }, res: []byte{0xC2, 0xA0, 0x86, 0x01, 0x00}},
+		{name: "测试非规范整数", fn: func(e *rdbEncoder) { e.saveString("01") }, res: []byte{0x02, '0', '1'}},
+		{name: "测试intset", fn: func(e *rdbEncoder) { e.saveObject(&setObj{ints: intset{1, 70000}}) },
+			res: []byte{0x10, 4, 0, 0, 0, 2, 0, 0, 0, 1, 0, 0, 0, 0x70, 0x11, 0x01, 0x00}},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			v.fn(&rdbEncoder{w: &buf})
+			assert.Equal(t, v.res, buf.Bytes())
+		})
+	}
+}
+
+func TestRDBRoundTrip(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	doCommand(s, p, "SET", "str", "hello")
+	doCommand(s, p, "SET", "int", "12345")
+	doCommand(s, p, "SET", "ttl", "v", "PX", "100000")
+	doCommand(s, p, "RPUSH", "list", "a", "1", "-5000")
+	for i := 0; i < 300; i++ {
+		doCommand(s, p, "RPUSH", "biglist", strconv.Itoa(i))
+	}
+	doCommand(s, p, "SADD", "ints", "3", "-1", "100000")
+	doCommand(s, p, "SADD", "set", "a", "b")
+	doCommand(s, p, "ZADD", "zset", "1.5", "a", "-inf", "b", "2", "c")
+	doCommand(s, p, "HSET", "hash", "f1", "v1", "f2", "2")
+	doCommand(s, p, "XADD", "st", "1-1", "a", "1", "b", "2")
+	doCommand(s, p, "XADD", "st", "1-2", "a", "3", "b", "4")
+	doCommand(s, p, "XADD", "st", "2-0", "c", "5")
+	doCommand(s, p, "XADD", "st", "3-0", "a", "6", "b", "7")
+	doCommand(s, p, "XDEL", "st", "3-0")
+	doCommand(s, p, "XGROUP", "CREATE", "st", "g", "0", "ENTRIESREAD", "0")
+	doCommand(s, p, "XREADGROUP", "GROUP", "g", "alice", "COUNT", "2", "STREAMS", "st", ">")
+	doCommand(s, p, "XGROUP", "CREATECONSUMER", "st", "g", "bob")
+
+	var buf bytes.Buffer
+	assert.NoError(t, rdbWrite(&buf, s.db.snapshot(false), false))
+	data := buf.Bytes()
+	assert.Equal(t, "REDIS0011", string(data[:9]))
+	assert.Equal(t, crc64Jones(0, data[:len(data)-8]), binary.LittleEndian.Uint64(data[len(data)-8:]))
+
+	s2 := NewService(Config{})
+	p2, _ := newTestPeer(s2)
+	now := mstime()
+	assert.NoError(t, rdbRead(bytes.NewReader(data), func(kv rdbKeyValue) { s2.db.loadKeyValue(kv, now) }))
+	testCases := []struct {
+		name string
+		args []string
+	}{
+		{name: "测试字符串", args: []string{"GET", "str"}},
+		{name: "测试整数字符串", args: []string{"GET", "int"}},
+		{name: "测试过期时间", args: []string{"PEXPIRETIME", "ttl"}},
+		{name: "测试列表", args: []string{"LRANGE", "list", "0", "-1"}},
+		{name: "测试多节点列表", args: []string{"LRANGE", "biglist", "0", "-1"}},
+		{name: "测试intset", args: []string{"SMEMBERS", "ints"}},
+		{name: "测试intset编码", args: []string{"OBJECT", "ENCODING", "ints"}},
+		{name: "测试集合", args: []string{"SCARD", "set"}},
+		{name: "测试有序集合", args: []string{"ZRANGE", "zset", "0", "-1", "WITHSCORES"}},
+		{name: "测试哈希", args: []string{"HGET", "hash", "f2"}},
+		{name: "测试流", args: []string{"XRANGE", "st", "-", "+"}},
+		{name: "测试流最大ID", args: []string{"XADD", "st", "3-0", "x", "1"}},
+		{name: "测试待确认条目", args: []string{"XPENDING", "st", "g"}},
+		{name: "测试消费者", args: []string{"XGROUP", "CREATECONSUMER", "st", "g", "bob"}},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert.Equal(t, doCommand(s, p, v.args...), doCommand(s2, p2, v.args...))
+		})
+	}
+	st, _, err := s2.db.lookupGroup("st", "g")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), st.groups["g"].entriesRead)
+	assert.Equal(t, uint64(4), st.entriesAdded)
+	assert.Equal(t, streamID{3, 0}, st.maxDeletedID)
+
+	// 校验和不一致时拒绝载入
+	data[20] ^= 0xFF
+	assert.Error(t, rdbRead(bytes.NewReader(data), func(rdbKeyValue) {}))
+}
+
+func TestSnapshotCopyOnWrite(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	doCommand(s, p, "RPUSH", "list", "a", "b")
+	doCommand(s, p, "SET", "str", "v")
+
+	// 后台快照与键空间共享值，修改前先复制
+	entries := s.db.snapshot(true)
+	shared, _ := s.db.dict["list"].(*quicklist)
+	doCommand(s, p, "RPUSH", "list", "c")
+	assert.Equal(t, 2, shared.len())
+	ql, _ := s.db.dict["list"].(*quicklist)
+	assert.NotSame(t, shared, ql)
+	assert.Equal(t, 3, ql.len())
+	// 同一个快照期间只复制一次
+	doCommand(s, p, "RPUSH", "list", "d")
+	assert.Same(t, ql, s.db.dict["list"])
+	// 快照之后写入的新值不需要复制
+	doCommand(s, p, "SET", "str2", "v")
+	assert.Equal(t, map[string]uint64{"list": 1, "str2": 1}, s.db.owned)
+
+	// 第二个快照开始后，已复制过的值也与新快照共享
+	entries2 := s.db.snapshot(true)
+	doCommand(s, p, "RPUSH", "list", "e")
+	assert.Equal(t, 4, ql.len())
+	assert.NotSame(t, ql, s.db.dict["list"])
+
+	var buf bytes.Buffer
+	assert.NoError(t, rdbWrite(&buf, entries, false))
+	assert.NoError(t, rdbWrite(&buf, entries2, false))
+	s.db.releaseSnapshot()
+	s.db.releaseSnapshot()
+	assert.Nil(t, s.db.owned)
+	// 没有进行中的快照时原地修改
+	ql, _ = s.db.dict["list"].(*quicklist)
+	doCommand(s, p, "RPUSH", "list", "f")
+	assert.Same(t, ql, s.db.dict["list"])
+}
+
+func TestLZFDecompress(t *testing.T) {
+	out, err := lzfDecompress([]byte{0x00, 'a', 0xE0, 0x00, 0x00}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "aaaaaaaaaa", string(out))
+	_, err = lzfDecompress([]byte{0x20, 0x05}, 3)
+	assert.Equal(t, errBadLZF, err)
+}
+
+func TestSaveCommands(t *testing.T) {
+	dir := t.TempDir()
+	s := NewService(Config{Dir: dir})
+	p, _ := newTestPeer(s)
+	doCommand(s, p, "SET", "k", "v")
+	doCommand(s, p, "LASTSAVE")
+
+	assert.Equal(t, "OK", doCommand(s, p, "SAVE"))
+	_, err := os.Stat(filepath.Join(dir, defaultDBFilename))
+	assert.NoError(t, err)
+
+	// 后台保存的是开始时的快照，之后的修改不会写入
+	doCommand(s, p, "SET", "k2", "v2")
+	assert.Equal(t, "Background saving started", doCommand(s, p, "BGSAVE"))
+	doCommand(s, p, "SET", "k3", "v3")
+	assert.Equal(t, errors.New("ERR Background save already in progress"), doCommand(s, p, "SAVE"))
+	assert.Equal(t, "Background saving scheduled", doCommand(s, p, "BGSAVE", "SCHEDULE"))
+	s.backgroundSaveDone(<-s.rdbBgsaveCh)
+	assert.NotNil(t, s.rdbBgsaveCh)
+	s.backgroundSaveDone(<-s.rdbBgsaveCh)
+	assert.Nil(t, s.rdbBgsaveCh)
+	assert.NoError(t, s.lastBgsaveErr)
+
+	s2 := NewService(Config{Dir: dir})
+	p2, _ := newTestPeer(s2)
+	assert.NoError(t, s2.loadDataFromDisk())
+	assert.Equal(t, int64(3), doCommand(s2, p2, "EXISTS", "k", "k2", "k3"))
+	// 临时文件已被重命名
+	tmp, _ := filepath.Glob(filepath.Join(dir, "temp-*"))
+	assert.Empty(t, tmp)
+
+	// 文件不存在时以空数据启动
+	s3 := NewService(Config{Dir: t.TempDir()})
+	assert.NoError(t, s3.loadDataFromDisk())
+}
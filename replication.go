@@ -0,0 +1,868 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+)
+
+const (
+	CommentReplicaOf = "REPLICAOF"
+	CommentSlaveOf   = "SLAVEOF"
+	CommentSync      = "SYNC"
+	CommentPSync     = "PSYNC"
+	CommentReplConf  = "REPLCONF"
+	CommentRole      = "ROLE"
+	CommentWait      = "WAIT"
+	CommentWaitAOF   = "WAITAOF"
+)
+
+const (
+	// defaultReplBacklogSize 复制积压缓冲区的默认大小
+	defaultReplBacklogSize = 1 << 20
+	// defaultClientOutputBufferLimitReplica 副本输出缓冲区的默认上限，与Redis的client-output-buffer-limit replica硬限制一致
+	defaultClientOutputBufferLimitReplica = 256 << 20
+	// replTimeout 副本连接主节点并完成同步的超时时间
+	replTimeout = 60 * time.Second
+	// replPingPeriod 主节点向副本发送PING的间隔（秒），使副本的偏移量持续前进
+	replPingPeriod = 10
+	// emptyReplID 未设置的复制ID
+	emptyReplID = "0000000000000000000000000000000000000000"
+)
+
+// 副本与主节点的连接状态
+const (
+	// replStateNone 本节点是主节点
+	replStateNone = iota
+	// replStateConnect 需要连接主节点，由replicationCron发起
+	replStateConnect
+	// replStateConnecting 正在连接主节点、握手并接收快照
+	replStateConnecting
+	// replStateConnected 同步完成，正在接收复制流
+	replStateConnected
+)
+
+// 主节点上副本的同步状态
+const (
+	// replicaWaitSnapshot 等待全量同步的快照编码完成，期间产生的复制流暂存在pending中
+	replicaWaitSnapshot = iota
+	// replicaOnline 快照已发送，复制流直接写给副本
+	replicaOnline
+)
+
+// replicaState 连接是本节点的副本时的复制状态
+type replicaState struct {
+	state int
+	// pending 等待快照期间产生的复制流，快照发送后紧接着写出
+	pending bytes.Buffer
+	// ackOffset 副本通过REPLCONF ACK确认已处理的偏移量，aofAckOffset 副本已fsync到AOF的偏移量，
+	// ackTime 最近一次确认的时刻
+	ackOffset    int64
+	aofAckOffset int64
+	ackTime      time.Time
+}
+
+// replSnapshotResult 全量同步的快照编码结果
+type replSnapshotResult struct {
+	peer *Peer
+	data []byte
+	err  error
+}
+
+// replBacklog 环形的复制积压缓冲区，保存最近产生的复制流，
+// 副本断线重连后可从中取回缺失的部分，避免全量同步
+type replBacklog struct {
+	buf []byte
+	// idx 下一次写入的位置，histlen 缓冲区中有效数据的长度
+	idx     int
+	histlen int
+}
+
+func newReplBacklog(size int) *replBacklog {
+	return &replBacklog{buf: make([]byte, size)}
+}
+
+// feed 追加复制流，超出容量时覆盖最旧的数据
+func (b *replBacklog) feed(p []byte) {
+	for len(p) > 0 {
+		n := copy(b.buf[b.idx:], p)
+		b.idx = (b.idx + n) % len(b.buf)
+		b.histlen = min(b.histlen+n, len(b.buf))
+		p = p[n:]
+	}
+}
+
+// tail 返回缓冲区中最后n字节的复制流
+func (b *replBacklog) tail(n int) []byte {
+	start := (b.idx - n + len(b.buf)) % len(b.buf)
+	out := make([]byte, 0, n)
+	if start+n <= len(b.buf) {
+		return append(out, b.buf[start:start+n]...)
+	}
+	out = append(out, b.buf[start:]...)
+	return append(out, b.buf[:n-(len(b.buf)-start)]...)
+}
+
+// replHandshake 副本与主节点握手、同步的请求与结果，握手在单独的goroutine中进行，
+// 完成后交给事件循环
+type replHandshake struct {
+	gen  int64
+	addr string
+	// replID、offset 请求部分重同步使用的复制ID与偏移量
+	replID        string
+	offset        int64
+	listeningPort int
+
+	conn net.Conn
+	rd   *resp.Reader
+	// full 为true表示进行了全量同步，entries为主节点的快照
+	full    bool
+	entries []rdbKeyValue
+	// masterReplID、masterOffset 主节点的复制ID及快照对应的偏移量
+	masterReplID string
+	masterOffset int64
+	err          error
+}
+
+func init() {
+	registerCommand(
+		&command{name: CommentReplicaOf, arity: 3, flags: cmdAdmin, proc: replicaofCommand},
+		&command{name: CommentSlaveOf, arity: 3, flags: cmdAdmin, proc: replicaofCommand},
+		&command{name: CommentSync, arity: 1, flags: cmdAdmin, proc: syncCommand},
+		&command{name: CommentPSync, arity: -3, flags: cmdAdmin, proc: syncCommand},
+		&command{name: CommentReplConf, arity: -1, flags: cmdAdmin, proc: replconfCommand},
+		&command{name: CommentRole, arity: 1, flags: cmdFast | cmdSentinel, proc: roleCommand},
+		&command{name: CommentWait, arity: 3, flags: cmdBlocking, proc: waitCommand},
+		&command{name: CommentWaitAOF, arity: 4, flags: cmdBlocking, proc: waitaofCommand},
+	)
+}
+
+// changeReplID 生成新的复制ID，此后的复制流与之前的历史不再相同
+func (s *Service) changeReplID() {
+	s.replID = genRunID()
+}
+
+// clearReplID2 清除之前的复制ID
+func (s *Service) clearReplID2() {
+	s.replID2 = emptyReplID
+	s.secondReplOffset = -1
+}
+
+// shiftReplID 切换到新的复制ID，旧的ID在当前偏移量之前仍然有效，
+// 副本提升为主节点后其他副本可以据此部分重同步
+func (s *Service) shiftReplID() {
+	s.replID2 = s.replID
+	s.secondReplOffset = s.masterReplOffset + 1
+	s.changeReplID()
+	slog.Info("replication ID shifted", "new", s.replID, "old", s.replID2, "offset", s.secondReplOffset)
+}
+
+// createReplBacklog 第一个副本开始同步时创建积压缓冲区
+func (s *Service) createReplBacklog() {
+	if s.replBacklog != nil {
+		return
+	}
+	s.replBacklog = newReplBacklog(s.ReplBacklogSize)
+	// 没有积压缓冲区期间的复制流无从取回，更换复制ID使旧的副本不会据此部分重同步
+	if s.masterHost == "" {
+		s.changeReplID()
+		s.clearReplID2()
+	}
+}
+
+// replBacklogOffset 返回积压缓冲区中第一个字节的偏移量
+func (s *Service) replBacklogOffset() int64 {
+	return s.masterReplOffset - int64(s.replBacklog.histlen) + 1
+}
+
+// replicationFeedReplicas 将本节点执行的写命令编码后发给副本
+func (s *Service) replicationFeedReplicas(args []string) {
+	if s.replBacklog == nil {
+		return
+	}
+	s.replBuf.Reset()
+	if err := catAppendOnlyCommand(s.replWr, args); err != nil {
+		slog.Error("encode replication stream error", "err", err)
+		return
+	}
+	s.replWr.Flush()
+	s.replicationFeedStream(s.replBuf.Bytes())
+}
+
+// replicationFeedStream 将已编码的复制流写入积压缓冲区与各副本，并推进偏移量
+func (s *Service) replicationFeedStream(b []byte) {
+	s.masterReplOffset += int64(len(b))
+	s.replBacklog.feed(b)
+	for _, r := range s.replicas {
+		if r.replica.state == replicaWaitSnapshot {
+			r.replica.pending.Write(b)
+		} else {
+			r.wr.Write(b)
+			r.flush()
+		}
+		s.checkOutputBufferLimit(r)
+	}
+}
+
+// replicationProxyMasterStream 副本执行完主节点发来的命令后调用，按原样转发给下级副本，
+// 使整条复制链上相同偏移量处的数据一致
+func (s *Service) replicationProxyMasterStream(args []string) {
+	s.createReplBacklog()
+	s.replicationFeedReplicas(args)
+}
+
+// addReplica 将peer登记为副本
+func (s *Service) addReplica(p *Peer, state int) {
+	p.replica = &replicaState{state: state}
+	s.replicas = append(s.replicas, p)
+}
+
+// disconnectReplicas 断开所有副本，使其重新同步以获得新的复制历史
+func (s *Service) disconnectReplicas() {
+	for _, r := range slices.Clone(s.replicas) {
+		s.removePeer(r)
+	}
+}
+
+// replicaAddr 返回副本的地址，端口为副本通过REPLCONF告知的监听端口
+func (p *Peer) replicaAddr() (string, int) {
+	ip := p.replAddr
+	if ip == "" && p.conn != nil {
+		ip, _, _ = net.SplitHostPort(p.conn.RemoteAddr().String())
+	}
+	return ip, p.replListeningPort
+}
+
+// primaryTryPartialResync 副本请求的复制ID与偏移量仍在积压缓冲区中时进行部分重同步，
+// 回复+CONTINUE后发送缺失的复制流
+func (s *Service) primaryTryPartialResync(p *Peer, replID string, offset int64) bool {
+	if replID != s.replID && (replID != s.replID2 || offset > s.secondReplOffset) {
+		if replID != "?" {
+			s.statSyncPartialErr++
+		}
+		return false
+	}
+	if s.replBacklog == nil || offset < s.replBacklogOffset() || offset > s.masterReplOffset+1 {
+		s.statSyncPartialErr++
+		return false
+	}
+	s.statSyncPartialOK++
+	s.addReplica(p, replicaOnline)
+	p.addReply("CONTINUE " + s.replID)
+	p.wr.Write(s.replBacklog.tail(int(s.masterReplOffset + 1 - offset)))
+	slog.Info("partial resynchronization accepted", "id", p.id, "offset", offset)
+	return true
+}
+
+// primaryFullResync 生成快照并在后台编码，编码完成后由replicaSnapshotDone发送给副本
+func (s *Service) primaryFullResync(p *Peer, psync bool) {
+	s.statSyncFull++
+	s.createReplBacklog()
+	s.addReplica(p, replicaWaitSnapshot)
+	if psync {
+		p.addReply(fmt.Sprintf("FULLRESYNC %s %d", s.replID, s.masterReplOffset))
+	}
+	entries := s.backgroundSnapshot()
+	go func() {
+		var buf bytes.Buffer
+		err := rdbWrite(&buf, entries, false)
+		select {
+		case s.replSnapshotCh <- replSnapshotResult{peer: p, data: buf.Bytes(), err: err}:
+		case <-s.quitPeerCh:
+		}
+	}()
+	slog.Info("starting full resynchronization", "id", p.id, "offset", s.masterReplOffset)
+}
+
+// replicaSnapshotDone 快照编码完成后在事件循环中调用，依次发送快照与等待期间的复制流
+func (s *Service) replicaSnapshotDone(res replSnapshotResult) {
+	s.db.releaseSnapshot()
+	p := res.peer
+	if p.replica == nil || !s.peers[p] {
+		return
+	}
+	if res.err != nil {
+		slog.Error("encode snapshot for replica error", "id", p.id, "err", res.err)
+		s.removePeer(p)
+		return
+	}
+	fmt.Fprintf(p.wr, "$%d\r\n", len(res.data))
+	if p.out != nil {
+		// 快照不复制到输出缓冲区，也不计入副本的输出缓冲区限制
+		p.wr.Flush()
+		p.out.appendUnlimited(res.data)
+	} else {
+		p.wr.Write(res.data)
+	}
+	p.wr.Write(p.replica.pending.Bytes())
+	p.replica.pending = bytes.Buffer{}
+	p.replica.state = replicaOnline
+	p.flush()
+	slog.Info("synchronization with replica succeeded", "id", p.id, "bytes", len(res.data))
+}
+
+// replicationSetMaster 成为host:port的副本，之前的主节点连接与握手都被放弃
+func (s *Service) replicationSetMaster(host string, port int) {
+	s.masterHost, s.masterPort = host, port
+	if s.master != nil {
+		s.removePeer(s.master)
+	}
+	s.replDownSince = 0
+	// 以自身的复制ID与偏移量请求部分重同步，新主节点曾是本节点的副本时无需全量同步
+	s.connectWithMaster()
+}
+
+// replicationUnsetMaster 不再作为副本，提升为主节点并保留数据
+func (s *Service) replicationUnsetMaster() {
+	if s.masterHost == "" {
+		return
+	}
+	s.masterHost, s.masterPort = "", 0
+	if s.master != nil {
+		s.removePeer(s.master)
+	}
+	s.replGen++
+	s.replState = replStateNone
+	s.shiftReplID()
+	// 下级副本重新同步以获知新的复制ID，旧ID仍可用于部分重同步
+	s.disconnectReplicas()
+}
+
+// replicationHandleMasterDisconnection 与主节点的连接断开后等待重连
+func (s *Service) replicationHandleMasterDisconnection() {
+	s.master = nil
+	if s.masterHost != "" {
+		s.replState = replStateConnect
+		s.replDownSince = time.Now().Unix()
+	}
+}
+
+// connectWithMaster 在后台goroutine中连接主节点并完成同步
+func (s *Service) connectWithMaster() {
+	s.replState = replStateConnecting
+	s.replGen++
+	h := &replHandshake{
+		gen:    s.replGen,
+		addr:   net.JoinHostPort(s.masterHost, strconv.Itoa(s.masterPort)),
+		replID: s.replID,
+		offset: s.masterReplOffset + 1,
+	}
+	if s.ln != nil {
+		if addr, ok := s.ln.Addr().(*net.TCPAddr); ok {
+			h.listeningPort = addr.Port
+		}
+	}
+	slog.Info("connecting to master", "addr", h.addr)
+	go func() {
+		h.err = h.run()
+		if h.err != nil && h.conn != nil {
+			h.conn.Close()
+		}
+		select {
+		case s.replHandshakeCh <- h:
+		case <-s.quitPeerCh:
+			if h.conn != nil {
+				h.conn.Close()
+			}
+		}
+	}()
+}
+
+// run 握手：PING、REPLCONF告知监听端口与能力、PSYNC请求同步，全量同步时接收并解码快照
+func (h *replHandshake) run() error {
+	conn, err := net.DialTimeout("tcp", h.addr, replTimeout)
+	if err != nil {
+		return err
+	}
+	h.conn = conn
+	conn.SetDeadline(time.Now().Add(replTimeout))
+	// 快照与之后的复制流共用同一个缓冲读取器，避免丢失已读入缓冲区的数据
+	br := bufio.NewReader(conn)
+	h.rd = resp.NewReader(br)
+	wr := resp.NewWriter(conn)
+	send := func(args ...string) (any, error) {
+		if err := catAppendOnlyCommand(wr, args); err != nil {
+			return nil, err
+		}
+		if err := wr.Flush(); err != nil {
+			return nil, err
+		}
+		reply, err := h.rd.ReadValue()
+		if err != nil {
+			return nil, err
+		}
+		if e, ok := reply.(error); ok {
+			return nil, fmt.Errorf("error reply to %s from master: %v", args[0], e)
+		}
+		return reply, nil
+	}
+
+	if _, err := send(CommentPing); err != nil {
+		return err
+	}
+	if h.listeningPort > 0 {
+		if _, err := send(CommentReplConf, "listening-port", strconv.Itoa(h.listeningPort)); err != nil {
+			return err
+		}
+	}
+	if _, err := send(CommentReplConf, "capa", "psync2"); err != nil {
+		return err
+	}
+	reply, err := send(CommentPSync, h.replID, strconv.FormatInt(h.offset, 10))
+	if err != nil {
+		return err
+	}
+	line, _ := reply.(string)
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) >= 1 && fields[0] == "CONTINUE":
+		h.masterReplID = h.replID
+		if len(fields) > 1 {
+			h.masterReplID = fields[1]
+		}
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		h.full = true
+		h.masterReplID = fields[1]
+		if h.masterOffset, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+			return fmt.Errorf("invalid FULLRESYNC offset %q", fields[2])
+		}
+		if err := h.readSnapshot(br); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unexpected reply to PSYNC from master: %v", reply)
+	}
+	return conn.SetDeadline(time.Time{})
+}
+
+// readSnapshot 读取$<len>\r\n后跟len字节的RDB快照，快照末尾没有\r\n
+func (h *replHandshake) readSnapshot(br *bufio.Reader) error {
+	header, err := br.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	header = strings.TrimRight(header, "\r\n")
+	if !strings.HasPrefix(header, "$") {
+		return fmt.Errorf("bad protocol from master, the first byte is not '$': %q", header)
+	}
+	n, err := strconv.ParseInt(header[1:], 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid snapshot length %q", header[1:])
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return err
+	}
+	return rdbRead(bytes.NewReader(data), func(kv rdbKeyValue) {
+		h.entries = append(h.entries, kv)
+	})
+}
+
+// replicationHandshakeDone 握手结束后在事件循环中调用，载入快照并开始接收复制流
+func (s *Service) replicationHandshakeDone(h *replHandshake) {
+	if h.gen != s.replGen || s.masterHost == "" {
+		if h.conn != nil {
+			h.conn.Close()
+		}
+		return
+	}
+	if h.err != nil {
+		slog.Warn("sync with master failed", "addr", h.addr, "err", h.err)
+		s.replState = replStateConnect
+		return
+	}
+	if h.full {
+		// 本节点的数据被替换，下级副本需要重新全量同步
+		s.disconnectReplicas()
+		s.db.empty()
+		// 已过期的键同样保留，等待主节点传播DEL
+		for _, kv := range h.entries {
+			s.db.loadKeyValue(kv, -1)
+		}
+		s.replID = h.masterReplID
+		s.clearReplID2()
+		s.masterReplOffset = h.masterOffset
+		s.replBacklog = newReplBacklog(s.ReplBacklogSize)
+		if s.AppendOnly {
+			if err := s.rewriteAppendOnlyFileBackground(); err != nil {
+				slog.Warn("rewrite append only file after sync failed", "err", err)
+			}
+		}
+		slog.Info("full resynchronization with master succeeded", "keys", s.db.size(), "offset", s.masterReplOffset)
+	} else {
+		s.createReplBacklog()
+		if h.masterReplID != s.replID {
+			s.replID2 = s.replID
+			s.secondReplOffset = s.masterReplOffset + 1
+			s.replID = h.masterReplID
+			s.disconnectReplicas()
+		}
+		slog.Info("partial resynchronization with master succeeded", "offset", s.masterReplOffset)
+	}
+
+	master := NewPeer(h.conn, s.msgCh, s.quitPeerCh)
+	master.rd = h.rd
+	master.master = true
+	s.nextPeerID++
+	master.id = s.nextPeerID
+	s.peers[master] = true
+	s.master = master
+	s.replState = replStateConnected
+	go s.readPeer(master)
+	s.replicationSendAck()
+}
+
+// replicationSendAck 副本向主节点报告已处理的偏移量与已fsync到AOF的偏移量
+func (s *Service) replicationSendAck() {
+	if s.master == nil {
+		return
+	}
+	args := []string{CommentReplConf, "ACK", strconv.FormatInt(s.masterReplOffset, 10),
+		"FACK", strconv.FormatInt(s.aofFsyncedOffset, 10)}
+	if err := catAppendOnlyCommand(s.master.wr, args); err != nil {
+		slog.Error("send ACK to master error", "err", err)
+		return
+	}
+	s.master.flush()
+	s.replAckedAOFOffset = s.aofFsyncedOffset
+}
+
+// replicationCountAcksByOffset 返回已确认处理到offset的副本数，aof为true时统计已fsync到AOF的副本
+func (s *Service) replicationCountAcksByOffset(offset int64, aof bool) int {
+	n := 0
+	for _, r := range s.replicas {
+		if r.replica.state != replicaOnline {
+			continue
+		}
+		acked := r.replica.ackOffset
+		if aof {
+			acked = r.replica.aofAckOffset
+		}
+		if acked >= offset {
+			n++
+		}
+	}
+	return n
+}
+
+// blockForReplication 阻塞peer直到足够多的副本（及本地AOF）确认了offset，或者超时
+func (s *Service) blockForReplication(p *Peer, timeout, offset int64, numLocal, numReplicas int, aof bool) {
+	bs := &blockState{
+		typ:         blockWait,
+		timeout:     timeout,
+		offset:      offset,
+		numReplicas: numReplicas,
+		numLocal:    numLocal,
+		waitAOF:     aof,
+	}
+	// 在EXEC中不阻塞，直接回复当前确认的数量
+	bs.timeoutReply = s.waitReply(bs)
+	p.bstate = bs
+	s.blockedPeers[p] = struct{}{}
+	s.clientsWaitingAcks = append(s.clientsWaitingAcks, p)
+	s.getAckFromReplicas = true
+}
+
+// waitLocalAcked 本地AOF是否已fsync到offset
+func (s *Service) waitLocalAcked(offset int64) bool {
+	return s.aofFile != nil && s.aofFsyncedOffset >= offset
+}
+
+// waitReply 返回WAIT（已确认的副本数）或WAITAOF（本地是否已fsync、已fsync的副本数）的回复
+func (s *Service) waitReply(bs *blockState) any {
+	acked := int64(s.replicationCountAcksByOffset(bs.offset, bs.waitAOF))
+	if !bs.waitAOF {
+		return acked
+	}
+	return resp.Array{int64(boolToInt(s.waitLocalAcked(bs.offset))), acked}
+}
+
+// processClientsWaitingReplicas 有新的等待者时向副本请求ACK，
+// 并唤醒确认数量已满足的WAIT/WAITAOF，在事件循环每次处理完事件后调用
+func (s *Service) processClientsWaitingReplicas() {
+	if s.getAckFromReplicas {
+		s.getAckFromReplicas = false
+		if len(s.replicas) > 0 {
+			s.replicationFeedReplicas([]string{CommentReplConf, "GETACK", "*"})
+		}
+	}
+	for _, p := range slices.Clone(s.clientsWaitingAcks) {
+		bs := p.bstate
+		if s.replicationCountAcksByOffset(bs.offset, bs.waitAOF) < bs.numReplicas {
+			continue
+		}
+		if bs.numLocal > 0 && !s.waitLocalAcked(bs.offset) {
+			continue
+		}
+		s.unblockPeer(p)
+		p.addReply(s.waitReply(bs))
+		p.flush()
+		s.processPending(p)
+	}
+}
+
+// replicationCron 每秒执行一次：副本重连主节点，主节点定期向副本发送PING
+func (s *Service) replicationCron() {
+	if s.masterHost != "" && s.replState == replStateConnect {
+		s.connectWithMaster()
+	}
+	if s.master != nil {
+		s.replicationSendAck()
+	}
+	if s.masterHost == "" && len(s.replicas) > 0 && s.cronloops%(serverHz*replPingPeriod) == 0 {
+		s.replicationFeedReplicas([]string{CommentPing})
+	}
+}
+
+// replStateName 返回ROLE中副本连接状态的名称
+func (s *Service) replStateName() string {
+	switch s.replState {
+	case replStateConnect:
+		return "connect"
+	case replStateConnecting:
+		return "connecting"
+	case replStateConnected:
+		return "connected"
+	default:
+		return "none"
+	}
+}
+
+// replicaofCommand REPLICAOF host port | REPLICAOF NO ONE
+func replicaofCommand(s *Service, p *Peer, args []string) any {
+	if s.cluster != nil {
+		return errors.New("ERR REPLICAOF not allowed in cluster mode.")
+	}
+	if strings.EqualFold(args[1], "no") && strings.EqualFold(args[2], "one") {
+		if s.masterHost != "" {
+			s.replicationUnsetMaster()
+			slog.Info("MASTER MODE enabled")
+		}
+		return "OK"
+	}
+	if p.replica != nil {
+		return errors.New("ERR Command is not valid when client is a replica.")
+	}
+	port, err := parseInt(args[2])
+	if err != nil || port < 0 || port > 65535 {
+		return errors.New("ERR Invalid master port")
+	}
+	if s.masterHost == args[1] && s.masterPort == int(port) {
+		return "OK Already connected to specified master"
+	}
+	s.replicationSetMaster(args[1], int(port))
+	slog.Info("REPLICAOF enabled", "host", args[1], "port", port)
+	return "OK"
+}
+
+// syncCommand SYNC | PSYNC replicationid offset
+func syncCommand(s *Service, p *Peer, args []string) any {
+	if p.replica != nil {
+		return noReply
+	}
+	if s.masterHost != "" && s.replState != replStateConnected {
+		return errors.New("NOMASTERLINK Can't SYNC while not connected with my master")
+	}
+	psync := strings.EqualFold(args[0], CommentPSync)
+	if psync {
+		offset, err := parseInt(args[2])
+		if err != nil {
+			return errNotInteger
+		}
+		if s.primaryTryPartialResync(p, args[1], offset) {
+			return noReply
+		}
+	}
+	s.primaryFullResync(p, psync)
+	return noReply
+}
+
+// replconfCommand REPLCONF option value [option value ...] 副本在同步前告知自身的信息
+func replconfCommand(s *Service, p *Peer, args []string) any {
+	if len(args)%2 == 0 {
+		return errSyntax
+	}
+	switch strings.ToLower(args[1]) {
+	case "ack":
+		// REPLCONF ACK offset [FACK aofoffset] 副本报告偏移量，不回复
+		if p.replica == nil {
+			return noReply
+		}
+		if offset, err := parseInt(args[2]); err == nil && offset > p.replica.ackOffset {
+			p.replica.ackOffset = offset
+		}
+		if len(args) == 5 && strings.EqualFold(args[3], "fack") {
+			if offset, err := parseInt(args[4]); err == nil && offset > p.replica.aofAckOffset {
+				p.replica.aofAckOffset = offset
+			}
+		}
+		p.replica.ackTime = time.Now()
+		return noReply
+	case "getack":
+		// REPLCONF GETACK * 主节点要求立即报告偏移量
+		if p.master {
+			s.replicationSendAck()
+		}
+		return noReply
+	}
+	for i := 1; i < len(args); i += 2 {
+		switch opt := strings.ToLower(args[i]); opt {
+		case "listening-port":
+			port, err := parseInt(args[i+1])
+			if err != nil || port < 0 || port > 65535 {
+				return errors.New("ERR Invalid listening port")
+			}
+			p.replListeningPort = int(port)
+		case "ip-address":
+			p.replAddr = args[i+1]
+		case "capa":
+			// 复制流始终以PSYNC2的方式处理，忽略其他能力
+		default:
+			return fmt.Errorf("ERR Unrecognized REPLCONF option: %s", args[i])
+		}
+	}
+	return "OK"
+}
+
+// roleCommand ROLE
+func roleCommand(s *Service, p *Peer, args []string) any {
+	if s.sentinel != nil {
+		return resp.Array{resp.BulkStrings("sentinel"), s.sentinelMasterNames()}
+	}
+	if s.masterHost != "" {
+		offset := int64(-1)
+		if s.replState == replStateConnected {
+			offset = s.masterReplOffset
+		}
+		return resp.Array{
+			resp.BulkStrings("slave"),
+			resp.BulkStrings(s.masterHost),
+			int64(s.masterPort),
+			resp.BulkStrings(s.replStateName()),
+			offset,
+		}
+	}
+	replicas := resp.Array{}
+	for _, r := range s.replicas {
+		if r.replica.state != replicaOnline {
+			continue
+		}
+		ip, port := r.replicaAddr()
+		replicas = append(replicas, resp.Array{
+			resp.BulkStrings(ip),
+			resp.BulkStrings(strconv.Itoa(port)),
+			resp.BulkStrings(strconv.FormatInt(r.replica.ackOffset, 10)),
+		})
+	}
+	return resp.Array{resp.BulkStrings("master"), s.masterReplOffset, replicas}
+}
+
+// genReplicationInfo INFO replication
+func (s *Service) genReplicationInfo(sb *strings.Builder) {
+	if s.masterHost == "" {
+		sb.WriteString("role:master\r\n")
+	} else {
+		linkStatus := "down"
+		if s.replState == replStateConnected {
+			linkStatus = "up"
+		}
+		syncing := 0
+		if s.replState == replStateConnecting {
+			syncing = 1
+		}
+		fmt.Fprintf(sb, "role:slave\r\nmaster_host:%s\r\nmaster_port:%d\r\nmaster_link_status:%s\r\n"+
+			"master_sync_in_progress:%d\r\nslave_repl_offset:%d\r\nslave_priority:100\r\nslave_read_only:1\r\n",
+			s.masterHost, s.masterPort, linkStatus, syncing, s.masterReplOffset)
+		// 连接断开时输出断开的秒数，从未连接过时为-1
+		if s.replState != replStateConnected {
+			downSince := int64(-1)
+			if s.replDownSince != 0 {
+				downSince = time.Now().Unix() - s.replDownSince
+			}
+			fmt.Fprintf(sb, "master_link_down_since_seconds:%d\r\n", downSince)
+		}
+	}
+	fmt.Fprintf(sb, "connected_slaves:%d\r\n", len(s.replicas))
+	for i, r := range s.replicas {
+		ip, port := r.replicaAddr()
+		state := "wait_bgsave"
+		if r.replica.state == replicaOnline {
+			state = "online"
+		}
+		lag := int64(0)
+		if !r.replica.ackTime.IsZero() {
+			lag = int64(time.Since(r.replica.ackTime).Seconds())
+		}
+		fmt.Fprintf(sb, "slave%d:ip=%s,port=%d,state=%s,offset=%d,lag=%d\r\n",
+			i, ip, port, state, r.replica.ackOffset, lag)
+	}
+	fmt.Fprintf(sb, "master_replid:%s\r\nmaster_replid2:%s\r\nmaster_repl_offset:%d\r\nsecond_repl_offset:%d\r\n",
+		s.replID, s.replID2, s.masterReplOffset, s.secondReplOffset)
+	if s.replBacklog == nil {
+		fmt.Fprintf(sb, "repl_backlog_active:0\r\nrepl_backlog_size:%d\r\nrepl_backlog_first_byte_offset:0\r\nrepl_backlog_histlen:0\r\n",
+			s.ReplBacklogSize)
+		return
+	}
+	fmt.Fprintf(sb, "repl_backlog_active:1\r\nrepl_backlog_size:%d\r\nrepl_backlog_first_byte_offset:%d\r\nrepl_backlog_histlen:%d\r\n",
+		s.ReplBacklogSize, s.replBacklogOffset(), s.replBacklog.histlen)
+}
+
+// waitCommand WAIT numreplicas timeout 阻塞直到之前的写命令被至少numreplicas个副本确认，
+// 回复确认的副本数
+func waitCommand(s *Service, p *Peer, args []string) any {
+	if s.masterHost != "" {
+		return errors.New("ERR WAIT cannot be used with replica instances.")
+	}
+	numReplicas, err := parseInt(args[1])
+	if err != nil {
+		return errNotInteger
+	}
+	timeout, err := parseTimeoutMs(args[2])
+	if err != nil {
+		return err
+	}
+	if acked := s.replicationCountAcksByOffset(p.woff, false); int64(acked) >= numReplicas {
+		return int64(acked)
+	}
+	s.blockForReplication(p, timeout, p.woff, 0, int(numReplicas), false)
+	return noReply
+}
+
+// waitaofCommand WAITAOF numlocal numreplicas timeout 阻塞直到之前的写命令被本地及
+// 至少numreplicas个副本fsync到AOF，回复[本地是否已fsync, 已fsync的副本数]
+func waitaofCommand(s *Service, p *Peer, args []string) any {
+	if s.masterHost != "" {
+		return errors.New("ERR WAITAOF cannot be used with replica instances.")
+	}
+	numLocal, err := parseInt(args[1])
+	if err != nil {
+		return errNotInteger
+	}
+	numReplicas, err := parseInt(args[2])
+	if err != nil {
+		return errNotInteger
+	}
+	timeout, err := parseTimeoutMs(args[3])
+	if err != nil {
+		return err
+	}
+	if numLocal > 0 && !s.AppendOnly {
+		return errors.New("ERR WAITAOF cannot be used when numlocal is set but appendonly is disabled.")
+	}
+	acked := s.replicationCountAcksByOffset(p.woff, true)
+	if int64(acked) >= numReplicas && (numLocal == 0 || s.waitLocalAcked(p.woff)) {
+		return resp.Array{int64(boolToInt(s.waitLocalAcked(p.woff))), int64(acked)}
+	}
+	s.blockForReplication(p, timeout, p.woff, int(numLocal), int(numReplicas), true)
+	return noReply
+}
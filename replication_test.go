@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+	"github.com/stretchr/testify/assert"
+)
+
+// testClient 通过回环连接向服务发送命令
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+	rd   *resp.Reader
+	wr   *resp.Writer
+}
+
+func dialTestService(t *testing.T, s *Service) *testClient {
+	return dialTestAddr(t, s.ln.Addr().String())
+}
+
+// dialTestAddr 连接到addr上的服务
+func dialTestAddr(t *testing.T, addr string) *testClient {
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	br := bufio.NewReader(conn)
+	return &testClient{t: t, conn: conn, br: br, rd: resp.NewReader(br), wr: resp.NewWriter(conn)}
+}
+
+// do 发送命令并读取回复
+func (c *testClient) do(args ...string) any {
+	assert.NoError(c.t, catAppendOnlyCommand(c.wr, args))
+	assert.NoError(c.t, c.wr.Flush())
+	// WAITAOF等待副本everysec的fsync时回复可能晚于1秒，超时只用于防止测试卡死
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	res, err := c.rd.ReadValue()
+	assert.NoError(c.t, err)
+	return res
+}
+
+// info 返回INFO中字段的值
+func (c *testClient) info(field string) string {
+	res := c.do(CommentInfo)
+	for _, line := range strings.Split(string(res.(resp.BulkStrings)), "\r\n") {
+		if v, ok := strings.CutPrefix(line, field+":"); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// startTestReplica 以cfg启动一个服务并使其成为master的副本，等待同步完成
+func startTestReplica(t *testing.T, master *Service, cfg Config) (*Service, *testClient) {
+	s := startTestService(t, cfg)
+	c := dialTestService(t, s)
+	port := strconv.Itoa(master.ln.Addr().(*net.TCPAddr).Port)
+	assert.Equal(t, "OK", c.do(CommentReplicaOf, "127.0.0.1", port))
+	assert.Eventually(t, func() bool {
+		return c.info("master_link_status") == "up"
+	}, 3*time.Second, 10*time.Millisecond)
+	return s, c
+}
+
+// waitReplOffset 等待副本处理完主节点已产生的复制流
+func waitReplOffset(t *testing.T, master, replica *testClient) {
+	assert.Eventually(t, func() bool {
+		return master.info("master_repl_offset") == replica.info("master_repl_offset")
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestReplBacklog(t *testing.T) {
+	testCases := []struct {
+		name  string
+		size  int
+		feeds []string
+		tail  int
+		res   string
+		hist  int
+	}{
+		{
+			name:  "测试未写满",
+			size:  8,
+			feeds: []string{"abc", "de"},
+			tail:  4,
+			res:   "bcde",
+			hist:  5,
+		},
+		{
+			name:  "测试环绕后读取跨越末尾的数据",
+			size:  8,
+			feeds: []string{"abcdef", "ghij"},
+			tail:  8,
+			res:   "cdefghij",
+			hist:  8,
+		},
+		{
+			name:  "测试单次写入超过容量",
+			size:  4,
+			feeds: []string{"abcdefghij"},
+			tail:  4,
+			res:   "ghij",
+			hist:  4,
+		},
+		{
+			name:  "测试读取0字节",
+			size:  4,
+			feeds: []string{"ab"},
+			tail:  0,
+			res:   "",
+			hist:  2,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := newReplBacklog(tc.size)
+			for _, f := range tc.feeds {
+				b.feed([]byte(f))
+			}
+			assert.Equal(t, tc.hist, b.histlen)
+			assert.Equal(t, tc.res, string(b.tail(tc.tail)))
+		})
+	}
+}
+
+func TestReplicaOutputBufferLimit(t *testing.T) {
+	s := NewService(Config{ClientOutputBufferLimitReplica: 1024})
+	p, _ := newTestPeer(s)
+	doCommand(s, p, "SET", "k", strings.Repeat("v", 4096))
+	quit := make(chan struct{})
+	defer close(quit)
+	// 副本不读取连接，快照与复制流都堆积在输出缓冲区中
+	server, client := net.Pipe()
+	defer client.Close()
+	r := NewPeer(server, s.msgCh, quit)
+	s.peers[r] = true
+	s.primaryFullResync(r, false)
+	s.replicationFeedStream(bytes.Repeat([]byte("x"), 600))
+	s.replicaSnapshotDone(<-s.replSnapshotCh)
+	assert.Equal(t, replicaOnline, r.replica.state)
+	// 快照不计入限制
+	assert.Less(t, r.out.pending(), 1024)
+	s.replicationFeedStream(bytes.Repeat([]byte("x"), 300))
+	assert.Greater(t, r.out.pending(), 900)
+
+	s.replicationFeedStream(bytes.Repeat([]byte("x"), 300))
+	assert.Equal(t, 0, r.out.pending())
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := io.ReadAll(client)
+	assert.NoError(t, err)
+}
+
+func TestReplicationSync(t *testing.T) {
+	master := startTestService(t, Config{})
+	mc := dialTestService(t, master)
+	mc.do("SET", "k", "v")
+	mc.do("RPUSH", "l", "a", "b")
+	mc.do("SET", "ttl", "v", "EX", "100")
+
+	replica, rc := startTestReplica(t, master, Config{})
+	port := replica.ln.Addr().(*net.TCPAddr).Port
+
+	// 全量同步载入快照，之后的写命令通过复制流到达
+	mc.do("HSET", "h", "f", "1")
+	mc.do("EXPIRE", "k", "100")
+	mc.do("MULTI")
+	mc.do("INCR", "n")
+	mc.do("LPOP", "l")
+	mc.do("EXEC")
+	waitReplOffset(t, mc, rc)
+
+	testCases := []struct {
+		name string
+		args []string
+		res  any
+	}{
+		{
+			name: "测试快照中的字符串",
+			args: []string{"GET", "k"},
+			res:  resp.BulkStrings("v"),
+		},
+		{
+			name: "测试复制流中的哈希",
+			args: []string{"HGET", "h", "f"},
+			res:  resp.BulkStrings("1"),
+		},
+		{
+			name: "测试事务中的修改",
+			args: []string{"LRANGE", "l", "0", "-1"},
+			res:  resp.Array{resp.BulkStrings("b")},
+		},
+		{
+			name: "测试事务中的INCR",
+			args: []string{"GET", "n"},
+			res:  resp.BulkStrings("1"),
+		},
+		{
+			name: "测试只读副本拒绝写命令",
+			args: []string{"SET", "x", "1"},
+			res:  errors.New("READONLY You can't write against a read only replica."),
+		},
+		{
+			name: "测试副本的ROLE",
+			args: []string{CommentRole},
+			res: resp.Array{
+				resp.BulkStrings("slave"),
+				resp.BulkStrings("127.0.0.1"),
+				int64(master.ln.Addr().(*net.TCPAddr).Port),
+				resp.BulkStrings("connected"),
+				mustParseInt(rc.info("master_repl_offset")),
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.res, rc.do(tc.args...))
+		})
+	}
+
+	// 过期时间以绝对时间传播
+	for _, key := range []string{"k", "ttl"} {
+		ttl := rc.do("TTL", key).(int64)
+		assert.True(t, ttl > 90 && ttl <= 100, key)
+	}
+	// 副本通过REPLCONF ACK报告偏移量
+	offset := resp.BulkStrings(mc.info("master_repl_offset"))
+	assert.Eventually(t, func() bool {
+		role := mc.do(CommentRole).(resp.Array)
+		return assert.ObjectsAreEqual(resp.Array{resp.Array{
+			resp.BulkStrings("127.0.0.1"), resp.BulkStrings(strconv.Itoa(port)), offset,
+		}}, role[2])
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "1", mc.info("connected_slaves"))
+	assert.Equal(t, "1", mc.info("sync_full"))
+	assert.Equal(t, mc.info("master_replid"), rc.info("master_replid"))
+	assert.Equal(t, "slave", rc.info("role"))
+
+	// 提升为主节点后可以写入，复制ID切换，旧的ID保留在replid2中
+	replid := rc.info("master_replid")
+	assert.Equal(t, "OK", rc.do(CommentReplicaOf, "NO", "ONE"))
+	assert.Equal(t, "OK", rc.do("SET", "x", "1"))
+	assert.Equal(t, replid, rc.info("master_replid2"))
+	assert.NotEqual(t, replid, rc.info("master_replid"))
+	assert.Eventually(t, func() bool {
+		return mc.info("connected_slaves") == "0"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestReplicationPartialResync(t *testing.T) {
+	master := startTestService(t, Config{ReplBacklogSize: 64})
+	mc := dialTestService(t, master)
+	_, rc := startTestReplica(t, master, Config{})
+
+	// 写入超过积压缓冲区容量的数据，最早的复制流被覆盖
+	mc.do("SET", "a", strings.Repeat("x", 100))
+	waitReplOffset(t, mc, rc)
+	replid := mc.info("master_replid")
+	offset := mustParseInt(mc.info("master_repl_offset"))
+	mc.do("SET", "b", "2")
+
+	var want bytes.Buffer
+	w := resp.NewWriter(&want)
+	catAppendOnlyCommand(w, []string{"SET", "b", "2"})
+	w.Flush()
+
+	testCases := []struct {
+		name   string
+		replid string
+		offset int64
+		res    string
+		stream string
+	}{
+		{
+			name:   "测试积压缓冲区中的偏移量",
+			replid: replid,
+			offset: offset + 1,
+			res:    "CONTINUE " + replid,
+			stream: want.String(),
+		},
+		{
+			name:   "测试偏移量等于当前偏移量加一",
+			replid: replid,
+			offset: offset + int64(want.Len()) + 1,
+			res:    "CONTINUE " + replid,
+		},
+		{
+			name:   "测试偏移量超出积压缓冲区",
+			replid: replid,
+			offset: 1,
+			res:    "FULLRESYNC " + replid + " " + strconv.FormatInt(offset+int64(want.Len()), 10),
+		},
+		{
+			name:   "测试复制ID不匹配",
+			replid: strings.Repeat("1", 40),
+			offset: offset + 1,
+			res:    "FULLRESYNC " + replid + " " + strconv.FormatInt(offset+int64(want.Len()), 10),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := dialTestService(t, master)
+			assert.Equal(t, tc.res, c.do(CommentPSync, tc.replid, strconv.FormatInt(tc.offset, 10)))
+			if tc.stream != "" {
+				buf := make([]byte, len(tc.stream))
+				_, err := io.ReadFull(c.br, buf)
+				assert.NoError(t, err)
+				assert.Equal(t, tc.stream, string(buf))
+			}
+		})
+	}
+}
+
+func TestReplicationFailover(t *testing.T) {
+	master := startTestService(t, Config{})
+	mc := dialTestService(t, master)
+	mc.do("SET", "a", "1")
+	r1, c1 := startTestReplica(t, master, Config{})
+	_, c2 := startTestReplica(t, master, Config{})
+	mc.do("SET", "b", "2")
+	waitReplOffset(t, mc, c1)
+	waitReplOffset(t, mc, c2)
+
+	// r1提升为主节点，r2改为复制r1，凭旧的复制ID部分重同步
+	assert.Equal(t, "OK", c1.do(CommentReplicaOf, "NO", "ONE"))
+	c1.do("SET", "c", "3")
+	port := strconv.Itoa(r1.ln.Addr().(*net.TCPAddr).Port)
+	assert.Equal(t, "OK", c2.do(CommentReplicaOf, "127.0.0.1", port))
+	assert.Eventually(t, func() bool {
+		return c2.info("master_link_status") == "up"
+	}, 3*time.Second, 10*time.Millisecond)
+	waitReplOffset(t, c1, c2)
+
+	assert.Equal(t, "1", c1.info("sync_partial_ok"))
+	assert.Equal(t, "0", c1.info("sync_full"))
+	assert.Equal(t, c1.info("master_replid"), c2.info("master_replid"))
+	assert.Equal(t, resp.BulkStrings("3"), c2.do("GET", "c"))
+	assert.Equal(t, resp.BulkStrings("2"), c2.do("GET", "b"))
+	assert.Equal(t, "OK Already connected to specified master", c2.do(CommentReplicaOf, "127.0.0.1", port))
+}
+
+func TestWait(t *testing.T) {
+	master := startTestService(t, Config{})
+	mc := dialTestService(t, master)
+	// 没有副本、没有写命令时立即返回
+	assert.Equal(t, int64(0), mc.do(CommentWait, "0", "0"))
+
+	_, c1 := startTestReplica(t, master, Config{})
+	_, c2 := startTestReplica(t, master, Config{})
+	mc.do("SET", "k", "v")
+
+	testCases := []struct {
+		name    string
+		args    []string
+		res     any
+		timeout bool
+	}{
+		{
+			name: "测试等待全部副本确认",
+			args: []string{CommentWait, "2", "0"},
+			res:  int64(2),
+		},
+		{
+			name: "测试等待一个副本",
+			args: []string{CommentWait, "1", "1000"},
+			res:  int64(2),
+		},
+		{
+			name:    "测试副本数不足时超时返回已确认的数量",
+			args:    []string{CommentWait, "3", "100"},
+			res:     int64(2),
+			timeout: true,
+		},
+		{
+			name: "测试超时参数错误",
+			args: []string{CommentWait, "1", "-1"},
+			res:  errors.New("ERR timeout is negative"),
+		},
+		{
+			name: "测试副本数参数错误",
+			args: []string{CommentWait, "x", "0"},
+			res:  errors.New("ERR value is not an integer or out of range"),
+		},
+		{
+			name: "测试未开启AOF时WAITAOF要求本地fsync",
+			args: []string{CommentWaitAOF, "1", "0", "0"},
+			res:  errors.New("ERR WAITAOF cannot be used when numlocal is set but appendonly is disabled."),
+		},
+		{
+			name:    "测试副本未开启AOF时WAITAOF超时",
+			args:    []string{CommentWaitAOF, "0", "1", "100"},
+			res:     resp.Array{int64(0), int64(0)},
+			timeout: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			start := time.Now()
+			assert.Equal(t, tc.res, mc.do(tc.args...))
+			if tc.timeout {
+				assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+			}
+		})
+	}
+
+	assert.Equal(t, errors.New("ERR WAIT cannot be used with replica instances."), c1.do(CommentWait, "0", "0"))
+	assert.Equal(t, errors.New("ERR WAITAOF cannot be used with replica instances."), c2.do(CommentWaitAOF, "0", "0", "0"))
+}
+
+func TestWaitAOF(t *testing.T) {
+	master := startTestService(t, Config{Dir: t.TempDir(), AppendOnly: true, AppendFsync: aofFsyncAlways})
+	mc := dialTestService(t, master)
+	_, rc := startTestReplica(t, master, Config{Dir: t.TempDir(), AppendOnly: true, AppendFsync: aofFsyncEverysec})
+	mc.do("SET", "k", "v")
+
+	// 本地以always策略立即fsync，副本在后台fsync后通过ACK报告
+	assert.Equal(t, resp.Array{int64(1), int64(1)}, mc.do(CommentWaitAOF, "1", "1", "3000"))
+	assert.Equal(t, int64(1), mc.do(CommentWait, "1", "1000"))
+
+	// EXEC中不阻塞，按事务之前的写命令回复当前已确认的副本数
+	mc.do("MULTI")
+	mc.do("SET", "k", "v2")
+	mc.do(CommentWait, "5", "0")
+	res := mc.do("EXEC").(resp.Array)
+	assert.Equal(t, "OK", res[0])
+	assert.Equal(t, int64(1), res[1])
+	waitReplOffset(t, mc, rc)
+}
@@ -0,0 +1,1746 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+)
+
+const (
+	CommentSentinel = "SENTINEL"
+)
+
+const (
+	// defaultSentinelConfigFile 哨兵配置文件的默认文件名，位于Dir中
+	defaultSentinelConfigFile = "sentinel.conf"
+	// defaultSentinelDownAfter 实例无响应多久（毫秒）后被认为主观下线
+	defaultSentinelDownAfter = 30000
+	// defaultSentinelFailoverTimeout 故障转移各阶段的超时（毫秒）
+	defaultSentinelFailoverTimeout = 180000
+	// defaultSentinelParallelSyncs 故障转移时同时改为复制新主节点的副本数
+	defaultSentinelParallelSyncs = 1
+
+	// sentinelInfoPeriod 向主节点与副本发送INFO的周期（毫秒）
+	sentinelInfoPeriod = 10000
+	// sentinelPingPeriod 向实例发送PING的周期（毫秒）
+	sentinelPingPeriod = 1000
+	// sentinelAskPeriod 主节点主观下线时询问其他哨兵的周期（毫秒）
+	sentinelAskPeriod = 1000
+	// sentinelPublishPeriod 发布hello消息的周期（毫秒）
+	sentinelPublishPeriod = 2000
+	// sentinelElectionTimeout 等待当选领头哨兵的最长时间（毫秒）
+	sentinelElectionTimeout = 10000
+	// sentinelSlaveReconfTimeout 副本收到REPLICAOF后多久（毫秒）没有开始同步时不再等待
+	sentinelSlaveReconfTimeout = 10000
+	// sentinelMinLinkReconnectPeriod 连接至少建立多久（毫秒）后才因PING无响应而重建
+	sentinelMinLinkReconnectPeriod = 15000
+	// sentinelMaxPendingCommands 连接上等待回复的命令超过该数量时不再发送周期性命令
+	sentinelMaxPendingCommands = 100
+	// sentinelMaxDesync 投票给其他哨兵后推迟自身发起故障转移的随机时间上限（毫秒）
+	sentinelMaxDesync = 1000
+	// sentinelHelloChannel 哨兵之间通过被监控实例上的该频道互相发现
+	sentinelHelloChannel = "__sentinel__:hello"
+)
+
+// 实例标志
+const (
+	// sriMaster、sriReplica、sriSentinel 实例的类型
+	sriMaster = 1 << iota
+	sriReplica
+	sriSentinel
+	// sriSDown 本哨兵认为实例已下线（主观下线）
+	sriSDown
+	// sriODown 足够多的哨兵认为主节点已下线（客观下线）
+	sriODown
+	// sriMasterDown 该哨兵认为主节点已下线
+	sriMasterDown
+	// sriFailoverInProgress 正在对该主节点进行故障转移
+	sriFailoverInProgress
+	// sriPromoted 被选中提升为新主节点的副本
+	sriPromoted
+	// sriReconfSent、sriReconfInprog、sriReconfDone 副本改为复制新主节点的进度
+	sriReconfSent
+	sriReconfInprog
+	sriReconfDone
+	// sriForceFailover SENTINEL FAILOVER发起的故障转移，无需当选领头哨兵
+	sriForceFailover
+)
+
+// sentinelFlagNames 标志在SENTINEL MASTER等回复中的名称，按输出顺序排列
+var sentinelFlagNames = []struct {
+	flag int
+	name string
+}{
+	{sriMaster, "master"},
+	{sriReplica, "slave"},
+	{sriSentinel, "sentinel"},
+	{sriSDown, "s_down"},
+	{sriODown, "o_down"},
+	{sriMasterDown, "master_down"},
+	{sriFailoverInProgress, "failover_in_progress"},
+	{sriPromoted, "promoted"},
+	{sriReconfSent, "reconf_sent"},
+	{sriReconfInprog, "reconf_inprog"},
+	{sriReconfDone, "reconf_done"},
+}
+
+// 故障转移的状态
+const (
+	failoverStateNone = iota
+	// failoverStateWaitStart 等待当选领头哨兵
+	failoverStateWaitStart
+	// failoverStateSelectSlave 选择提升为主节点的副本
+	failoverStateSelectSlave
+	// failoverStateSendSlaveofNoone 向选中的副本发送REPLICAOF NO ONE
+	failoverStateSendSlaveofNoone
+	// failoverStateWaitPromotion 等待选中的副本报告自己已是主节点
+	failoverStateWaitPromotion
+	// failoverStateReconfSlaves 让其余副本复制新的主节点
+	failoverStateReconfSlaves
+	// failoverStateUpdateConfig 以新的主节点代替旧的主节点
+	failoverStateUpdateConfig
+)
+
+var failoverStateNames = []string{
+	"none", "wait_start", "select_slave", "send_slaveof_noone", "wait_promotion", "reconf_slaves", "update_config",
+}
+
+var errNoSuchMaster = errors.New("ERR No such master with that name")
+
+// sentinelInstance 哨兵监控的一个实例：主节点、主节点的副本或监控同一主节点的其他哨兵
+type sentinelInstance struct {
+	flags int
+	// name 主节点为配置的名称，副本与哨兵为ip:port
+	name  string
+	runID string
+	ip    string
+	port  int
+	// master 副本与哨兵所属的主节点，主节点为nil
+	master *sentinelInstance
+	// link 命令连接，pubsubLink 订阅hello频道的连接（只连接主节点与副本），connecting 正在连接
+	link             *sentinelLink
+	pubsubLink       *sentinelLink
+	connecting       bool
+	pubsubConnecting bool
+	removed          bool
+	linkCtime        int64
+	// actPingTime 最早一次尚未收到有效回复的PING的发送时刻，0表示没有，lastPingTime 最近一次发送PING的时刻，
+	// lastPongTime 最近一次收到PING回复的时刻，lastAvailTime 最近一次收到有效回复的时刻（unix毫秒）
+	actPingTime   int64
+	lastPingTime  int64
+	lastPongTime  int64
+	lastAvailTime int64
+	// lastPubTime 最近一次发布hello的时刻，lastHelloTime 最近一次收到该哨兵的hello的时刻
+	lastPubTime   int64
+	lastHelloTime int64
+	// lastMasterDownReplyTime 最近一次收到该哨兵对is-master-down-by-addr的回复的时刻
+	lastMasterDownReplyTime int64
+	// sdownSince、odownSince 进入主观下线、客观下线的时刻
+	sdownSince int64
+	odownSince int64
+	// infoRefresh 最近一次收到INFO回复的时刻，roleReported 实例在INFO中报告的角色及其变化的时刻
+	infoRefresh      int64
+	roleReported     int
+	roleReportedTime int64
+
+	// 以下为主节点的字段
+	quorum          int
+	downAfter       int64
+	failoverTimeout int64
+	parallelSyncs   int
+	// replicas、sentinels 主节点的副本与监控该主节点的其他哨兵，键为ip:port
+	replicas  map[string]*sentinelInstance
+	sentinels map[string]*sentinelInstance
+	// configEpoch 主节点当前配置的纪元，故障转移成功后为执行故障转移时的纪元
+	configEpoch int64
+	// leader、leaderEpoch 主节点上本哨兵投票选出的领头哨兵及投票时的纪元；
+	// 在其他哨兵上为该哨兵回复的投票结果
+	leader      string
+	leaderEpoch int64
+	// failoverEpoch 本哨兵发起故障转移时的纪元，failoverState 故障转移的状态
+	failoverEpoch           int64
+	failoverState           int
+	failoverStateChangeTime int64
+	failoverStartTime       int64
+	promoted                *sentinelInstance
+
+	// 以下为副本在INFO中报告的字段
+	masterLinkDownTime  int64
+	replMasterHost      string
+	replMasterPort      int
+	replMasterLinkUp    bool
+	replPriority        int
+	replOffset          int64
+	slaveReconfSentTime int64
+}
+
+// sentinelState 哨兵的状态
+type sentinelState struct {
+	myID         string
+	currentEpoch int64
+	// masters 监控的主节点，键为名称
+	masters map[string]*sentinelInstance
+	// announcePort 在hello中通告的端口，为客户端端口
+	announcePort int
+}
+
+// sentinelLink 到实例的连接。命令连接上按顺序登记每条命令的回调，读到的回复依次交给回调；
+// 订阅连接只接收hello消息
+type sentinelLink struct {
+	conn      net.Conn
+	wr        *resp.Writer
+	inst      *sentinelInstance
+	pubsub    bool
+	callbacks []func(reply any)
+	freed     bool
+}
+
+// sentinelLinkEvent 连接实例的结果
+type sentinelLinkEvent struct {
+	inst   *sentinelInstance
+	pubsub bool
+	conn   net.Conn
+	err    error
+}
+
+// sentinelReply 连接上读到的一条回复，err不为空表示连接已断开
+type sentinelReply struct {
+	link  *sentinelLink
+	reply any
+	err   error
+}
+
+func init() {
+	registerCommand(
+		&command{name: CommentSentinel, arity: -2, flags: cmdAdmin | cmdSentinel | cmdOnlySentinel, proc: sentinelCommand},
+	)
+}
+
+// parseSentinelMonitor 解析name host port quorum
+func parseSentinelMonitor(fields []string) (*sentinelInstance, error) {
+	if len(fields) != 4 {
+		return nil, errors.New("expected name host port quorum")
+	}
+	port, err := strconv.Atoi(fields[2])
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, errors.New("invalid port number")
+	}
+	quorum, err := strconv.Atoi(fields[3])
+	if err != nil || quorum <= 0 {
+		return nil, errors.New("quorum must be 1 or greater")
+	}
+	ip := net.ParseIP(fields[1])
+	if ip == nil {
+		return nil, errors.New("invalid IP address")
+	}
+	ri := createSentinelInstance(fields[0], sriMaster, ip.String(), port, nil)
+	ri.quorum = quorum
+	return ri, nil
+}
+
+// createSentinelInstance 创建实例，副本与哨兵继承主节点的下线判断时间
+func createSentinelInstance(name string, flags int, ip string, port int, master *sentinelInstance) *sentinelInstance {
+	now := mstime()
+	ri := &sentinelInstance{
+		flags:  flags,
+		name:   name,
+		ip:     ip,
+		port:   port,
+		master: master,
+		// 从未连接上的实例在downAfter后同样被认为主观下线
+		actPingTime:      now,
+		lastAvailTime:    now,
+		lastPongTime:     now,
+		roleReported:     flags & (sriMaster | sriReplica),
+		roleReportedTime: now,
+		replPriority:     100,
+		downAfter:        defaultSentinelDownAfter,
+		failoverTimeout:  defaultSentinelFailoverTimeout,
+		parallelSyncs:    defaultSentinelParallelSyncs,
+	}
+	if flags&sriMaster != 0 {
+		ri.replicas = make(map[string]*sentinelInstance)
+		ri.sentinels = make(map[string]*sentinelInstance)
+	}
+	if master != nil {
+		ri.downAfter = master.downAfter
+	}
+	return ri
+}
+
+func (ri *sentinelInstance) hasFlag(flag int) bool {
+	return ri.flags&flag != 0
+}
+
+func (ri *sentinelInstance) addr() string {
+	return net.JoinHostPort(ri.ip, strconv.Itoa(ri.port))
+}
+
+// typeName 实例类型在事件中的名称
+func (ri *sentinelInstance) typeName() string {
+	switch {
+	case ri.hasFlag(sriMaster):
+		return "master"
+	case ri.hasFlag(sriReplica):
+		return "slave"
+	default:
+		return "sentinel"
+	}
+}
+
+func (ri *sentinelInstance) flagsString() string {
+	var names []string
+	for _, f := range sentinelFlagNames {
+		if ri.hasFlag(f.flag) {
+			names = append(names, f.name)
+		}
+	}
+	if ri.link == nil {
+		names = append(names, "disconnected")
+	}
+	return strings.Join(names, ",")
+}
+
+// sentinelInit 载入配置文件并加入启动参数中的主节点，没有配置文件时生成新的ID
+func (s *Service) sentinelInit() error {
+	s.sentinel = &sentinelState{masters: make(map[string]*sentinelInstance)}
+	if err := s.sentinelLoadConfig(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("load sentinel config: %w", err)
+	}
+	if s.sentinel.myID == "" {
+		s.sentinel.myID = genRunID()
+		slog.Info("sentinel new configuration saved on disk", "myid", s.sentinel.myID)
+	}
+	if s.SentinelMonitor != "" {
+		ri, err := parseSentinelMonitor(strings.Fields(s.SentinelMonitor))
+		if err != nil {
+			return err
+		}
+		if _, ok := s.sentinel.masters[ri.name]; !ok {
+			ri.downAfter, ri.failoverTimeout = s.SentinelDownAfter, s.SentinelFailoverTimeout
+			s.sentinel.masters[ri.name] = ri
+			s.sentinelEvent(ri, "+monitor", "quorum %d", ri.quorum)
+		}
+	}
+	return s.sentinelFlushConfig()
+}
+
+// sentinelConfigPath 返回配置文件的路径
+func (s *Service) sentinelConfigPath() string {
+	return filepath.Join(s.Dir, s.SentinelConfigFile)
+}
+
+// sentinelLoadConfig 载入配置文件，文件不存在时返回os.ErrNotExist
+func (s *Service) sentinelLoadConfig() error {
+	f, err := os.Open(s.sentinelConfigPath())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st := s.sentinel
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] != "sentinel" || len(fields) < 3 {
+			return fmt.Errorf("invalid sentinel config line %q", sc.Text())
+		}
+		if err := s.sentinelLoadConfigLine(fields[1], fields[2:]); err != nil {
+			return fmt.Errorf("invalid sentinel config line %q: %w", sc.Text(), err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if st.myID == "" {
+		return errors.New("myid not found in sentinel config file")
+	}
+	return nil
+}
+
+// sentinelLoadConfigLine 载入配置文件中的一行sentinel <option> <args ...>
+func (s *Service) sentinelLoadConfigLine(option string, args []string) error {
+	st := s.sentinel
+	switch option {
+	case "myid":
+		st.myID = args[0]
+		return nil
+	case "current-epoch":
+		epoch, err := strconv.ParseInt(args[0], 10, 64)
+		st.currentEpoch = epoch
+		return err
+	case "monitor":
+		ri, err := parseSentinelMonitor(args)
+		if err != nil {
+			return err
+		}
+		ri.downAfter, ri.failoverTimeout = s.SentinelDownAfter, s.SentinelFailoverTimeout
+		st.masters[ri.name] = ri
+		return nil
+	}
+	master := st.masters[args[0]]
+	if master == nil {
+		return errNoSuchMaster
+	}
+	switch {
+	case option == "known-replica" && len(args) == 3:
+		port, err := strconv.Atoi(args[2])
+		if err != nil {
+			return err
+		}
+		s.sentinelCreateReplica(master, args[1], port)
+		return nil
+	case option == "known-sentinel" && len(args) == 4:
+		port, err := strconv.Atoi(args[2])
+		if err != nil {
+			return err
+		}
+		si := createSentinelInstance(net.JoinHostPort(args[1], args[2]), sriSentinel, args[1], port, master)
+		si.runID = args[3]
+		master.sentinels[si.name] = si
+		return nil
+	case len(args) == 2:
+		return s.sentinelSetMasterOption(master, option, args[1])
+	}
+	return errSyntax
+}
+
+// sentinelFlushConfig 将当前状态写入配置文件，替换是原子的
+func (s *Service) sentinelFlushConfig() error {
+	st := s.sentinel
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "sentinel myid %s\nsentinel current-epoch %d\n", st.myID, st.currentEpoch)
+	for _, name := range s.sentinelSortedMasterNames() {
+		m := st.masters[name]
+		// 故障转移完成前仍以旧主节点的地址保存，完成后再切换
+		fmt.Fprintf(&sb, "sentinel monitor %s %s %d %d\n", m.name, m.ip, m.port, m.quorum)
+		fmt.Fprintf(&sb, "sentinel down-after-milliseconds %s %d\n", m.name, m.downAfter)
+		fmt.Fprintf(&sb, "sentinel failover-timeout %s %d\n", m.name, m.failoverTimeout)
+		fmt.Fprintf(&sb, "sentinel parallel-syncs %s %d\n", m.name, m.parallelSyncs)
+		fmt.Fprintf(&sb, "sentinel config-epoch %s %d\n", m.name, m.configEpoch)
+		fmt.Fprintf(&sb, "sentinel leader-epoch %s %d\n", m.name, m.leaderEpoch)
+		for _, r := range sortedInstances(m.replicas) {
+			fmt.Fprintf(&sb, "sentinel known-replica %s %s %d\n", m.name, r.ip, r.port)
+		}
+		for _, si := range sortedInstances(m.sentinels) {
+			if si.runID != "" {
+				fmt.Fprintf(&sb, "sentinel known-sentinel %s %s %d %s\n", m.name, si.ip, si.port, si.runID)
+			}
+		}
+	}
+	err := writeFileAtomic(s.sentinelConfigPath(), "temp-*.conf", func(w io.Writer) error {
+		_, err := io.WriteString(w, sb.String())
+		return err
+	})
+	if err != nil {
+		slog.Error("save sentinel config error", "err", err)
+	}
+	return err
+}
+
+// sortedInstances 按名称排列实例，使输出稳定
+func sortedInstances(m map[string]*sentinelInstance) []*sentinelInstance {
+	res := make([]*sentinelInstance, 0, len(m))
+	for _, ri := range m {
+		res = append(res, ri)
+	}
+	slices.SortFunc(res, func(a, b *sentinelInstance) int { return strings.Compare(a.name, b.name) })
+	return res
+}
+
+func (s *Service) sentinelSortedMasterNames() []string {
+	names := make([]string, 0, len(s.sentinel.masters))
+	for name := range s.sentinel.masters {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// sentinelMasterNames ROLE中哨兵监控的主节点名称
+func (s *Service) sentinelMasterNames() resp.Array {
+	res := resp.Array{}
+	for _, name := range s.sentinelSortedMasterNames() {
+		res = append(res, resp.BulkStrings(name))
+	}
+	return res
+}
+
+// sentinelEvent 记录事件并发布到本哨兵上以事件类型命名的频道。
+// 消息为<type> <name> <ip> <port>，非主节点后跟@ <master name> <master ip> <master port>
+func (s *Service) sentinelEvent(ri *sentinelInstance, typ, format string, args ...any) {
+	msg := fmt.Sprintf("%s %s %s %d", ri.typeName(), ri.name, ri.ip, ri.port)
+	if ri.master != nil {
+		msg += fmt.Sprintf(" @ %s %s %d", ri.master.name, ri.master.ip, ri.master.port)
+	}
+	if format != "" {
+		msg += " " + fmt.Sprintf(format, args...)
+	}
+	if strings.HasPrefix(typ, "-") || typ == "+sdown" || typ == "+odown" || typ == "+switch-master" {
+		slog.Warn(typ, "event", msg)
+	} else {
+		slog.Info(typ, "event", msg)
+	}
+	s.pubsubPublish(pubsubClassic, typ, msg)
+}
+
+// sentinelCreateReplica 为主节点加入ip:port上的副本，已存在时返回nil
+func (s *Service) sentinelCreateReplica(master *sentinelInstance, ip string, port int) *sentinelInstance {
+	name := net.JoinHostPort(ip, strconv.Itoa(port))
+	if _, ok := master.replicas[name]; ok {
+		return nil
+	}
+	r := createSentinelInstance(name, sriReplica, ip, port, master)
+	master.replicas[name] = r
+	return r
+}
+
+// sentinelReleaseInstance 释放实例的连接，连接中的goroutine结束后丢弃结果
+func (s *Service) sentinelReleaseInstance(ri *sentinelInstance) {
+	ri.removed = true
+	if ri.link != nil {
+		s.sentinelFreeLink(ri.link)
+	}
+	if ri.pubsubLink != nil {
+		s.sentinelFreeLink(ri.pubsubLink)
+	}
+}
+
+// sentinelReconnectInstance 为断开的实例在后台重新建立命令连接与订阅连接
+func (s *Service) sentinelReconnectInstance(ri *sentinelInstance) {
+	if ri.link == nil && !ri.connecting {
+		ri.connecting = true
+		s.sentinelConnect(ri, false)
+	}
+	if !ri.hasFlag(sriSentinel) && ri.pubsubLink == nil && !ri.pubsubConnecting {
+		ri.pubsubConnecting = true
+		s.sentinelConnect(ri, true)
+	}
+}
+
+// sentinelConnect 在goroutine中连接实例，结果交给事件循环
+func (s *Service) sentinelConnect(ri *sentinelInstance, pubsub bool) {
+	addr := ri.addr()
+	go func() {
+		conn, err := net.DialTimeout("tcp", addr, sentinelPingPeriod*time.Millisecond)
+		select {
+		case s.sentinelLinkCh <- sentinelLinkEvent{inst: ri, pubsub: pubsub, conn: conn, err: err}:
+		case <-s.quitPeerCh:
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}()
+}
+
+// sentinelLinkConnected 连接建立后开始读取回复。命令连接立即发送PING，订阅连接订阅hello频道
+func (s *Service) sentinelLinkConnected(ev sentinelLinkEvent) {
+	ri := ev.inst
+	if ev.pubsub {
+		ri.pubsubConnecting = false
+	} else {
+		ri.connecting = false
+	}
+	if ev.err != nil {
+		slog.Debug("sentinel connect error", "addr", ri.addr(), "err", ev.err)
+		return
+	}
+	if ri.removed {
+		ev.conn.Close()
+		return
+	}
+	link := &sentinelLink{conn: ev.conn, wr: resp.NewWriter(ev.conn), inst: ri, pubsub: ev.pubsub}
+	go s.sentinelReadLink(link)
+	if ev.pubsub {
+		ri.pubsubLink = link
+		s.sentinelSendCommand(link, nil, CommentSubscribe, sentinelHelloChannel)
+		return
+	}
+	ri.link = link
+	ri.linkCtime = mstime()
+	s.sentinelSendPing(ri)
+}
+
+// sentinelReadLink 在goroutine中读取回复并交给事件循环，连接断开时结束
+func (s *Service) sentinelReadLink(link *sentinelLink) {
+	rd := resp.NewReader(link.conn)
+	for {
+		reply, err := rd.ReadValue()
+		select {
+		case s.sentinelReplyCh <- sentinelReply{link: link, reply: reply, err: err}:
+		case <-s.quitPeerCh:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// sentinelFreeLink 关闭连接。命令连接上尚未回复的PING仍计入下线判断
+func (s *Service) sentinelFreeLink(link *sentinelLink) {
+	if link.freed {
+		return
+	}
+	link.freed = true
+	link.conn.Close()
+	ri := link.inst
+	if ri.link == link {
+		ri.link = nil
+	}
+	if ri.pubsubLink == link {
+		ri.pubsubLink = nil
+	}
+}
+
+// sentinelFreeAllLinks 关闭全部连接，服务退出时调用
+func (s *Service) sentinelFreeAllLinks() {
+	if s.sentinel == nil {
+		return
+	}
+	for _, m := range s.sentinel.masters {
+		s.sentinelReleaseInstance(m)
+		for _, r := range m.replicas {
+			s.sentinelReleaseInstance(r)
+		}
+		for _, si := range m.sentinels {
+			s.sentinelReleaseInstance(si)
+		}
+	}
+}
+
+// sentinelSendCommand 发送命令并登记回调，cb为nil时忽略回复。写入失败时关闭连接并返回false
+func (s *Service) sentinelSendCommand(link *sentinelLink, cb func(reply any), args ...string) bool {
+	if link == nil || link.freed {
+		return false
+	}
+	link.conn.SetWriteDeadline(time.Now().Add(sentinelPingPeriod * time.Millisecond))
+	err := catAppendOnlyCommand(link.wr, args)
+	if err == nil {
+		err = link.wr.Flush()
+	}
+	if err != nil {
+		slog.Debug("sentinel write error", "addr", link.inst.addr(), "err", err)
+		s.sentinelFreeLink(link)
+		return false
+	}
+	if !link.pubsub {
+		link.callbacks = append(link.callbacks, cb)
+	}
+	return true
+}
+
+// sentinelHandleReply 在事件循环中处理连接上读到的回复
+func (s *Service) sentinelHandleReply(r sentinelReply) {
+	link := r.link
+	if link.freed {
+		return
+	}
+	if r.err != nil {
+		s.sentinelFreeLink(link)
+		return
+	}
+	if link.pubsub {
+		// message sentinelHelloChannel payload
+		msg, ok := r.reply.(resp.Array)
+		if ok && len(msg) == 3 && fmt.Sprint(msg[0]) == "message" {
+			s.sentinelProcessHelloMessage(fmt.Sprint(msg[2]))
+		}
+		return
+	}
+	if len(link.callbacks) == 0 {
+		return
+	}
+	cb := link.callbacks[0]
+	link.callbacks = link.callbacks[1:]
+	if cb != nil {
+		cb(r.reply)
+	}
+}
+
+// sentinelSendPing 发送PING，有效的回复为PONG、LOADING或MASTERDOWN
+func (s *Service) sentinelSendPing(ri *sentinelInstance) bool {
+	ok := s.sentinelSendCommand(ri.link, func(reply any) {
+		var text string
+		switch v := reply.(type) {
+		case string:
+			text = v
+		case error:
+			text = v.Error()
+		}
+		if strings.HasPrefix(text, "PONG") || strings.HasPrefix(text, "LOADING") || strings.HasPrefix(text, "MASTERDOWN") {
+			ri.lastAvailTime = mstime()
+			ri.actPingTime = 0
+		}
+		ri.lastPongTime = mstime()
+	}, CommentPing)
+	if ok {
+		ri.lastPingTime = mstime()
+		if ri.actPingTime == 0 {
+			ri.actPingTime = ri.lastPingTime
+		}
+	}
+	return ok
+}
+
+// sentinelSendHello 在实例上发布hello：本哨兵的ip,port,runid,currentEpoch以及
+// 主节点的name,ip,port,configEpoch
+func (s *Service) sentinelSendHello(ri *sentinelInstance) bool {
+	if ri.link == nil {
+		return false
+	}
+	master := ri
+	if ri.master != nil {
+		master = ri.master
+	}
+	ip, _, err := net.SplitHostPort(ri.link.conn.LocalAddr().String())
+	if err != nil {
+		return false
+	}
+	mip, mport := s.sentinelGetCurrentMasterAddress(master)
+	payload := fmt.Sprintf("%s,%d,%s,%d,%s,%s,%d,%d", ip, s.sentinel.announcePort, s.sentinel.myID,
+		s.sentinel.currentEpoch, master.name, mip, mport, master.configEpoch)
+	ok := s.sentinelSendCommand(ri.link, nil, CommentPublish, sentinelHelloChannel, payload)
+	if ok {
+		ri.lastPubTime = mstime()
+	}
+	return ok
+}
+
+// sentinelForceHelloUpdateForMaster 使主节点与其副本尽快发布hello，传播新的配置
+func (s *Service) sentinelForceHelloUpdateForMaster(master *sentinelInstance) {
+	master.lastPubTime = 0
+	for _, r := range master.replicas {
+		r.lastPubTime = 0
+	}
+}
+
+// sentinelProcessHelloMessage 处理hello：发现新的哨兵，更新纪元，
+// 其他哨兵通告的主节点配置更新时切换到新的地址
+func (s *Service) sentinelProcessHelloMessage(payload string) {
+	st := s.sentinel
+	token := strings.Split(payload, ",")
+	if len(token) != 8 {
+		return
+	}
+	port, err1 := strconv.Atoi(token[1])
+	epoch, err2 := strconv.ParseInt(token[3], 10, 64)
+	mport, err3 := strconv.Atoi(token[6])
+	masterEpoch, err4 := strconv.ParseInt(token[7], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return
+	}
+	ip, runID, mip := token[0], token[2], token[5]
+	if runID == st.myID {
+		return
+	}
+	master := st.masters[token[4]]
+	if master == nil {
+		return
+	}
+	name := net.JoinHostPort(ip, token[1])
+	si := master.sentinels[name]
+	if si == nil || si.runID != runID {
+		// 同一哨兵换了地址，或地址上换了另一个哨兵
+		for key, other := range master.sentinels {
+			if other.runID == runID || key == name {
+				s.sentinelReleaseInstance(other)
+				delete(master.sentinels, key)
+			}
+		}
+		si = createSentinelInstance(name, sriSentinel, ip, port, master)
+		si.runID = runID
+		master.sentinels[name] = si
+		s.sentinelEvent(si, "+sentinel", "")
+		s.sentinelFlushConfig()
+	}
+	if epoch > st.currentEpoch {
+		st.currentEpoch = epoch
+		s.sentinelFlushConfig()
+		s.sentinelEvent(master, "+new-epoch", "%d", epoch)
+	}
+	if master.configEpoch < masterEpoch {
+		master.configEpoch = masterEpoch
+		if mip != master.ip || mport != master.port {
+			s.sentinelEvent(si, "+config-update-from", "")
+			s.sentinelEvent(master, "+switch-master", "%s %s %d %s %d", master.name, master.ip, master.port, mip, mport)
+			s.sentinelResetMasterAndChangeAddress(master, mip, mport)
+		}
+		s.sentinelFlushConfig()
+	}
+	si.lastHelloTime = mstime()
+}
+
+// sentinelGetCurrentMasterAddress 返回主节点当前的地址，故障转移中新主节点已提升后为新主节点的地址
+func (s *Service) sentinelGetCurrentMasterAddress(master *sentinelInstance) (string, int) {
+	if master.hasFlag(sriFailoverInProgress) && master.promoted != nil && master.failoverState >= failoverStateReconfSlaves {
+		return master.promoted.ip, master.promoted.port
+	}
+	return master.ip, master.port
+}
+
+// sentinelResetMaster 清除主节点的副本、连接与故障转移状态，保留已知的哨兵
+func (s *Service) sentinelResetMaster(master *sentinelInstance) {
+	for _, r := range master.replicas {
+		s.sentinelReleaseInstance(r)
+	}
+	master.replicas = make(map[string]*sentinelInstance)
+	if master.link != nil {
+		s.sentinelFreeLink(master.link)
+	}
+	if master.pubsubLink != nil {
+		s.sentinelFreeLink(master.pubsubLink)
+	}
+	now := mstime()
+	master.flags &= sriMaster
+	master.failoverState = failoverStateNone
+	master.failoverStateChangeTime = 0
+	master.failoverStartTime = 0
+	master.promoted = nil
+	master.runID = ""
+	master.infoRefresh = 0
+	master.actPingTime, master.lastAvailTime, master.lastPongTime = now, now, now
+	master.roleReported, master.roleReportedTime = sriMaster, now
+}
+
+// sentinelResetMasterAndChangeAddress 主节点切换到ip:port，原有的副本与旧的主节点作为新主节点的副本
+func (s *Service) sentinelResetMasterAndChangeAddress(master *sentinelInstance, ip string, port int) {
+	newName := net.JoinHostPort(ip, strconv.Itoa(port))
+	var replicas [][2]string
+	for _, r := range master.replicas {
+		if r.name != newName {
+			replicas = append(replicas, [2]string{r.ip, strconv.Itoa(r.port)})
+		}
+	}
+	if master.addr() != newName {
+		replicas = append(replicas, [2]string{master.ip, strconv.Itoa(master.port)})
+	}
+	s.sentinelResetMaster(master)
+	master.ip, master.port = ip, port
+	for _, r := range replicas {
+		port, _ := strconv.Atoi(r[1])
+		s.sentinelCreateReplica(master, r[0], port)
+	}
+	s.sentinelFlushConfig()
+}
+
+// sentinelSendPeriodicCommands 周期性地发送INFO、PING与hello。主节点客观下线或故障转移中时
+// 每秒向副本发送INFO，以便及时得知副本的状态变化
+func (s *Service) sentinelSendPeriodicCommands(ri *sentinelInstance) {
+	if ri.link == nil || len(ri.link.callbacks) >= sentinelMaxPendingCommands {
+		return
+	}
+	now := mstime()
+	infoPeriod := int64(sentinelInfoPeriod)
+	if ri.hasFlag(sriReplica) && (ri.master.hasFlag(sriODown|sriFailoverInProgress) || ri.masterLinkDownTime != 0) {
+		infoPeriod = 1000
+	}
+	pingPeriod := min(ri.downAfter, sentinelPingPeriod)
+	if !ri.hasFlag(sriSentinel) && (ri.infoRefresh == 0 || now-ri.infoRefresh > infoPeriod) {
+		s.sentinelSendCommand(ri.link, func(reply any) {
+			switch v := reply.(type) {
+			case resp.BulkStrings:
+				s.sentinelRefreshInstanceInfo(ri, string(v))
+			case resp.Verbatim:
+				s.sentinelRefreshInstanceInfo(ri, string(v.Data))
+			}
+		}, CommentInfo)
+	}
+	if now-ri.lastPongTime > pingPeriod && now-ri.lastPingTime > pingPeriod/2 {
+		s.sentinelSendPing(ri)
+	}
+	if !ri.hasFlag(sriSentinel) && now-ri.lastPubTime > sentinelPublishPeriod {
+		s.sentinelSendHello(ri)
+	}
+}
+
+// sentinelRefreshInstanceInfo 解析INFO：发现主节点的副本，记录副本的复制状态，
+// 检测故障转移中被提升的副本是否已成为主节点，以及副本是否需要重新配置
+func (s *Service) sentinelRefreshInstanceInfo(ri *sentinelInstance, info string) {
+	if ri.removed {
+		return
+	}
+	now := mstime()
+	role := 0
+	ri.masterLinkDownTime = 0
+	for _, line := range strings.Split(info, "\r\n") {
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch {
+		case key == "run_id" && len(val) == 40:
+			if ri.runID != val {
+				if ri.runID != "" {
+					s.sentinelEvent(ri, "+reboot", "")
+				}
+				ri.runID = val
+			}
+		case key == "role":
+			switch val {
+			case "master":
+				role = sriMaster
+			case "slave":
+				role = sriReplica
+			}
+		case strings.HasPrefix(key, "slave") && ri.hasFlag(sriMaster):
+			// slave0:ip=127.0.0.1,port=6380,state=online,offset=0,lag=0
+			var ip string
+			var port int
+			for _, kv := range strings.Split(val, ",") {
+				k, v, _ := strings.Cut(kv, "=")
+				switch k {
+				case "ip":
+					ip = v
+				case "port":
+					port, _ = strconv.Atoi(v)
+				}
+			}
+			if ip != "" && port > 0 {
+				if r := s.sentinelCreateReplica(ri, ip, port); r != nil {
+					s.sentinelEvent(r, "+slave", "")
+					s.sentinelFlushConfig()
+				}
+			}
+		case key == "master_link_down_since_seconds":
+			secs, _ := strconv.ParseInt(val, 10, 64)
+			ri.masterLinkDownTime = secs * 1000
+		case key == "master_host":
+			ri.replMasterHost = val
+		case key == "master_port":
+			ri.replMasterPort, _ = strconv.Atoi(val)
+		case key == "master_link_status":
+			ri.replMasterLinkUp = val == "up"
+		case key == "slave_priority":
+			ri.replPriority, _ = strconv.Atoi(val)
+		case key == "slave_repl_offset":
+			ri.replOffset, _ = strconv.ParseInt(val, 10, 64)
+		}
+	}
+	ri.infoRefresh = now
+	if role != 0 && role != ri.roleReported {
+		ri.roleReported = role
+		ri.roleReportedTime = now
+		s.sentinelEvent(ri, "-role-change", "new reported role is %s", map[int]string{sriMaster: "master", sriReplica: "slave"}[role])
+	}
+	if !ri.hasFlag(sriReplica) {
+		return
+	}
+	master := ri.master
+
+	if role == sriMaster {
+		if ri.hasFlag(sriPromoted) && master.hasFlag(sriFailoverInProgress) && master.failoverState == failoverStateWaitPromotion {
+			// 提升成功，新的配置使用本次故障转移的纪元
+			master.configEpoch = master.failoverEpoch
+			master.failoverState = failoverStateReconfSlaves
+			master.failoverStateChangeTime = now
+			s.sentinelFlushConfig()
+			s.sentinelEvent(ri, "+promoted-slave", "")
+			s.sentinelEvent(master, "+failover-state-reconf-slaves", "")
+			s.sentinelForceHelloUpdateForMaster(master)
+			return
+		}
+		// 副本报告自己是主节点（例如下线后恢复的旧主节点），一段时间后仍如此时改为复制当前的主节点
+		wait := int64(sentinelPublishPeriod * 4)
+		if !ri.hasFlag(sriPromoted) && s.sentinelMasterLooksSane(master) && s.sentinelNoDownFor(ri, wait) &&
+			now-ri.roleReportedTime > wait {
+			if s.sentinelSendReplicaOf(ri, master.ip, master.port) {
+				s.sentinelEvent(ri, "+convert-to-slave", "")
+			}
+		}
+		return
+	}
+
+	// 副本复制的不是当前的主节点
+	if role == sriReplica && (ri.replMasterHost != master.ip || ri.replMasterPort != master.port) &&
+		!master.hasFlag(sriFailoverInProgress) {
+		wait := int64(sentinelPublishPeriod * 4)
+		if s.sentinelMasterLooksSane(master) && s.sentinelNoDownFor(ri, wait) && now-ri.slaveReconfSentTime > wait {
+			if s.sentinelSendReplicaOf(ri, master.ip, master.port) {
+				ri.slaveReconfSentTime = now
+				s.sentinelEvent(ri, "+fix-slave-config", "")
+			}
+		}
+	}
+
+	// 故障转移中副本改为复制新主节点的进度：SENT -> INPROG -> DONE
+	if role == sriReplica && master.promoted != nil && ri.hasFlag(sriReconfSent|sriReconfInprog) {
+		if ri.hasFlag(sriReconfSent) && ri.replMasterHost == master.promoted.ip && ri.replMasterPort == master.promoted.port {
+			ri.flags = ri.flags&^sriReconfSent | sriReconfInprog
+			s.sentinelEvent(ri, "+slave-reconf-inprog", "")
+		}
+		if ri.hasFlag(sriReconfInprog) && ri.replMasterLinkUp {
+			ri.flags = ri.flags&^sriReconfInprog | sriReconfDone
+			s.sentinelEvent(ri, "+slave-reconf-done", "")
+		}
+	}
+}
+
+// sentinelMasterLooksSane 主节点报告自己是主节点、没有下线且INFO是新的
+func (s *Service) sentinelMasterLooksSane(master *sentinelInstance) bool {
+	return master.roleReported == sriMaster && !master.hasFlag(sriSDown|sriODown) &&
+		mstime()-master.infoRefresh < sentinelInfoPeriod*2
+}
+
+// sentinelNoDownFor 实例至少ms毫秒内没有处于下线状态
+func (s *Service) sentinelNoDownFor(ri *sentinelInstance, ms int64) bool {
+	since := max(ri.sdownSince, ri.odownSince)
+	return !ri.hasFlag(sriSDown|sriODown) && (since == 0 || mstime()-since > ms)
+}
+
+// sentinelSendReplicaOf 让实例复制ip:port，ip为空时提升为主节点
+func (s *Service) sentinelSendReplicaOf(ri *sentinelInstance, ip string, port int) bool {
+	if ip == "" {
+		return s.sentinelSendCommand(ri.link, nil, CommentReplicaOf, "NO", "ONE")
+	}
+	return s.sentinelSendCommand(ri.link, nil, CommentReplicaOf, ip, strconv.Itoa(port))
+}
+
+// sentinelCheckSubjectivelyDown 超过downAfter没有收到PING的有效回复，或主节点长时间报告自己是副本时，
+// 认为实例主观下线
+func (s *Service) sentinelCheckSubjectivelyDown(ri *sentinelInstance) {
+	now := mstime()
+	var elapsed int64
+	if ri.actPingTime != 0 {
+		elapsed = now - ri.actPingTime
+	} else if ri.link == nil {
+		elapsed = now - ri.lastAvailTime
+	}
+	// 连接可能已失效，PING长时间没有回复时重建连接
+	if ri.link != nil && now-ri.linkCtime >= sentinelMinLinkReconnectPeriod && ri.actPingTime != 0 &&
+		now-ri.actPingTime > ri.downAfter/2 && now-ri.lastPongTime > ri.downAfter/2 {
+		s.sentinelFreeLink(ri.link)
+	}
+	down := elapsed > ri.downAfter ||
+		(ri.hasFlag(sriMaster) && ri.roleReported == sriReplica && now-ri.roleReportedTime > ri.downAfter+sentinelInfoPeriod*2)
+	if down {
+		if !ri.hasFlag(sriSDown) {
+			s.sentinelEvent(ri, "+sdown", "")
+			ri.sdownSince = now
+			ri.flags |= sriSDown
+		}
+	} else if ri.hasFlag(sriSDown) {
+		s.sentinelEvent(ri, "-sdown", "")
+		ri.flags &^= sriSDown
+	}
+}
+
+// sentinelCheckObjectivelyDown 认为主节点已下线的哨兵（含本哨兵）达到quorum时，主节点客观下线
+func (s *Service) sentinelCheckObjectivelyDown(master *sentinelInstance) {
+	quorum := 0
+	odown := false
+	if master.hasFlag(sriSDown) {
+		quorum = 1
+		for _, si := range master.sentinels {
+			if si.hasFlag(sriMasterDown) {
+				quorum++
+			}
+		}
+		odown = quorum >= master.quorum
+	}
+	if odown {
+		if !master.hasFlag(sriODown) {
+			s.sentinelEvent(master, "+odown", "#quorum %d/%d", quorum, master.quorum)
+			master.flags |= sriODown
+			master.odownSince = mstime()
+		}
+	} else if master.hasFlag(sriODown) {
+		s.sentinelEvent(master, "-odown", "")
+		master.flags &^= sriODown
+	}
+}
+
+// sentinelAskMasterStateToOtherSentinels 主节点主观下线时询问其他哨兵是否也认为其已下线，
+// 故障转移开始后同时请求其他哨兵投票。force为true时立即询问
+func (s *Service) sentinelAskMasterStateToOtherSentinels(master *sentinelInstance, force bool) {
+	now := mstime()
+	for _, si := range master.sentinels {
+		elapsed := now - si.lastMasterDownReplyTime
+		// 过期的回复不再有效
+		if elapsed > sentinelAskPeriod*5 {
+			si.flags &^= sriMasterDown
+			si.leader = ""
+		}
+		if !master.hasFlag(sriSDown) || si.link == nil {
+			continue
+		}
+		if !force && elapsed < sentinelAskPeriod {
+			continue
+		}
+		runID := "*"
+		if master.failoverState > failoverStateNone {
+			runID = s.sentinel.myID
+		}
+		s.sentinelSendCommand(si.link, func(reply any) {
+			// [down_state, leader_runid, leader_epoch]
+			r, ok := reply.(resp.Array)
+			if !ok || len(r) != 3 {
+				return
+			}
+			down, ok1 := r[0].(int64)
+			leader, ok2 := r[1].(resp.BulkStrings)
+			epoch, ok3 := r[2].(int64)
+			if !ok1 || !ok2 || !ok3 {
+				return
+			}
+			si.lastMasterDownReplyTime = mstime()
+			if down == 1 {
+				si.flags |= sriMasterDown
+			} else {
+				si.flags &^= sriMasterDown
+			}
+			if leader != "*" {
+				si.leader, si.leaderEpoch = string(leader), epoch
+			}
+		}, CommentSentinel, "is-master-down-by-addr", master.ip, strconv.Itoa(master.port),
+			strconv.FormatInt(s.sentinel.currentEpoch, 10), runID)
+	}
+}
+
+// sentinelVoteLeader 在reqEpoch中投票给reqRunID，每个纪元只投一次票，返回本哨兵在该主节点上的投票
+func (s *Service) sentinelVoteLeader(master *sentinelInstance, reqEpoch int64, reqRunID string) (string, int64) {
+	st := s.sentinel
+	if reqEpoch > st.currentEpoch {
+		st.currentEpoch = reqEpoch
+		s.sentinelFlushConfig()
+		s.sentinelEvent(master, "+new-epoch", "%d", st.currentEpoch)
+	}
+	if master.leaderEpoch < reqEpoch && st.currentEpoch <= reqEpoch {
+		master.leader = reqRunID
+		master.leaderEpoch = st.currentEpoch
+		s.sentinelFlushConfig()
+		s.sentinelEvent(master, "+vote-for-leader", "%s %d", master.leader, master.leaderEpoch)
+		// 投票给其他哨兵后推迟自身发起故障转移，避免同时发起
+		if reqRunID != st.myID {
+			master.failoverStartTime = mstime() + rand.Int64N(sentinelMaxDesync)
+		}
+	}
+	return master.leader, master.leaderEpoch
+}
+
+// sentinelGetLeader 统计epoch中的投票，得票数同时达到多数与quorum的哨兵当选，否则返回空
+func (s *Service) sentinelGetLeader(master *sentinelInstance, epoch int64) string {
+	counters := make(map[string]int)
+	voters := len(master.sentinels) + 1
+	for _, si := range master.sentinels {
+		if si.leader != "" && si.leaderEpoch == s.sentinel.currentEpoch {
+			counters[si.leader]++
+		}
+	}
+	winner, maxVotes := "", 0
+	pick := func() {
+		for runID, votes := range counters {
+			if votes > maxVotes || (votes == maxVotes && runID < winner) {
+				winner, maxVotes = runID, votes
+			}
+		}
+	}
+	pick()
+	// 本哨兵投给当前得票最多的哨兵，没有时投给自己
+	candidate := winner
+	if candidate == "" {
+		candidate = s.sentinel.myID
+	}
+	if myVote, voteEpoch := s.sentinelVoteLeader(master, epoch, candidate); myVote != "" && voteEpoch == epoch {
+		counters[myVote]++
+		pick()
+	}
+	if winner != "" && (maxVotes < voters/2+1 || maxVotes < master.quorum) {
+		return ""
+	}
+	return winner
+}
+
+// sentinelStartFailoverIfNeeded 主节点客观下线且距上次故障转移足够久时发起故障转移
+func (s *Service) sentinelStartFailoverIfNeeded(master *sentinelInstance) bool {
+	if !master.hasFlag(sriODown) || master.hasFlag(sriFailoverInProgress) {
+		return false
+	}
+	if mstime()-master.failoverStartTime < master.failoverTimeout*2 {
+		return false
+	}
+	s.sentinelStartFailover(master)
+	return true
+}
+
+// sentinelStartFailover 以新的纪元开始故障转移，先等待当选领头哨兵
+func (s *Service) sentinelStartFailover(master *sentinelInstance) {
+	st := s.sentinel
+	master.failoverState = failoverStateWaitStart
+	master.flags |= sriFailoverInProgress
+	st.currentEpoch++
+	master.failoverEpoch = st.currentEpoch
+	s.sentinelFlushConfig()
+	s.sentinelEvent(master, "+new-epoch", "%d", st.currentEpoch)
+	s.sentinelEvent(master, "+try-failover", "")
+	master.failoverStartTime = mstime() + rand.Int64N(sentinelMaxDesync)
+	master.failoverStateChangeTime = mstime()
+}
+
+// sentinelAbortFailover 放弃故障转移，被选中的副本不再标记为提升
+func (s *Service) sentinelAbortFailover(master *sentinelInstance) {
+	master.flags &^= sriFailoverInProgress | sriForceFailover
+	master.failoverState = failoverStateNone
+	master.failoverStateChangeTime = mstime()
+	if master.promoted != nil {
+		master.promoted.flags &^= sriPromoted
+		master.promoted = nil
+	}
+	for _, r := range master.replicas {
+		r.flags &^= sriReconfSent | sriReconfInprog | sriReconfDone
+	}
+}
+
+// sentinelSelectSlave 选择提升为主节点的副本：排除下线、断开、优先级为0以及与主节点断开过久的副本，
+// 按优先级从小到大、复制偏移量从大到小、runid从小到大选择第一个
+func (s *Service) sentinelSelectSlave(master *sentinelInstance) *sentinelInstance {
+	now := mstime()
+	maxMasterDownTime := master.downAfter * 10
+	if master.hasFlag(sriSDown) {
+		maxMasterDownTime += now - master.sdownSince
+	}
+	infoValidity := int64(sentinelInfoPeriod * 3)
+	if master.hasFlag(sriSDown) {
+		infoValidity = sentinelPingPeriod * 5
+	}
+	var candidates []*sentinelInstance
+	for _, r := range master.replicas {
+		if r.hasFlag(sriSDown|sriODown) || r.link == nil || now-r.lastAvailTime > sentinelPingPeriod*5 ||
+			r.replPriority == 0 || now-r.infoRefresh > infoValidity || r.masterLinkDownTime > maxMasterDownTime {
+			continue
+		}
+		candidates = append(candidates, r)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	slices.SortFunc(candidates, func(a, b *sentinelInstance) int {
+		if a.replPriority != b.replPriority {
+			return a.replPriority - b.replPriority
+		}
+		if a.replOffset != b.replOffset {
+			if a.replOffset > b.replOffset {
+				return -1
+			}
+			return 1
+		}
+		// 没有runid的副本排在后面
+		if (a.runID == "") != (b.runID == "") {
+			if a.runID == "" {
+				return 1
+			}
+			return -1
+		}
+		return strings.Compare(a.runID, b.runID)
+	})
+	return candidates[0]
+}
+
+// sentinelFailoverStateMachine 推进故障转移
+func (s *Service) sentinelFailoverStateMachine(master *sentinelInstance) {
+	if !master.hasFlag(sriFailoverInProgress) {
+		return
+	}
+	now := mstime()
+	switch master.failoverState {
+	case failoverStateWaitStart:
+		leader := s.sentinelGetLeader(master, master.failoverEpoch)
+		if leader != s.sentinel.myID && !master.hasFlag(sriForceFailover) {
+			if now-master.failoverStartTime > min(sentinelElectionTimeout, master.failoverTimeout) {
+				s.sentinelEvent(master, "-failover-abort-not-elected", "")
+				s.sentinelAbortFailover(master)
+			}
+			return
+		}
+		s.sentinelEvent(master, "+elected-leader", "")
+		s.sentinelSetFailoverState(master, failoverStateSelectSlave)
+	case failoverStateSelectSlave:
+		r := s.sentinelSelectSlave(master)
+		if r == nil {
+			s.sentinelEvent(master, "-failover-abort-no-good-slave", "")
+			s.sentinelAbortFailover(master)
+			return
+		}
+		s.sentinelEvent(r, "+selected-slave", "")
+		r.flags |= sriPromoted
+		master.promoted = r
+		s.sentinelSetFailoverState(master, failoverStateSendSlaveofNoone)
+	case failoverStateSendSlaveofNoone:
+		r := master.promoted
+		if r.link == nil {
+			if now-master.failoverStateChangeTime > master.failoverTimeout {
+				s.sentinelEvent(r, "-failover-abort-slave-timeout", "")
+				s.sentinelAbortFailover(master)
+			}
+			return
+		}
+		if !s.sentinelSendReplicaOf(r, "", 0) {
+			return
+		}
+		s.sentinelEvent(r, "+failover-state-send-slaveof-noone", "")
+		s.sentinelSetFailoverState(master, failoverStateWaitPromotion)
+	case failoverStateWaitPromotion:
+		// 由INFO检测到提升完成，超时则放弃
+		if now-master.failoverStateChangeTime > master.failoverTimeout {
+			s.sentinelEvent(master.promoted, "-failover-abort-slave-timeout", "")
+			s.sentinelAbortFailover(master)
+		}
+	case failoverStateReconfSlaves:
+		s.sentinelFailoverReconfNextSlave(master)
+	}
+}
+
+// sentinelSetFailoverState 进入故障转移的下一个状态
+func (s *Service) sentinelSetFailoverState(master *sentinelInstance, state int) {
+	master.failoverState = state
+	master.failoverStateChangeTime = mstime()
+	if state != failoverStateWaitPromotion {
+		s.sentinelEvent(master, "+failover-state-"+strings.ReplaceAll(failoverStateNames[state], "_", "-"), "")
+	}
+}
+
+// sentinelFailoverReconfNextSlave 让其余副本复制新主节点，同时进行的不超过parallelSyncs个
+func (s *Service) sentinelFailoverReconfNextSlave(master *sentinelInstance) {
+	now := mstime()
+	inProgress := 0
+	for _, r := range master.replicas {
+		if r.hasFlag(sriReconfSent | sriReconfInprog) {
+			inProgress++
+		}
+	}
+	for _, r := range sortedInstances(master.replicas) {
+		if inProgress >= master.parallelSyncs {
+			break
+		}
+		if r.hasFlag(sriPromoted | sriReconfDone) {
+			continue
+		}
+		// 副本迟迟没有开始同步时不再等待
+		if r.hasFlag(sriReconfSent) && now-r.slaveReconfSentTime > sentinelSlaveReconfTimeout {
+			s.sentinelEvent(r, "-slave-reconf-sent-timeout", "")
+			r.flags = r.flags&^sriReconfSent | sriReconfDone
+			continue
+		}
+		if r.hasFlag(sriReconfSent|sriReconfInprog) || r.link == nil {
+			continue
+		}
+		if s.sentinelSendReplicaOf(r, master.promoted.ip, master.promoted.port) {
+			r.flags |= sriReconfSent
+			r.slaveReconfSentTime = now
+			s.sentinelEvent(r, "+slave-reconf-sent", "")
+			inProgress++
+		}
+	}
+	s.sentinelFailoverDetectEnd(master)
+}
+
+// sentinelFailoverDetectEnd 其余副本都已复制新主节点或超时后结束故障转移，超时时尽力再发送一次REPLICAOF
+func (s *Service) sentinelFailoverDetectEnd(master *sentinelInstance) {
+	if master.promoted == nil || master.promoted.hasFlag(sriSDown) {
+		return
+	}
+	notReconfigured := 0
+	for _, r := range master.replicas {
+		if !r.hasFlag(sriPromoted | sriReconfDone | sriSDown) {
+			notReconfigured++
+		}
+	}
+	timeout := mstime()-master.failoverStateChangeTime > master.failoverTimeout
+	if notReconfigured > 0 && !timeout {
+		return
+	}
+	if timeout {
+		s.sentinelEvent(master, "-failover-end-for-timeout", "")
+		for _, r := range master.replicas {
+			if !r.hasFlag(sriPromoted | sriReconfDone | sriReconfSent) {
+				if s.sentinelSendReplicaOf(r, master.promoted.ip, master.promoted.port) {
+					r.flags |= sriReconfSent
+				}
+			}
+		}
+	}
+	s.sentinelEvent(master, "+failover-end", "")
+	s.sentinelSetFailoverState(master, failoverStateUpdateConfig)
+}
+
+// sentinelFailoverSwitchToPromotedSlave 故障转移结束，以新主节点代替旧主节点
+func (s *Service) sentinelFailoverSwitchToPromotedSlave(master *sentinelInstance) {
+	r := master.promoted
+	s.sentinelEvent(master, "+switch-master", "%s %s %d %s %d", master.name, master.ip, master.port, r.ip, r.port)
+	s.sentinelResetMasterAndChangeAddress(master, r.ip, r.port)
+}
+
+// sentinelHandleInstance 对一个实例执行周期性的检查
+func (s *Service) sentinelHandleInstance(ri *sentinelInstance) {
+	s.sentinelReconnectInstance(ri)
+	s.sentinelSendPeriodicCommands(ri)
+	s.sentinelCheckSubjectivelyDown(ri)
+	if !ri.hasFlag(sriMaster) {
+		return
+	}
+	s.sentinelCheckObjectivelyDown(ri)
+	if s.sentinelStartFailoverIfNeeded(ri) {
+		s.sentinelAskMasterStateToOtherSentinels(ri, true)
+	}
+	s.sentinelFailoverStateMachine(ri)
+	s.sentinelAskMasterStateToOtherSentinels(ri, false)
+}
+
+// sentinelTimer 每次serverCron时处理全部实例
+func (s *Service) sentinelTimer() {
+	if s.sentinel.announcePort == 0 && s.ln != nil {
+		if addr, ok := s.ln.Addr().(*net.TCPAddr); ok {
+			s.sentinel.announcePort = addr.Port
+		}
+	}
+	for _, name := range s.sentinelSortedMasterNames() {
+		m := s.sentinel.masters[name]
+		s.sentinelHandleInstance(m)
+		for _, r := range sortedInstances(m.replicas) {
+			s.sentinelHandleInstance(r)
+		}
+		for _, si := range sortedInstances(m.sentinels) {
+			s.sentinelHandleInstance(si)
+		}
+		if m.failoverState == failoverStateUpdateConfig {
+			s.sentinelFailoverSwitchToPromotedSlave(m)
+		}
+	}
+}
+
+// sentinelSetMasterOption 设置主节点的选项
+func (s *Service) sentinelSetMasterOption(master *sentinelInstance, option, value string) error {
+	n, err := strconv.ParseInt(value, 10, 64)
+	switch strings.ToLower(option) {
+	case "down-after-milliseconds":
+		if err != nil || n <= 0 {
+			return errNotInteger
+		}
+		master.downAfter = n
+		for _, r := range master.replicas {
+			r.downAfter = n
+		}
+		for _, si := range master.sentinels {
+			si.downAfter = n
+		}
+	case "failover-timeout":
+		if err != nil || n <= 0 {
+			return errNotInteger
+		}
+		master.failoverTimeout = n
+	case "parallel-syncs":
+		if err != nil || n <= 0 {
+			return errNotInteger
+		}
+		master.parallelSyncs = int(n)
+	case "quorum":
+		if err != nil || n <= 0 {
+			return errNotInteger
+		}
+		master.quorum = int(n)
+	case "config-epoch":
+		if err != nil {
+			return errNotInteger
+		}
+		master.configEpoch = n
+	case "leader-epoch":
+		if err != nil {
+			return errNotInteger
+		}
+		master.leaderEpoch = n
+	default:
+		return errSyntax
+	}
+	return nil
+}
+
+// sentinelInstanceInfo SENTINEL MASTER/REPLICAS/SENTINELS中一个实例的字段
+func (s *Service) sentinelInstanceInfo(ri *sentinelInstance) resp.Maps {
+	now := mstime()
+	pending := 0
+	if ri.link != nil {
+		pending = len(ri.link.callbacks)
+	}
+	field := func(v any) resp.BulkStrings { return resp.BulkStrings(fmt.Sprint(v)) }
+	lastPingSent := int64(0)
+	if ri.actPingTime != 0 {
+		lastPingSent = now - ri.actPingTime
+	}
+	res := resp.Maps{
+		resp.BulkStrings("name"):                    field(ri.name),
+		resp.BulkStrings("ip"):                      field(ri.ip),
+		resp.BulkStrings("port"):                    field(ri.port),
+		resp.BulkStrings("runid"):                   field(ri.runID),
+		resp.BulkStrings("flags"):                   field(ri.flagsString()),
+		resp.BulkStrings("link-pending-commands"):   field(pending),
+		resp.BulkStrings("last-ping-sent"):          field(lastPingSent),
+		resp.BulkStrings("last-ok-ping-reply"):      field(now - ri.lastAvailTime),
+		resp.BulkStrings("last-ping-reply"):         field(now - ri.lastPongTime),
+		resp.BulkStrings("down-after-milliseconds"): field(ri.downAfter),
+	}
+	if ri.hasFlag(sriSDown) {
+		res[resp.BulkStrings("s-down-time")] = field(now - ri.sdownSince)
+	}
+	if ri.hasFlag(sriODown) {
+		res[resp.BulkStrings("o-down-time")] = field(now - ri.odownSince)
+	}
+	if !ri.hasFlag(sriSentinel) {
+		infoRefresh := int64(0)
+		if ri.infoRefresh != 0 {
+			infoRefresh = now - ri.infoRefresh
+		}
+		role := "master"
+		if ri.roleReported == sriReplica {
+			role = "slave"
+		}
+		res[resp.BulkStrings("info-refresh")] = field(infoRefresh)
+		res[resp.BulkStrings("role-reported")] = field(role)
+		res[resp.BulkStrings("role-reported-time")] = field(now - ri.roleReportedTime)
+	}
+	switch {
+	case ri.hasFlag(sriMaster):
+		res[resp.BulkStrings("config-epoch")] = field(ri.configEpoch)
+		res[resp.BulkStrings("num-slaves")] = field(len(ri.replicas))
+		res[resp.BulkStrings("num-other-sentinels")] = field(len(ri.sentinels))
+		res[resp.BulkStrings("quorum")] = field(ri.quorum)
+		res[resp.BulkStrings("failover-timeout")] = field(ri.failoverTimeout)
+		res[resp.BulkStrings("parallel-syncs")] = field(ri.parallelSyncs)
+		if ri.hasFlag(sriFailoverInProgress) {
+			res[resp.BulkStrings("failover-state")] = field(failoverStateNames[ri.failoverState])
+		}
+	case ri.hasFlag(sriReplica):
+		linkStatus := "err"
+		if ri.replMasterLinkUp {
+			linkStatus = "ok"
+		}
+		res[resp.BulkStrings("master-link-down-time")] = field(ri.masterLinkDownTime)
+		res[resp.BulkStrings("master-link-status")] = field(linkStatus)
+		res[resp.BulkStrings("master-host")] = field(ri.replMasterHost)
+		res[resp.BulkStrings("master-port")] = field(ri.replMasterPort)
+		res[resp.BulkStrings("slave-priority")] = field(ri.replPriority)
+		res[resp.BulkStrings("slave-repl-offset")] = field(ri.replOffset)
+	default:
+		res[resp.BulkStrings("last-hello-message")] = field(now - ri.lastHelloTime)
+		res[resp.BulkStrings("voted-leader")] = field(stringOr(ri.leader, "?"))
+		res[resp.BulkStrings("voted-leader-epoch")] = field(ri.leaderEpoch)
+	}
+	return res
+}
+
+// stringOr 返回a，a为空时返回b
+func stringOr(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a
+}
+
+// genSentinelInfo INFO sentinel
+func (s *Service) genSentinelInfo(sb *strings.Builder) {
+	st := s.sentinel
+	fmt.Fprintf(sb, "sentinel_masters:%d\r\nsentinel_tilt:0\r\nsentinel_running_scripts:0\r\n", len(st.masters))
+	for i, name := range s.sentinelSortedMasterNames() {
+		m := st.masters[name]
+		status := "ok"
+		if m.hasFlag(sriODown) {
+			status = "odown"
+		} else if m.hasFlag(sriSDown) {
+			status = "sdown"
+		}
+		fmt.Fprintf(sb, "master%d:name=%s,status=%s,address=%s,slaves=%d,sentinels=%d\r\n",
+			i, m.name, status, m.addr(), len(m.replicas), len(m.sentinels)+1)
+	}
+}
+
+// sentinelCommand SENTINEL subcommand [arguments ...]
+func sentinelCommand(s *Service, p *Peer, args []string) any {
+	st := s.sentinel
+	sub := strings.ToUpper(args[1])
+	lookup := func(name string) (*sentinelInstance, error) {
+		m := st.masters[name]
+		if m == nil {
+			return nil, errNoSuchMaster
+		}
+		return m, nil
+	}
+	switch {
+	case sub == "MYID" && len(args) == 2:
+		return resp.BulkStrings(st.myID)
+	case sub == "MASTERS" && len(args) == 2:
+		res := resp.Array{}
+		for _, name := range s.sentinelSortedMasterNames() {
+			res = append(res, s.sentinelInstanceInfo(st.masters[name]))
+		}
+		return res
+	case sub == "MASTER" && len(args) == 3:
+		m, err := lookup(args[2])
+		if err != nil {
+			return err
+		}
+		return s.sentinelInstanceInfo(m)
+	case (sub == "REPLICAS" || sub == "SLAVES" || sub == "SENTINELS") && len(args) == 3:
+		m, err := lookup(args[2])
+		if err != nil {
+			return err
+		}
+		instances := m.replicas
+		if sub == "SENTINELS" {
+			instances = m.sentinels
+		}
+		res := resp.Array{}
+		for _, ri := range sortedInstances(instances) {
+			res = append(res, s.sentinelInstanceInfo(ri))
+		}
+		return res
+	case sub == "GET-MASTER-ADDR-BY-NAME" && len(args) == 3:
+		m := st.masters[args[2]]
+		if m == nil {
+			return resp.Array(nil)
+		}
+		ip, port := s.sentinelGetCurrentMasterAddress(m)
+		return resp.Array{resp.BulkStrings(ip), resp.BulkStrings(strconv.Itoa(port))}
+	case sub == "IS-MASTER-DOWN-BY-ADDR" && len(args) == 6:
+		return s.sentinelIsMasterDownByAddr(args[2:])
+	case sub == "MONITOR" && len(args) == 6:
+		if _, ok := st.masters[args[2]]; ok {
+			return errors.New("ERR Duplicated master name")
+		}
+		m, err := parseSentinelMonitor(args[2:])
+		if err != nil {
+			return fmt.Errorf("ERR %s", err)
+		}
+		m.downAfter, m.failoverTimeout = s.SentinelDownAfter, s.SentinelFailoverTimeout
+		st.masters[m.name] = m
+		s.sentinelFlushConfig()
+		s.sentinelEvent(m, "+monitor", "quorum %d", m.quorum)
+		return "OK"
+	case sub == "REMOVE" && len(args) == 3:
+		m, err := lookup(args[2])
+		if err != nil {
+			return err
+		}
+		s.sentinelEvent(m, "-monitor", "")
+		s.sentinelResetMaster(m)
+		for _, si := range m.sentinels {
+			s.sentinelReleaseInstance(si)
+		}
+		s.sentinelReleaseInstance(m)
+		delete(st.masters, m.name)
+		s.sentinelFlushConfig()
+		return "OK"
+	case sub == "SET" && len(args) >= 5 && len(args)%2 == 1:
+		m, err := lookup(args[2])
+		if err != nil {
+			return err
+		}
+		for i := 3; i < len(args); i += 2 {
+			switch strings.ToLower(args[i]) {
+			case "down-after-milliseconds", "failover-timeout", "parallel-syncs", "quorum":
+			default:
+				return fmt.Errorf("ERR Invalid argument '%s' to SENTINEL SET", args[i])
+			}
+			if err := s.sentinelSetMasterOption(m, args[i], args[i+1]); err != nil {
+				return fmt.Errorf("ERR Invalid argument '%s' for SENTINEL SET '%s'", args[i+1], args[i])
+			}
+			s.sentinelEvent(m, "+set", "%s %s", args[i], args[i+1])
+		}
+		s.sentinelFlushConfig()
+		return "OK"
+	case sub == "FAILOVER" && len(args) == 3:
+		m, err := lookup(args[2])
+		if err != nil {
+			return err
+		}
+		if m.hasFlag(sriFailoverInProgress) {
+			return errors.New("INPROG Failover already in progress")
+		}
+		if s.sentinelSelectSlave(m) == nil {
+			return errors.New("NOGOODSLAVE No suitable replica to promote")
+		}
+		s.sentinelStartFailover(m)
+		m.flags |= sriForceFailover
+		return "OK"
+	case sub == "CKQUORUM" && len(args) == 3:
+		m, err := lookup(args[2])
+		if err != nil {
+			return err
+		}
+		usable := 1
+		for _, si := range m.sentinels {
+			if !si.hasFlag(sriSDown | sriODown) {
+				usable++
+			}
+		}
+		voters := len(m.sentinels) + 1
+		if usable < m.quorum {
+			return fmt.Errorf("NOQUORUM %d usable Sentinels. Not enough available Sentinels to reach the specified quorum for this master", usable)
+		}
+		if usable < voters/2+1 {
+			return fmt.Errorf("NOQUORUM %d usable Sentinels. Not enough available Sentinels to reach the majority and authorize a failover", usable)
+		}
+		return fmt.Sprintf("OK %d usable Sentinels. Quorum and failover authorization can be reached", usable)
+	case sub == "RESET" && len(args) == 3:
+		var n int64
+		for _, name := range s.sentinelSortedMasterNames() {
+			m := st.masters[name]
+			if !stringMatch(args[2], name, false) {
+				continue
+			}
+			s.sentinelResetMaster(m)
+			for _, si := range m.sentinels {
+				s.sentinelReleaseInstance(si)
+			}
+			m.sentinels = make(map[string]*sentinelInstance)
+			s.sentinelEvent(m, "+reset-master", "")
+			n++
+		}
+		s.sentinelFlushConfig()
+		return n
+	default:
+		return fmt.Errorf("ERR unknown subcommand or wrong number of arguments for '%.128s'. Try SENTINEL HELP.", args[1])
+	}
+}
+
+// sentinelIsMasterDownByAddr SENTINEL is-master-down-by-addr ip port current-epoch runid
+// 回复[本哨兵是否认为该主节点主观下线, 投票选出的领头哨兵, 投票的纪元]，runid为*时只询问状态不投票
+func (s *Service) sentinelIsMasterDownByAddr(args []string) any {
+	port, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+	reqEpoch, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	var master *sentinelInstance
+	for _, m := range s.sentinel.masters {
+		if m.ip == args[0] && m.port == int(port) {
+			master = m
+			break
+		}
+	}
+	down := int64(0)
+	if master != nil && master.hasFlag(sriSDown) {
+		down = 1
+	}
+	leader, leaderEpoch := "*", int64(0)
+	if master != nil && args[3] != "*" {
+		leader, leaderEpoch = s.sentinelVoteLeader(master, reqEpoch, args[3])
+		leader = stringOr(leader, "*")
+	}
+	return resp.Array{down, resp.BulkStrings(leader), leaderEpoch}
+}
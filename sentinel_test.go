@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+	"github.com/stretchr/testify/assert"
+)
+
+// startTestSentinel 启动一个监控master的哨兵
+func startTestSentinel(t *testing.T, master *Service, quorum int) (*Service, *testClient) {
+	port := master.ln.Addr().(*net.TCPAddr).Port
+	s := startTestService(t, Config{
+		Sentinel:                true,
+		Dir:                     t.TempDir(),
+		SentinelMonitor:         fmt.Sprintf("mymaster 127.0.0.1 %d %d", port, quorum),
+		SentinelDownAfter:       200,
+		SentinelFailoverTimeout: 3000,
+	})
+	return s, dialTestService(t, s)
+}
+
+// sentinelMasterInfo 返回INFO sentinel中master0的字段
+func sentinelMasterInfo(c *testClient) string {
+	return c.info("master0")
+}
+
+func TestSentinelCommand(t *testing.T) {
+	master := startTestService(t, Config{Dir: t.TempDir()})
+	port := strconv.Itoa(master.ln.Addr().(*net.TCPAddr).Port)
+	s, c := startTestSentinel(t, master, 1)
+	mc := dialTestService(t, master)
+
+	testCases := []struct {
+		name string
+		args []string
+		res  any
+	}{
+		{name: "测试哨兵模式下不支持数据命令", args: []string{"GET", "k"}, res: errors.New("ERR unknown command 'GET', with args beginning with: 'k' ")},
+		{name: "测试PING", args: []string{"PING"}, res: "PONG"},
+		{name: "测试MYID", args: []string{CommentSentinel, "MYID"}, res: resp.BulkStrings(s.sentinel.myID)},
+		{name: "测试主节点地址", args: []string{CommentSentinel, "GET-MASTER-ADDR-BY-NAME", "mymaster"}, res: resp.Array{resp.BulkStrings("127.0.0.1"), resp.BulkStrings(port)}},
+		{name: "测试未知的主节点地址", args: []string{CommentSentinel, "GET-MASTER-ADDR-BY-NAME", "nomaster"}, res: nil},
+		{name: "测试未知的主节点", args: []string{CommentSentinel, "MASTER", "nomaster"}, res: errNoSuchMaster},
+		{name: "测试重复监控", args: []string{CommentSentinel, "MONITOR", "mymaster", "127.0.0.1", port, "1"}, res: errors.New("ERR Duplicated master name")},
+		{name: "测试监控非法地址", args: []string{CommentSentinel, "MONITOR", "other", "localhost", port, "1"}, res: errors.New("ERR invalid IP address")},
+		{name: "测试监控非法quorum", args: []string{CommentSentinel, "MONITOR", "other", "127.0.0.1", port, "0"}, res: errors.New("ERR quorum must be 1 or greater")},
+		{name: "测试SET", args: []string{CommentSentinel, "SET", "mymaster", "parallel-syncs", "2"}, res: "OK"},
+		{name: "测试SET非法选项", args: []string{CommentSentinel, "SET", "mymaster", "foo", "2"}, res: errors.New("ERR Invalid argument 'foo' to SENTINEL SET")},
+		{name: "测试SET非法值", args: []string{CommentSentinel, "SET", "mymaster", "quorum", "x"}, res: errors.New("ERR Invalid argument 'x' for SENTINEL SET 'quorum'")},
+		{name: "测试CKQUORUM", args: []string{CommentSentinel, "CKQUORUM", "mymaster"}, res: "OK 1 usable Sentinels. Quorum and failover authorization can be reached"},
+		{name: "测试没有副本时不能故障转移", args: []string{CommentSentinel, "FAILOVER", "mymaster"}, res: errors.New("NOGOODSLAVE No suitable replica to promote")},
+		{name: "测试ROLE", args: []string{CommentRole}, res: resp.Array{resp.BulkStrings("sentinel"), resp.Array{resp.BulkStrings("mymaster")}}},
+		{name: "测试未知子命令", args: []string{CommentSentinel, "FOO"}, res: errors.New("ERR unknown subcommand or wrong number of arguments for 'FOO'. Try SENTINEL HELP.")},
+		{name: "测试非哨兵模式下不支持SENTINEL", args: []string{CommentSentinel, "MASTERS"}, res: nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cl := c
+			if strings.HasPrefix(tc.name, "测试非哨兵模式") {
+				cl = mc
+				tc.res = errors.New("ERR unknown command 'SENTINEL', with args beginning with: 'MASTERS' ")
+			}
+			assert.Equal(t, tc.res, cl.do(tc.args...))
+		})
+	}
+
+	assert.Eventually(t, func() bool {
+		return sentinelMasterInfo(c) == fmt.Sprintf("name=mymaster,status=ok,address=127.0.0.1:%s,slaves=0,sentinels=1", port)
+	}, 3*time.Second, 10*time.Millisecond)
+	// 配置文件记录了ID与修改后的选项
+	data, err := os.ReadFile(filepath.Join(s.Dir, defaultSentinelConfigFile))
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "sentinel myid "+s.sentinel.myID+"\n")
+	assert.Contains(t, string(data), "sentinel parallel-syncs mymaster 2\n")
+
+	// 主节点下线后进入主观下线，quorum为1时同时客观下线
+	master.Close()
+	assert.Eventually(t, func() bool {
+		return strings.Contains(sentinelMasterInfo(c), "status=odown")
+	}, 3*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, "OK", c.do(CommentSentinel, "REMOVE", "mymaster"))
+	assert.Equal(t, "0", c.info("sentinel_masters"))
+	assert.Equal(t, errNoSuchMaster, c.do(CommentSentinel, "MASTER", "mymaster"))
+}
+
+func TestSentinelLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	conf := "sentinel myid 0123456789012345678901234567890123456789\n" +
+		"sentinel current-epoch 5\n" +
+		"sentinel monitor mymaster 127.0.0.1 6379 2\n" +
+		"sentinel down-after-milliseconds mymaster 1000\n" +
+		"sentinel config-epoch mymaster 3\n" +
+		"sentinel known-replica mymaster 127.0.0.1 6380\n" +
+		"sentinel known-sentinel mymaster 127.0.0.1 26380 9876543210987654321098765432109876543210\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, defaultSentinelConfigFile), []byte(conf), 0644))
+	s := NewService(Config{Sentinel: true, Dir: dir, SentinelMonitor: "other 127.0.0.1 6381 1"})
+	assert.NoError(t, s.sentinelInit())
+
+	assert.Equal(t, "0123456789012345678901234567890123456789", s.sentinel.myID)
+	assert.Equal(t, int64(5), s.sentinel.currentEpoch)
+	m := s.sentinel.masters["mymaster"]
+	assert.Equal(t, 2, m.quorum)
+	assert.Equal(t, int64(1000), m.downAfter)
+	assert.Equal(t, int64(3), m.configEpoch)
+	assert.Contains(t, m.replicas, "127.0.0.1:6380")
+	assert.Contains(t, m.sentinels, "127.0.0.1:26380")
+	assert.Contains(t, s.sentinel.masters, "other")
+
+	// 配置文件中非法的行
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, defaultSentinelConfigFile), []byte("sentinel foo mymaster 1\n"), 0644))
+	s = NewService(Config{Sentinel: true, Dir: dir})
+	assert.Error(t, s.sentinelInit())
+}
+
+func TestSentinelFailover(t *testing.T) {
+	master := startTestService(t, Config{Dir: t.TempDir()})
+	mc := dialTestService(t, master)
+	r1, c1 := startTestReplica(t, master, Config{Dir: t.TempDir()})
+	r2, c2 := startTestReplica(t, master, Config{Dir: t.TempDir()})
+	oldPort := strconv.Itoa(master.ln.Addr().(*net.TCPAddr).Port)
+
+	var sentinels []*testClient
+	for range 3 {
+		_, c := startTestSentinel(t, master, 2)
+		sentinels = append(sentinels, c)
+	}
+	// 通过主节点的INFO发现副本，通过hello发现其他哨兵
+	for _, c := range sentinels {
+		assert.Eventually(t, func() bool {
+			return strings.HasSuffix(sentinelMasterInfo(c), "status=ok,address=127.0.0.1:"+oldPort+",slaves=2,sentinels=3")
+		}, 10*time.Second, 50*time.Millisecond)
+	}
+	mc.do("SET", "k", "v")
+	waitReplOffset(t, mc, c1)
+	waitReplOffset(t, mc, c2)
+
+	master.Close()
+	var newPort string
+	for _, c := range sentinels {
+		assert.Eventually(t, func() bool {
+			addr, ok := c.do(CommentSentinel, "GET-MASTER-ADDR-BY-NAME", "mymaster").(resp.Array)
+			if !ok || len(addr) != 2 || addr[1] == resp.BulkStrings(oldPort) {
+				return false
+			}
+			newPort = string(addr[1].(resp.BulkStrings))
+			return true
+		}, 15*time.Second, 50*time.Millisecond)
+	}
+
+	// 被提升的副本成为主节点，另一个副本改为复制它
+	promoted, other := c1, c2
+	if newPort == strconv.Itoa(r2.ln.Addr().(*net.TCPAddr).Port) {
+		promoted, other = c2, c1
+	} else {
+		assert.Equal(t, strconv.Itoa(r1.ln.Addr().(*net.TCPAddr).Port), newPort)
+	}
+	assert.Equal(t, resp.BulkStrings("master"), promoted.do(CommentRole).(resp.Array)[0])
+	assert.Eventually(t, func() bool {
+		return other.info("master_port") == newPort && other.info("master_link_status") == "up"
+	}, 10*time.Second, 50*time.Millisecond)
+	assert.Equal(t, resp.BulkStrings("v"), other.do("GET", "k"))
+	for _, c := range sentinels {
+		assert.Eventually(t, func() bool {
+			return strings.Contains(sentinelMasterInfo(c), "address=127.0.0.1:"+newPort)
+		}, 5*time.Second, 50*time.Millisecond)
+	}
+}
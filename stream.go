@@ -0,0 +1,545 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// streamChunkSize 每个块最多保存的条目数，与Redis的stream-node-max-entries默认值一致
+const streamChunkSize = 100
+
+// pelChunkSize 待确认列表每个块最多保存的ID数，超过时对半拆分
+const pelChunkSize = 128
+
+var errInvalidStreamID = errors.New("ERR Invalid stream ID specified as stream command argument")
+
+// streamID 流条目的ID，由毫秒时间戳与同一毫秒内的序号组成
+type streamID struct {
+	ms, seq uint64
+}
+
+var (
+	streamMinID = streamID{}
+	streamMaxID = streamID{math.MaxUint64, math.MaxUint64}
+)
+
+// streamEntry 流中的一个条目，fields为字段与值交替排列
+type streamEntry struct {
+	id     streamID
+	fields []string
+}
+
+// streamChunk 一段ID连续递增的条目
+type streamChunk struct {
+	entries []streamEntry
+}
+
+// stream 流类型的值：由定长块组成的有序序列，块内与块间都按ID升序排列，
+// 按ID查找先二分定位块再在块内二分。近似裁剪(~)只删除整块
+type stream struct {
+	chunks []*streamChunk
+	length int
+	// lastID 曾经添加过的最大ID，删除条目后也不会减小
+	lastID streamID
+	// entriesAdded 曾经添加过的条目总数，包括已被删除的条目
+	entriesAdded uint64
+	// maxDeletedID 被删除条目中的最大ID，没有删除过时为0-0
+	maxDeletedID streamID
+	// groups 消费者组，没有组时为nil
+	groups map[string]*streamCG
+}
+
+func newStream() *stream {
+	return &stream{}
+}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+// compare 比较两个ID，返回-1、0、1
+func (id streamID) compare(o streamID) int {
+	switch {
+	case id.ms < o.ms || (id.ms == o.ms && id.seq < o.seq):
+		return -1
+	case id == o:
+		return 0
+	default:
+		return 1
+	}
+}
+
+// incr 返回下一个ID，已是最大ID时ok为false
+func (id streamID) incr() (streamID, bool) {
+	switch {
+	case id.seq < math.MaxUint64:
+		return streamID{id.ms, id.seq + 1}, true
+	case id.ms < math.MaxUint64:
+		return streamID{id.ms + 1, 0}, true
+	default:
+		return id, false
+	}
+}
+
+// decr 返回上一个ID，已是最小ID时ok为false
+func (id streamID) decr() (streamID, bool) {
+	switch {
+	case id.seq > 0:
+		return streamID{id.ms, id.seq - 1}, true
+	case id.ms > 0:
+		return streamID{id.ms - 1, math.MaxUint64}, true
+	default:
+		return id, false
+	}
+}
+
+// parseStreamID 解析ms-seq形式的ID，只给出ms时seq取missingSeq
+func parseStreamID(s string, missingSeq uint64) (streamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, errInvalidStreamID
+	}
+	if !hasSeq {
+		return streamID{ms, missingSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return streamID{}, errInvalidStreamID
+	}
+	return streamID{ms, seq}, nil
+}
+
+// parseRangeID 解析XRANGE类命令的区间端点，支持"-"、"+"以及"("前缀的开区间
+func parseRangeID(s string, missingSeq uint64) (id streamID, exclusive bool, err error) {
+	switch s {
+	case "-":
+		return streamMinID, false, nil
+	case "+":
+		return streamMaxID, false, nil
+	}
+	if strings.HasPrefix(s, "(") {
+		s, exclusive = s[1:], true
+	}
+	id, err = parseStreamID(s, missingSeq)
+	return id, exclusive, err
+}
+
+// append 在末尾追加条目，调用方需保证id大于lastID
+func (st *stream) append(id streamID, fields []string) {
+	n := len(st.chunks)
+	if n == 0 || len(st.chunks[n-1].entries) >= streamChunkSize {
+		st.chunks = append(st.chunks, &streamChunk{entries: make([]streamEntry, 0, streamChunkSize)})
+		n++
+	}
+	c := st.chunks[n-1]
+	c.entries = append(c.entries, streamEntry{id: id, fields: fields})
+	st.length++
+	st.entriesAdded++
+	st.lastID = id
+}
+
+// first 返回第一个条目
+func (st *stream) first() (streamEntry, bool) {
+	if st.length == 0 {
+		return streamEntry{}, false
+	}
+	return st.chunks[0].entries[0], true
+}
+
+// last 返回最后一个条目
+func (st *stream) last() (streamEntry, bool) {
+	if st.length == 0 {
+		return streamEntry{}, false
+	}
+	c := st.chunks[len(st.chunks)-1]
+	return c.entries[len(c.entries)-1], true
+}
+
+// seek 返回第一个ID不小于id的条目位置，不存在时ci等于len(chunks)
+func (st *stream) seek(id streamID) (ci, ei int) {
+	ci = sort.Search(len(st.chunks), func(i int) bool {
+		entries := st.chunks[i].entries
+		return entries[len(entries)-1].id.compare(id) >= 0
+	})
+	if ci == len(st.chunks) {
+		return ci, 0
+	}
+	entries := st.chunks[ci].entries
+	ei = sort.Search(len(entries), func(i int) bool { return entries[i].id.compare(id) >= 0 })
+	return ci, ei
+}
+
+// rangeEntries 返回ID在[start, end]内的条目，rev为true时从end向start遍历，count为0表示不限
+func (st *stream) rangeEntries(start, end streamID, count int, rev bool) []streamEntry {
+	if start.compare(end) > 0 {
+		return nil
+	}
+	var res []streamEntry
+	if !rev {
+		ci, ei := st.seek(start)
+		for ; ci < len(st.chunks); ci, ei = ci+1, 0 {
+			for _, e := range st.chunks[ci].entries[ei:] {
+				if e.id.compare(end) > 0 || (count > 0 && len(res) >= count) {
+					return res
+				}
+				res = append(res, e)
+			}
+		}
+		return res
+	}
+	// 从第一个大于end的位置向前遍历
+	ci, ei := st.seek(end)
+	if ci < len(st.chunks) && st.chunks[ci].entries[ei].id == end {
+		ei++
+	}
+	for {
+		if ei == 0 {
+			if ci == 0 {
+				return res
+			}
+			ci--
+			ei = len(st.chunks[ci].entries)
+		}
+		ei--
+		e := st.chunks[ci].entries[ei]
+		if e.id.compare(start) < 0 || (count > 0 && len(res) >= count) {
+			return res
+		}
+		res = append(res, e)
+	}
+}
+
+// delete 删除指定ID的条目，返回是否存在
+func (st *stream) delete(id streamID) bool {
+	ci, ei := st.seek(id)
+	if ci == len(st.chunks) || st.chunks[ci].entries[ei].id != id {
+		return false
+	}
+	c := st.chunks[ci]
+	c.entries = append(c.entries[:ei], c.entries[ei+1:]...)
+	if len(c.entries) == 0 {
+		st.chunks = append(st.chunks[:ci], st.chunks[ci+1:]...)
+	}
+	st.length--
+	st.deleted(id)
+	return true
+}
+
+// trim 从头部删除条目直到不满足should，approx为true时只删除整块，
+// limit大于0时最多删除limit个条目，返回删除的条目数
+func (st *stream) trim(should func(e streamEntry, remain int) bool, approx bool, limit int) int {
+	deleted := 0
+	var lastDeleted streamID
+	for len(st.chunks) > 0 {
+		c := st.chunks[0]
+		n := len(c.entries)
+		// 整块删除
+		if should(c.entries[n-1], st.length-n+1) {
+			if limit > 0 && deleted+n > limit {
+				break
+			}
+			lastDeleted = c.entries[n-1].id
+			st.chunks = st.chunks[1:]
+			st.length -= n
+			deleted += n
+			continue
+		}
+		if approx {
+			break
+		}
+		i := 0
+		for i < n && should(c.entries[i], st.length-i) && (limit == 0 || deleted+i < limit) {
+			i++
+		}
+		if i > 0 {
+			lastDeleted = c.entries[i-1].id
+		}
+		c.entries = c.entries[i:]
+		st.length -= i
+		deleted += i
+		break
+	}
+	if deleted > 0 {
+		st.deleted(lastDeleted)
+	}
+	return deleted
+}
+
+// deleted 删除了ID为id的条目后更新被删除的最大ID，并使尚未读到该条目的组的已读条目数失效
+func (st *stream) deleted(id streamID) {
+	if st.maxDeletedID.compare(id) < 0 {
+		st.maxDeletedID = id
+	}
+	st.invalidateEntriesRead(id)
+}
+
+// trimByLen 裁剪到最多maxlen个条目
+func (st *stream) trimByLen(maxlen int, approx bool, limit int) int {
+	return st.trim(func(_ streamEntry, remain int) bool { return remain > maxlen }, approx, limit)
+}
+
+// trimByMinID 删除ID小于minID的条目
+func (st *stream) trimByMinID(minID streamID, approx bool, limit int) int {
+	return st.trim(func(e streamEntry, _ int) bool { return e.id.compare(minID) < 0 }, approx, limit)
+}
+
+// streamCG 消费者组
+type streamCG struct {
+	// lastID 已分发给组内消费者的最大ID
+	lastID streamID
+	// entriesRead 组已读取的条目数，与累计添加的条目数之差即为组的lag，-1表示未知
+	entriesRead int64
+	// pel 已分发但未确认的条目
+	pel       *streamPEL
+	consumers map[string]*streamConsumer
+}
+
+// streamNACK 待确认条目
+type streamNACK struct {
+	// deliveryTime 最后一次分发的时刻（unix毫秒）
+	deliveryTime  int64
+	deliveryCount int64
+	consumer      *streamConsumer
+}
+
+// streamConsumer 组内的消费者，pel为其名下的待确认条目，与组的pel共享streamNACK
+type streamConsumer struct {
+	name string
+	// seenTime 最后一次尝试读取或认领的时刻，activeTime 最后一次成功读取或认领的时刻
+	seenTime   int64
+	activeTime int64
+	pel        *streamPEL
+}
+
+// streamPEL 按ID升序排列的待确认列表：nacks用于按ID查找，ID另外保存在有序的定长块中，
+// 插入删除只移动一个块内的元素，按序遍历时不需要再排序
+type streamPEL struct {
+	nacks  map[streamID]*streamNACK
+	chunks [][]streamID
+}
+
+func newStreamPEL() *streamPEL {
+	return &streamPEL{nacks: make(map[streamID]*streamNACK)}
+}
+
+// len 返回待确认条目数
+func (pel *streamPEL) len() int {
+	return len(pel.nacks)
+}
+
+// get 按ID查找待确认条目，不存在时返回nil
+func (pel *streamPEL) get(id streamID) *streamNACK {
+	return pel.nacks[id]
+}
+
+// seek 返回第一个不小于id的位置，不存在时ci等于len(chunks)
+func (pel *streamPEL) seek(id streamID) (ci, ii int) {
+	ci = sort.Search(len(pel.chunks), func(i int) bool {
+		ids := pel.chunks[i]
+		return ids[len(ids)-1].compare(id) >= 0
+	})
+	if ci == len(pel.chunks) {
+		return ci, 0
+	}
+	ids := pel.chunks[ci]
+	ii = sort.Search(len(ids), func(i int) bool { return ids[i].compare(id) >= 0 })
+	return ci, ii
+}
+
+// insert 加入或替换待确认条目
+func (pel *streamPEL) insert(id streamID, nack *streamNACK) {
+	if _, ok := pel.nacks[id]; ok {
+		pel.nacks[id] = nack
+		return
+	}
+	pel.nacks[id] = nack
+	ci, ii := pel.seek(id)
+	// 大于所有已有ID，追加到最后一块
+	if ci == len(pel.chunks) {
+		if ci == 0 || len(pel.chunks[ci-1]) >= pelChunkSize {
+			pel.chunks = append(pel.chunks, make([]streamID, 0, pelChunkSize))
+			ci++
+		}
+		pel.chunks[ci-1] = append(pel.chunks[ci-1], id)
+		return
+	}
+	ids := slices.Insert(pel.chunks[ci], ii, id)
+	if len(ids) <= pelChunkSize {
+		pel.chunks[ci] = ids
+		return
+	}
+	half := len(ids) / 2
+	pel.chunks[ci] = ids[:half]
+	pel.chunks = slices.Insert(pel.chunks, ci+1, slices.Clone(ids[half:]))
+}
+
+// remove 删除待确认条目，返回是否存在
+func (pel *streamPEL) remove(id streamID) bool {
+	if _, ok := pel.nacks[id]; !ok {
+		return false
+	}
+	delete(pel.nacks, id)
+	ci, ii := pel.seek(id)
+	ids := slices.Delete(pel.chunks[ci], ii, ii+1)
+	if len(ids) == 0 {
+		pel.chunks = slices.Delete(pel.chunks, ci, ci+1)
+	} else {
+		pel.chunks[ci] = ids
+	}
+	return true
+}
+
+// first 返回最小的ID
+func (pel *streamPEL) first() (streamID, bool) {
+	if len(pel.chunks) == 0 {
+		return streamID{}, false
+	}
+	return pel.chunks[0][0], true
+}
+
+// last 返回最大的ID
+func (pel *streamPEL) last() (streamID, bool) {
+	if len(pel.chunks) == 0 {
+		return streamID{}, false
+	}
+	ids := pel.chunks[len(pel.chunks)-1]
+	return ids[len(ids)-1], true
+}
+
+// ascend 从第一个不小于start的ID开始按升序遍历，fn返回false时停止，遍历期间不能修改pel
+func (pel *streamPEL) ascend(start streamID, fn func(id streamID, nack *streamNACK) bool) {
+	ci, ii := pel.seek(start)
+	for ; ci < len(pel.chunks); ci, ii = ci+1, 0 {
+		for _, id := range pel.chunks[ci][ii:] {
+			if !fn(id, pel.nacks[id]) {
+				return
+			}
+		}
+	}
+}
+
+func newStreamCG(lastID streamID) *streamCG {
+	return &streamCG{
+		lastID:      lastID,
+		entriesRead: -1,
+		pel:         newStreamPEL(),
+		consumers:   make(map[string]*streamConsumer),
+	}
+}
+
+// createGroup 创建消费者组，同名组已存在时返回nil
+func (st *stream) createGroup(name string, lastID streamID) *streamCG {
+	if st.groups == nil {
+		st.groups = make(map[string]*streamCG)
+	}
+	if _, ok := st.groups[name]; ok {
+		return nil
+	}
+	cg := newStreamCG(lastID)
+	st.groups[name] = cg
+	return cg
+}
+
+// lookupEntry 按ID查找条目
+func (st *stream) lookupEntry(id streamID) (streamEntry, bool) {
+	ci, ei := st.seek(id)
+	if ci == len(st.chunks) || st.chunks[ci].entries[ei].id != id {
+		return streamEntry{}, false
+	}
+	return st.chunks[ci].entries[ei], true
+}
+
+// lookupConsumer 查找消费者，create为true时不存在则创建
+func (cg *streamCG) lookupConsumer(name string, create bool) *streamConsumer {
+	c, ok := cg.consumers[name]
+	if !ok && create {
+		now := mstime()
+		c = &streamConsumer{name: name, seenTime: now, activeTime: -1, pel: newStreamPEL()}
+		cg.consumers[name] = c
+	}
+	return c
+}
+
+// deleteConsumer 删除消费者及其名下的待确认条目，返回删除的待确认条目数
+func (cg *streamCG) deleteConsumer(c *streamConsumer) int {
+	n := c.pel.len()
+	for id := range c.pel.nacks {
+		cg.pel.remove(id)
+	}
+	delete(cg.consumers, c.name)
+	return n
+}
+
+// deliver 将条目记入消费者的待确认列表，已存在时转移归属并重置分发时间
+func (cg *streamCG) deliver(id streamID, c *streamConsumer, now int64) *streamNACK {
+	nack := cg.pel.get(id)
+	if nack == nil {
+		nack = &streamNACK{}
+		cg.pel.insert(id, nack)
+	}
+	cg.claim(id, nack, c, now, true)
+	return nack
+}
+
+// claim 将待确认条目转移给消费者c
+func (cg *streamCG) claim(id streamID, nack *streamNACK, c *streamConsumer, deliveryTime int64, incrCount bool) {
+	if nack.consumer != nil && nack.consumer != c {
+		nack.consumer.pel.remove(id)
+	}
+	nack.consumer = c
+	nack.deliveryTime = deliveryTime
+	if incrCount {
+		nack.deliveryCount++
+	}
+	c.pel.insert(id, nack)
+}
+
+// ack 确认条目，返回是否在待确认列表中
+func (cg *streamCG) ack(id streamID) bool {
+	nack := cg.pel.get(id)
+	if nack == nil {
+		return false
+	}
+	nack.consumer.pel.remove(id)
+	cg.pel.remove(id)
+	return true
+}
+
+// invalidateEntriesRead 删除了ID为id的条目，尚未读到该条目的组无法再得知已读条目数
+func (st *stream) invalidateEntriesRead(id streamID) {
+	for _, cg := range st.groups {
+		if cg.lastID.compare(id) < 0 {
+			cg.entriesRead = -1
+		}
+	}
+}
+
+// clone 深拷贝流及其消费者组，条目的字段切片写入后不会再修改，可以共享
+func (st *stream) clone() *stream {
+	c := &stream{length: st.length, lastID: st.lastID, entriesAdded: st.entriesAdded, maxDeletedID: st.maxDeletedID}
+	for _, chunk := range st.chunks {
+		c.chunks = append(c.chunks, &streamChunk{entries: slices.Clone(chunk.entries)})
+	}
+	for name, cg := range st.groups {
+		ccg := c.createGroup(name, cg.lastID)
+		ccg.entriesRead = cg.entriesRead
+		for cname, consumer := range cg.consumers {
+			cc := ccg.lookupConsumer(cname, true)
+			cc.seenTime, cc.activeTime = consumer.seenTime, consumer.activeTime
+		}
+		// 按升序插入，每次都追加在最后一块
+		cg.pel.ascend(streamMinID, func(id streamID, nack *streamNACK) bool {
+			cn := *nack
+			cn.consumer = ccg.consumers[nack.consumer.name]
+			ccg.pel.insert(id, &cn)
+			cn.consumer.pel.insert(id, &cn)
+			return true
+		})
+	}
+	return c
+}
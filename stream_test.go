@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func streamIDs(entries []streamEntry) []streamID {
+	res := []streamID{}
+	for _, e := range entries {
+		res = append(res, e.id)
+	}
+	return res
+}
+
+func TestStream(t *testing.T) {
+	st := newStream()
+	var expect []streamID
+	// 超过单个块的容量，覆盖跨块查找
+	for i := uint64(1); i <= 350; i++ {
+		id := streamID{i / 3, i % 3}
+		st.append(id, []string{"f", "v"})
+		expect = append(expect, id)
+	}
+	assert.Equal(t, 350, st.length)
+	assert.Equal(t, expect, streamIDs(st.rangeEntries(streamMinID, streamMaxID, 0, false)))
+	assert.Equal(t, expect[99:102], streamIDs(st.rangeEntries(expect[99], expect[101], 0, false)))
+	assert.Equal(t, []streamID{expect[101], expect[100]}, streamIDs(st.rangeEntries(expect[99], expect[101], 2, true)))
+	assert.Equal(t, expect[:5], streamIDs(st.rangeEntries(streamMinID, streamMaxID, 5, false)))
+	assert.Empty(t, st.rangeEntries(expect[5], expect[4], 0, false))
+
+	assert.True(t, st.delete(expect[100]))
+	assert.False(t, st.delete(expect[100]))
+	expect = append(expect[:100:100], expect[101:]...)
+	assert.Equal(t, expect, streamIDs(st.rangeEntries(streamMinID, streamMaxID, 0, false)))
+	assert.Equal(t, []streamID{expect[100], expect[99]}, streamIDs(st.rangeEntries(expect[99], expect[100], 0, true)))
+
+	// 近似裁剪只删除整块，第一个块有100个条目
+	assert.Equal(t, 0, st.trimByLen(300, true, 0))
+	assert.Equal(t, 100, st.trimByLen(200, true, 0))
+	assert.Equal(t, 249, st.length)
+	assert.Equal(t, 49, st.trimByLen(200, false, 0))
+	assert.Equal(t, expect[149:], streamIDs(st.rangeEntries(streamMinID, streamMaxID, 0, false)))
+	assert.Equal(t, 10, st.trimByMinID(expect[159], false, 0))
+	first, _ := st.first()
+	assert.Equal(t, expect[159], first.id)
+	last, _ := st.last()
+	assert.Equal(t, expect[len(expect)-1], last.id)
+}
+
+func TestStreamPEL(t *testing.T) {
+	pel := newStreamPEL()
+	ids := func(start streamID) []streamID {
+		res := []streamID{}
+		pel.ascend(start, func(id streamID, _ *streamNACK) bool {
+			res = append(res, id)
+			return true
+		})
+		return res
+	}
+	// 先插入偶数再倒序插入奇数，覆盖块的追加、中间插入与拆分
+	var expect []streamID
+	for i := uint64(0); i < 600; i += 2 {
+		pel.insert(streamID{i, 0}, &streamNACK{})
+	}
+	for i := 599; i > 0; i -= 2 {
+		pel.insert(streamID{uint64(i), 0}, &streamNACK{})
+	}
+	for i := uint64(0); i < 600; i++ {
+		expect = append(expect, streamID{i, 0})
+	}
+	assert.Equal(t, 600, pel.len())
+	assert.Equal(t, expect, ids(streamMinID))
+	assert.Equal(t, expect[300:], ids(streamID{300, 0}))
+	for _, chunk := range pel.chunks {
+		assert.LessOrEqual(t, len(chunk), pelChunkSize)
+	}
+
+	nack := &streamNACK{deliveryCount: 3}
+	pel.insert(streamID{10, 0}, nack)
+	assert.Equal(t, 600, pel.len())
+	assert.Same(t, nack, pel.get(streamID{10, 0}))
+
+	for i := uint64(0); i < 600; i++ {
+		if i%3 != 0 {
+			assert.True(t, pel.remove(streamID{i, 0}))
+		}
+	}
+	assert.False(t, pel.remove(streamID{1, 0}))
+	expect = expect[:0]
+	for i := uint64(0); i < 600; i += 3 {
+		expect = append(expect, streamID{i, 0})
+	}
+	assert.Equal(t, expect, ids(streamMinID))
+	first, _ := pel.first()
+	assert.Equal(t, streamID{0, 0}, first)
+	last, _ := pel.last()
+	assert.Equal(t, streamID{597, 0}, last)
+	assert.Empty(t, ids(streamID{598, 0}))
+}
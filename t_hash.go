@@ -0,0 +1,395 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+)
+
+const (
+	CommentHSet         = "HSET"
+	CommentHSetNX       = "HSETNX"
+	CommentHMSet        = "HMSET"
+	CommentHGet         = "HGET"
+	CommentHMGet        = "HMGET"
+	CommentHDel         = "HDEL"
+	CommentHLen         = "HLEN"
+	CommentHExists      = "HEXISTS"
+	CommentHKeys        = "HKEYS"
+	CommentHVals        = "HVALS"
+	CommentHGetAll      = "HGETALL"
+	CommentHIncrBy      = "HINCRBY"
+	CommentHIncrByFloat = "HINCRBYFLOAT"
+	CommentHStrlen      = "HSTRLEN"
+	CommentHScan        = "HSCAN"
+	CommentHRandField   = "HRANDFIELD"
+)
+
+var (
+	errHashNotInteger = errors.New("ERR hash value is not an integer")
+	errHashNotFloat   = errors.New("ERR hash value is not a float")
+	errValueOutRange  = errors.New("ERR value is out of range")
+)
+
+// hashObj 哈希类型的值，字段名到字段值的映射
+type hashObj map[string]string
+
+func init() {
+	registerCommand(
+		&command{name: CommentHSet, arity: -4, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: hsetCommand},
+		&command{name: CommentHSetNX, arity: 4, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: hsetnxCommand},
+		&command{name: CommentHMSet, arity: -4, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: hsetCommand},
+		&command{name: CommentHGet, arity: 3, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: hgetCommand},
+		&command{name: CommentHMGet, arity: -3, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: hmgetCommand},
+		&command{name: CommentHDel, arity: -3, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: hdelCommand},
+		&command{name: CommentHLen, arity: 2, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: hlenCommand},
+		&command{name: CommentHExists, arity: 3, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: hexistsCommand},
+		&command{name: CommentHKeys, arity: 2, flags: cmdReadonly, firstKey: 1, lastKey: 1, keyStep: 1, proc: hkeysCommand},
+		&command{name: CommentHVals, arity: 2, flags: cmdReadonly, firstKey: 1, lastKey: 1, keyStep: 1, proc: hvalsCommand},
+		&command{name: CommentHGetAll, arity: 2, flags: cmdReadonly, firstKey: 1, lastKey: 1, keyStep: 1, proc: hgetallCommand},
+		&command{name: CommentHIncrBy, arity: 4, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: hincrbyCommand},
+		&command{name: CommentHIncrByFloat, arity: 4, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: hincrbyfloatCommand},
+		&command{name: CommentHStrlen, arity: 3, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: hstrlenCommand},
+		&command{name: CommentHScan, arity: -3, flags: cmdReadonly, firstKey: 1, lastKey: 1, keyStep: 1, proc: hscanCommand},
+		&command{name: CommentHRandField, arity: -2, flags: cmdReadonly, firstKey: 1, lastKey: 1, keyStep: 1, proc: hrandfieldCommand},
+	)
+}
+
+// lookupHash 获取哈希类型的值，键不存在时返回nil
+func (ks *keyspace) lookupHash(key string) (hashObj, error) {
+	val, ok := ks.lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	h, isHash := val.(hashObj)
+	if !isHash {
+		return nil, errWrongType
+	}
+	return h, nil
+}
+
+// lookupHashOrCreate 获取哈希类型的值，键不存在时创建
+func (ks *keyspace) lookupHashOrCreate(key string) (hashObj, error) {
+	h, err := ks.lookupHash(key)
+	if err != nil || h != nil {
+		return h, err
+	}
+	h = make(hashObj)
+	ks.set(key, h)
+	return h, nil
+}
+
+// hashSet 设置字段的值，h为nil时创建新的哈希
+func (ks *keyspace) hashSet(key string, h hashObj, field, value string) {
+	if h == nil {
+		ks.set(key, hashObj{field: value})
+		return
+	}
+	h[field] = value
+	ks.signalModifiedKey(key)
+}
+
+// hsetCommand HSET key field value [field value ...]，HMSET与其相同但回复OK
+func hsetCommand(s *Service, p *Peer, args []string) any {
+	if len(args)%2 != 0 {
+		return wrongArityErr(args[0])
+	}
+	key := args[1]
+	h, err := s.db.lookupHashOrCreate(key)
+	if err != nil {
+		return err
+	}
+	var created int64
+	for i := 2; i < len(args); i += 2 {
+		if _, ok := h[args[i]]; !ok {
+			created++
+		}
+		h[args[i]] = args[i+1]
+	}
+	s.db.signalModifiedKey(key)
+	if strings.ToUpper(args[0]) == CommentHMSet {
+		return "OK"
+	}
+	return created
+}
+
+// hsetnxCommand HSETNX key field value
+func hsetnxCommand(s *Service, p *Peer, args []string) any {
+	key := args[1]
+	h, err := s.db.lookupHashOrCreate(key)
+	if err != nil {
+		return err
+	}
+	if _, ok := h[args[2]]; ok {
+		return int64(0)
+	}
+	h[args[2]] = args[3]
+	s.db.signalModifiedKey(key)
+	return int64(1)
+}
+
+// hgetCommand HGET key field
+func hgetCommand(s *Service, p *Peer, args []string) any {
+	h, err := s.db.lookupHash(args[1])
+	if err != nil {
+		return err
+	}
+	v, ok := h[args[2]]
+	if !ok {
+		return nil
+	}
+	return resp.BulkStrings(v)
+}
+
+// hmgetCommand HMGET key field [field ...]
+func hmgetCommand(s *Service, p *Peer, args []string) any {
+	h, err := s.db.lookupHash(args[1])
+	if err != nil {
+		return err
+	}
+	res := make(resp.Array, 0, len(args)-2)
+	for _, field := range args[2:] {
+		if v, ok := h[field]; ok {
+			res = append(res, resp.BulkStrings(v))
+		} else {
+			res = append(res, nil)
+		}
+	}
+	return res
+}
+
+// hdelCommand HDEL key field [field ...]
+func hdelCommand(s *Service, p *Peer, args []string) any {
+	key := args[1]
+	h, err := s.db.lookupHash(key)
+	if err != nil {
+		return err
+	}
+	var deleted int64
+	for _, field := range args[2:] {
+		if _, ok := h[field]; ok {
+			delete(h, field)
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		if len(h) == 0 {
+			s.db.delete(key)
+		} else {
+			s.db.signalModifiedKey(key)
+		}
+	}
+	return deleted
+}
+
+// hlenCommand HLEN key
+func hlenCommand(s *Service, p *Peer, args []string) any {
+	h, err := s.db.lookupHash(args[1])
+	if err != nil {
+		return err
+	}
+	return int64(len(h))
+}
+
+// hexistsCommand HEXISTS key field
+func hexistsCommand(s *Service, p *Peer, args []string) any {
+	h, err := s.db.lookupHash(args[1])
+	if err != nil {
+		return err
+	}
+	if _, ok := h[args[2]]; ok {
+		return int64(1)
+	}
+	return int64(0)
+}
+
+// hkeysCommand HKEYS key
+func hkeysCommand(s *Service, p *Peer, args []string) any {
+	h, err := s.db.lookupHash(args[1])
+	if err != nil {
+		return err
+	}
+	res := make(resp.Array, 0, len(h))
+	for f := range h {
+		res = append(res, resp.BulkStrings(f))
+	}
+	return res
+}
+
+// hvalsCommand HVALS key
+func hvalsCommand(s *Service, p *Peer, args []string) any {
+	h, err := s.db.lookupHash(args[1])
+	if err != nil {
+		return err
+	}
+	res := make(resp.Array, 0, len(h))
+	for _, v := range h {
+		res = append(res, resp.BulkStrings(v))
+	}
+	return res
+}
+
+// hgetallCommand HGETALL key
+// 回复为Maps，RESP3下编码为Map类型，RESP2下由Writer降级为字段与值交替的数组
+func hgetallCommand(s *Service, p *Peer, args []string) any {
+	h, err := s.db.lookupHash(args[1])
+	if err != nil {
+		return err
+	}
+	res := make(resp.Maps, len(h))
+	for f, v := range h {
+		res[resp.BulkStrings(f)] = resp.BulkStrings(v)
+	}
+	return res
+}
+
+// hincrbyCommand HINCRBY key field increment
+func hincrbyCommand(s *Service, p *Peer, args []string) any {
+	incr, err := parseInt(args[3])
+	if err != nil {
+		return err
+	}
+	key, field := args[1], args[2]
+	h, err := s.db.lookupHash(key)
+	if err != nil {
+		return err
+	}
+	var value int64
+	if cur, ok := h[field]; ok {
+		if value, err = parseInt(cur); err != nil {
+			return errHashNotInteger
+		}
+	}
+	if (incr < 0 && value < 0 && incr < math.MinInt64-value) ||
+		(incr > 0 && value > 0 && incr > math.MaxInt64-value) {
+		return errOverflow
+	}
+	value += incr
+	s.db.hashSet(key, h, field, strconv.FormatInt(value, 10))
+	return value
+}
+
+// hincrbyfloatCommand HINCRBYFLOAT key field increment
+func hincrbyfloatCommand(s *Service, p *Peer, args []string) any {
+	incr, err := parseFloat(args[3])
+	if err != nil {
+		return err
+	}
+	key, field := args[1], args[2]
+	h, err := s.db.lookupHash(key)
+	if err != nil {
+		return err
+	}
+	var value float64
+	if cur, ok := h[field]; ok {
+		if value, err = parseFloat(cur); err != nil {
+			return errHashNotFloat
+		}
+	}
+	value += incr
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return errNaNOrInf
+	}
+	str := resp.FormatDouble(value)
+	s.db.hashSet(key, h, field, str)
+	return resp.BulkStrings(str)
+}
+
+// hstrlenCommand HSTRLEN key field
+func hstrlenCommand(s *Service, p *Peer, args []string) any {
+	h, err := s.db.lookupHash(args[1])
+	if err != nil {
+		return err
+	}
+	return int64(len(h[args[2]]))
+}
+
+// hscanCommand HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES]
+func hscanCommand(s *Service, p *Peer, args []string) any {
+	opt, err := parseScanArgs(args[2:], "NOVALUES")
+	if err != nil {
+		return err
+	}
+	h, err := s.db.lookupHash(args[1])
+	if err != nil {
+		return err
+	}
+	fields := make([]string, 0, len(h))
+	for f := range h {
+		fields = append(fields, f)
+	}
+	batch, next := scanOrder(fields, opt.cursor, opt.count)
+	res := make(resp.Array, 0, len(batch)*2)
+	for _, f := range batch {
+		if opt.match != "" && !stringMatch(opt.match, f, false) {
+			continue
+		}
+		res = append(res, resp.BulkStrings(f))
+		if !opt.novalues {
+			res = append(res, resp.BulkStrings(h[f]))
+		}
+	}
+	return resp.Array{resp.BulkStrings(strconv.FormatUint(next, 10)), res}
+}
+
+// hrandfieldCommand HRANDFIELD key [count [WITHVALUES]]
+// count为正数时返回不重复的字段，为负数时可能重复并且恰好返回-count个
+func hrandfieldCommand(s *Service, p *Peer, args []string) any {
+	if len(args) > 4 || (len(args) == 4 && strings.ToUpper(args[3]) != "WITHVALUES") {
+		return errSyntax
+	}
+	var count int64
+	if len(args) >= 3 {
+		c, err := parseInt(args[2])
+		if err != nil {
+			return err
+		}
+		if c < -math.MaxInt64/2 || c > math.MaxInt64/2 {
+			return errValueOutRange
+		}
+		count = c
+	}
+	h, err := s.db.lookupHash(args[1])
+	if err != nil {
+		return err
+	}
+	fields := make([]string, 0, len(h))
+	for f := range h {
+		fields = append(fields, f)
+	}
+	if len(args) == 2 {
+		if len(fields) == 0 {
+			return nil
+		}
+		return resp.BulkStrings(fields[rand.IntN(len(fields))])
+	}
+	var picked []string
+	switch {
+	case len(fields) == 0 || count == 0:
+	case count > 0:
+		rand.Shuffle(len(fields), func(i, j int) { fields[i], fields[j] = fields[j], fields[i] })
+		picked = fields[:min(count, int64(len(fields)))]
+	default:
+		picked = make([]string, -count)
+		for i := range picked {
+			picked[i] = fields[rand.IntN(len(fields))]
+		}
+	}
+	withValues := len(args) == 4
+	res := make(resp.Array, 0, len(picked))
+	for _, f := range picked {
+		switch {
+		case !withValues:
+			res = append(res, resp.BulkStrings(f))
+		case p.proto >= resp.Proto3:
+			res = append(res, resp.Array{resp.BulkStrings(f), resp.BulkStrings(h[f])})
+		default:
+			res = append(res, resp.BulkStrings(f), resp.BulkStrings(h[f]))
+		}
+	}
+	return res
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashCommands(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	testCases := []struct {
+		name string
+		args []string
+		res  any
+	}{
+		{name: "测试HSET", args: []string{"HSET", "h", "a", "1", "b", "2"}, res: int64(2)},
+		{name: "测试HSET覆盖", args: []string{"HSET", "h", "a", "3", "c", "x"}, res: int64(1)},
+		{name: "测试HSET参数个数", args: []string{"HSET", "h", "a", "1", "b"}, res: wrongArityErr("HSET")},
+		{name: "测试HMSET", args: []string{"HMSET", "h", "d", "4"}, res: "OK"},
+		{name: "测试HSETNX已存在", args: []string{"HSETNX", "h", "a", "9"}, res: int64(0)},
+		{name: "测试HSETNX", args: []string{"HSETNX", "h", "e", "5"}, res: int64(1)},
+		{name: "测试TYPE", args: []string{"TYPE", "h"}, res: "hash"},
+		{name: "测试HGET", args: []string{"HGET", "h", "a"}, res: resp.BulkStrings("3")},
+		{name: "测试HGET不存在", args: []string{"HGET", "h", "zz"}, res: nil},
+		{name: "测试HMGET", args: []string{"HMGET", "h", "a", "zz", "b"}, res: resp.Array{resp.BulkStrings("3"), nil, resp.BulkStrings("2")}},
+		{name: "测试HLEN", args: []string{"HLEN", "h"}, res: int64(5)},
+		{name: "测试HEXISTS", args: []string{"HEXISTS", "h", "c"}, res: int64(1)},
+		{name: "测试HSTRLEN", args: []string{"HSTRLEN", "h", "c"}, res: int64(1)},
+		{name: "测试HINCRBY", args: []string{"HINCRBY", "h", "a", "10"}, res: int64(13)},
+		{name: "测试HINCRBY新字段", args: []string{"HINCRBY", "h2", "n", "-2"}, res: int64(-2)},
+		{name: "测试HINCRBY非整数", args: []string{"HINCRBY", "h", "c", "1"}, res: errHashNotInteger},
+		{name: "测试HINCRBY溢出", args: []string{"HINCRBY", "h", "a", "9223372036854775800"}, res: errOverflow},
+		{name: "测试HINCRBYFLOAT", args: []string{"HINCRBYFLOAT", "h", "b", "0.5"}, res: resp.BulkStrings("2.5")},
+		{name: "测试HINCRBYFLOAT非浮点数", args: []string{"HINCRBYFLOAT", "h", "c", "1"}, res: errHashNotFloat},
+		{name: "测试HINCRBYFLOAT无穷大", args: []string{"HINCRBYFLOAT", "h3", "f", "inf"}, res: errNaNOrInf},
+		{name: "测试HINCRBYFLOAT失败不创建键", args: []string{"EXISTS", "h3"}, res: int64(0)},
+		{name: "测试HDEL", args: []string{"HDEL", "h", "a", "zz", "b"}, res: int64(2)},
+		{name: "测试HDEL全部", args: []string{"HDEL", "h2", "n"}, res: int64(1)},
+		{name: "测试空哈希删除", args: []string{"EXISTS", "h2"}, res: int64(0)},
+		{name: "测试HDEL不存在", args: []string{"HDEL", "h2", "n"}, res: int64(0)},
+		{name: "测试HGETALL不存在", args: []string{"HGETALL", "h2"}, res: resp.Maps{}},
+		{name: "测试HRANDFIELD不存在", args: []string{"HRANDFIELD", "h2"}, res: nil},
+		{name: "测试HRANDFIELD count不存在", args: []string{"HRANDFIELD", "h2", "3"}, res: resp.Array{}},
+		{name: "测试HRANDFIELD语法错误", args: []string{"HRANDFIELD", "h", "1", "x"}, res: errSyntax},
+		{name: "测试SET", args: []string{"SET", "str", "v"}, res: "OK"},
+		{name: "测试WRONGTYPE", args: []string{"HGET", "str", "a"}, res: errWrongType},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert.Equal(t, v.res, doCommand(s, p, v.args...))
+		})
+	}
+}
+
+func TestHGetAllProtocol(t *testing.T) {
+	s := NewService(Config{})
+	p, buf := newTestPeer(s)
+	doCommand(s, p, "HSET", "h", "a", "1")
+	p.handleMSG(s, Message{peer: p, args: []string{"HGETALL", "h"}})
+	assert.Equal(t, "*2\r\n$1\r\na\r\n$1\r\n1\r\n", buf.String())
+	buf.Reset()
+	p.setProtocol(resp.Proto3)
+	p.handleMSG(s, Message{peer: p, args: []string{"HGETALL", "h"}})
+	assert.Equal(t, "%1\r\n$1\r\na\r\n$1\r\n1\r\n", buf.String())
+}
+
+func TestHRandField(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	doCommand(s, p, "HSET", "h", "a", "1", "b", "2", "c", "3")
+
+	// 正数count返回不重复的字段，最多返回全部字段
+	res := doCommand(s, p, "HRANDFIELD", "h", "10").(resp.Array)
+	assert.ElementsMatch(t, resp.Array{resp.BulkStrings("a"), resp.BulkStrings("b"), resp.BulkStrings("c")}, res)
+
+	// 负数count允许重复，恰好返回-count个
+	res = doCommand(s, p, "HRANDFIELD", "h", "-7").(resp.Array)
+	assert.Len(t, res, 7)
+
+	// RESP2下WITHVALUES返回平铺的数组，RESP3下返回字段与值组成的二元数组
+	res = doCommand(s, p, "HRANDFIELD", "h", "2", "WITHVALUES").(resp.Array)
+	assert.Len(t, res, 4)
+	p.setProtocol(resp.Proto3)
+	res = doCommand(s, p, "HRANDFIELD", "h", "-2", "WITHVALUES").(resp.Array)
+	assert.Len(t, res, 2)
+	for _, pair := range res {
+		kv := pair.(resp.Array)
+		assert.Equal(t, doCommand(s, p, "HGET", "h", string(kv[0].(resp.BulkStrings))), kv[1])
+	}
+}
+
+func TestHScan(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	args := []string{"HSET", "h"}
+	for i := 0; i < 100; i++ {
+		f := string(rune('a'+i%26)) + strings.Repeat("x", i/26)
+		args = append(args, f, "v")
+	}
+	doCommand(s, p, args...)
+
+	// 完整遍历一次应当恰好返回每个字段一次
+	seen := make(map[string]int)
+	cursor := "0"
+	for {
+		res := doCommand(s, p, "HSCAN", "h", cursor, "COUNT", "7", "NOVALUES").(resp.Array)
+		for _, f := range res[1].(resp.Array) {
+			seen[string(f.(resp.BulkStrings))]++
+		}
+		cursor = string(res[0].(resp.BulkStrings))
+		if cursor == "0" {
+			break
+		}
+	}
+	assert.Len(t, seen, 100)
+	for _, n := range seen {
+		assert.Equal(t, 1, n)
+	}
+
+	res := doCommand(s, p, "HSCAN", "h", "0", "MATCH", "a*", "COUNT", "1000").(resp.Array)
+	assert.Equal(t, resp.BulkStrings("0"), res[0])
+	assert.Len(t, res[1], 8)
+	assert.Equal(t, errCursor, doCommand(s, p, "HSCAN", "h", "x"))
+	assert.Equal(t, errSyntax, doCommand(s, p, "HSCAN", "h", "0", "COUNT", "0"))
+}
@@ -0,0 +1,584 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+)
+
+const (
+	CommentLPush      = "LPUSH"
+	CommentRPush      = "RPUSH"
+	CommentLPushX     = "LPUSHX"
+	CommentRPushX     = "RPUSHX"
+	CommentLPop       = "LPOP"
+	CommentRPop       = "RPOP"
+	CommentLLen       = "LLEN"
+	CommentLRange     = "LRANGE"
+	CommentLIndex     = "LINDEX"
+	CommentLSet       = "LSET"
+	CommentLRem       = "LREM"
+	CommentLTrim      = "LTRIM"
+	CommentLInsert    = "LINSERT"
+	CommentLMove      = "LMOVE"
+	CommentRPopLPush  = "RPOPLPUSH"
+	CommentLMPop      = "LMPOP"
+	CommentBLPop      = "BLPOP"
+	CommentBRPop      = "BRPOP"
+	CommentBLMove     = "BLMOVE"
+	CommentBRPopLPush = "BRPOPLPUSH"
+	CommentBLMPop     = "BLMPOP"
+)
+
+const (
+	listHead = iota
+	listTail
+)
+
+var (
+	errNoSuchKey     = errors.New("ERR no such key")
+	errIndexOutRange = errors.New("ERR index out of range")
+	errNotPositive   = errors.New("ERR value is out of range, must be positive")
+)
+
+func init() {
+	registerCommand(
+		&command{name: CommentLPush, arity: -3, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: lpushCommand},
+		&command{name: CommentRPush, arity: -3, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: rpushCommand},
+		&command{name: CommentLPushX, arity: -3, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: lpushxCommand},
+		&command{name: CommentRPushX, arity: -3, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: rpushxCommand},
+		&command{name: CommentLPop, arity: -2, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: lpopCommand},
+		&command{name: CommentRPop, arity: -2, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: rpopCommand},
+		&command{name: CommentLLen, arity: 2, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: llenCommand},
+		&command{name: CommentLRange, arity: 4, flags: cmdReadonly, firstKey: 1, lastKey: 1, keyStep: 1, proc: lrangeCommand},
+		&command{name: CommentLIndex, arity: 3, flags: cmdReadonly, firstKey: 1, lastKey: 1, keyStep: 1, proc: lindexCommand},
+		&command{name: CommentLSet, arity: 4, flags: cmdWrite, firstKey: 1, lastKey: 1, keyStep: 1, proc: lsetCommand},
+		&command{name: CommentLRem, arity: 4, flags: cmdWrite, firstKey: 1, lastKey: 1, keyStep: 1, proc: lremCommand},
+		&command{name: CommentLTrim, arity: 4, flags: cmdWrite, firstKey: 1, lastKey: 1, keyStep: 1, proc: ltrimCommand},
+		&command{name: CommentLInsert, arity: 5, flags: cmdWrite, firstKey: 1, lastKey: 1, keyStep: 1, proc: linsertCommand},
+		&command{name: CommentLMove, arity: 5, flags: cmdWrite, firstKey: 1, lastKey: 2, keyStep: 1, proc: lmoveCommand},
+		&command{name: CommentRPopLPush, arity: 3, flags: cmdWrite, firstKey: 1, lastKey: 2, keyStep: 1, proc: rpoplpushCommand},
+		&command{name: CommentLMPop, arity: -4, flags: cmdWrite, getkeys: mpopKeys(1), proc: lmpopCommand},
+		&command{name: CommentBLPop, arity: -3, flags: cmdWrite | cmdBlocking, firstKey: 1, lastKey: -2, keyStep: 1, proc: blpopCommand},
+		&command{name: CommentBRPop, arity: -3, flags: cmdWrite | cmdBlocking, firstKey: 1, lastKey: -2, keyStep: 1, proc: brpopCommand},
+		&command{name: CommentBLMove, arity: 6, flags: cmdWrite | cmdBlocking, firstKey: 1, lastKey: 2, keyStep: 1, proc: blmoveCommand},
+		&command{name: CommentBRPopLPush, arity: 4, flags: cmdWrite | cmdBlocking, firstKey: 1, lastKey: 2, keyStep: 1, proc: brpoplpushCommand},
+		&command{name: CommentBLMPop, arity: -5, flags: cmdWrite | cmdBlocking, getkeys: mpopKeys(2), proc: blmpopCommand},
+	)
+}
+
+// lookupList 获取列表类型的值，键不存在时返回nil
+func (ks *keyspace) lookupList(key string) (*quicklist, error) {
+	val, ok := ks.lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	ql, isList := val.(*quicklist)
+	if !isList {
+		return nil, errWrongType
+	}
+	return ql, nil
+}
+
+// listPush 向列表中插入元素，键不存在且create为true时创建列表
+func listPush(s *Service, key string, elems []string, where int, create bool) any {
+	ql, err := s.db.lookupList(key)
+	if err != nil {
+		return err
+	}
+	if ql == nil {
+		if !create {
+			return int64(0)
+		}
+		ql = newQuicklist()
+		s.db.set(key, ql)
+	}
+	for _, e := range elems {
+		if where == listHead {
+			ql.pushHead(e)
+		} else {
+			ql.pushTail(e)
+		}
+	}
+	s.db.signalModifiedKey(key)
+	s.signalKeyAsReady(key)
+	return int64(ql.len())
+}
+
+// listPop 从列表一端弹出元素，列表为空时删除键
+func listPop(s *Service, key string, ql *quicklist, where int) string {
+	var v string
+	if where == listHead {
+		v, _ = ql.popHead()
+	} else {
+		v, _ = ql.popTail()
+	}
+	if ql.len() == 0 {
+		s.db.delete(key)
+	} else {
+		s.db.signalModifiedKey(key)
+	}
+	return v
+}
+
+// lpushCommand LPUSH key element [element ...]
+func lpushCommand(s *Service, p *Peer, args []string) any {
+	return listPush(s, args[1], args[2:], listHead, true)
+}
+
+// rpushCommand RPUSH key element [element ...]
+func rpushCommand(s *Service, p *Peer, args []string) any {
+	return listPush(s, args[1], args[2:], listTail, true)
+}
+
+// lpushxCommand LPUSHX key element [element ...]
+func lpushxCommand(s *Service, p *Peer, args []string) any {
+	return listPush(s, args[1], args[2:], listHead, false)
+}
+
+// rpushxCommand RPUSHX key element [element ...]
+func rpushxCommand(s *Service, p *Peer, args []string) any {
+	return listPush(s, args[1], args[2:], listTail, false)
+}
+
+// popGenericCommand LPOP|RPOP key [count]
+func popGenericCommand(s *Service, args []string, where int) any {
+	if len(args) > 3 {
+		return wrongArityErr(args[0])
+	}
+	count := int64(-1)
+	if len(args) == 3 {
+		c, err := parseInt(args[2])
+		if err != nil || c < 0 {
+			return errNotPositive
+		}
+		count = c
+	}
+	key := args[1]
+	ql, err := s.db.lookupList(key)
+	if err != nil {
+		return err
+	}
+	if ql == nil {
+		if count >= 0 {
+			return resp.Array(nil)
+		}
+		return nil
+	}
+	if count < 0 {
+		return resp.BulkStrings(listPop(s, key, ql, where))
+	}
+	res := make(resp.Array, 0, min(count, int64(ql.len())))
+	for int64(len(res)) < count && ql.len() > 0 {
+		res = append(res, resp.BulkStrings(listPop(s, key, ql, where)))
+	}
+	return res
+}
+
+// lpopCommand LPOP key [count]
+func lpopCommand(s *Service, p *Peer, args []string) any {
+	return popGenericCommand(s, args, listHead)
+}
+
+// rpopCommand RPOP key [count]
+func rpopCommand(s *Service, p *Peer, args []string) any {
+	return popGenericCommand(s, args, listTail)
+}
+
+// llenCommand LLEN key
+func llenCommand(s *Service, p *Peer, args []string) any {
+	ql, err := s.db.lookupList(args[1])
+	if err != nil {
+		return err
+	}
+	if ql == nil {
+		return int64(0)
+	}
+	return int64(ql.len())
+}
+
+// normalizeRange 将支持负数的[start, end]区间规范化为长度为n的序列中的合法下标，
+// 区间为空时返回ok为false
+func normalizeRange(start, end, n int64) (int64, int64, bool) {
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if end < 0 {
+		end += n
+	}
+	end = min(end, n-1)
+	if start > end || start >= n {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// lrangeCommand LRANGE key start stop
+func lrangeCommand(s *Service, p *Peer, args []string) any {
+	start, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	end, err := parseInt(args[3])
+	if err != nil {
+		return err
+	}
+	ql, err := s.db.lookupList(args[1])
+	if err != nil {
+		return err
+	}
+	if ql == nil {
+		return resp.Array{}
+	}
+	start, end, ok := normalizeRange(start, end, int64(ql.len()))
+	if !ok {
+		return resp.Array{}
+	}
+	res := make(resp.Array, 0, end-start+1)
+	ql.iterRange(int(start), int(end), func(v string) bool {
+		res = append(res, resp.BulkStrings(v))
+		return true
+	})
+	return res
+}
+
+// lindexCommand LINDEX key index
+func lindexCommand(s *Service, p *Peer, args []string) any {
+	idx, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	ql, err := s.db.lookupList(args[1])
+	if err != nil || ql == nil {
+		return err
+	}
+	v, ok := ql.index(int(idx))
+	if !ok {
+		return nil
+	}
+	return resp.BulkStrings(v)
+}
+
+// lsetCommand LSET key index element
+func lsetCommand(s *Service, p *Peer, args []string) any {
+	idx, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	key := args[1]
+	ql, err := s.db.lookupList(key)
+	if err != nil {
+		return err
+	}
+	if ql == nil {
+		return errNoSuchKey
+	}
+	if !ql.set(int(idx), args[3]) {
+		return errIndexOutRange
+	}
+	s.db.signalModifiedKey(key)
+	return "OK"
+}
+
+// lremCommand LREM key count element
+func lremCommand(s *Service, p *Peer, args []string) any {
+	count, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	key := args[1]
+	ql, err := s.db.lookupList(key)
+	if err != nil {
+		return err
+	}
+	if ql == nil {
+		return int64(0)
+	}
+	removed := ql.remove(args[3], int(count))
+	if removed > 0 {
+		if ql.len() == 0 {
+			s.db.delete(key)
+		} else {
+			s.db.signalModifiedKey(key)
+		}
+	}
+	return int64(removed)
+}
+
+// ltrimCommand LTRIM key start stop
+func ltrimCommand(s *Service, p *Peer, args []string) any {
+	start, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	end, err := parseInt(args[3])
+	if err != nil {
+		return err
+	}
+	key := args[1]
+	ql, err := s.db.lookupList(key)
+	if err != nil {
+		return err
+	}
+	if ql == nil {
+		return "OK"
+	}
+	start, end, ok := normalizeRange(start, end, int64(ql.len()))
+	if !ok {
+		s.db.delete(key)
+		return "OK"
+	}
+	ql.trim(int(start), int(end))
+	s.db.signalModifiedKey(key)
+	return "OK"
+}
+
+// linsertCommand LINSERT key BEFORE|AFTER pivot element
+func linsertCommand(s *Service, p *Peer, args []string) any {
+	var after bool
+	switch strings.ToUpper(args[2]) {
+	case "BEFORE":
+	case "AFTER":
+		after = true
+	default:
+		return errSyntax
+	}
+	key := args[1]
+	ql, err := s.db.lookupList(key)
+	if err != nil {
+		return err
+	}
+	if ql == nil {
+		return int64(0)
+	}
+	if !ql.insert(args[3], args[4], after) {
+		return int64(-1)
+	}
+	s.db.signalModifiedKey(key)
+	return int64(ql.len())
+}
+
+// parseListWhere 解析LEFT|RIGHT
+func parseListWhere(arg string) (int, error) {
+	switch strings.ToUpper(arg) {
+	case "LEFT":
+		return listHead, nil
+	case "RIGHT":
+		return listTail, nil
+	default:
+		return 0, errSyntax
+	}
+}
+
+// listWhereName 返回LEFT|RIGHT，与parseListWhere相反
+func listWhereName(where int) string {
+	if where == listHead {
+		return "LEFT"
+	}
+	return "RIGHT"
+}
+
+// lmoveGeneric 从src的一端弹出元素并插入到dst的一端，src不存在时返回nil
+func lmoveGeneric(s *Service, src, dst string, from, to int) any {
+	sql, err := s.db.lookupList(src)
+	if err != nil || sql == nil {
+		return err
+	}
+	if _, err = s.db.lookupList(dst); err != nil {
+		return err
+	}
+	v := listPop(s, src, sql, from)
+	listPush(s, dst, []string{v}, to, true)
+	return resp.BulkStrings(v)
+}
+
+// lmoveCommand LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func lmoveCommand(s *Service, p *Peer, args []string) any {
+	from, err := parseListWhere(args[3])
+	if err != nil {
+		return err
+	}
+	to, err := parseListWhere(args[4])
+	if err != nil {
+		return err
+	}
+	return lmoveGeneric(s, args[1], args[2], from, to)
+}
+
+// rpoplpushCommand RPOPLPUSH source destination
+func rpoplpushCommand(s *Service, p *Peer, args []string) any {
+	return lmoveGeneric(s, args[1], args[2], listTail, listHead)
+}
+
+// blockingPopGeneric BLPOP|BRPOP key [key ...] timeout
+func blockingPopGeneric(s *Service, p *Peer, args []string, where int) any {
+	timeout, err := parseTimeout(args[len(args)-1])
+	if err != nil {
+		return err
+	}
+	keys := args[1 : len(args)-1]
+	for _, key := range keys {
+		ql, err := s.db.lookupList(key)
+		if err != nil {
+			return err
+		}
+		if ql != nil && ql.len() > 0 {
+			// 以非阻塞的形式传播，副本与AOF载入时不会执行阻塞命令
+			name := CommentLPop
+			if where == listTail {
+				name = CommentRPop
+			}
+			s.rewritePropagate([]string{name, key})
+			return resp.Array{resp.BulkStrings(key), resp.BulkStrings(listPop(s, key, ql, where))}
+		}
+	}
+	s.blockForKeys(p, blockList, keys, timeout, resp.Array(nil), args)
+	return noReply
+}
+
+// blpopCommand BLPOP key [key ...] timeout
+func blpopCommand(s *Service, p *Peer, args []string) any {
+	return blockingPopGeneric(s, p, args, listHead)
+}
+
+// brpopCommand BRPOP key [key ...] timeout
+func brpopCommand(s *Service, p *Peer, args []string) any {
+	return blockingPopGeneric(s, p, args, listTail)
+}
+
+// blmoveGeneric 源列表为空时阻塞，直到有数据或超时
+func blmoveGeneric(s *Service, p *Peer, args []string, from, to int, timeoutArg string) any {
+	timeout, err := parseTimeout(timeoutArg)
+	if err != nil {
+		return err
+	}
+	src := args[1]
+	ql, err := s.db.lookupList(src)
+	if err != nil {
+		return err
+	}
+	if ql != nil && ql.len() > 0 {
+		s.rewritePropagate([]string{CommentLMove, src, args[2], listWhereName(from), listWhereName(to)})
+		return lmoveGeneric(s, src, args[2], from, to)
+	}
+	s.blockForKeys(p, blockList, []string{src}, timeout, nil, args)
+	return noReply
+}
+
+// blmoveCommand BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+func blmoveCommand(s *Service, p *Peer, args []string) any {
+	from, err := parseListWhere(args[3])
+	if err != nil {
+		return err
+	}
+	to, err := parseListWhere(args[4])
+	if err != nil {
+		return err
+	}
+	return blmoveGeneric(s, p, args, from, to, args[5])
+}
+
+// brpoplpushCommand BRPOPLPUSH source destination timeout
+func brpoplpushCommand(s *Service, p *Peer, args []string) any {
+	return blmoveGeneric(s, p, args, listTail, listHead, args[3])
+}
+
+// mpopKeys 返回LMPOP/BLMPOP类命令的键提取函数，numkeys位于下标pos
+func mpopKeys(pos int) func(args []string) []string {
+	return func(args []string) []string {
+		n, err := parseInt(args[pos])
+		if err != nil || n <= 0 || int(n) > len(args)-pos-1 {
+			return nil
+		}
+		return args[pos+1 : pos+1+int(n)]
+	}
+}
+
+// parseMPopArgs 解析numkeys key [key ...] LEFT|RIGHT [COUNT count]，args从numkeys开始
+func parseMPopArgs(args []string) (keys []string, where int, count int64, err error) {
+	n, err := parseInt(args[0])
+	if err != nil || n <= 0 {
+		return nil, 0, 0, errors.New("ERR numkeys should be greater than 0")
+	}
+	if n > int64(len(args)-2) {
+		return nil, 0, 0, errors.New("ERR Number of keys can't be greater than number of args")
+	}
+	keys = args[1 : 1+n]
+	rest := args[1+n:]
+	if where, err = parseListWhere(rest[0]); err != nil {
+		return nil, 0, 0, err
+	}
+	count = 1
+	switch {
+	case len(rest) == 1:
+	case len(rest) == 3 && strings.ToUpper(rest[1]) == "COUNT":
+		count, err = parseInt(rest[2])
+		if err != nil || count <= 0 {
+			return nil, 0, 0, errors.New("ERR count should be greater than 0")
+		}
+	default:
+		return nil, 0, 0, errSyntax
+	}
+	return keys, where, count, nil
+}
+
+// mpopGeneric 从第一个非空列表中弹出最多count个元素，返回弹出的键与元素，
+// 所有列表都为空时elems为nil
+func mpopGeneric(s *Service, keys []string, where int, count int64) (string, resp.Array, error) {
+	for _, key := range keys {
+		ql, err := s.db.lookupList(key)
+		if err != nil {
+			return "", nil, err
+		}
+		if ql == nil || ql.len() == 0 {
+			continue
+		}
+		elems := make(resp.Array, 0, min(count, int64(ql.len())))
+		for int64(len(elems)) < count && ql.len() > 0 {
+			elems = append(elems, resp.BulkStrings(listPop(s, key, ql, where)))
+		}
+		return key, elems, nil
+	}
+	return "", nil, nil
+}
+
+// lmpopCommand LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func lmpopCommand(s *Service, p *Peer, args []string) any {
+	keys, where, count, err := parseMPopArgs(args[1:])
+	if err != nil {
+		return err
+	}
+	key, elems, err := mpopGeneric(s, keys, where, count)
+	if err != nil {
+		return err
+	}
+	if elems == nil {
+		return resp.Array(nil)
+	}
+	return resp.Array{resp.BulkStrings(key), elems}
+}
+
+// blmpopCommand BLMPOP timeout numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func blmpopCommand(s *Service, p *Peer, args []string) any {
+	timeout, err := parseTimeout(args[1])
+	if err != nil {
+		return err
+	}
+	keys, where, count, err := parseMPopArgs(args[2:])
+	if err != nil {
+		return err
+	}
+	key, elems, err := mpopGeneric(s, keys, where, count)
+	if err != nil {
+		return err
+	}
+	if elems != nil {
+		// 以实际弹出的个数传播为LMPOP
+		s.rewritePropagate([]string{CommentLMPop, "1", key, listWhereName(where), "COUNT", strconv.Itoa(len(elems))})
+		return resp.Array{resp.BulkStrings(key), elems}
+	}
+	s.blockForKeys(p, blockList, keys, timeout, resp.Array(nil), args)
+	return noReply
+}
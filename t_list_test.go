@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListCommands(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	testCases := []struct {
+		name string
+		args []string
+		res  any
+	}{
+		{name: "测试RPUSH", args: []string{"RPUSH", "l", "a", "b", "c"}, res: int64(3)},
+		{name: "测试LPUSH", args: []string{"LPUSH", "l", "z"}, res: int64(4)},
+		{name: "测试LPUSHX不存在", args: []string{"LPUSHX", "none", "a"}, res: int64(0)},
+		{name: "测试TYPE", args: []string{"TYPE", "l"}, res: "list"},
+		{name: "测试LRANGE", args: []string{"LRANGE", "l", "0", "-1"}, res: resp.Array{resp.BulkStrings("z"), resp.BulkStrings("a"), resp.BulkStrings("b"), resp.BulkStrings("c")}},
+		{name: "测试LRANGE越界", args: []string{"LRANGE", "l", "5", "10"}, res: resp.Array{}},
+		{name: "测试LINDEX", args: []string{"LINDEX", "l", "-1"}, res: resp.BulkStrings("c")},
+		{name: "测试LINDEX越界", args: []string{"LINDEX", "l", "10"}, res: nil},
+		{name: "测试LSET", args: []string{"LSET", "l", "0", "y"}, res: "OK"},
+		{name: "测试LSET越界", args: []string{"LSET", "l", "10", "y"}, res: errIndexOutRange},
+		{name: "测试LSET不存在", args: []string{"LSET", "none", "0", "y"}, res: errNoSuchKey},
+		{name: "测试LINSERT", args: []string{"LINSERT", "l", "AFTER", "a", "a"}, res: int64(5)},
+		{name: "测试LINSERT无pivot", args: []string{"LINSERT", "l", "BEFORE", "x", "a"}, res: int64(-1)},
+		{name: "测试LREM", args: []string{"LREM", "l", "-1", "a"}, res: int64(1)},
+		{name: "测试LLEN", args: []string{"LLEN", "l"}, res: int64(4)},
+		{name: "测试LTRIM", args: []string{"LTRIM", "l", "1", "-2"}, res: "OK"},
+		{name: "测试LTRIM结果", args: []string{"LRANGE", "l", "0", "-1"}, res: resp.Array{resp.BulkStrings("a"), resp.BulkStrings("b")}},
+		{name: "测试LMOVE", args: []string{"LMOVE", "l", "l2", "LEFT", "RIGHT"}, res: resp.BulkStrings("a")},
+		{name: "测试RPOPLPUSH", args: []string{"RPOPLPUSH", "l", "l2"}, res: resp.BulkStrings("b")},
+		{name: "测试空列表删除", args: []string{"EXISTS", "l"}, res: int64(0)},
+		{name: "测试LPOP count", args: []string{"LPOP", "l2", "5"}, res: resp.Array{resp.BulkStrings("b"), resp.BulkStrings("a")}},
+		{name: "测试LPOP count不存在", args: []string{"LPOP", "l2", "1"}, res: resp.Array(nil)},
+		{name: "测试RPOP不存在", args: []string{"RPOP", "l2"}, res: nil},
+		{name: "测试LPOP负数count", args: []string{"LPOP", "l2", "-1"}, res: errNotPositive},
+		{name: "测试SET", args: []string{"SET", "str", "v"}, res: "OK"},
+		{name: "测试WRONGTYPE", args: []string{"LPUSH", "str", "v"}, res: errWrongType},
+		{name: "测试LMOVE目标WRONGTYPE", args: []string{"RPUSH", "l", "a"}, res: int64(1)},
+		{name: "测试LMOVE目标类型错误", args: []string{"LMOVE", "l", "str", "LEFT", "LEFT"}, res: errWrongType},
+		{name: "测试LMOVE未弹出", args: []string{"LLEN", "l"}, res: int64(1)},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert.Equal(t, v.res, doCommand(s, p, v.args...))
+		})
+	}
+}
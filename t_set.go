@@ -0,0 +1,590 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+	gttype "github.com/BeginerAndProgresses/generalized-tools/type"
+)
+
+const (
+	CommentSAdd        = "SADD"
+	CommentSRem        = "SREM"
+	CommentSMembers    = "SMEMBERS"
+	CommentSIsMember   = "SISMEMBER"
+	CommentSMIsMember  = "SMISMEMBER"
+	CommentSCard       = "SCARD"
+	CommentSMove       = "SMOVE"
+	CommentSInter      = "SINTER"
+	CommentSInterCard  = "SINTERCARD"
+	CommentSInterStore = "SINTERSTORE"
+	CommentSUnion      = "SUNION"
+	CommentSUnionStore = "SUNIONSTORE"
+	CommentSDiff       = "SDIFF"
+	CommentSDiffStore  = "SDIFFSTORE"
+	CommentSPop        = "SPOP"
+	CommentSRandMember = "SRANDMEMBER"
+	CommentSScan       = "SSCAN"
+)
+
+// 集合运算类型
+const (
+	setOpUnion = iota
+	setOpInter
+	setOpDiff
+)
+
+// setObj 集合类型的值。元素全部为整数且个数不超过setMaxIntsetEntries时使用intset编码，
+// 否则转换为哈希表编码，转换后不再转回
+type setObj struct {
+	ints intset
+	// dict 哈希表编码，为nil表示当前为intset编码
+	dict map[string]struct{}
+}
+
+func init() {
+	registerCommand(
+		&command{name: CommentSAdd, arity: -3, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: saddCommand},
+		&command{name: CommentSRem, arity: -3, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: sremCommand},
+		&command{name: CommentSMembers, arity: 2, flags: cmdReadonly, firstKey: 1, lastKey: 1, keyStep: 1, proc: smembersCommand},
+		&command{name: CommentSIsMember, arity: 3, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: sismemberCommand},
+		&command{name: CommentSMIsMember, arity: -3, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: smismemberCommand},
+		&command{name: CommentSCard, arity: 2, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: scardCommand},
+		&command{name: CommentSMove, arity: 4, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 2, keyStep: 1, proc: smoveCommand},
+		&command{name: CommentSInter, arity: -2, flags: cmdReadonly, firstKey: 1, lastKey: -1, keyStep: 1, proc: sinterCommand},
+		&command{name: CommentSInterCard, arity: -3, flags: cmdReadonly, getkeys: mpopKeys(1), proc: sintercardCommand},
+		&command{name: CommentSInterStore, arity: -3, flags: cmdWrite, firstKey: 1, lastKey: -1, keyStep: 1, proc: sinterstoreCommand},
+		&command{name: CommentSUnion, arity: -2, flags: cmdReadonly, firstKey: 1, lastKey: -1, keyStep: 1, proc: sunionCommand},
+		&command{name: CommentSUnionStore, arity: -3, flags: cmdWrite, firstKey: 1, lastKey: -1, keyStep: 1, proc: sunionstoreCommand},
+		&command{name: CommentSDiff, arity: -2, flags: cmdReadonly, firstKey: 1, lastKey: -1, keyStep: 1, proc: sdiffCommand},
+		&command{name: CommentSDiffStore, arity: -3, flags: cmdWrite, firstKey: 1, lastKey: -1, keyStep: 1, proc: sdiffstoreCommand},
+		&command{name: CommentSPop, arity: -2, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: spopCommand},
+		&command{name: CommentSRandMember, arity: -2, flags: cmdReadonly, firstKey: 1, lastKey: 1, keyStep: 1, proc: srandmemberCommand},
+		&command{name: CommentSScan, arity: -3, flags: cmdReadonly, firstKey: 1, lastKey: 1, keyStep: 1, proc: sscanCommand},
+	)
+}
+
+func newSetObj() *setObj {
+	return &setObj{}
+}
+
+// encoding 返回当前的编码名称
+func (so *setObj) encoding() string {
+	if so.dict == nil {
+		return "intset"
+	}
+	return "hashtable"
+}
+
+// size 返回元素个数
+func (so *setObj) size() int {
+	if so.dict == nil {
+		return len(so.ints)
+	}
+	return len(so.dict)
+}
+
+// add 插入元素，返回是否新增。插入非整数或元素过多时转换为哈希表编码
+func (so *setObj) add(m string) bool {
+	if so.dict == nil {
+		if v, err := parseInt(m); err == nil {
+			if so.ints.has(v) {
+				return false
+			}
+			if len(so.ints) < setMaxIntsetEntries {
+				return so.ints.add(v)
+			}
+		}
+		so.convert()
+	}
+	if _, ok := so.dict[m]; ok {
+		return false
+	}
+	so.dict[m] = struct{}{}
+	return true
+}
+
+// convert 将intset编码转换为哈希表编码
+func (so *setObj) convert() {
+	so.dict = make(map[string]struct{}, len(so.ints)+1)
+	for _, m := range so.ints.strings() {
+		so.dict[m] = struct{}{}
+	}
+	so.ints = nil
+}
+
+// remove 删除元素，返回是否存在
+func (so *setObj) remove(m string) bool {
+	if so.dict == nil {
+		v, err := parseInt(m)
+		return err == nil && so.ints.remove(v)
+	}
+	if _, ok := so.dict[m]; !ok {
+		return false
+	}
+	delete(so.dict, m)
+	return true
+}
+
+// has 判断元素是否存在
+func (so *setObj) has(m string) bool {
+	if so.dict == nil {
+		v, err := parseInt(m)
+		return err == nil && so.ints.has(v)
+	}
+	_, ok := so.dict[m]
+	return ok
+}
+
+// members 返回全部元素，intset编码下按升序返回
+func (so *setObj) members() []string {
+	if so.dict == nil {
+		return so.ints.strings()
+	}
+	res := make([]string, 0, len(so.dict))
+	for m := range so.dict {
+		res = append(res, m)
+	}
+	return res
+}
+
+// clone 深拷贝集合，用于生成持久化快照
+func (so *setObj) clone() *setObj {
+	c := &setObj{ints: slices.Clone(so.ints)}
+	if so.dict != nil {
+		c.dict = maps.Clone(so.dict)
+	}
+	return c
+}
+
+// lookupSet 获取集合类型的值，键不存在时返回nil
+func (ks *keyspace) lookupSet(key string) (*setObj, error) {
+	val, ok := ks.lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	so, isSet := val.(*setObj)
+	if !isSet {
+		return nil, errWrongType
+	}
+	return so, nil
+}
+
+// setsReply 将元素构造为Sets回复，RESP2下由Writer降级为数组
+func setsReply(members []string) resp.Sets {
+	res := gttype.NewHashSet[any]()
+	for _, m := range members {
+		res.Add(resp.BulkStrings(m))
+	}
+	return res
+}
+
+// saddCommand SADD key member [member ...]
+func saddCommand(s *Service, p *Peer, args []string) any {
+	key := args[1]
+	so, err := s.db.lookupSet(key)
+	if err != nil {
+		return err
+	}
+	if so == nil {
+		so = newSetObj()
+		s.db.set(key, so)
+	}
+	var added int64
+	for _, m := range args[2:] {
+		if so.add(m) {
+			added++
+		}
+	}
+	if added > 0 {
+		s.db.signalModifiedKey(key)
+	}
+	return added
+}
+
+// sremCommand SREM key member [member ...]
+func sremCommand(s *Service, p *Peer, args []string) any {
+	key := args[1]
+	so, err := s.db.lookupSet(key)
+	if err != nil || so == nil {
+		return zeroOrErr(err)
+	}
+	var removed int64
+	for _, m := range args[2:] {
+		if so.remove(m) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		if so.size() == 0 {
+			s.db.delete(key)
+		} else {
+			s.db.signalModifiedKey(key)
+		}
+	}
+	return removed
+}
+
+// zeroOrErr 键不存在时统一回复0，类型错误时回复错误
+func zeroOrErr(err error) any {
+	if err != nil {
+		return err
+	}
+	return int64(0)
+}
+
+// smembersCommand SMEMBERS key
+func smembersCommand(s *Service, p *Peer, args []string) any {
+	so, err := s.db.lookupSet(args[1])
+	if err != nil {
+		return err
+	}
+	if so == nil {
+		return setsReply(nil)
+	}
+	return setsReply(so.members())
+}
+
+// sismemberCommand SISMEMBER key member
+func sismemberCommand(s *Service, p *Peer, args []string) any {
+	so, err := s.db.lookupSet(args[1])
+	if err != nil || so == nil {
+		return zeroOrErr(err)
+	}
+	if so.has(args[2]) {
+		return int64(1)
+	}
+	return int64(0)
+}
+
+// smismemberCommand SMISMEMBER key member [member ...]
+func smismemberCommand(s *Service, p *Peer, args []string) any {
+	so, err := s.db.lookupSet(args[1])
+	if err != nil {
+		return err
+	}
+	res := make(resp.Array, 0, len(args)-2)
+	for _, m := range args[2:] {
+		if so != nil && so.has(m) {
+			res = append(res, int64(1))
+		} else {
+			res = append(res, int64(0))
+		}
+	}
+	return res
+}
+
+// scardCommand SCARD key
+func scardCommand(s *Service, p *Peer, args []string) any {
+	so, err := s.db.lookupSet(args[1])
+	if err != nil || so == nil {
+		return zeroOrErr(err)
+	}
+	return int64(so.size())
+}
+
+// smoveCommand SMOVE source destination member
+func smoveCommand(s *Service, p *Peer, args []string) any {
+	src, dst, m := args[1], args[2], args[3]
+	srcSet, err := s.db.lookupSet(src)
+	if err != nil {
+		return err
+	}
+	dstSet, err := s.db.lookupSet(dst)
+	if err != nil {
+		return err
+	}
+	if srcSet == nil {
+		return int64(0)
+	}
+	if src == dst {
+		if srcSet.has(m) {
+			return int64(1)
+		}
+		return int64(0)
+	}
+	if !srcSet.remove(m) {
+		return int64(0)
+	}
+	if srcSet.size() == 0 {
+		s.db.delete(src)
+	} else {
+		s.db.signalModifiedKey(src)
+	}
+	if dstSet == nil {
+		dstSet = newSetObj()
+		s.db.set(dst, dstSet)
+	}
+	dstSet.add(m)
+	s.db.signalModifiedKey(dst)
+	return int64(1)
+}
+
+// setOperation 对keys对应的集合做并集、交集或差集运算，不存在的键视为空集合
+func (ks *keyspace) setOperation(keys []string, op int) (*setObj, error) {
+	sets := make([]*setObj, len(keys))
+	for i, key := range keys {
+		so, err := ks.lookupSet(key)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = so
+	}
+	res := newSetObj()
+	switch op {
+	case setOpUnion:
+		for _, so := range sets {
+			if so == nil {
+				continue
+			}
+			for _, m := range so.members() {
+				res.add(m)
+			}
+		}
+	case setOpInter:
+		// 遍历最小的集合，任一集合为空时结果为空
+		smallest := sets[0]
+		for _, so := range sets {
+			if so == nil {
+				return res, nil
+			}
+			if so.size() < smallest.size() {
+				smallest = so
+			}
+		}
+	next:
+		for _, m := range smallest.members() {
+			for _, so := range sets {
+				if so != smallest && !so.has(m) {
+					continue next
+				}
+			}
+			res.add(m)
+		}
+	case setOpDiff:
+		if sets[0] == nil {
+			return res, nil
+		}
+	diff:
+		for _, m := range sets[0].members() {
+			for _, so := range sets[1:] {
+				if so != nil && so.has(m) {
+					continue diff
+				}
+			}
+			res.add(m)
+		}
+	}
+	return res, nil
+}
+
+// setOperationCommand SINTER/SUNION/SDIFF的公共实现
+func setOperationCommand(s *Service, keys []string, op int) any {
+	res, err := s.db.setOperation(keys, op)
+	if err != nil {
+		return err
+	}
+	return setsReply(res.members())
+}
+
+// setOperationStoreCommand SINTERSTORE/SUNIONSTORE/SDIFFSTORE的公共实现，
+// 结果为空时删除目标键
+func setOperationStoreCommand(s *Service, dst string, keys []string, op int) any {
+	res, err := s.db.setOperation(keys, op)
+	if err != nil {
+		return err
+	}
+	if res.size() == 0 {
+		s.db.delete(dst)
+	} else {
+		s.db.set(dst, res)
+	}
+	return int64(res.size())
+}
+
+// sinterCommand SINTER key [key ...]
+func sinterCommand(s *Service, p *Peer, args []string) any {
+	return setOperationCommand(s, args[1:], setOpInter)
+}
+
+// sunionCommand SUNION key [key ...]
+func sunionCommand(s *Service, p *Peer, args []string) any {
+	return setOperationCommand(s, args[1:], setOpUnion)
+}
+
+// sdiffCommand SDIFF key [key ...]
+func sdiffCommand(s *Service, p *Peer, args []string) any {
+	return setOperationCommand(s, args[1:], setOpDiff)
+}
+
+// sinterstoreCommand SINTERSTORE destination key [key ...]
+func sinterstoreCommand(s *Service, p *Peer, args []string) any {
+	return setOperationStoreCommand(s, args[1], args[2:], setOpInter)
+}
+
+// sunionstoreCommand SUNIONSTORE destination key [key ...]
+func sunionstoreCommand(s *Service, p *Peer, args []string) any {
+	return setOperationStoreCommand(s, args[1], args[2:], setOpUnion)
+}
+
+// sdiffstoreCommand SDIFFSTORE destination key [key ...]
+func sdiffstoreCommand(s *Service, p *Peer, args []string) any {
+	return setOperationStoreCommand(s, args[1], args[2:], setOpDiff)
+}
+
+// sintercardCommand SINTERCARD numkeys key [key ...] [LIMIT limit]
+func sintercardCommand(s *Service, p *Peer, args []string) any {
+	n, err := parseInt(args[1])
+	if err != nil || n <= 0 {
+		return errors.New("ERR numkeys should be greater than 0")
+	}
+	if n > int64(len(args)-2) {
+		return errors.New("ERR Number of keys can't be greater than number of args")
+	}
+	keys := args[2 : 2+n]
+	var limit int64
+	for i := 2 + int(n); i < len(args); i++ {
+		if strings.ToUpper(args[i]) != "LIMIT" || i+1 >= len(args) {
+			return errSyntax
+		}
+		if limit, err = parseInt(args[i+1]); err != nil {
+			return err
+		}
+		if limit < 0 {
+			return errors.New("ERR LIMIT can't be negative")
+		}
+		i++
+	}
+	res, err := s.db.setOperation(keys, setOpInter)
+	if err != nil {
+		return err
+	}
+	card := int64(res.size())
+	if limit > 0 && card > limit {
+		card = limit
+	}
+	return card
+}
+
+// spopCommand SPOP key [count]
+func spopCommand(s *Service, p *Peer, args []string) any {
+	if len(args) > 3 {
+		return errSyntax
+	}
+	count := int64(1)
+	if len(args) == 3 {
+		c, err := parseInt(args[2])
+		if err != nil || c < 0 {
+			return errNotPositive
+		}
+		count = c
+	}
+	key := args[1]
+	so, err := s.db.lookupSet(key)
+	if err != nil {
+		return err
+	}
+	if so == nil {
+		if len(args) == 3 {
+			return setsReply(nil)
+		}
+		return nil
+	}
+	members := so.members()
+	rand.Shuffle(len(members), func(i, j int) { members[i], members[j] = members[j], members[i] })
+	popped := members[:min(count, int64(len(members)))]
+	for _, m := range popped {
+		so.remove(m)
+	}
+	if so.size() == 0 {
+		s.db.delete(key)
+	} else if len(popped) > 0 {
+		s.db.signalModifiedKey(key)
+	}
+	// 弹出的元素是随机的，以SREM传播
+	s.rewritePropagate(append([]string{CommentSRem, key}, popped...))
+	if len(args) == 3 {
+		return setsReply(popped)
+	}
+	return resp.BulkStrings(popped[0])
+}
+
+// srandmemberCommand SRANDMEMBER key [count]
+// count为正数时返回不重复的元素，为负数时可能重复并且恰好返回-count个
+func srandmemberCommand(s *Service, p *Peer, args []string) any {
+	if len(args) > 3 {
+		return errSyntax
+	}
+	var count int64
+	if len(args) == 3 {
+		c, err := parseInt(args[2])
+		if err != nil {
+			return err
+		}
+		if c < -math.MaxInt64/2 || c > math.MaxInt64/2 {
+			return errValueOutRange
+		}
+		count = c
+	}
+	so, err := s.db.lookupSet(args[1])
+	if err != nil {
+		return err
+	}
+	var members []string
+	if so != nil {
+		members = so.members()
+	}
+	if len(args) == 2 {
+		if len(members) == 0 {
+			return nil
+		}
+		return resp.BulkStrings(members[rand.IntN(len(members))])
+	}
+	var picked []string
+	switch {
+	case len(members) == 0 || count == 0:
+	case count > 0:
+		rand.Shuffle(len(members), func(i, j int) { members[i], members[j] = members[j], members[i] })
+		picked = members[:min(count, int64(len(members)))]
+	default:
+		picked = make([]string, -count)
+		for i := range picked {
+			picked[i] = members[rand.IntN(len(members))]
+		}
+	}
+	res := make(resp.Array, 0, len(picked))
+	for _, m := range picked {
+		res = append(res, resp.BulkStrings(m))
+	}
+	return res
+}
+
+// sscanCommand SSCAN key cursor [MATCH pattern] [COUNT count]
+func sscanCommand(s *Service, p *Peer, args []string) any {
+	opt, err := parseScanArgs(args[2:])
+	if err != nil {
+		return err
+	}
+	so, err := s.db.lookupSet(args[1])
+	if err != nil {
+		return err
+	}
+	var members []string
+	if so != nil {
+		members = so.members()
+	}
+	batch, next := scanOrder(members, opt.cursor, opt.count)
+	res := make(resp.Array, 0, len(batch))
+	for _, m := range batch {
+		if opt.match == "" || stringMatch(opt.match, m, false) {
+			res = append(res, resp.BulkStrings(m))
+		}
+	}
+	return resp.Array{resp.BulkStrings(strconv.FormatUint(next, 10)), res}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetCommands(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	testCases := []struct {
+		name string
+		args []string
+		res  any
+	}{
+		{name: "测试SADD", args: []string{"SADD", "s1", "1", "2", "3", "2"}, res: int64(3)},
+		{name: "测试TYPE", args: []string{"TYPE", "s1"}, res: "set"},
+		{name: "测试intset编码", args: []string{"OBJECT", "ENCODING", "s1"}, res: resp.BulkStrings("intset")},
+		{name: "测试SADD第二个集合", args: []string{"SADD", "s2", "2", "3", "4"}, res: int64(3)},
+		{name: "测试SADD非整数", args: []string{"SADD", "s3", "3", "a"}, res: int64(2)},
+		{name: "测试哈希表编码", args: []string{"OBJECT", "ENCODING", "s3"}, res: resp.BulkStrings("hashtable")},
+		{name: "测试SMEMBERS", args: []string{"SMEMBERS", "s1"}, res: setsReply([]string{"1", "2", "3"})},
+		{name: "测试SMEMBERS不存在", args: []string{"SMEMBERS", "none"}, res: setsReply(nil)},
+		{name: "测试SISMEMBER", args: []string{"SISMEMBER", "s1", "2"}, res: int64(1)},
+		{name: "测试SISMEMBER非整数", args: []string{"SISMEMBER", "s1", "02"}, res: int64(0)},
+		{name: "测试SMISMEMBER", args: []string{"SMISMEMBER", "s3", "a", "b", "3"}, res: resp.Array{int64(1), int64(0), int64(1)}},
+		{name: "测试SCARD", args: []string{"SCARD", "s1"}, res: int64(3)},
+		{name: "测试SINTER", args: []string{"SINTER", "s1", "s2", "s3"}, res: setsReply([]string{"3"})},
+		{name: "测试SINTER不存在", args: []string{"SINTER", "s1", "none"}, res: setsReply(nil)},
+		{name: "测试SUNION", args: []string{"SUNION", "s1", "s2", "none"}, res: setsReply([]string{"1", "2", "3", "4"})},
+		{name: "测试SDIFF", args: []string{"SDIFF", "s1", "s2"}, res: setsReply([]string{"1"})},
+		{name: "测试SINTERCARD", args: []string{"SINTERCARD", "2", "s1", "s2"}, res: int64(2)},
+		{name: "测试SINTERCARD LIMIT", args: []string{"SINTERCARD", "2", "s1", "s2", "LIMIT", "1"}, res: int64(1)},
+		{name: "测试SINTERCARD numkeys", args: []string{"SINTERCARD", "3", "s1", "s2"}, res: errors.New("ERR Number of keys can't be greater than number of args")},
+		{name: "测试SINTERSTORE", args: []string{"SINTERSTORE", "d", "s1", "s2"}, res: int64(2)},
+		{name: "测试STORE结果", args: []string{"SMEMBERS", "d"}, res: setsReply([]string{"2", "3"})},
+		{name: "测试SUNIONSTORE", args: []string{"SUNIONSTORE", "d", "s1", "s3"}, res: int64(4)},
+		{name: "测试SDIFFSTORE为空", args: []string{"SDIFFSTORE", "d", "s1", "s1"}, res: int64(0)},
+		{name: "测试SDIFFSTORE删除目标", args: []string{"EXISTS", "d"}, res: int64(0)},
+		{name: "测试SMOVE", args: []string{"SMOVE", "s3", "s1", "a"}, res: int64(1)},
+		{name: "测试SMOVE后编码", args: []string{"OBJECT", "ENCODING", "s1"}, res: resp.BulkStrings("hashtable")},
+		{name: "测试SMOVE不存在", args: []string{"SMOVE", "s3", "s1", "a"}, res: int64(0)},
+		{name: "测试SREM", args: []string{"SREM", "s3", "3", "x"}, res: int64(1)},
+		{name: "测试空集合删除", args: []string{"EXISTS", "s3"}, res: int64(0)},
+		{name: "测试SPOP不存在", args: []string{"SPOP", "none"}, res: nil},
+		{name: "测试SPOP负数", args: []string{"SPOP", "s1", "-1"}, res: errors.New("ERR value is out of range, must be positive")},
+		{name: "测试SPOP全部", args: []string{"SPOP", "s2", "10"}, res: setsReply([]string{"2", "3", "4"})},
+		{name: "测试SPOP后删除", args: []string{"EXISTS", "s2"}, res: int64(0)},
+		{name: "测试SRANDMEMBER不存在", args: []string{"SRANDMEMBER", "none", "5"}, res: resp.Array{}},
+		{name: "测试SET", args: []string{"SET", "str", "v"}, res: "OK"},
+		{name: "测试WRONGTYPE", args: []string{"SADD", "str", "v"}, res: errWrongType},
+		{name: "测试运算WRONGTYPE", args: []string{"SUNION", "s1", "str"}, res: errWrongType},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert.Equal(t, v.res, doCommand(s, p, v.args...))
+		})
+	}
+}
+
+func TestSetEncodingConversion(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	args := []string{"SADD", "s"}
+	for i := setMaxIntsetEntries; i > 0; i-- {
+		args = append(args, strconv.Itoa(i))
+	}
+	doCommand(s, p, args...)
+	assert.Equal(t, resp.BulkStrings("intset"), doCommand(s, p, "OBJECT", "ENCODING", "s"))
+	so, _ := s.db.lookupSet("s")
+	assert.Equal(t, "1", so.members()[0])
+
+	// 超过上限后转换为哈希表编码，元素保持不变
+	doCommand(s, p, "SADD", "s", "0")
+	assert.Equal(t, resp.BulkStrings("hashtable"), doCommand(s, p, "OBJECT", "ENCODING", "s"))
+	assert.Equal(t, int64(setMaxIntsetEntries+1), doCommand(s, p, "SCARD", "s"))
+	assert.Equal(t, int64(1), doCommand(s, p, "SISMEMBER", "s", "512"))
+}
+
+func TestSMembersProtocol(t *testing.T) {
+	s := NewService(Config{})
+	p, buf := newTestPeer(s)
+	doCommand(s, p, "SADD", "s", "a")
+	p.handleMSG(s, Message{peer: p, args: []string{"SMEMBERS", "s"}})
+	assert.Equal(t, "*1\r\n$1\r\na\r\n", buf.String())
+	buf.Reset()
+	p.setProtocol(resp.Proto3)
+	p.handleMSG(s, Message{peer: p, args: []string{"SMEMBERS", "s"}})
+	assert.Equal(t, "~1\r\n$1\r\na\r\n", buf.String())
+}
+
+func TestSRandMember(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	doCommand(s, p, "SADD", "s", "a", "b", "c")
+	res := doCommand(s, p, "SRANDMEMBER", "s", "10").(resp.Array)
+	assert.ElementsMatch(t, resp.Array{resp.BulkStrings("a"), resp.BulkStrings("b"), resp.BulkStrings("c")}, res)
+	res = doCommand(s, p, "SRANDMEMBER", "s", "-5").(resp.Array)
+	assert.Len(t, res, 5)
+	assert.Equal(t, int64(3), doCommand(s, p, "SCARD", "s"))
+
+	res = doCommand(s, p, "SSCAN", "s", "0", "MATCH", "[ab]").(resp.Array)
+	assert.Equal(t, resp.BulkStrings("0"), res[0])
+	assert.ElementsMatch(t, resp.Array{resp.BulkStrings("a"), resp.BulkStrings("b")}, res[1])
+}
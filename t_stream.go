@@ -0,0 +1,1128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+)
+
+const (
+	CommentXAdd       = "XADD"
+	CommentXRange     = "XRANGE"
+	CommentXRevRange  = "XREVRANGE"
+	CommentXLen       = "XLEN"
+	CommentXTrim      = "XTRIM"
+	CommentXDel       = "XDEL"
+	CommentXSetID     = "XSETID"
+	CommentXRead      = "XREAD"
+	CommentXReadGroup = "XREADGROUP"
+	CommentXGroup     = "XGROUP"
+	CommentXAck       = "XACK"
+	CommentXPending   = "XPENDING"
+	CommentXClaim     = "XCLAIM"
+	CommentXAutoClaim = "XAUTOCLAIM"
+)
+
+// 裁剪策略
+const (
+	trimNone = iota
+	trimMaxLen
+	trimMinID
+)
+
+var (
+	errXAddIDTooSmall  = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	errXAddIDZero      = errors.New("ERR The ID specified in XADD must be greater than 0-0")
+	errStreamExhausted = errors.New("ERR The stream has exhausted the last possible ID, unable to add more items")
+)
+
+// streamTrimArgs XADD与XTRIM的裁剪选项
+type streamTrimArgs struct {
+	strategy int
+	approx   bool
+	maxlen   int64
+	minID    streamID
+	// limit 近似裁剪时最多删除的条目数，0表示不限
+	limit int64
+}
+
+func init() {
+	registerCommand(
+		&command{name: CommentXAdd, arity: -5, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: xaddCommand},
+		&command{name: CommentXRange, arity: -4, flags: cmdReadonly, firstKey: 1, lastKey: 1, keyStep: 1, proc: xrangeCommand},
+		&command{name: CommentXRevRange, arity: -4, flags: cmdReadonly, firstKey: 1, lastKey: 1, keyStep: 1, proc: xrevrangeCommand},
+		&command{name: CommentXLen, arity: 2, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: xlenCommand},
+		&command{name: CommentXTrim, arity: -4, flags: cmdWrite, firstKey: 1, lastKey: 1, keyStep: 1, proc: xtrimCommand},
+		&command{name: CommentXDel, arity: -3, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: xdelCommand},
+		&command{name: CommentXSetID, arity: -3, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: xsetidCommand},
+		&command{name: CommentXRead, arity: -4, flags: cmdReadonly | cmdBlocking, getkeys: xreadKeys, proc: xreadCommand},
+		&command{name: CommentXReadGroup, arity: -7, flags: cmdWrite | cmdBlocking, getkeys: xreadKeys, proc: xreadgroupCommand},
+		&command{name: CommentXGroup, arity: -2, flags: cmdWrite, firstKey: 2, lastKey: 2, keyStep: 1, proc: xgroupCommand},
+		&command{name: CommentXAck, arity: -4, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: xackCommand},
+		&command{name: CommentXPending, arity: -3, flags: cmdReadonly, firstKey: 1, lastKey: 1, keyStep: 1, proc: xpendingCommand},
+		&command{name: CommentXClaim, arity: -6, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: xclaimCommand},
+		&command{name: CommentXAutoClaim, arity: -6, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: xautoclaimCommand},
+	)
+}
+
+// lookupStream 获取流类型的值，键不存在时返回nil
+func (ks *keyspace) lookupStream(key string) (*stream, error) {
+	val, ok := ks.lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	st, isStream := val.(*stream)
+	if !isStream {
+		return nil, errWrongType
+	}
+	return st, nil
+}
+
+// streamEntryReply 将条目编码为[id, [field, value, ...]]
+func streamEntryReply(e streamEntry) resp.Array {
+	fields := make(resp.Array, 0, len(e.fields))
+	for _, f := range e.fields {
+		fields = append(fields, resp.BulkStrings(f))
+	}
+	return resp.Array{resp.BulkStrings(e.id.String()), fields}
+}
+
+// streamEntriesReply 将条目列表编码为嵌套数组
+func streamEntriesReply(entries []streamEntry) resp.Array {
+	res := make(resp.Array, 0, len(entries))
+	for _, e := range entries {
+		res = append(res, streamEntryReply(e))
+	}
+	return res
+}
+
+// parseStreamTrimArgs 从下标i开始解析MAXLEN|MINID [=|~] threshold与LIMIT count选项，
+// noMkStream不为nil时还接受XADD的NOMKSTREAM。遇到无法识别的参数时停止，返回该参数的下标
+func parseStreamTrimArgs(args []string, i int, noMkStream *bool) (streamTrimArgs, int, error) {
+	var t streamTrimArgs
+	hasLimit := false
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		switch {
+		case opt == "NOMKSTREAM" && noMkStream != nil:
+			*noMkStream = true
+		case (opt == "MAXLEN" || opt == "MINID") && i+1 < len(args):
+			if t.strategy != trimNone {
+				return t, i, errors.New("ERR syntax error, MAXLEN and MINID options at the same time are not compatible")
+			}
+			i++
+			if (args[i] == "~" || args[i] == "=") && i+1 < len(args) {
+				t.approx = args[i] == "~"
+				i++
+			}
+			if opt == "MAXLEN" {
+				n, err := parseInt(args[i])
+				if err != nil {
+					return t, i, err
+				}
+				if n < 0 {
+					return t, i, errors.New("ERR The MAXLEN argument must be >= 0.")
+				}
+				t.strategy, t.maxlen = trimMaxLen, n
+			} else {
+				id, err := parseStreamID(args[i], 0)
+				if err != nil {
+					return t, i, err
+				}
+				t.strategy, t.minID = trimMinID, id
+			}
+		case opt == "LIMIT" && i+1 < len(args):
+			n, err := parseInt(args[i+1])
+			if err != nil {
+				return t, i, err
+			}
+			if n < 0 {
+				return t, i, errors.New("ERR The LIMIT argument must be >= 0.")
+			}
+			t.limit, hasLimit = n, true
+			i++
+		default:
+			return t, i, t.check(hasLimit)
+		}
+	}
+	return t, i, t.check(hasLimit)
+}
+
+// check 校验LIMIT只能与~一起使用，并设置近似裁剪的默认LIMIT
+func (t *streamTrimArgs) check(hasLimit bool) error {
+	if hasLimit && !t.approx {
+		return errors.New("ERR syntax error, LIMIT cannot be used without the special ~ option")
+	}
+	if t.approx && !hasLimit {
+		t.limit = 100 * streamChunkSize
+	}
+	return nil
+}
+
+// apply 按裁剪选项裁剪流，返回删除的条目数
+func (t *streamTrimArgs) apply(st *stream) int {
+	switch t.strategy {
+	case trimMaxLen:
+		return st.trimByLen(int(min(t.maxlen, math.MaxInt32)), t.approx, int(t.limit))
+	case trimMinID:
+		return st.trimByMinID(t.minID, t.approx, int(t.limit))
+	}
+	return 0
+}
+
+// nextStreamID 按XADD的ID参数计算新条目的ID："*"完全自动生成，"ms-*"自动生成序号
+func nextStreamID(st *stream, arg string) (streamID, error) {
+	last := st.lastID
+	if arg == "*" {
+		ms := uint64(mstime())
+		if ms > last.ms {
+			return streamID{ms, 0}, nil
+		}
+		id, ok := last.incr()
+		if !ok {
+			return id, errStreamExhausted
+		}
+		return id, nil
+	}
+	if msPart, ok := strings.CutSuffix(arg, "-*"); ok {
+		ms, err := strconv.ParseUint(msPart, 10, 64)
+		if err != nil {
+			return streamID{}, errInvalidStreamID
+		}
+		if ms != last.ms {
+			return streamID{ms, 0}.checkAfter(last)
+		}
+		if last.seq == math.MaxUint64 {
+			return streamID{}, errXAddIDTooSmall
+		}
+		return streamID{ms, last.seq + 1}, nil
+	}
+	id, err := parseStreamID(arg, 0)
+	if err != nil {
+		return id, err
+	}
+	if id == streamMinID {
+		return id, errXAddIDZero
+	}
+	return id.checkAfter(last)
+}
+
+// checkAfter 检查新ID大于流中的最大ID
+func (id streamID) checkAfter(last streamID) (streamID, error) {
+	if id.compare(last) <= 0 {
+		return id, errXAddIDTooSmall
+	}
+	return id, nil
+}
+
+// xaddCommand XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [field value ...]
+func xaddCommand(s *Service, p *Peer, args []string) any {
+	noMkStream := false
+	trim, i, err := parseStreamTrimArgs(args, 2, &noMkStream)
+	if err != nil {
+		return err
+	}
+	if i >= len(args) || (len(args)-i-1)%2 != 0 || len(args)-i-1 == 0 {
+		return wrongArityErr(args[0])
+	}
+
+	key := args[1]
+	st, err := s.db.lookupStream(key)
+	if err != nil {
+		return err
+	}
+	if st == nil && noMkStream {
+		return nil
+	}
+	created := st == nil
+	if created {
+		st = newStream()
+	}
+	id, err := nextStreamID(st, args[i])
+	if err != nil {
+		return err
+	}
+	if created {
+		s.db.set(key, st)
+	}
+	st.append(id, append([]string(nil), args[i+1:]...))
+	trim.apply(st)
+	s.db.signalModifiedKey(key)
+	s.signalKeyAsReady(key)
+	// 自动生成的ID以实际的ID传播
+	argv := slices.Clone(args)
+	argv[i] = id.String()
+	s.rewritePropagate(argv)
+	return resp.BulkStrings(id.String())
+}
+
+// parseIntervalID 解析区间的一端并将开区间转换为闭区间，isEnd为true时不完整ID的seq取最大值
+func parseIntervalID(arg string, isEnd bool) (streamID, error) {
+	var missingSeq uint64
+	if isEnd {
+		missingSeq = math.MaxUint64
+	}
+	id, exclusive, err := parseRangeID(arg, missingSeq)
+	if err != nil || !exclusive {
+		return id, err
+	}
+	var ok bool
+	if isEnd {
+		if id, ok = id.decr(); !ok {
+			return id, errors.New("ERR invalid end ID for the interval")
+		}
+	} else if id, ok = id.incr(); !ok {
+		return id, errors.New("ERR invalid start ID for the interval")
+	}
+	return id, nil
+}
+
+// xrangeGeneric XRANGE/XREVRANGE的公共实现，rev为true时参数顺序为end start
+func xrangeGeneric(s *Service, args []string, rev bool) any {
+	startArg, endArg := args[2], args[3]
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+	start, err := parseIntervalID(startArg, false)
+	if err != nil {
+		return err
+	}
+	end, err := parseIntervalID(endArg, true)
+	if err != nil {
+		return err
+	}
+	count := -1
+	for i := 4; i < len(args); i++ {
+		if strings.ToUpper(args[i]) != "COUNT" || i+1 >= len(args) {
+			return errSyntax
+		}
+		n, err := parseInt(args[i+1])
+		if err != nil {
+			return err
+		}
+		count = int(max(n, 0))
+		i++
+	}
+	st, err := s.db.lookupStream(args[1])
+	if err != nil {
+		return err
+	}
+	if st == nil || count == 0 {
+		return resp.Array{}
+	}
+	return streamEntriesReply(st.rangeEntries(start, end, max(count, 0), rev))
+}
+
+// xrangeCommand XRANGE key start end [COUNT count]
+func xrangeCommand(s *Service, p *Peer, args []string) any {
+	return xrangeGeneric(s, args, false)
+}
+
+// xrevrangeCommand XREVRANGE key end start [COUNT count]
+func xrevrangeCommand(s *Service, p *Peer, args []string) any {
+	return xrangeGeneric(s, args, true)
+}
+
+// xlenCommand XLEN key
+func xlenCommand(s *Service, p *Peer, args []string) any {
+	st, err := s.db.lookupStream(args[1])
+	if err != nil || st == nil {
+		return zeroOrErr(err)
+	}
+	return int64(st.length)
+}
+
+// xtrimCommand XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT count]
+func xtrimCommand(s *Service, p *Peer, args []string) any {
+	trim, next, err := parseStreamTrimArgs(args, 2, nil)
+	if err != nil {
+		return err
+	}
+	if next != len(args) || trim.strategy == trimNone {
+		return errSyntax
+	}
+	key := args[1]
+	st, err := s.db.lookupStream(key)
+	if err != nil || st == nil {
+		return zeroOrErr(err)
+	}
+	deleted := trim.apply(st)
+	if deleted > 0 {
+		s.db.signalModifiedKey(key)
+	}
+	return int64(deleted)
+}
+
+// xdelCommand XDEL key id [id ...]
+// 流被删空后仍然保留，以保存lastID
+func xdelCommand(s *Service, p *Peer, args []string) any {
+	ids := make([]streamID, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	key := args[1]
+	st, err := s.db.lookupStream(key)
+	if err != nil || st == nil {
+		return zeroOrErr(err)
+	}
+	var deleted int64
+	for _, id := range ids {
+		if st.delete(id) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		s.db.signalModifiedKey(key)
+	}
+	return deleted
+}
+
+// xsetidCommand XSETID key last-id [ENTRIESADDED entries-added] [MAXDELETEDID max-deleted-id]
+// 设置流曾经添加过的最大ID，不能小于流中现有的最大ID，可同时设置累计添加的条目数与被删除的最大ID
+func xsetidCommand(s *Service, p *Peer, args []string) any {
+	id, err := parseStreamID(args[2], 0)
+	if err != nil {
+		return err
+	}
+	entriesAdded := int64(-1)
+	var maxDeleted streamID
+	hasMaxDeleted := false
+	for i := 3; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return errSyntax
+		}
+		switch strings.ToUpper(args[i]) {
+		case "ENTRIESADDED":
+			n, err := parseInt(args[i+1])
+			if err != nil {
+				return err
+			}
+			if n < 0 {
+				return errors.New("ERR entries_added must be positive")
+			}
+			entriesAdded = n
+		case "MAXDELETEDID":
+			if maxDeleted, err = parseStreamID(args[i+1], 0); err != nil {
+				return err
+			}
+			hasMaxDeleted = true
+			if id.compare(maxDeleted) < 0 {
+				return errors.New("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+			}
+		default:
+			return errSyntax
+		}
+	}
+	key := args[1]
+	st, err := s.db.lookupStream(key)
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		return errors.New("ERR no such key")
+	}
+	if entriesAdded >= 0 && entriesAdded < int64(st.length) {
+		return errors.New("ERR The entries_added specified in XSETID is smaller than the target stream length")
+	}
+	if last, ok := st.last(); ok && id.compare(last.id) < 0 {
+		return errors.New("ERR The ID specified in XSETID is smaller than the target stream top item")
+	}
+	st.lastID = id
+	if entriesAdded >= 0 {
+		st.entriesAdded = uint64(entriesAdded)
+	}
+	if hasMaxDeleted {
+		st.maxDeletedID = maxDeleted
+	}
+	s.db.signalModifiedKey(key)
+	return "OK"
+}
+
+// noGroupErr 键或消费者组不存在
+func noGroupErr(key, group string) error {
+	return fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s'", key, group)
+}
+
+// lookupGroup 获取流及其消费者组，任一不存在时返回NOGROUP错误
+func (ks *keyspace) lookupGroup(key, group string) (*stream, *streamCG, error) {
+	st, err := ks.lookupStream(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if st == nil || st.groups[group] == nil {
+		return nil, nil, noGroupErr(key, group)
+	}
+	return st, st.groups[group], nil
+}
+
+// xreadKeys 返回XREAD/XREADGROUP中STREAMS之后的键
+func xreadKeys(args []string) []string {
+	for i := 1; i < len(args); i++ {
+		if strings.ToUpper(args[i]) == "STREAMS" {
+			rest := args[i+1:]
+			if len(rest)%2 != 0 {
+				return nil
+			}
+			return rest[:len(rest)/2]
+		}
+	}
+	return nil
+}
+
+// xreadReply RESP3下回复键到条目的Map，RESP2下回复[key, entries]组成的数组
+func xreadReply(p *Peer, keys []string, entries []resp.Array) any {
+	if p.proto >= resp.Proto3 {
+		res := make(resp.Maps, len(keys))
+		for i, key := range keys {
+			res[resp.BulkStrings(key)] = entries[i]
+		}
+		return res
+	}
+	res := make(resp.Array, 0, len(keys))
+	for i, key := range keys {
+		res = append(res, resp.Array{resp.BulkStrings(key), entries[i]})
+	}
+	return res
+}
+
+// xreadGeneric XREAD与XREADGROUP的公共实现。
+// 没有数据且指定了BLOCK时阻塞在全部键上，XREAD的"$"会先替换为当前的最大ID再阻塞，
+// 以便键就绪后重新执行命令时只读取阻塞之后添加的条目
+func xreadGeneric(s *Service, p *Peer, args []string, isGroup bool) any {
+	var timeout int64
+	block, noAck := false, false
+	count := 0
+	var group, consumer string
+	streamsIdx := -1
+	for i := 1; i < len(args) && streamsIdx < 0; i++ {
+		opt := strings.ToUpper(args[i])
+		switch {
+		case opt == "BLOCK" && i+1 < len(args):
+			t, err := parseTimeoutMs(args[i+1])
+			if err != nil {
+				return err
+			}
+			timeout, block = t, true
+			i++
+		case opt == "COUNT" && i+1 < len(args):
+			n, err := parseInt(args[i+1])
+			if err != nil {
+				return err
+			}
+			count = int(max(n, 0))
+			i++
+		case opt == "STREAMS":
+			streamsIdx = i + 1
+		case opt == "GROUP" && isGroup && i+2 < len(args):
+			group, consumer = args[i+1], args[i+2]
+			i += 2
+		case opt == "NOACK" && isGroup:
+			noAck = true
+		default:
+			return errSyntax
+		}
+	}
+	if streamsIdx < 0 {
+		return errSyntax
+	}
+	rest := args[streamsIdx:]
+	if len(rest) == 0 || len(rest)%2 != 0 {
+		return fmt.Errorf("ERR Unbalanced '%s' list of streams: for each stream key an ID or '$' must be specified.", strings.ToLower(args[0]))
+	}
+	if isGroup && group == "" {
+		return errors.New("ERR Missing GROUP option for XREADGROUP")
+	}
+	keys, idArgs := rest[:len(rest)/2], rest[len(rest)/2:]
+
+	// 先解析并校验全部ID，newOnly表示XREADGROUP的">"
+	ids := make([]streamID, len(keys))
+	newOnly := make([]bool, len(keys))
+	streams := make([]*stream, len(keys))
+	for i, key := range keys {
+		st, err := s.db.lookupStream(key)
+		if err != nil {
+			return err
+		}
+		streams[i] = st
+		switch arg := idArgs[i]; {
+		case isGroup && (st == nil || st.groups[group] == nil):
+			return fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option", key, group)
+		case arg == ">" && !isGroup:
+			return errors.New("ERR The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option.")
+		case arg == ">":
+			ids[i], newOnly[i] = st.groups[group].lastID, true
+		case arg == "$" && isGroup:
+			return errors.New("ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+		case arg == "$":
+			if st != nil {
+				ids[i] = st.lastID
+			}
+		default:
+			id, err := parseStreamID(arg, 0)
+			if err != nil {
+				return err
+			}
+			ids[i] = id
+		}
+	}
+
+	now := mstime()
+	var replyKeys []string
+	var replies []resp.Array
+	for i, key := range keys {
+		st := streams[i]
+		if st == nil {
+			continue
+		}
+		if !isGroup {
+			if entries := st.readAfter(ids[i], count); len(entries) > 0 {
+				replyKeys = append(replyKeys, key)
+				replies = append(replies, streamEntriesReply(entries))
+			}
+			continue
+		}
+		cg := st.groups[group]
+		c := cg.lookupConsumer(consumer, true)
+		c.seenTime = now
+		if !newOnly[i] {
+			// 读取消费者自己的待确认条目，即使为空也回复该键
+			replyKeys = append(replyKeys, key)
+			replies = append(replies, st.pendingHistory(c, ids[i], count))
+			continue
+		}
+		entries := st.readAfter(ids[i], count)
+		if len(entries) == 0 {
+			continue
+		}
+		for _, e := range entries {
+			if !noAck {
+				cg.deliver(e.id, c, now)
+			}
+		}
+		cg.lastID = entries[len(entries)-1].id
+		if cg.entriesRead >= 0 {
+			cg.entriesRead += int64(len(entries))
+		}
+		c.activeTime = now
+		s.db.signalModifiedKey(key)
+		replyKeys = append(replyKeys, key)
+		replies = append(replies, streamEntriesReply(entries))
+	}
+	if len(replyKeys) > 0 {
+		return xreadReply(p, replyKeys, replies)
+	}
+	if !block {
+		return resp.Array(nil)
+	}
+
+	blockArgs := args
+	streamIDs := make(map[string]streamID, len(keys))
+	if !isGroup {
+		blockArgs = append([]string(nil), args...)
+		for i, key := range keys {
+			blockArgs[streamsIdx+len(keys)+i] = ids[i].String()
+			streamIDs[key] = ids[i]
+		}
+	}
+	s.blockForKeys(p, blockStream, keys, timeout, resp.Array(nil), blockArgs)
+	if isGroup {
+		p.bstate.group = group
+	} else {
+		p.bstate.streamIDs = streamIDs
+	}
+	return noReply
+}
+
+// readAfter 返回ID大于after的最多count个条目，count为0表示不限
+func (st *stream) readAfter(after streamID, count int) []streamEntry {
+	start, ok := after.incr()
+	if !ok {
+		return nil
+	}
+	return st.rangeEntries(start, streamMaxID, count, false)
+}
+
+// pendingHistory 返回消费者待确认列表中ID大于after的条目，已被删除的条目回复为[id, nil]
+func (st *stream) pendingHistory(c *streamConsumer, after streamID, count int) resp.Array {
+	res := resp.Array{}
+	start, ok := after.incr()
+	if !ok {
+		return res
+	}
+	c.pel.ascend(start, func(id streamID, _ *streamNACK) bool {
+		if count > 0 && len(res) >= count {
+			return false
+		}
+		if e, ok := st.lookupEntry(id); ok {
+			res = append(res, streamEntryReply(e))
+		} else {
+			res = append(res, resp.Array{resp.BulkStrings(id.String()), resp.Array(nil)})
+		}
+		return true
+	})
+	return res
+}
+
+// xreadCommand XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+func xreadCommand(s *Service, p *Peer, args []string) any {
+	return xreadGeneric(s, p, args, false)
+}
+
+// xreadgroupCommand XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+func xreadgroupCommand(s *Service, p *Peer, args []string) any {
+	return xreadGeneric(s, p, args, true)
+}
+
+// parseGroupLastID 解析XGROUP CREATE/SETID的ID参数，"$"表示流当前的最大ID
+func parseGroupLastID(st *stream, arg string) (streamID, error) {
+	if arg == "$" {
+		if st != nil {
+			return st.lastID, nil
+		}
+		return streamMinID, nil
+	}
+	return parseStreamID(arg, 0)
+}
+
+// xgroupCommand XGROUP CREATE|SETID|DESTROY|CREATECONSUMER|DELCONSUMER key group ...
+func xgroupCommand(s *Service, p *Peer, args []string) any {
+	sub := strings.ToUpper(args[1])
+	arity := map[string]int{"CREATE": -5, "SETID": -5, "DESTROY": 4, "CREATECONSUMER": 5, "DELCONSUMER": 5}
+	n, ok := arity[sub]
+	if !ok {
+		return fmt.Errorf("ERR unknown subcommand '%.128s'. Try XGROUP HELP.", args[1])
+	}
+	if (n > 0 && len(args) != n) || (n < 0 && len(args) < -n) {
+		return wrongArityErr("xgroup|" + sub)
+	}
+	key, group := args[2], args[3]
+	st, err := s.db.lookupStream(key)
+	if err != nil {
+		return err
+	}
+
+	if sub == "CREATE" {
+		mkStream := false
+		entriesRead := int64(-1)
+		for i := 5; i < len(args); i++ {
+			switch opt := strings.ToUpper(args[i]); {
+			case opt == "MKSTREAM":
+				mkStream = true
+			case opt == "ENTRIESREAD" && i+1 < len(args):
+				if entriesRead, err = parseEntriesRead(args[i+1]); err != nil {
+					return err
+				}
+				i++
+			default:
+				return errSyntax
+			}
+		}
+		id, err := parseGroupLastID(st, args[4])
+		if err != nil {
+			return err
+		}
+		if st == nil {
+			if !mkStream {
+				return errors.New("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+			}
+			st = newStream()
+			s.db.set(key, st)
+		}
+		cg := st.createGroup(group, id)
+		if cg == nil {
+			return errors.New("BUSYGROUP Consumer Group name already exists")
+		}
+		cg.entriesRead = entriesRead
+		s.db.signalModifiedKey(key)
+		return "OK"
+	}
+
+	if st == nil {
+		return errors.New("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+	}
+	cg := st.groups[group]
+	if sub == "DESTROY" {
+		if cg == nil {
+			return int64(0)
+		}
+		delete(st.groups, group)
+		s.db.signalModifiedKey(key)
+		// 唤醒阻塞在该组上的XREADGROUP，使其回复NOGROUP错误
+		s.signalKeyAsReady(key)
+		return int64(1)
+	}
+	if cg == nil {
+		return fmt.Errorf("NOGROUP No such consumer group '%s' for key name '%s'", group, key)
+	}
+	switch sub {
+	case "SETID":
+		entriesRead := int64(-1)
+		for i := 5; i < len(args); i++ {
+			if strings.ToUpper(args[i]) != "ENTRIESREAD" || i+1 >= len(args) {
+				return errSyntax
+			}
+			if entriesRead, err = parseEntriesRead(args[i+1]); err != nil {
+				return err
+			}
+			i++
+		}
+		id, err := parseGroupLastID(st, args[4])
+		if err != nil {
+			return err
+		}
+		cg.lastID = id
+		cg.entriesRead = entriesRead
+		s.db.signalModifiedKey(key)
+		return "OK"
+	case "CREATECONSUMER":
+		if cg.lookupConsumer(args[4], false) != nil {
+			return int64(0)
+		}
+		cg.lookupConsumer(args[4], true)
+		s.db.signalModifiedKey(key)
+		return int64(1)
+	default:
+		c := cg.lookupConsumer(args[4], false)
+		if c == nil {
+			return int64(0)
+		}
+		s.db.signalModifiedKey(key)
+		return int64(cg.deleteConsumer(c))
+	}
+}
+
+// parseEntriesRead 解析ENTRIESREAD的参数，-1表示未知
+func parseEntriesRead(arg string) (int64, error) {
+	n, err := parseInt(arg)
+	if err != nil {
+		return 0, err
+	}
+	if n < -1 {
+		return 0, errors.New("ERR value for ENTRIESREAD must be positive or -1")
+	}
+	return n, nil
+}
+
+// xackCommand XACK key group id [id ...]
+func xackCommand(s *Service, p *Peer, args []string) any {
+	ids := make([]streamID, 0, len(args)-3)
+	for _, arg := range args[3:] {
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	key := args[1]
+	st, err := s.db.lookupStream(key)
+	if err != nil || st == nil || st.groups[args[2]] == nil {
+		return zeroOrErr(err)
+	}
+	cg := st.groups[args[2]]
+	var acked int64
+	for _, id := range ids {
+		if cg.ack(id) {
+			acked++
+		}
+	}
+	if acked > 0 {
+		s.db.signalModifiedKey(key)
+	}
+	return acked
+}
+
+// xpendingCommand XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+func xpendingCommand(s *Service, p *Peer, args []string) any {
+	key, group := args[1], args[2]
+	var minIdle int64
+	rest := args[3:]
+	if len(rest) > 0 && strings.ToUpper(rest[0]) == "IDLE" {
+		if len(rest) < 2 {
+			return errSyntax
+		}
+		n, err := parseInt(rest[1])
+		if err != nil {
+			return err
+		}
+		minIdle, rest = n, rest[2:]
+	}
+	if len(args) > 3 && (len(rest) < 3 || len(rest) > 4) {
+		return errSyntax
+	}
+	var start, end streamID
+	var count int64
+	if len(rest) > 0 {
+		var err error
+		if start, err = parseIntervalID(rest[0], false); err != nil {
+			return err
+		}
+		if end, err = parseIntervalID(rest[1], true); err != nil {
+			return err
+		}
+		if count, err = parseInt(rest[2]); err != nil {
+			return err
+		}
+	}
+	_, cg, err := s.db.lookupGroup(key, group)
+	if err != nil {
+		return err
+	}
+
+	// 不带区间时回复摘要：总数、最小ID、最大ID以及每个消费者的待确认数
+	if len(args) == 3 {
+		first, ok := cg.pel.first()
+		if !ok {
+			return resp.Array{int64(0), nil, nil, resp.Array(nil)}
+		}
+		last, _ := cg.pel.last()
+		names := make([]string, 0, len(cg.consumers))
+		for name, c := range cg.consumers {
+			if c.pel.len() > 0 {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		consumers := make(resp.Array, 0, len(names))
+		for _, name := range names {
+			n := strconv.Itoa(cg.consumers[name].pel.len())
+			consumers = append(consumers, resp.Array{resp.BulkStrings(name), resp.BulkStrings(n)})
+		}
+		return resp.Array{
+			int64(cg.pel.len()),
+			resp.BulkStrings(first.String()),
+			resp.BulkStrings(last.String()),
+			consumers,
+		}
+	}
+
+	pel := cg.pel
+	if len(rest) == 4 {
+		c := cg.lookupConsumer(rest[3], false)
+		if c == nil {
+			return resp.Array{}
+		}
+		pel = c.pel
+	}
+	now := mstime()
+	res := resp.Array{}
+	pel.ascend(start, func(id streamID, nack *streamNACK) bool {
+		if int64(len(res)) >= count || id.compare(end) > 0 {
+			return false
+		}
+		idle := now - nack.deliveryTime
+		if idle < minIdle {
+			return true
+		}
+		res = append(res, resp.Array{
+			resp.BulkStrings(id.String()),
+			resp.BulkStrings(nack.consumer.name),
+			idle,
+			nack.deliveryCount,
+		})
+		return true
+	})
+	return res
+}
+
+// xclaimCommand XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds]
+// [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid]
+func xclaimCommand(s *Service, p *Peer, args []string) any {
+	key, group := args[1], args[2]
+	minIdle, err := parseInt(args[4])
+	if err != nil {
+		return errors.New("ERR Invalid min-idle-time argument for XCLAIM")
+	}
+	i := 5
+	var ids []streamID
+	for ; i < len(args); i++ {
+		id, err := parseStreamID(args[i], 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return errInvalidStreamID
+	}
+	now := mstime()
+	deliveryTime := now
+	retryCount := int64(-1)
+	force, justID := false, false
+	var lastID streamID
+	hasLastID := false
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		switch {
+		case opt == "FORCE":
+			force = true
+		case opt == "JUSTID":
+			justID = true
+		case (opt == "IDLE" || opt == "TIME" || opt == "RETRYCOUNT") && i+1 < len(args):
+			n, err := parseInt(args[i+1])
+			if err != nil {
+				return err
+			}
+			switch opt {
+			case "IDLE":
+				deliveryTime = now - max(n, 0)
+			case "TIME":
+				deliveryTime = n
+			default:
+				retryCount = n
+			}
+			i++
+		case opt == "LASTID" && i+1 < len(args):
+			id, err := parseStreamID(args[i+1], 0)
+			if err != nil {
+				return err
+			}
+			lastID, hasLastID = id, true
+			i++
+		default:
+			return fmt.Errorf("ERR Unrecognized XCLAIM option '%s'", args[i])
+		}
+	}
+	st, cg, err := s.db.lookupGroup(key, group)
+	if err != nil {
+		return err
+	}
+	// changed 组的状态是否改变，没有改变时不通知修改，也就不会传播
+	changed := false
+	if hasLastID && lastID.compare(cg.lastID) > 0 {
+		cg.lastID = lastID
+		changed = true
+	}
+	c := cg.lookupConsumer(args[3], false)
+	if c == nil {
+		c = cg.lookupConsumer(args[3], true)
+		changed = true
+	}
+	c.seenTime = now
+	res := resp.Array{}
+	for _, id := range ids {
+		e, exists := st.lookupEntry(id)
+		nack := cg.pel.get(id)
+		if nack == nil && force && exists {
+			nack = &streamNACK{}
+			cg.pel.insert(id, nack)
+		}
+		if nack == nil {
+			continue
+		}
+		// 条目已被删除时从待确认列表中移除
+		if !exists {
+			cg.ack(id)
+			changed = true
+			continue
+		}
+		if minIdle > 0 && now-nack.deliveryTime < minIdle {
+			continue
+		}
+		cg.claim(id, nack, c, deliveryTime, !justID)
+		changed = true
+		if retryCount >= 0 {
+			nack.deliveryCount = retryCount
+		}
+		c.activeTime = now
+		if justID {
+			res = append(res, resp.BulkStrings(id.String()))
+		} else {
+			res = append(res, streamEntryReply(e))
+		}
+	}
+	if changed {
+		s.db.signalModifiedKey(key)
+	}
+	return res
+}
+
+// xautoclaimCommand XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]
+// 回复[下一次扫描的起始ID, 认领的条目, 已被删除的条目ID]，扫描完毕时起始ID为0-0
+func xautoclaimCommand(s *Service, p *Peer, args []string) any {
+	key, group := args[1], args[2]
+	minIdle, err := parseInt(args[4])
+	if err != nil {
+		return errors.New("ERR Invalid min-idle-time argument for XAUTOCLAIM")
+	}
+	start, err := parseIntervalID(args[5], false)
+	if err != nil {
+		return err
+	}
+	count := int64(100)
+	justID := false
+	for i := 6; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		switch {
+		case opt == "JUSTID":
+			justID = true
+		case opt == "COUNT" && i+1 < len(args):
+			n, err := parseInt(args[i+1])
+			if err != nil || n < 1 || n > math.MaxInt64/100 {
+				return errors.New("ERR COUNT must be > 0")
+			}
+			count = n
+			i++
+		default:
+			return errSyntax
+		}
+	}
+	st, cg, err := s.db.lookupGroup(key, group)
+	if err != nil {
+		return err
+	}
+	now := mstime()
+	changed := false
+	c := cg.lookupConsumer(args[3], false)
+	if c == nil {
+		c = cg.lookupConsumer(args[3], true)
+		changed = true
+	}
+	c.seenTime = now
+	claimed, deleted := resp.Array{}, resp.Array{}
+	next := streamMinID
+	attempts := count * 10
+	// 认领和确认会修改待确认列表，先取出最多需要检查的ID
+	var ids []streamID
+	cg.pel.ascend(start, func(id streamID, _ *streamNACK) bool {
+		ids = append(ids, id)
+		return int64(len(ids)) <= attempts
+	})
+	for _, id := range ids {
+		if int64(len(claimed)) >= count || attempts == 0 {
+			next = id
+			break
+		}
+		attempts--
+		e, exists := st.lookupEntry(id)
+		if !exists {
+			cg.ack(id)
+			deleted = append(deleted, resp.BulkStrings(id.String()))
+			continue
+		}
+		nack := cg.pel.get(id)
+		if minIdle > 0 && now-nack.deliveryTime < minIdle {
+			continue
+		}
+		cg.claim(id, nack, c, now, !justID)
+		c.activeTime = now
+		if justID {
+			claimed = append(claimed, resp.BulkStrings(id.String()))
+		} else {
+			claimed = append(claimed, streamEntryReply(e))
+		}
+	}
+	if changed || len(claimed) > 0 || len(deleted) > 0 {
+		s.db.signalModifiedKey(key)
+	}
+	return resp.Array{resp.BulkStrings(next.String()), claimed, deleted}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+	"github.com/stretchr/testify/assert"
+)
+
+func entryReply(id string, fields ...string) resp.Array {
+	return resp.Array{resp.BulkStrings(id), bulks(fields...)}
+}
+
+func TestStreamCommands(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	testCases := []struct {
+		name string
+		args []string
+		res  any
+	}{
+		{name: "测试XADD", args: []string{"XADD", "x", "1-1", "a", "1"}, res: resp.BulkStrings("1-1")},
+		{name: "测试XADD自动序号", args: []string{"XADD", "x", "1-*", "b", "2"}, res: resp.BulkStrings("1-2")},
+		{name: "测试XADD只给ms", args: []string{"XADD", "x", "2", "c", "3"}, res: resp.BulkStrings("2-0")},
+		{name: "测试XADD ID过小", args: []string{"XADD", "x", "1-5", "d", "4"}, res: errXAddIDTooSmall},
+		{name: "测试XADD 0-0", args: []string{"XADD", "y", "0-0", "d", "4"}, res: errXAddIDZero},
+		{name: "测试XADD 0-*", args: []string{"XADD", "y", "0-*", "d", "4"}, res: resp.BulkStrings("0-1")},
+		{name: "测试XADD非法ID", args: []string{"XADD", "x", "a-1", "d", "4"}, res: errInvalidStreamID},
+		{name: "测试XADD参数个数", args: []string{"XADD", "x", "3-0", "d"}, res: wrongArityErr("XADD")},
+		{name: "测试XADD NOMKSTREAM", args: []string{"XADD", "none", "NOMKSTREAM", "*", "d", "4"}, res: nil},
+		{name: "测试XADD NOMKSTREAM不创建", args: []string{"EXISTS", "none"}, res: int64(0)},
+		{name: "测试XADD MAXLEN", args: []string{"XADD", "x", "MAXLEN", "3", "3-0", "d", "4"}, res: resp.BulkStrings("3-0")},
+		{name: "测试TYPE", args: []string{"TYPE", "x"}, res: "stream"},
+		{name: "测试XLEN", args: []string{"XLEN", "x"}, res: int64(3)},
+		{name: "测试XRANGE", args: []string{"XRANGE", "x", "-", "+"}, res: resp.Array{entryReply("1-2", "b", "2"), entryReply("2-0", "c", "3"), entryReply("3-0", "d", "4")}},
+		{name: "测试XRANGE不完整ID", args: []string{"XRANGE", "x", "1", "2"}, res: resp.Array{entryReply("1-2", "b", "2"), entryReply("2-0", "c", "3")}},
+		{name: "测试XRANGE开区间", args: []string{"XRANGE", "x", "(1-2", "+", "COUNT", "1"}, res: resp.Array{entryReply("2-0", "c", "3")}},
+		{name: "测试XREVRANGE", args: []string{"XREVRANGE", "x", "+", "-", "COUNT", "2"}, res: resp.Array{entryReply("3-0", "d", "4"), entryReply("2-0", "c", "3")}},
+		{name: "测试XRANGE不存在", args: []string{"XRANGE", "none", "-", "+"}, res: resp.Array{}},
+		{name: "测试XDEL", args: []string{"XDEL", "x", "2-0", "9-9"}, res: int64(1)},
+		{name: "测试XTRIM MINID", args: []string{"XTRIM", "x", "MINID", "3"}, res: int64(1)},
+		{name: "测试XTRIM LIMIT无~", args: []string{"XTRIM", "x", "MAXLEN", "0", "LIMIT", "1"}, res: errors.New("ERR syntax error, LIMIT cannot be used without the special ~ option")},
+		{name: "测试XTRIM语法错误", args: []string{"XTRIM", "x", "FOO", "1"}, res: errSyntax},
+		{name: "测试XTRIM MAXLEN", args: []string{"XTRIM", "x", "MAXLEN", "=", "0"}, res: int64(1)},
+		{name: "测试空流保留", args: []string{"XLEN", "x"}, res: int64(0)},
+		{name: "测试删空后ID仍递增", args: []string{"XADD", "x", "3-0", "e", "5"}, res: errXAddIDTooSmall},
+		{name: "测试XSETID", args: []string{"XSETID", "x", "5-0"}, res: "OK"},
+		{name: "测试XSETID后ID递增", args: []string{"XADD", "x", "4-0", "e", "5"}, res: errXAddIDTooSmall},
+		{name: "测试XSETID ENTRIESADDED", args: []string{"XSETID", "x", "6-0", "ENTRIESADDED", "-1"}, res: errors.New("ERR entries_added must be positive")},
+		{name: "测试XSETID MAXDELETEDID", args: []string{"XSETID", "x", "6-0", "MAXDELETEDID", "7-0"}, res: errors.New("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id")},
+		{name: "测试XSETID不存在", args: []string{"XSETID", "none", "1-0"}, res: errors.New("ERR no such key")},
+		{name: "测试XADD大于XSETID", args: []string{"XADD", "x", "7-0", "e", "5"}, res: resp.BulkStrings("7-0")},
+		{name: "测试XSETID小于最大条目", args: []string{"XSETID", "x", "6-0"}, res: errors.New("ERR The ID specified in XSETID is smaller than the target stream top item")},
+		{name: "测试SET", args: []string{"SET", "str", "v"}, res: "OK"},
+		{name: "测试WRONGTYPE", args: []string{"XADD", "str", "*", "a", "1"}, res: errWrongType},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert.Equal(t, v.res, doCommand(s, p, v.args...))
+		})
+	}
+	st, _ := s.db.lookupStream("x")
+	assert.Equal(t, uint64(5), st.entriesAdded)
+	assert.Equal(t, streamID{3, 0}, st.maxDeletedID)
+	assert.Equal(t, "OK", doCommand(s, p, "XSETID", "x", "8-0", "ENTRIESADDED", "10", "MAXDELETEDID", "6-0"))
+	assert.Equal(t, uint64(10), st.entriesAdded)
+	assert.Equal(t, streamID{6, 0}, st.maxDeletedID)
+}
+
+func TestXAddApproxTrim(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	for i := 0; i < 250; i++ {
+		doCommand(s, p, "XADD", "x", "MAXLEN", "~", "120", "*", "f", "v")
+	}
+	// 近似裁剪只删除整块，长度不小于阈值且不超过阈值加一个块
+	n := doCommand(s, p, "XLEN", "x").(int64)
+	assert.GreaterOrEqual(t, n, int64(120))
+	assert.Less(t, n, int64(120+streamChunkSize))
+	assert.Equal(t, int64(0), doCommand(s, p, "XTRIM", "x", "MAXLEN", "~", "100", "LIMIT", "10"))
+	assert.Equal(t, n-100, doCommand(s, p, "XTRIM", "x", "MAXLEN", "100"))
+}
+
+func TestStreamGroupCommands(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	doCommand(s, p, "XADD", "x", "1-0", "a", "1")
+	doCommand(s, p, "XADD", "x", "2-0", "b", "2")
+	doCommand(s, p, "XADD", "x", "3-0", "c", "3")
+	testCases := []struct {
+		name string
+		args []string
+		res  any
+	}{
+		{name: "测试XGROUP CREATE", args: []string{"XGROUP", "CREATE", "x", "g", "0"}, res: "OK"},
+		{name: "测试XGROUP CREATE已存在", args: []string{"XGROUP", "CREATE", "x", "g", "$"}, res: errors.New("BUSYGROUP Consumer Group name already exists")},
+		{name: "测试XGROUP CREATE键不存在", args: []string{"XGROUP", "CREATE", "none", "g", "$"}, res: errors.New("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")},
+		{name: "测试XGROUP CREATE MKSTREAM", args: []string{"XGROUP", "CREATE", "y", "g", "$", "MKSTREAM"}, res: "OK"},
+		{name: "测试MKSTREAM创建空流", args: []string{"XLEN", "y"}, res: int64(0)},
+		{name: "测试XREADGROUP新消息", args: []string{"XREADGROUP", "GROUP", "g", "alice", "COUNT", "2", "STREAMS", "x", ">"}, res: resp.Array{resp.Array{resp.BulkStrings("x"), resp.Array{entryReply("1-0", "a", "1"), entryReply("2-0", "b", "2")}}}},
+		{name: "测试XREADGROUP另一个消费者", args: []string{"XREADGROUP", "GROUP", "g", "bob", "STREAMS", "x", ">"}, res: resp.Array{resp.Array{resp.BulkStrings("x"), resp.Array{entryReply("3-0", "c", "3")}}}},
+		{name: "测试XREADGROUP无新消息", args: []string{"XREADGROUP", "GROUP", "g", "bob", "STREAMS", "x", ">"}, res: resp.Array(nil)},
+		{name: "测试XREADGROUP历史", args: []string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "x", "0"}, res: resp.Array{resp.Array{resp.BulkStrings("x"), resp.Array{entryReply("1-0", "a", "1"), entryReply("2-0", "b", "2")}}}},
+		{name: "测试XREADGROUP $", args: []string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "x", "$"}, res: errors.New("ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")},
+		{name: "测试XREADGROUP组不存在", args: []string{"XREADGROUP", "GROUP", "none", "alice", "STREAMS", "x", ">"}, res: errors.New("NOGROUP No such key 'x' or consumer group 'none' in XREADGROUP with GROUP option")},
+		{name: "测试XPENDING摘要", args: []string{"XPENDING", "x", "g"}, res: resp.Array{int64(3), resp.BulkStrings("1-0"), resp.BulkStrings("3-0"), resp.Array{bulks("alice", "2"), bulks("bob", "1")}}},
+		{name: "测试XACK", args: []string{"XACK", "x", "g", "1-0", "9-0"}, res: int64(1)},
+		{name: "测试XACK组不存在", args: []string{"XACK", "x", "none", "2-0"}, res: int64(0)},
+		{name: "测试XDEL待确认条目", args: []string{"XDEL", "x", "2-0"}, res: int64(1)},
+		{name: "测试XREADGROUP历史含已删除", args: []string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "x", "0"}, res: resp.Array{resp.Array{resp.BulkStrings("x"), resp.Array{resp.Array{resp.BulkStrings("2-0"), resp.Array(nil)}}}}},
+		{name: "测试XCLAIM", args: []string{"XCLAIM", "x", "g", "alice", "0", "3-0", "JUSTID"}, res: bulks("3-0")},
+		{name: "测试XCLAIM已删除条目", args: []string{"XCLAIM", "x", "g", "alice", "0", "2-0"}, res: resp.Array{}},
+		{name: "测试XPENDING已删除条目被移除", args: []string{"XPENDING", "x", "g"}, res: resp.Array{int64(1), resp.BulkStrings("3-0"), resp.BulkStrings("3-0"), resp.Array{bulks("alice", "1")}}},
+		{name: "测试XAUTOCLAIM", args: []string{"XAUTOCLAIM", "x", "g", "bob", "0", "0", "COUNT", "1"}, res: resp.Array{resp.BulkStrings("0-0"), resp.Array{entryReply("3-0", "c", "3")}, resp.Array{}}},
+		{name: "测试XAUTOCLAIM COUNT", args: []string{"XAUTOCLAIM", "x", "g", "bob", "0", "0", "COUNT", "0"}, res: errors.New("ERR COUNT must be > 0")},
+		{name: "测试XGROUP CREATECONSUMER", args: []string{"XGROUP", "CREATECONSUMER", "x", "g", "carol"}, res: int64(1)},
+		{name: "测试XGROUP CREATECONSUMER已存在", args: []string{"XGROUP", "CREATECONSUMER", "x", "g", "carol"}, res: int64(0)},
+		{name: "测试XGROUP DELCONSUMER", args: []string{"XGROUP", "DELCONSUMER", "x", "g", "bob"}, res: int64(1)},
+		{name: "测试XPENDING空", args: []string{"XPENDING", "x", "g"}, res: resp.Array{int64(0), nil, nil, resp.Array(nil)}},
+		{name: "测试XGROUP SETID", args: []string{"XGROUP", "SETID", "x", "g", "0"}, res: "OK"},
+		{name: "测试SETID后重新读取", args: []string{"XREADGROUP", "GROUP", "g", "carol", "NOACK", "STREAMS", "x", ">"}, res: resp.Array{resp.Array{resp.BulkStrings("x"), resp.Array{entryReply("1-0", "a", "1"), entryReply("3-0", "c", "3")}}}},
+		{name: "测试NOACK不记入待确认", args: []string{"XPENDING", "x", "g", "-", "+", "10"}, res: resp.Array{}},
+		{name: "测试XGROUP DESTROY", args: []string{"XGROUP", "DESTROY", "x", "g"}, res: int64(1)},
+		{name: "测试XGROUP DESTROY不存在", args: []string{"XGROUP", "DESTROY", "x", "g"}, res: int64(0)},
+		{name: "测试XPENDING组不存在", args: []string{"XPENDING", "x", "g"}, res: errors.New("NOGROUP No such key 'x' or consumer group 'g'")},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert.Equal(t, v.res, doCommand(s, p, v.args...))
+		})
+	}
+}
+
+func TestXRead(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	doCommand(s, p, "XADD", "x", "1-0", "a", "1")
+	doCommand(s, p, "XADD", "x", "2-0", "b", "2")
+	assert.Equal(t, resp.Array{resp.Array{resp.BulkStrings("x"), resp.Array{entryReply("2-0", "b", "2")}}}, doCommand(s, p, "XREAD", "STREAMS", "x", "none", "1-0", "0"))
+	assert.Equal(t, resp.Array(nil), doCommand(s, p, "XREAD", "STREAMS", "x", "$"))
+	assert.Equal(t, errors.New("ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified."), doCommand(s, p, "XREAD", "STREAMS", "x", "y", "0"))
+
+	p.proto = resp.Proto3
+	assert.Equal(t, resp.Maps{resp.BulkStrings("x"): resp.Array{entryReply("1-0", "a", "1")}}, doCommand(s, p, "XREAD", "COUNT", "1", "STREAMS", "x", "0"))
+}
+
+func TestStreamGroupEntriesRead(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	for _, id := range []string{"1-0", "2-0", "3-0", "4-0"} {
+		doCommand(s, p, "XADD", "x", id, "f", "v")
+	}
+	entriesRead := func() int64 {
+		_, cg, err := s.db.lookupGroup("x", "g")
+		assert.NoError(t, err)
+		return cg.entriesRead
+	}
+	testCases := []struct {
+		name        string
+		args        []string
+		res         any
+		entriesRead int64
+	}{
+		{name: "测试CREATE ENTRIESREAD", args: []string{"XGROUP", "CREATE", "x", "g", "0", "ENTRIESREAD", "0"}, res: "OK", entriesRead: 0},
+		{name: "测试读取新条目后增加", args: []string{"XREADGROUP", "GROUP", "g", "alice", "COUNT", "2", "STREAMS", "x", ">"}, entriesRead: 2},
+		{name: "测试删除已读条目不影响", args: []string{"XDEL", "x", "1-0"}, res: int64(1), entriesRead: 2},
+		{name: "测试删除未读条目后未知", args: []string{"XDEL", "x", "4-0"}, res: int64(1), entriesRead: -1},
+		{name: "测试未知时读取仍未知", args: []string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "x", ">"}, entriesRead: -1},
+		{name: "测试SETID ENTRIESREAD", args: []string{"XGROUP", "SETID", "x", "g", "0", "ENTRIESREAD", "0"}, res: "OK", entriesRead: 0},
+		{name: "测试裁剪未读条目后未知", args: []string{"XTRIM", "x", "MAXLEN", "1"}, res: int64(1), entriesRead: -1},
+		{name: "测试SETID ENTRIESREAD非法", args: []string{"XGROUP", "SETID", "x", "g", "$", "ENTRIESREAD", "-2"}, res: errors.New("ERR value for ENTRIESREAD must be positive or -1"), entriesRead: -1},
+		{name: "测试SETID ENTRIESREAD非整数", args: []string{"XGROUP", "SETID", "x", "g", "$", "ENTRIESREAD", "x"}, res: errNotInteger, entriesRead: -1},
+		{name: "测试SETID ENTRIESREAD设置", args: []string{"XGROUP", "SETID", "x", "g", "$", "ENTRIESREAD", "4"}, res: "OK", entriesRead: 4},
+		{name: "测试SETID不带ENTRIESREAD时未知", args: []string{"XGROUP", "SETID", "x", "g", "$"}, res: "OK", entriesRead: -1},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			res := doCommand(s, p, v.args...)
+			if v.res != nil {
+				assert.Equal(t, v.res, res)
+			}
+			assert.Equal(t, v.entriesRead, entriesRead())
+		})
+	}
+}
+
+func TestStreamClaimNoop(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	doCommand(s, p, "XADD", "x", "1-0", "f", "v")
+	doCommand(s, p, "XGROUP", "CREATE", "x", "g", "0")
+	doCommand(s, p, "XREADGROUP", "GROUP", "g", "alice", "STREAMS", "x", ">")
+	testCases := []struct {
+		name    string
+		args    []string
+		changed bool
+	}{
+		{name: "测试XCLAIM未达到空闲时间", args: []string{"XCLAIM", "x", "g", "alice", "3600000", "1-0"}},
+		{name: "测试XCLAIM不在待确认列表", args: []string{"XCLAIM", "x", "g", "alice", "0", "9-0"}},
+		{name: "测试XAUTOCLAIM没有可认领的条目", args: []string{"XAUTOCLAIM", "x", "g", "alice", "3600000", "0"}},
+		{name: "测试XCLAIM创建消费者", args: []string{"XCLAIM", "x", "g", "bob", "3600000", "1-0"}, changed: true},
+		{name: "测试XAUTOCLAIM认领", args: []string{"XAUTOCLAIM", "x", "g", "bob", "0", "0"}, changed: true},
+		{name: "测试XCLAIM认领", args: []string{"XCLAIM", "x", "g", "alice", "0", "1-0"}, changed: true},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			dirty := s.db.dirtyCount()
+			doCommand(s, p, v.args...)
+			assert.Equal(t, v.changed, s.db.dirtyCount() != dirty)
+		})
+	}
+}
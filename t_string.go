@@ -0,0 +1,339 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+)
+
+const (
+	CommentGet         = "GET"
+	CommentAppend      = "APPEND"
+	CommentStrlen      = "STRLEN"
+	CommentGetRange    = "GETRANGE"
+	CommentSetRange    = "SETRANGE"
+	CommentIncr        = "INCR"
+	CommentDecr        = "DECR"
+	CommentIncrBy      = "INCRBY"
+	CommentDecrBy      = "DECRBY"
+	CommentIncrByFloat = "INCRBYFLOAT"
+)
+
+var (
+	errStringTooBig = errors.New("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	errOverflow     = errors.New("ERR increment or decrement would overflow")
+	errNaNOrInf     = errors.New("ERR increment would produce NaN or Infinity")
+)
+
+// 字符串类型的值以[]byte保存，并视为不可变：修改时总是生成新的切片，
+// 以免已经作为回复返回的值被后续命令改写
+
+// SET命令的选项
+const (
+	setNX = 1 << iota
+	setXX
+	setGet
+	setKeepTTL
+	setEX
+	setPX
+	setEXAT
+	setPXAT
+)
+
+// setExpireFlags 与过期时间相关、相互排斥的SET选项
+const setExpireFlags = setKeepTTL | setEX | setPX | setEXAT | setPXAT
+
+func init() {
+	registerCommand(
+		&command{name: CommentSet, arity: -3, flags: cmdWrite, firstKey: 1, lastKey: 1, keyStep: 1, proc: setCommand},
+		&command{name: CommentGet, arity: 2, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: getCommand},
+		&command{name: CommentAppend, arity: 3, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: appendCommand},
+		&command{name: CommentStrlen, arity: 2, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: strlenCommand},
+		&command{name: CommentGetRange, arity: 4, flags: cmdReadonly, firstKey: 1, lastKey: 1, keyStep: 1, proc: getrangeCommand},
+		&command{name: CommentSetRange, arity: 4, flags: cmdWrite, firstKey: 1, lastKey: 1, keyStep: 1, proc: setrangeCommand},
+		&command{name: CommentIncr, arity: 2, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: incrCommand},
+		&command{name: CommentDecr, arity: 2, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: decrCommand},
+		&command{name: CommentIncrBy, arity: 3, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: incrbyCommand},
+		&command{name: CommentDecrBy, arity: 3, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: decrbyCommand},
+		&command{name: CommentIncrByFloat, arity: 3, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: incrbyfloatCommand},
+	)
+}
+
+// lookupString 获取字符串类型的值，键存在但不是字符串时返回WRONGTYPE错误
+func (ks *keyspace) lookupString(key string) ([]byte, bool, error) {
+	val, ok := ks.lookup(key)
+	if !ok {
+		return nil, false, nil
+	}
+	b, isStr := val.([]byte)
+	if !isStr {
+		return nil, true, errWrongType
+	}
+	return b, true, nil
+}
+
+// setCommand SET key value [NX|XX] [GET] [EX seconds|PX milliseconds|EXAT unix-time-seconds|PXAT unix-time-milliseconds|KEEPTTL]
+func setCommand(s *Service, p *Peer, args []string) any {
+	flags := 0
+	// expire 过期时间参数，hasExpire 已读取过期时间参数（参数本身可能为空串）
+	var expire string
+	var hasExpire bool
+	for i := 3; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		hasNext := i+1 < len(args)
+		switch {
+		case opt == "NX" && flags&setXX == 0:
+			flags |= setNX
+		case opt == "XX" && flags&setNX == 0:
+			flags |= setXX
+		case opt == "GET":
+			flags |= setGet
+		case opt == "KEEPTTL" && flags&setExpireFlags == 0:
+			flags |= setKeepTTL
+		case opt == "EX" && flags&setExpireFlags == 0 && hasNext:
+			flags |= setEX
+		case opt == "PX" && flags&setExpireFlags == 0 && hasNext:
+			flags |= setPX
+		case opt == "EXAT" && flags&setExpireFlags == 0 && hasNext:
+			flags |= setEXAT
+		case opt == "PXAT" && flags&setExpireFlags == 0 && hasNext:
+			flags |= setPXAT
+		default:
+			return errSyntax
+		}
+		if flags&(setEX|setPX|setEXAT|setPXAT) != 0 && !hasExpire {
+			i++
+			expire, hasExpire = args[i], true
+		}
+	}
+	when := int64(-1)
+	if hasExpire {
+		if expire == "" {
+			return invalidExpireErr(args[0])
+		}
+		unit := int64(1000)
+		if flags&(setPX|setPXAT) != 0 {
+			unit = 1
+		}
+		t, err := parseInt(expire)
+		if err != nil {
+			return err
+		}
+		if t <= 0 {
+			return invalidExpireErr(args[0])
+		}
+		when, err = parseExpireTime(args[0], expire, unit, flags&(setEXAT|setPXAT) != 0)
+		if err != nil {
+			return err
+		}
+	}
+	key := args[1]
+	old, found, err := s.db.lookupString(key)
+	if err != nil && flags&setGet != 0 {
+		return err
+	}
+	if (flags&setNX != 0 && found) || (flags&setXX != 0 && !found) {
+		if flags&setGet != 0 {
+			return bulkOrNil(old, found)
+		}
+		return nil
+	}
+	if flags&setKeepTTL != 0 {
+		s.db.setKeepTTL(key, []byte(args[2]))
+	} else {
+		s.db.set(key, []byte(args[2]))
+	}
+	if when != -1 {
+		s.db.setExpire(key, when)
+		// 相对的过期时间改写为绝对时间传播，重放时不受执行时刻影响
+		s.rewritePropagate([]string{CommentSet, key, args[2], "PXAT", strconv.FormatInt(when, 10)})
+	}
+	if flags&setGet != 0 {
+		return bulkOrNil(old, found)
+	}
+	return "OK"
+}
+
+// getCommand GET key
+func getCommand(s *Service, p *Peer, args []string) any {
+	b, found, err := s.db.lookupString(args[1])
+	if err != nil {
+		return err
+	}
+	return bulkOrNil(b, found)
+}
+
+// appendCommand APPEND key value
+func appendCommand(s *Service, p *Peer, args []string) any {
+	key := args[1]
+	b, _, err := s.db.lookupString(key)
+	if err != nil {
+		return err
+	}
+	if len(b)+len(args[2]) > resp.MaxBulkLen {
+		return errStringTooBig
+	}
+	nb := make([]byte, 0, len(b)+len(args[2]))
+	nb = append(append(nb, b...), args[2]...)
+	s.db.setKeepTTL(key, nb)
+	return int64(len(nb))
+}
+
+// strlenCommand STRLEN key
+func strlenCommand(s *Service, p *Peer, args []string) any {
+	b, _, err := s.db.lookupString(args[1])
+	if err != nil {
+		return err
+	}
+	return int64(len(b))
+}
+
+// getrangeCommand GETRANGE key start end
+func getrangeCommand(s *Service, p *Peer, args []string) any {
+	start, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	end, err := parseInt(args[3])
+	if err != nil {
+		return err
+	}
+	b, _, err := s.db.lookupString(args[1])
+	if err != nil {
+		return err
+	}
+	n := int64(len(b))
+	if start < 0 && end < 0 && start > end {
+		return resp.BulkStrings("")
+	}
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if end < 0 {
+		end = max(n+end, 0)
+	}
+	end = min(end, n-1)
+	if start > end || n == 0 {
+		return resp.BulkStrings("")
+	}
+	return b[start : end+1]
+}
+
+// setrangeCommand SETRANGE key offset value
+func setrangeCommand(s *Service, p *Peer, args []string) any {
+	offset, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	if offset < 0 {
+		return errors.New("ERR offset is out of range")
+	}
+	key, value := args[1], args[3]
+	b, _, err := s.db.lookupString(key)
+	if err != nil {
+		return err
+	}
+	if len(value) == 0 {
+		return int64(len(b))
+	}
+	if offset+int64(len(value)) > resp.MaxBulkLen {
+		return errStringTooBig
+	}
+	nb := make([]byte, max(int64(len(b)), offset+int64(len(value))))
+	copy(nb, b)
+	copy(nb[offset:], value)
+	s.db.setKeepTTL(key, nb)
+	return int64(len(nb))
+}
+
+// incrDecr 将键的值按整数加上incr，键不存在时视为0
+func incrDecr(s *Service, key string, incr int64) any {
+	b, _, err := s.db.lookupString(key)
+	if err != nil {
+		return err
+	}
+	var value int64
+	if b != nil {
+		if value, err = parseInt(string(b)); err != nil {
+			return err
+		}
+	}
+	if (incr < 0 && value < 0 && incr < math.MinInt64-value) ||
+		(incr > 0 && value > 0 && incr > math.MaxInt64-value) {
+		return errOverflow
+	}
+	value += incr
+	s.db.setKeepTTL(key, strconv.AppendInt(nil, value, 10))
+	return value
+}
+
+// incrCommand INCR key
+func incrCommand(s *Service, p *Peer, args []string) any {
+	return incrDecr(s, args[1], 1)
+}
+
+// decrCommand DECR key
+func decrCommand(s *Service, p *Peer, args []string) any {
+	return incrDecr(s, args[1], -1)
+}
+
+// incrbyCommand INCRBY key increment
+func incrbyCommand(s *Service, p *Peer, args []string) any {
+	incr, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	return incrDecr(s, args[1], incr)
+}
+
+// decrbyCommand DECRBY key decrement
+func decrbyCommand(s *Service, p *Peer, args []string) any {
+	decr, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	if decr == math.MinInt64 {
+		return errors.New("ERR decrement would overflow")
+	}
+	return incrDecr(s, args[1], -decr)
+}
+
+// incrbyfloatCommand INCRBYFLOAT key increment
+// 回复为浮点数，RESP2下以批量字符串返回，RESP3下以Double返回
+func incrbyfloatCommand(s *Service, p *Peer, args []string) any {
+	incr, err := parseFloat(args[2])
+	if err != nil {
+		return err
+	}
+	key := args[1]
+	b, _, err := s.db.lookupString(key)
+	if err != nil {
+		return err
+	}
+	var value float64
+	if b != nil {
+		if value, err = parseFloat(string(b)); err != nil {
+			return err
+		}
+	}
+	value += incr
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return errNaNOrInf
+	}
+	str := resp.FormatDouble(value)
+	s.db.setKeepTTL(key, []byte(str))
+	// 以SET传播结果，浮点运算与格式化在副本和AOF载入时可能得到不同的字符串
+	s.rewritePropagate([]string{CommentSet, key, str, "KEEPTTL"})
+	return value
+}
+
+// bulkOrNil 键存在时返回批量字符串，否则返回空值
+func bulkOrNil(b []byte, found bool) any {
+	if !found {
+		return nil
+	}
+	return b
+}
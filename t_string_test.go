@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStringCommands(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	testCases := []struct {
+		name string
+		args []string
+		res  any
+	}{
+		{name: "测试GET不存在", args: []string{"GET", "k"}, res: nil},
+		{name: "测试SET", args: []string{"SET", "k", "hello"}, res: "OK"},
+		{name: "测试GET", args: []string{"get", "k"}, res: []byte("hello")},
+		{name: "测试SET NX已存在", args: []string{"SET", "k", "x", "NX"}, res: nil},
+		{name: "测试SET XX GET", args: []string{"SET", "k", "world", "XX", "GET"}, res: []byte("hello")},
+		{name: "测试SET XX不存在", args: []string{"SET", "k2", "x", "XX"}, res: nil},
+		{name: "测试SET NX XX", args: []string{"SET", "k2", "x", "NX", "XX"}, res: errSyntax},
+		{name: "测试APPEND", args: []string{"APPEND", "k", "!!"}, res: int64(7)},
+		{name: "测试STRLEN", args: []string{"STRLEN", "k"}, res: int64(7)},
+		{name: "测试GETRANGE", args: []string{"GETRANGE", "k", "1", "-3"}, res: []byte("orld")},
+		{name: "测试GETRANGE越界", args: []string{"GETRANGE", "k", "-100", "100"}, res: []byte("world!!")},
+		{name: "测试GETRANGE负数反转", args: []string{"GETRANGE", "k", "-1", "-5"}, res: resp.BulkStrings("")},
+		{name: "测试SETRANGE补零", args: []string{"SETRANGE", "k3", "3", "ab"}, res: int64(5)},
+		{name: "测试SETRANGE结果", args: []string{"GET", "k3"}, res: []byte("\x00\x00\x00ab")},
+		{name: "测试SETRANGE空值不创建", args: []string{"SETRANGE", "k4", "3", ""}, res: int64(0)},
+		{name: "测试SETRANGE负偏移", args: []string{"SETRANGE", "k", "-1", "a"}, res: errors.New("ERR offset is out of range")},
+		{name: "测试EXISTS重复计数", args: []string{"EXISTS", "k", "k", "k4"}, res: int64(2)},
+		{name: "测试DEL", args: []string{"DEL", "k", "k3", "k4"}, res: int64(2)},
+		{name: "测试GETRANGE非整数", args: []string{"GETRANGE", "k", "a", "1"}, res: errNotInteger},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert.Equal(t, v.res, doCommand(s, p, v.args...))
+		})
+	}
+}
+
+func TestParseInt(t *testing.T) {
+	for _, v := range []string{"+1", "01", "-0", " 1", "", "99999999999999999999"} {
+		_, err := parseInt(v)
+		assert.Error(t, err, v)
+	}
+	i, err := parseInt("-9223372036854775808")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-9223372036854775808), i)
+}
+
+func TestCounterCommands(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	testCases := []struct {
+		name string
+		args []string
+		res  any
+	}{
+		{name: "测试INCR不存在", args: []string{"INCR", "c"}, res: int64(1)},
+		{name: "测试INCRBY", args: []string{"INCRBY", "c", "41"}, res: int64(42)},
+		{name: "测试DECR", args: []string{"DECR", "c"}, res: int64(41)},
+		{name: "测试DECRBY", args: []string{"DECRBY", "c", "-9"}, res: int64(50)},
+		{name: "测试INCRBY非整数增量", args: []string{"INCRBY", "c", "1.5"}, res: errNotInteger},
+		{name: "测试SET最大值", args: []string{"SET", "c", "9223372036854775807"}, res: "OK"},
+		{name: "测试INCR溢出", args: []string{"INCR", "c"}, res: errOverflow},
+		{name: "测试DECRBY最小值", args: []string{"DECRBY", "c", "-9223372036854775808"}, res: errors.New("ERR decrement would overflow")},
+		{name: "测试SET非整数", args: []string{"SET", "c", " 1"}, res: "OK"},
+		{name: "测试INCR非整数", args: []string{"INCR", "c"}, res: errNotInteger},
+		{name: "测试INCRBYFLOAT", args: []string{"INCRBYFLOAT", "f", "10.5"}, res: 10.5},
+		{name: "测试INCRBYFLOAT负数", args: []string{"INCRBYFLOAT", "f", "-0.25"}, res: 10.25},
+		{name: "测试INCRBYFLOAT结果", args: []string{"GET", "f"}, res: []byte("10.25")},
+		{name: "测试INCRBYFLOAT非法", args: []string{"INCRBYFLOAT", "f", "abc"}, res: errNotFloat},
+		{name: "测试INCRBYFLOAT无穷", args: []string{"INCRBYFLOAT", "f", "inf"}, res: errNaNOrInf},
+		{name: "测试INCR保留TTL", args: []string{"SET", "t", "1", "EX", "100"}, res: "OK"},
+		{name: "测试INCR", args: []string{"INCR", "t"}, res: int64(2)},
+		{name: "测试TTL", args: []string{"TTL", "t"}, res: int64(100)},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert.Equal(t, v.res, doCommand(s, p, v.args...))
+		})
+	}
+}
+
+func TestIncrByFloatProtocol(t *testing.T) {
+	s := NewService(Config{})
+	p, buf := newTestPeer(s)
+	p.handleMSG(s, Message{peer: p, args: []string{"INCRBYFLOAT", "f", "1.5"}})
+	assert.Equal(t, "$3\r\n1.5\r\n", buf.String())
+	buf.Reset()
+	p.setProtocol(resp.Proto3)
+	p.handleMSG(s, Message{peer: p, args: []string{"INCRBYFLOAT", "f", "1.5"}})
+	assert.Equal(t, ",3\r\n", buf.String())
+}
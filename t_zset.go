@@ -0,0 +1,829 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+)
+
+const (
+	CommentZAdd             = "ZADD"
+	CommentZCard            = "ZCARD"
+	CommentZScore           = "ZSCORE"
+	CommentZMScore          = "ZMSCORE"
+	CommentZIncrBy          = "ZINCRBY"
+	CommentZRem             = "ZREM"
+	CommentZRank            = "ZRANK"
+	CommentZRevRank         = "ZREVRANK"
+	CommentZCount           = "ZCOUNT"
+	CommentZLexCount        = "ZLEXCOUNT"
+	CommentZRange           = "ZRANGE"
+	CommentZRangeStore      = "ZRANGESTORE"
+	CommentZRangeByScore    = "ZRANGEBYSCORE"
+	CommentZRevRangeByScore = "ZREVRANGEBYSCORE"
+	CommentZRangeByLex      = "ZRANGEBYLEX"
+	CommentZRevRangeByLex   = "ZREVRANGEBYLEX"
+	CommentZRevRange        = "ZREVRANGE"
+	CommentZPopMin          = "ZPOPMIN"
+	CommentZPopMax          = "ZPOPMAX"
+	CommentZScan            = "ZSCAN"
+)
+
+// ZADD的输入标志
+const (
+	zaddNX = 1 << iota
+	zaddXX
+	zaddGT
+	zaddLT
+	zaddIncr
+)
+
+// zsetObj.add的结果
+const (
+	// zaddNop 因NX、XX、GT、LT条件未执行
+	zaddNop = iota
+	zaddAdded
+	zaddUpdated
+	// zaddUnchanged 已执行但分值未变化
+	zaddUnchanged
+)
+
+// 区间类型
+const (
+	zrangeRank = iota
+	zrangeScore
+	zrangeLex
+)
+
+var (
+	errMinMaxNotFloat = errors.New("ERR min or max is not a float")
+	errMinMaxNotLex   = errors.New("ERR min or max not valid string range item")
+	errScoreNaN       = errors.New("ERR resulting score is not a number (NaN)")
+)
+
+// zsetObj 有序集合类型的值，dict用于按成员查分值，zsl用于按分值与排名查找
+type zsetObj struct {
+	zsl  *zskiplist
+	dict map[string]float64
+}
+
+// zsetEntry 有序集合中的一个元素
+type zsetEntry struct {
+	member string
+	score  float64
+}
+
+// zscoreRange 分值区间，minex、maxex表示开区间
+type zscoreRange struct {
+	min, max     float64
+	minex, maxex bool
+}
+
+// zlexBound 字典序区间的一端，inf为-1、1分别表示"-"与"+"
+type zlexBound struct {
+	value string
+	ex    bool
+	inf   int
+}
+
+// zlexRange 字典序区间
+type zlexRange struct {
+	min, max zlexBound
+}
+
+func init() {
+	registerCommand(
+		&command{name: CommentZAdd, arity: -4, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: zaddCommand},
+		&command{name: CommentZCard, arity: 2, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: zcardCommand},
+		&command{name: CommentZScore, arity: 3, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: zscoreCommand},
+		&command{name: CommentZMScore, arity: -3, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: zmscoreCommand},
+		&command{name: CommentZIncrBy, arity: 4, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: zincrbyCommand},
+		&command{name: CommentZRem, arity: -3, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: zremCommand},
+		&command{name: CommentZRank, arity: -3, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: zrankCommand},
+		&command{name: CommentZRevRank, arity: -3, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: zrevrankCommand},
+		&command{name: CommentZCount, arity: 4, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: zcountCommand},
+		&command{name: CommentZLexCount, arity: 4, flags: cmdReadonly | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: zlexcountCommand},
+		&command{name: CommentZRange, arity: -4, flags: cmdReadonly, firstKey: 1, lastKey: 1, keyStep: 1, proc: zrangeCommand},
+		&command{name: CommentZRangeStore, arity: -5, flags: cmdWrite, firstKey: 1, lastKey: 2, keyStep: 1, proc: zrangestoreCommand},
+		&command{name: CommentZRangeByScore, arity: -4, flags: cmdReadonly, firstKey: 1, lastKey: 1, keyStep: 1, proc: zrangebyscoreCommand},
+		&command{name: CommentZRevRangeByScore, arity: -4, flags: cmdReadonly, firstKey: 1, lastKey: 1, keyStep: 1, proc: zrevrangebyscoreCommand},
+		&command{name: CommentZRangeByLex, arity: -4, flags: cmdReadonly, firstKey: 1, lastKey: 1, keyStep: 1, proc: zrangebylexCommand},
+		&command{name: CommentZRevRangeByLex, arity: -4, flags: cmdReadonly, firstKey: 1, lastKey: 1, keyStep: 1, proc: zrevrangebylexCommand},
+		&command{name: CommentZRevRange, arity: -4, flags: cmdReadonly, firstKey: 1, lastKey: 1, keyStep: 1, proc: zrevrangeCommand},
+		&command{name: CommentZPopMin, arity: -2, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: zpopminCommand},
+		&command{name: CommentZPopMax, arity: -2, flags: cmdWrite | cmdFast, firstKey: 1, lastKey: 1, keyStep: 1, proc: zpopmaxCommand},
+		&command{name: CommentZScan, arity: -3, flags: cmdReadonly, firstKey: 1, lastKey: 1, keyStep: 1, proc: zscanCommand},
+	)
+}
+
+func newZsetObj() *zsetObj {
+	return &zsetObj{zsl: newZskiplist(), dict: make(map[string]float64)}
+}
+
+// size 返回元素个数
+func (zs *zsetObj) size() int {
+	return len(zs.dict)
+}
+
+// clone 深拷贝有序集合，用于生成持久化快照
+func (zs *zsetObj) clone() *zsetObj {
+	c := newZsetObj()
+	for x := zs.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		c.zsl.insert(x.score, x.member)
+		c.dict[x.member] = x.score
+	}
+	return c
+}
+
+// add 按ZADD的标志插入或更新元素，返回执行结果与元素最终的分值
+func (zs *zsetObj) add(score float64, member string, flags int) (int, float64, error) {
+	cur, ok := zs.dict[member]
+	if !ok {
+		if flags&zaddXX != 0 {
+			return zaddNop, 0, nil
+		}
+		zs.zsl.insert(score, member)
+		zs.dict[member] = score
+		return zaddAdded, score, nil
+	}
+	if flags&zaddNX != 0 {
+		return zaddNop, cur, nil
+	}
+	if flags&zaddIncr != 0 {
+		score += cur
+		if math.IsNaN(score) {
+			return zaddNop, cur, errScoreNaN
+		}
+	}
+	if (flags&zaddGT != 0 && score <= cur) || (flags&zaddLT != 0 && score >= cur) {
+		return zaddNop, cur, nil
+	}
+	if score == cur {
+		return zaddUnchanged, cur, nil
+	}
+	zs.zsl.delete(cur, member)
+	zs.zsl.insert(score, member)
+	zs.dict[member] = score
+	return zaddUpdated, score, nil
+}
+
+// score 返回元素的分值，zs为nil时视为空集合
+func (zs *zsetObj) score(member string) (float64, bool) {
+	if zs == nil {
+		return 0, false
+	}
+	score, ok := zs.dict[member]
+	return score, ok
+}
+
+// remove 删除元素，返回是否存在
+func (zs *zsetObj) remove(member string) bool {
+	score, ok := zs.dict[member]
+	if !ok {
+		return false
+	}
+	zs.zsl.delete(score, member)
+	delete(zs.dict, member)
+	return true
+}
+
+// rank 返回元素从0开始的排名，rev为true时按分值从大到小排名
+func (zs *zsetObj) rank(member string, rev bool) (int, bool) {
+	score, ok := zs.dict[member]
+	if !ok {
+		return 0, false
+	}
+	r := zs.zsl.rank(score, member)
+	if rev {
+		return zs.size() - r, true
+	}
+	return r - 1, true
+}
+
+// rangeByRank 返回排名在[start, end]内的元素，支持负数下标
+func (zs *zsetObj) rangeByRank(start, end int64, rev bool) []zsetEntry {
+	n := int64(zs.size())
+	start, end, ok := normalizeRange(start, end, n)
+	if !ok {
+		return nil
+	}
+	var ln *zskiplistNode
+	if rev {
+		ln = zs.zsl.byRank(int(n - start))
+	} else {
+		ln = zs.zsl.byRank(int(start + 1))
+	}
+	res := make([]zsetEntry, 0, end-start+1)
+	for cnt := end - start + 1; cnt > 0 && ln != nil; cnt-- {
+		res = append(res, zsetEntry{ln.member, ln.score})
+		ln = ln.next(rev)
+	}
+	return res
+}
+
+// rangeByBound 返回分值或字典序区间内的元素，跳过offset个后最多返回limit个，limit为负数表示不限
+func (zs *zsetObj) rangeByBound(r zrangeBound, rev bool, offset, limit int64) []zsetEntry {
+	if offset < 0 {
+		return nil
+	}
+	var ln *zskiplistNode
+	if rev {
+		ln = zs.zsl.lastInRange(r)
+	} else {
+		ln = zs.zsl.firstInRange(r)
+	}
+	for ; ln != nil && offset > 0; offset-- {
+		ln = ln.next(rev)
+	}
+	var res []zsetEntry
+	for ; ln != nil && limit != 0; limit-- {
+		if (rev && !r.gteMin(ln)) || (!rev && !r.lteMax(ln)) {
+			break
+		}
+		res = append(res, zsetEntry{ln.member, ln.score})
+		ln = ln.next(rev)
+	}
+	return res
+}
+
+// count 返回区间内的元素个数
+func (zs *zsetObj) count(r zrangeBound) int64 {
+	first := zs.zsl.firstInRange(r)
+	if first == nil {
+		return 0
+	}
+	last := zs.zsl.lastInRange(r)
+	return int64(zs.zsl.rank(last.score, last.member) - zs.zsl.rank(first.score, first.member) + 1)
+}
+
+func (r zscoreRange) gteMin(n *zskiplistNode) bool {
+	if r.minex {
+		return n.score > r.min
+	}
+	return n.score >= r.min
+}
+
+func (r zscoreRange) lteMax(n *zskiplistNode) bool {
+	if r.maxex {
+		return n.score < r.max
+	}
+	return n.score <= r.max
+}
+
+func (r zlexRange) gteMin(n *zskiplistNode) bool {
+	switch r.min.inf {
+	case -1:
+		return true
+	case 1:
+		return false
+	}
+	c := strings.Compare(n.member, r.min.value)
+	return c > 0 || (c == 0 && !r.min.ex)
+}
+
+func (r zlexRange) lteMax(n *zskiplistNode) bool {
+	switch r.max.inf {
+	case 1:
+		return true
+	case -1:
+		return false
+	}
+	c := strings.Compare(n.member, r.max.value)
+	return c < 0 || (c == 0 && !r.max.ex)
+}
+
+// parseScoreBound 解析分值区间的一端，"("前缀表示开区间
+func parseScoreBound(s string) (float64, bool, error) {
+	ex := strings.HasPrefix(s, "(")
+	if ex {
+		s = s[1:]
+	}
+	f, err := parseFloat(s)
+	if err != nil {
+		return 0, false, errMinMaxNotFloat
+	}
+	return f, ex, nil
+}
+
+// parseScoreRange 解析min max形式的分值区间
+func parseScoreRange(min, max string) (zscoreRange, error) {
+	var r zscoreRange
+	var err error
+	if r.min, r.minex, err = parseScoreBound(min); err != nil {
+		return r, err
+	}
+	if r.max, r.maxex, err = parseScoreBound(max); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// parseLexBound 解析字典序区间的一端，必须为"-"、"+"或以"("、"["开头
+func parseLexBound(s string) (zlexBound, error) {
+	switch {
+	case s == "-":
+		return zlexBound{inf: -1}, nil
+	case s == "+":
+		return zlexBound{inf: 1}, nil
+	case strings.HasPrefix(s, "("):
+		return zlexBound{value: s[1:], ex: true}, nil
+	case strings.HasPrefix(s, "["):
+		return zlexBound{value: s[1:]}, nil
+	default:
+		return zlexBound{}, errMinMaxNotLex
+	}
+}
+
+// parseLexRange 解析min max形式的字典序区间
+func parseLexRange(min, max string) (zlexRange, error) {
+	var r zlexRange
+	var err error
+	if r.min, err = parseLexBound(min); err != nil {
+		return r, err
+	}
+	if r.max, err = parseLexBound(max); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// lookupZset 获取有序集合类型的值，键不存在时返回nil
+func (ks *keyspace) lookupZset(key string) (*zsetObj, error) {
+	val, ok := ks.lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	zs, isZset := val.(*zsetObj)
+	if !isZset {
+		return nil, errWrongType
+	}
+	return zs, nil
+}
+
+// zsetReply 构造元素列表的回复，withScores为true时RESP3下每个元素为[member, score]，
+// RESP2下成员与分值交替平铺；分值为float64，RESP3下编码为Double
+func zsetReply(p *Peer, entries []zsetEntry, withScores bool) resp.Array {
+	res := make(resp.Array, 0, len(entries))
+	for _, e := range entries {
+		switch {
+		case !withScores:
+			res = append(res, resp.BulkStrings(e.member))
+		case p.proto >= resp.Proto3:
+			res = append(res, resp.Array{resp.BulkStrings(e.member), e.score})
+		default:
+			res = append(res, resp.BulkStrings(e.member), e.score)
+		}
+	}
+	return res
+}
+
+// zaddCommand ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+func zaddCommand(s *Service, p *Peer, args []string) any {
+	flags, ch := 0, false
+	i := 2
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			flags |= zaddNX
+		case "XX":
+			flags |= zaddXX
+		case "GT":
+			flags |= zaddGT
+		case "LT":
+			flags |= zaddLT
+		case "INCR":
+			flags |= zaddIncr
+		case "CH":
+			ch = true
+		default:
+			break options
+		}
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return errSyntax
+	}
+	if flags&zaddNX != 0 && flags&zaddXX != 0 {
+		return errors.New("ERR XX and NX options at the same time are not compatible")
+	}
+	if (flags&zaddGT != 0 && flags&(zaddLT|zaddNX) != 0) || (flags&zaddLT != 0 && flags&zaddNX != 0) {
+		return errors.New("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	incr := flags&zaddIncr != 0
+	if incr && len(pairs) > 2 {
+		return errors.New("ERR INCR option supports a single increment-element pair")
+	}
+	// 先解析全部分值，任一非法时不做任何修改
+	scores := make([]float64, len(pairs)/2)
+	for j := range scores {
+		f, err := parseFloat(pairs[j*2])
+		if err != nil {
+			return err
+		}
+		scores[j] = f
+	}
+
+	key := args[1]
+	zs, err := s.db.lookupZset(key)
+	if err != nil {
+		return err
+	}
+	if zs == nil {
+		if flags&zaddXX != 0 {
+			if incr {
+				return nil
+			}
+			return int64(0)
+		}
+		zs = newZsetObj()
+		s.db.set(key, zs)
+	}
+	var added, updated, processed int64
+	var score float64
+	for j := range scores {
+		out, newScore, err := zs.add(scores[j], pairs[j*2+1], flags)
+		if err != nil {
+			return err
+		}
+		switch out {
+		case zaddAdded:
+			added++
+		case zaddUpdated:
+			updated++
+		}
+		if out != zaddNop {
+			processed++
+		}
+		score = newScore
+	}
+	if added+updated > 0 {
+		s.db.signalModifiedKey(key)
+	}
+	if incr {
+		if processed == 0 {
+			return nil
+		}
+		return score
+	}
+	if ch {
+		return added + updated
+	}
+	return added
+}
+
+// zcardCommand ZCARD key
+func zcardCommand(s *Service, p *Peer, args []string) any {
+	zs, err := s.db.lookupZset(args[1])
+	if err != nil || zs == nil {
+		return zeroOrErr(err)
+	}
+	return int64(zs.size())
+}
+
+// zscoreCommand ZSCORE key member
+func zscoreCommand(s *Service, p *Peer, args []string) any {
+	zs, err := s.db.lookupZset(args[1])
+	if err != nil || zs == nil {
+		return err
+	}
+	if score, ok := zs.dict[args[2]]; ok {
+		return score
+	}
+	return nil
+}
+
+// zmscoreCommand ZMSCORE key member [member ...]
+func zmscoreCommand(s *Service, p *Peer, args []string) any {
+	zs, err := s.db.lookupZset(args[1])
+	if err != nil {
+		return err
+	}
+	res := make(resp.Array, 0, len(args)-2)
+	for _, member := range args[2:] {
+		if score, ok := zs.score(member); ok {
+			res = append(res, score)
+		} else {
+			res = append(res, nil)
+		}
+	}
+	return res
+}
+
+// zincrbyCommand ZINCRBY key increment member
+func zincrbyCommand(s *Service, p *Peer, args []string) any {
+	incr, err := parseFloat(args[2])
+	if err != nil {
+		return err
+	}
+	key := args[1]
+	zs, err := s.db.lookupZset(key)
+	if err != nil {
+		return err
+	}
+	if zs == nil {
+		zs = newZsetObj()
+		s.db.set(key, zs)
+	}
+	_, score, err := zs.add(incr, args[3], zaddIncr)
+	if err != nil {
+		return err
+	}
+	s.db.signalModifiedKey(key)
+	return score
+}
+
+// zremCommand ZREM key member [member ...]
+func zremCommand(s *Service, p *Peer, args []string) any {
+	key := args[1]
+	zs, err := s.db.lookupZset(key)
+	if err != nil || zs == nil {
+		return zeroOrErr(err)
+	}
+	var removed int64
+	for _, member := range args[2:] {
+		if zs.remove(member) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		if zs.size() == 0 {
+			s.db.delete(key)
+		} else {
+			s.db.signalModifiedKey(key)
+		}
+	}
+	return removed
+}
+
+// zrankGeneric ZRANK/ZREVRANK key member [WITHSCORE]
+func zrankGeneric(s *Service, args []string, rev bool) any {
+	if len(args) > 4 || (len(args) == 4 && strings.ToUpper(args[3]) != "WITHSCORE") {
+		return errSyntax
+	}
+	withScore := len(args) == 4
+	zs, err := s.db.lookupZset(args[1])
+	if err != nil {
+		return err
+	}
+	var rank int
+	var ok bool
+	if zs != nil {
+		rank, ok = zs.rank(args[2], rev)
+	}
+	switch {
+	case !ok && withScore:
+		return resp.Array(nil)
+	case !ok:
+		return nil
+	case withScore:
+		return resp.Array{int64(rank), zs.dict[args[2]]}
+	default:
+		return int64(rank)
+	}
+}
+
+// zrankCommand ZRANK key member [WITHSCORE]
+func zrankCommand(s *Service, p *Peer, args []string) any {
+	return zrankGeneric(s, args, false)
+}
+
+// zrevrankCommand ZREVRANK key member [WITHSCORE]
+func zrevrankCommand(s *Service, p *Peer, args []string) any {
+	return zrankGeneric(s, args, true)
+}
+
+// zcountCommand ZCOUNT key min max
+func zcountCommand(s *Service, p *Peer, args []string) any {
+	r, err := parseScoreRange(args[2], args[3])
+	if err != nil {
+		return err
+	}
+	zs, err := s.db.lookupZset(args[1])
+	if err != nil || zs == nil {
+		return zeroOrErr(err)
+	}
+	return zs.count(r)
+}
+
+// zlexcountCommand ZLEXCOUNT key min max
+func zlexcountCommand(s *Service, p *Peer, args []string) any {
+	r, err := parseLexRange(args[2], args[3])
+	if err != nil {
+		return err
+	}
+	zs, err := s.db.lookupZset(args[1])
+	if err != nil || zs == nil {
+		return zeroOrErr(err)
+	}
+	return zs.count(r)
+}
+
+// zrangeGeneric ZRANGE族命令的公共实现，args从min、max开始。
+// unified为true时接受BYSCORE、BYLEX与REV选项，store不为空时将结果保存到该键并回复元素个数
+func zrangeGeneric(s *Service, p *Peer, key string, args []string, store string, rangeType int, rev, unified bool) any {
+	withScores, hasLimit := false, false
+	offset, limit := int64(0), int64(-1)
+	for i := 2; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		switch {
+		case opt == "WITHSCORES" && store == "":
+			withScores = true
+		case opt == "LIMIT" && i+2 < len(args):
+			var err error
+			if offset, err = parseInt(args[i+1]); err != nil {
+				return err
+			}
+			if limit, err = parseInt(args[i+2]); err != nil {
+				return err
+			}
+			hasLimit = true
+			i += 2
+		case opt == "BYSCORE" && unified:
+			rangeType = zrangeScore
+		case opt == "BYLEX" && unified:
+			rangeType = zrangeLex
+		case opt == "REV" && unified:
+			rev = true
+		default:
+			return errSyntax
+		}
+	}
+	if hasLimit && rangeType == zrangeRank {
+		return errors.New("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if withScores && rangeType == zrangeLex {
+		return errors.New("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	// 逆序按分值或字典序查询时参数顺序为max min
+	minArg, maxArg := args[0], args[1]
+	if rev && rangeType != zrangeRank {
+		minArg, maxArg = maxArg, minArg
+	}
+
+	var bound zrangeBound
+	var start, end int64
+	var err error
+	switch rangeType {
+	case zrangeRank:
+		if start, err = parseInt(minArg); err != nil {
+			return err
+		}
+		if end, err = parseInt(maxArg); err != nil {
+			return err
+		}
+	case zrangeScore:
+		if bound, err = parseScoreRange(minArg, maxArg); err != nil {
+			return err
+		}
+	case zrangeLex:
+		if bound, err = parseLexRange(minArg, maxArg); err != nil {
+			return err
+		}
+	}
+
+	zs, err := s.db.lookupZset(key)
+	if err != nil {
+		return err
+	}
+	var entries []zsetEntry
+	if zs != nil {
+		if rangeType == zrangeRank {
+			entries = zs.rangeByRank(start, end, rev)
+		} else {
+			entries = zs.rangeByBound(bound, rev, offset, limit)
+		}
+	}
+	if store == "" {
+		return zsetReply(p, entries, withScores)
+	}
+	if len(entries) == 0 {
+		s.db.delete(store)
+		return int64(0)
+	}
+	dst := newZsetObj()
+	for _, e := range entries {
+		dst.add(e.score, e.member, 0)
+	}
+	s.db.set(store, dst)
+	return int64(len(entries))
+}
+
+// zrangeCommand ZRANGE key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func zrangeCommand(s *Service, p *Peer, args []string) any {
+	return zrangeGeneric(s, p, args[1], args[2:], "", zrangeRank, false, true)
+}
+
+// zrangestoreCommand ZRANGESTORE dst src min max [BYSCORE|BYLEX] [REV] [LIMIT offset count]
+func zrangestoreCommand(s *Service, p *Peer, args []string) any {
+	return zrangeGeneric(s, p, args[2], args[3:], args[1], zrangeRank, false, true)
+}
+
+// zrangebyscoreCommand ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count]
+func zrangebyscoreCommand(s *Service, p *Peer, args []string) any {
+	return zrangeGeneric(s, p, args[1], args[2:], "", zrangeScore, false, false)
+}
+
+// zrevrangebyscoreCommand ZREVRANGEBYSCORE key max min [WITHSCORES] [LIMIT offset count]
+func zrevrangebyscoreCommand(s *Service, p *Peer, args []string) any {
+	return zrangeGeneric(s, p, args[1], args[2:], "", zrangeScore, true, false)
+}
+
+// zrangebylexCommand ZRANGEBYLEX key min max [LIMIT offset count]
+func zrangebylexCommand(s *Service, p *Peer, args []string) any {
+	return zrangeGeneric(s, p, args[1], args[2:], "", zrangeLex, false, false)
+}
+
+// zrevrangebylexCommand ZREVRANGEBYLEX key max min [LIMIT offset count]
+func zrevrangebylexCommand(s *Service, p *Peer, args []string) any {
+	return zrangeGeneric(s, p, args[1], args[2:], "", zrangeLex, true, false)
+}
+
+// zrevrangeCommand ZREVRANGE key start stop [WITHSCORES]
+func zrevrangeCommand(s *Service, p *Peer, args []string) any {
+	return zrangeGeneric(s, p, args[1], args[2:], "", zrangeRank, true, false)
+}
+
+// zpopGeneric ZPOPMIN/ZPOPMAX key [count]
+// 不带count时回复平铺的[member, score]，带count时回复格式与ZRANGE WITHSCORES一致
+func zpopGeneric(s *Service, p *Peer, args []string, max bool) any {
+	if len(args) > 3 {
+		return errSyntax
+	}
+	count := int64(1)
+	if len(args) == 3 {
+		c, err := parseInt(args[2])
+		if err != nil || c < 0 {
+			return errNotPositive
+		}
+		count = c
+	}
+	key := args[1]
+	zs, err := s.db.lookupZset(key)
+	if err != nil {
+		return err
+	}
+	var entries []zsetEntry
+	for zs != nil && int64(len(entries)) < count && zs.size() > 0 {
+		ln := zs.zsl.header.level[0].forward
+		if max {
+			ln = zs.zsl.tail
+		}
+		entries = append(entries, zsetEntry{ln.member, ln.score})
+		zs.remove(ln.member)
+	}
+	if len(entries) > 0 {
+		if zs.size() == 0 {
+			s.db.delete(key)
+		} else {
+			s.db.signalModifiedKey(key)
+		}
+	}
+	if len(args) == 3 {
+		return zsetReply(p, entries, true)
+	}
+	if len(entries) == 0 {
+		return resp.Array{}
+	}
+	return resp.Array{resp.BulkStrings(entries[0].member), entries[0].score}
+}
+
+// zpopminCommand ZPOPMIN key [count]
+func zpopminCommand(s *Service, p *Peer, args []string) any {
+	return zpopGeneric(s, p, args, false)
+}
+
+// zpopmaxCommand ZPOPMAX key [count]
+func zpopmaxCommand(s *Service, p *Peer, args []string) any {
+	return zpopGeneric(s, p, args, true)
+}
+
+// zscanCommand ZSCAN key cursor [MATCH pattern] [COUNT count]
+func zscanCommand(s *Service, p *Peer, args []string) any {
+	opt, err := parseScanArgs(args[2:])
+	if err != nil {
+		return err
+	}
+	zs, err := s.db.lookupZset(args[1])
+	if err != nil {
+		return err
+	}
+	var members []string
+	if zs != nil {
+		members = make([]string, 0, zs.size())
+		for m := range zs.dict {
+			members = append(members, m)
+		}
+	}
+	batch, next := scanOrder(members, opt.cursor, opt.count)
+	res := make(resp.Array, 0, len(batch)*2)
+	for _, m := range batch {
+		if opt.match == "" || stringMatch(opt.match, m, false) {
+			res = append(res, resp.BulkStrings(m), resp.BulkStrings(resp.FormatDouble(zs.dict[m])))
+		}
+	}
+	return resp.Array{resp.BulkStrings(strconv.FormatUint(next, 10)), res}
+}
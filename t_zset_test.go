@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	resp "BeginerAndProgresses/go-mini-redis/RESP"
+	"github.com/stretchr/testify/assert"
+)
+
+func bulks(vals ...string) resp.Array {
+	res := make(resp.Array, 0, len(vals))
+	for _, v := range vals {
+		res = append(res, resp.BulkStrings(v))
+	}
+	return res
+}
+
+func TestZsetCommands(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	testCases := []struct {
+		name string
+		args []string
+		res  any
+	}{
+		{name: "测试ZADD", args: []string{"ZADD", "z", "1", "a", "2", "b", "3", "c"}, res: int64(3)},
+		{name: "测试ZADD CH", args: []string{"ZADD", "z", "CH", "5", "c", "4", "d"}, res: int64(2)},
+		{name: "测试ZADD NX", args: []string{"ZADD", "z", "NX", "9", "a"}, res: int64(0)},
+		{name: "测试ZADD XX不存在", args: []string{"ZADD", "z", "XX", "9", "x"}, res: int64(0)},
+		{name: "测试ZADD GT", args: []string{"ZADD", "z", "GT", "CH", "0", "a"}, res: int64(0)},
+		{name: "测试ZADD INCR", args: []string{"ZADD", "z", "INCR", "1.5", "a"}, res: 2.5},
+		{name: "测试ZADD INCR未执行", args: []string{"ZADD", "z", "NX", "INCR", "1", "a"}, res: nil},
+		{name: "测试ZADD NX XX", args: []string{"ZADD", "z", "NX", "XX", "1", "a"}, res: errors.New("ERR XX and NX options at the same time are not compatible")},
+		{name: "测试ZADD GT LT", args: []string{"ZADD", "z", "GT", "LT", "1", "a"}, res: errors.New("ERR GT, LT, and/or NX options at the same time are not compatible")},
+		{name: "测试ZADD非法分值", args: []string{"ZADD", "z", "1", "x", "nan", "y"}, res: errNotFloat},
+		{name: "测试ZADD非法分值不修改", args: []string{"ZSCORE", "z", "x"}, res: nil},
+		{name: "测试ZADD语法错误", args: []string{"ZADD", "z", "NX", "1"}, res: errSyntax},
+		{name: "测试TYPE", args: []string{"TYPE", "z"}, res: "zset"},
+		{name: "测试ZCARD", args: []string{"ZCARD", "z"}, res: int64(4)},
+		{name: "测试ZSCORE", args: []string{"ZSCORE", "z", "c"}, res: 5.0},
+		{name: "测试ZMSCORE", args: []string{"ZMSCORE", "z", "b", "x"}, res: resp.Array{2.0, nil}},
+		{name: "测试ZINCRBY", args: []string{"ZINCRBY", "z", "-1", "c"}, res: 4.0},
+		{name: "测试ZRANGE", args: []string{"ZRANGE", "z", "0", "-1"}, res: bulks("b", "a", "c", "d")},
+		{name: "测试ZRANGE WITHSCORES", args: []string{"ZRANGE", "z", "0", "1", "WITHSCORES"}, res: resp.Array{resp.BulkStrings("b"), 2.0, resp.BulkStrings("a"), 2.5}},
+		{name: "测试ZRANGE REV", args: []string{"ZRANGE", "z", "0", "1", "REV"}, res: bulks("d", "c")},
+		{name: "测试ZRANGE BYSCORE", args: []string{"ZRANGE", "z", "(2", "4", "BYSCORE"}, res: bulks("a", "c", "d")},
+		{name: "测试ZRANGE BYSCORE REV LIMIT", args: []string{"ZRANGE", "z", "+inf", "-inf", "BYSCORE", "REV", "LIMIT", "1", "2"}, res: bulks("c", "a")},
+		{name: "测试ZRANGE LIMIT无BY", args: []string{"ZRANGE", "z", "0", "1", "LIMIT", "0", "1"}, res: errors.New("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")},
+		{name: "测试ZRANGE BYLEX WITHSCORES", args: []string{"ZRANGE", "z", "-", "+", "BYLEX", "WITHSCORES"}, res: errors.New("ERR syntax error, WITHSCORES not supported in combination with BYLEX")},
+		{name: "测试ZRANGE非法分值", args: []string{"ZRANGE", "z", "x", "1", "BYSCORE"}, res: errMinMaxNotFloat},
+		{name: "测试ZRANGEBYSCORE", args: []string{"ZRANGEBYSCORE", "z", "-inf", "(4", "WITHSCORES", "LIMIT", "1", "-1"}, res: resp.Array{resp.BulkStrings("a"), 2.5}},
+		{name: "测试ZREVRANGEBYSCORE", args: []string{"ZREVRANGEBYSCORE", "z", "4", "2.5"}, res: bulks("d", "c", "a")},
+		{name: "测试ZREVRANGE", args: []string{"ZREVRANGE", "z", "-2", "-1"}, res: bulks("a", "b")},
+		{name: "测试ZRANK", args: []string{"ZRANK", "z", "c"}, res: int64(2)},
+		{name: "测试ZREVRANK WITHSCORE", args: []string{"ZREVRANK", "z", "c", "WITHSCORE"}, res: resp.Array{int64(1), 4.0}},
+		{name: "测试ZRANK不存在", args: []string{"ZRANK", "z", "x"}, res: nil},
+		{name: "测试ZCOUNT", args: []string{"ZCOUNT", "z", "2", "(4"}, res: int64(2)},
+		{name: "测试ZCOUNT空区间", args: []string{"ZCOUNT", "z", "5", "1"}, res: int64(0)},
+		{name: "测试ZRANGESTORE", args: []string{"ZRANGESTORE", "dst", "z", "1", "2"}, res: int64(2)},
+		{name: "测试ZRANGESTORE结果", args: []string{"ZRANGE", "dst", "0", "-1", "WITHSCORES"}, res: resp.Array{resp.BulkStrings("a"), 2.5, resp.BulkStrings("c"), 4.0}},
+		{name: "测试ZRANGESTORE为空", args: []string{"ZRANGESTORE", "dst", "z", "10", "20"}, res: int64(0)},
+		{name: "测试ZRANGESTORE删除目标", args: []string{"EXISTS", "dst"}, res: int64(0)},
+		{name: "测试ZREM", args: []string{"ZREM", "z", "a", "x"}, res: int64(1)},
+		{name: "测试ZPOPMIN", args: []string{"ZPOPMIN", "z"}, res: resp.Array{resp.BulkStrings("b"), 2.0}},
+		{name: "测试ZPOPMAX count", args: []string{"ZPOPMAX", "z", "5"}, res: resp.Array{resp.BulkStrings("d"), 4.0, resp.BulkStrings("c"), 4.0}},
+		{name: "测试弹出后删除", args: []string{"EXISTS", "z"}, res: int64(0)},
+		{name: "测试ZPOPMIN不存在", args: []string{"ZPOPMIN", "z"}, res: resp.Array{}},
+		{name: "测试ZPOPMIN负数", args: []string{"ZPOPMIN", "z", "-1"}, res: errNotPositive},
+		{name: "测试SET", args: []string{"SET", "str", "v"}, res: "OK"},
+		{name: "测试WRONGTYPE", args: []string{"ZADD", "str", "1", "a"}, res: errWrongType},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert.Equal(t, v.res, doCommand(s, p, v.args...))
+		})
+	}
+}
+
+func TestZsetLex(t *testing.T) {
+	s := NewService(Config{})
+	p, _ := newTestPeer(s)
+	doCommand(s, p, "ZADD", "z", "0", "a", "0", "b", "0", "c", "0", "d", "0", "e")
+	assert.Equal(t, bulks("a", "b", "c"), doCommand(s, p, "ZRANGEBYLEX", "z", "-", "[c"))
+	assert.Equal(t, bulks("b", "c"), doCommand(s, p, "ZRANGE", "z", "(a", "(d", "BYLEX"))
+	assert.Equal(t, bulks("e", "d"), doCommand(s, p, "ZREVRANGEBYLEX", "z", "+", "[b", "LIMIT", "0", "2"))
+	assert.Equal(t, bulks("d", "c"), doCommand(s, p, "ZRANGE", "z", "[d", "(b", "BYLEX", "REV"))
+	assert.Equal(t, int64(3), doCommand(s, p, "ZLEXCOUNT", "z", "[b", "[d"))
+	assert.Equal(t, errMinMaxNotLex, doCommand(s, p, "ZLEXCOUNT", "z", "b", "[d"))
+}
+
+func TestZsetScoreProtocol(t *testing.T) {
+	s := NewService(Config{})
+	p, buf := newTestPeer(s)
+	doCommand(s, p, "ZADD", "z", "1.5", "a", "2", "b")
+	p.handleMSG(s, Message{peer: p, args: []string{"ZRANGE", "z", "0", "0", "WITHSCORES"}})
+	assert.Equal(t, "*2\r\n$1\r\na\r\n$3\r\n1.5\r\n", buf.String())
+	buf.Reset()
+
+	// RESP3下分值编码为Double，WITHSCORES的每个元素为[member, score]
+	p.setProtocol(resp.Proto3)
+	p.handleMSG(s, Message{peer: p, args: []string{"ZRANGE", "z", "0", "-1", "WITHSCORES"}})
+	assert.Equal(t, "*2\r\n*2\r\n$1\r\na\r\n,1.5\r\n*2\r\n$1\r\nb\r\n,2\r\n", buf.String())
+	buf.Reset()
+	p.handleMSG(s, Message{peer: p, args: []string{"ZSCORE", "z", "a"}})
+	assert.Equal(t, ",1.5\r\n", buf.String())
+}
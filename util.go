@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	errNotInteger = errors.New("ERR value is not an integer or out of range")
+	errNotFloat   = errors.New("ERR value is not a valid float")
+	errCursor     = errors.New("ERR invalid cursor")
+)
+
+// parseInt 按Redis的string2ll规则严格解析整数，不接受前导空白、'+'号与多余的前导零
+func parseInt(s string) (int64, error) {
+	if len(s) == 0 || len(s) > 20 {
+		return 0, errNotInteger
+	}
+	if s[0] == '+' || (s[0] == '0' && len(s) > 1) || (s[0] == '-' && len(s) > 1 && s[1] == '0') {
+		return 0, errNotInteger
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errNotInteger
+	}
+	return i, nil
+}
+
+// parseFloat 解析浮点数，不接受NaN
+func parseFloat(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, errNotFloat
+	}
+	return f, nil
+}
+
+// stringMatch 按Redis的glob规则匹配字符串，支持*、?、[...]、[^...]以及\转义
+func stringMatch(pattern, s string, nocase bool) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if stringMatch(pattern[1:], s[i:], nocase) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			match := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				switch {
+				case pattern[0] == '\\' && len(pattern) >= 2:
+					pattern = pattern[1:]
+					match = match || equalFold(pattern[0], s[0], nocase)
+				case len(pattern) >= 3 && pattern[1] == '-':
+					lo, hi, c := pattern[0], pattern[2], s[0]
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+					if nocase {
+						lo, hi, c = toLower(lo), toLower(hi), toLower(c)
+					}
+					match = match || (c >= lo && c <= hi)
+					pattern = pattern[2:]
+				default:
+					match = match || equalFold(pattern[0], s[0], nocase)
+				}
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				// 缺少]时视为匹配到模式末尾
+				return false
+			}
+			if match == not {
+				return false
+			}
+			s = s[1:]
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || !equalFold(pattern[0], s[0], nocase) {
+				return false
+			}
+			s = s[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(s) == 0
+}
+
+func toLower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func equalFold(a, b byte, nocase bool) bool {
+	if nocase {
+		return toLower(a) == toLower(b)
+	}
+	return a == b
+}
+
+// scanOptions SCAN系列命令的公共参数
+type scanOptions struct {
+	cursor   uint64
+	match    string
+	count    int
+	novalues bool
+	typ      string
+}
+
+// parseScanArgs 解析cursor [MATCH pattern] [COUNT count]以及命令特有的选项，
+// args从cursor开始，extra为命令额外允许的选项（NOVALUES、TYPE）
+func parseScanArgs(args []string, extra ...string) (scanOptions, error) {
+	opt := scanOptions{count: 10}
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return opt, errCursor
+	}
+	opt.cursor = cursor
+	for i := 1; i < len(args); i++ {
+		name := strings.ToUpper(args[i])
+		hasNext := i+1 < len(args)
+		switch {
+		case name == "MATCH" && hasNext:
+			opt.match = args[i+1]
+			i++
+		case name == "COUNT" && hasNext:
+			n, err := parseInt(args[i+1])
+			if err != nil {
+				return opt, err
+			}
+			if n < 1 {
+				return opt, errSyntax
+			}
+			opt.count = int(min(n, math.MaxInt32))
+			i++
+		case name == "TYPE" && hasNext && slices.Contains(extra, name):
+			opt.typ = args[i+1]
+			i++
+		case name == "NOVALUES" && slices.Contains(extra, name):
+			opt.novalues = true
+		default:
+			return opt, errSyntax
+		}
+	}
+	return opt, nil
+}
+
+// scanHash 元素在扫描顺序中的位置
+func scanHash(s string) uint64 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return uint64(h.Sum32())
+}
+
+// scanOrder 将元素按哈希值排序后从cursor开始选取至少count个元素，返回选中的元素与下一个cursor，
+// 哈希值相同的元素总在同一批中返回，因此扫描期间一直存在的元素至少会被返回一次
+func scanOrder(items []string, cursor uint64, count int) ([]string, uint64) {
+	type entry struct {
+		h uint64
+		s string
+	}
+	entries := make([]entry, 0, len(items))
+	for _, item := range items {
+		if h := scanHash(item); h >= cursor {
+			entries = append(entries, entry{h: h, s: item})
+		}
+	}
+	slices.SortFunc(entries, func(a, b entry) int {
+		if a.h != b.h {
+			if a.h < b.h {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.s, b.s)
+	})
+	var res []string
+	for i, e := range entries {
+		if len(res) >= count && e.h != entries[i-1].h {
+			return res, e.h
+		}
+		res = append(res, e.s)
+	}
+	return res, 0
+}
+
+// genRunID 生成40个十六进制字符的随机ID，用作运行ID与复制ID
+func genRunID() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// splitHostPort 拆分host:port形式的地址并解析端口
+func splitHostPort(addr string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port %q", portStr)
+	}
+	return host, port, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStringMatch(t *testing.T) {
+	testCases := []struct {
+		name    string
+		pattern string
+		str     string
+		nocase  bool
+		res     bool
+	}{
+		{name: "测试*", pattern: "h*llo", str: "heeello", res: true},
+		{name: "测试?", pattern: "h?llo", str: "hallo", res: true},
+		{name: "测试?不匹配空", pattern: "h?llo", str: "hllo", res: false},
+		{name: "测试[]", pattern: "h[ae]llo", str: "hello", res: true},
+		{name: "测试[^]", pattern: "h[^e]llo", str: "hello", res: false},
+		{name: "测试范围", pattern: "h[a-b]llo", str: "hbllo", res: true},
+		{name: "测试转义", pattern: `h\*llo`, str: "h*llo", res: true},
+		{name: "测试忽略大小写", pattern: "HE*", str: "hello", nocase: true, res: true},
+		{name: "测试大小写敏感", pattern: "HE*", str: "hello", res: false},
+	}
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert.Equal(t, v.res, stringMatch(v.pattern, v.str, v.nocase))
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZskiplist(t *testing.T) {
+	zsl := newZskiplist()
+	var expect []zsetEntry
+	for i := 0; i < 1000; i++ {
+		e := zsetEntry{member: strconv.Itoa(i), score: float64(rand.IntN(100))}
+		zsl.insert(e.score, e.member)
+		expect = append(expect, e)
+	}
+	// 随机删除一半，覆盖各层跨度的维护
+	rand.Shuffle(len(expect), func(i, j int) { expect[i], expect[j] = expect[j], expect[i] })
+	for _, e := range expect[500:] {
+		assert.True(t, zsl.delete(e.score, e.member))
+	}
+	assert.False(t, zsl.delete(-1, "none"))
+	expect = expect[:500]
+	slices.SortFunc(expect, func(a, b zsetEntry) int {
+		return cmp.Or(cmp.Compare(a.score, b.score), cmp.Compare(a.member, b.member))
+	})
+
+	assert.Equal(t, 500, zsl.length)
+	for i, e := range expect {
+		assert.Equal(t, i+1, zsl.rank(e.score, e.member))
+		n := zsl.byRank(i + 1)
+		assert.Equal(t, e, zsetEntry{n.member, n.score})
+	}
+	assert.Equal(t, expect[len(expect)-1].member, zsl.tail.member)
+	var backward []zsetEntry
+	for n := zsl.tail; n != nil; n = n.backward {
+		backward = append(backward, zsetEntry{n.member, n.score})
+	}
+	slices.Reverse(backward)
+	assert.Equal(t, expect, backward)
+
+	r := zscoreRange{min: 10, max: 20, minex: true}
+	first, last := zsl.firstInRange(r), zsl.lastInRange(r)
+	i := slices.IndexFunc(expect, func(e zsetEntry) bool { return e.score > 10 })
+	j := slices.IndexFunc(expect, func(e zsetEntry) bool { return e.score > 20 }) - 1
+	assert.Equal(t, expect[i].member, first.member)
+	assert.Equal(t, expect[j].member, last.member)
+	assert.Nil(t, zsl.firstInRange(zscoreRange{min: 200, max: 300}))
+}